@@ -45,7 +45,7 @@ func EqualCount(t *testing.T, db *sql.DB, expected int, table string, filter squ
 }
 
 // GetDatabase gets a test database
-func GetDatabase(t *testing.T, inits ...DBInitializer) (name string, testDB *sql.DB) {
+func GetDatabase(t testing.TB, inits ...DBInitializer) (name string, testDB *sql.DB) {
 	var err error
 	defer func() {
 		if testDB == nil {
@@ -0,0 +1,414 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	cerrors "github.com/contiamo/go-base/v4/pkg/errors"
+	"github.com/contiamo/go-base/v4/pkg/http/handlers"
+	"github.com/contiamo/go-base/v4/pkg/http/middlewares/authorization"
+	"github.com/contiamo/go-base/v4/pkg/http/parameters"
+	"github.com/contiamo/go-base/v4/pkg/queue"
+	"github.com/go-chi/chi"
+	uuid "github.com/satori/go.uuid"
+)
+
+// Action is an operation of the admin API that requires authorization
+type Action string
+
+const (
+	// ListTasksAction lists the tasks
+	ListTasksAction Action = "tasks:list"
+	// GetTaskAction gets a single task
+	GetTaskAction Action = "tasks:get"
+	// CancelTaskAction cancels a task
+	CancelTaskAction Action = "tasks:cancel"
+	// RetryTaskAction puts a failed or cancelled task back into its queue
+	RetryTaskAction Action = "tasks:retry"
+	// GetWorkflowAction gets the status and the tasks of a workflow
+	GetWorkflowAction Action = "workflows:get"
+	// ListSchedulesAction lists the schedules
+	ListSchedulesAction Action = "schedules:list"
+	// GetScheduleAction gets a single schedule
+	GetScheduleAction Action = "schedules:get"
+	// UpdateScheduleAction changes the task and the timing settings of a schedule
+	UpdateScheduleAction Action = "schedules:update"
+	// PauseScheduleAction pauses a schedule
+	PauseScheduleAction Action = "schedules:pause"
+	// ResumeScheduleAction resumes a paused schedule
+	ResumeScheduleAction Action = "schedules:resume"
+	// DeleteScheduleAction deletes a schedule
+	DeleteScheduleAction Action = "schedules:delete"
+	// TriggerScheduleAction enqueues the task of a schedule immediately
+	TriggerScheduleAction Action = "schedules:trigger"
+)
+
+// Authorizer decides if the request is allowed to perform the action.
+// It should return cerrors.ErrAuthorization for unauthenticated requests
+// and cerrors.ErrPermission when the action is not allowed.
+type Authorizer func(r *http.Request, action Action) error
+
+// RequireClaims is an Authorizer that allows any action to the requests
+// with the authorization claims set by the authorization middleware.
+func RequireClaims(r *http.Request, _ Action) error {
+	_, ok := authorization.GetClaims(r)
+	if !ok {
+		return cerrors.ErrAuthorization
+	}
+	return nil
+}
+
+// validStatuses is the list of task statuses accepted by the task filter
+var validStatuses = []queue.TaskStatus{
+	queue.Waiting,
+	queue.Running,
+	queue.Blocked,
+	queue.Cancelled,
+	queue.Finished,
+	queue.Failed,
+}
+
+// NewHandler creates an HTTP handler of the queue admin API with the following routes:
+//
+//	GET    /tasks                              lists tasks, filtered by `queue`, `type`, `status` and `workflowId`
+//	GET    /tasks/{taskID}                     gets a task
+//	POST   /tasks/{taskID}/cancel              cancels a task
+//	POST   /tasks/{taskID}/retry               retries a failed or cancelled task
+//	GET    /workflows/{workflowID}             gets the status and the tasks of a workflow
+//	GET    /schedules                          lists schedules, filtered by `queue` and `type`
+//	GET    /schedules/{scheduleID}             gets a schedule
+//	PATCH  /schedules/{scheduleID}             changes a schedule, see ScheduleUpdate
+//	POST   /schedules/{scheduleID}/pause       pauses a schedule
+//	POST   /schedules/{scheduleID}/resume      resumes a schedule
+//	POST   /schedules/{scheduleID}/trigger     enqueues the scheduled task immediately
+//	DELETE /schedules/{scheduleID}             deletes a schedule
+//
+// The lists are paginated with the `page` and `pageSize` query parameters.
+// The handler is supposed to be mounted to a router, e.g. `r.Mount("/admin/queue", handler)`.
+//
+// Every request is checked by the authorizer before it's processed,
+// RequireClaims is used when the authorizer is nil.
+func NewHandler(
+	inspector queue.Inspector,
+	manager queue.Manager,
+	schedules queue.ScheduleManager,
+	authorizer Authorizer,
+	debug bool,
+) http.Handler {
+	if authorizer == nil {
+		authorizer = RequireClaims
+	}
+
+	base := handlers.NewBaseHandler("QueueAdmin", handlers.Megabyte, debug)
+	base.ErrorParser = parseError
+
+	h := &handler{
+		Handler:    base,
+		inspector:  inspector,
+		manager:    manager,
+		schedules:  schedules,
+		authorizer: authorizer,
+	}
+
+	r := chi.NewRouter()
+	r.Get("/tasks", h.authorize(ListTasksAction, h.listTasks))
+	r.Get("/tasks/{taskID}", h.authorize(GetTaskAction, h.getTask))
+	r.Post("/tasks/{taskID}/cancel", h.authorize(CancelTaskAction, h.cancelTask))
+	r.Post("/tasks/{taskID}/retry", h.authorize(RetryTaskAction, h.retryTask))
+	r.Get("/workflows/{workflowID}", h.authorize(GetWorkflowAction, h.getWorkflow))
+	r.Get("/schedules", h.authorize(ListSchedulesAction, h.listSchedules))
+	r.Get("/schedules/{scheduleID}", h.authorize(GetScheduleAction, h.getSchedule))
+	r.Patch("/schedules/{scheduleID}", h.authorize(UpdateScheduleAction, h.updateSchedule))
+	r.Post("/schedules/{scheduleID}/pause", h.authorize(PauseScheduleAction, h.pauseSchedule))
+	r.Post("/schedules/{scheduleID}/resume", h.authorize(ResumeScheduleAction, h.resumeSchedule))
+	r.Post("/schedules/{scheduleID}/trigger", h.authorize(TriggerScheduleAction, h.triggerSchedule))
+	r.Delete("/schedules/{scheduleID}", h.authorize(DeleteScheduleAction, h.deleteSchedule))
+
+	return r
+}
+
+type handler struct {
+	*handlers.Handler
+	inspector  queue.Inspector
+	manager    queue.Manager
+	schedules  queue.ScheduleManager
+	authorizer Authorizer
+}
+
+// authorize wraps the handler function with the authorization check for the action
+func (h *handler) authorize(action Action, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := h.authorizer(r, action)
+		if err != nil {
+			h.Error(r.Context(), w, err)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (h *handler) listTasks(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	query := r.URL.Query()
+
+	filter := queue.TaskFilter{
+		Queue:      query.Get("queue"),
+		Type:       queue.TaskType(query.Get("type")),
+		WorkflowID: query.Get("workflowId"),
+	}
+
+	statuses, err := parseStatuses(query["status"])
+	if err != nil {
+		h.Error(ctx, w, err)
+		return
+	}
+	filter.Statuses = statuses
+
+	page := parameters.NormalizePagination(query.Get("page"), query.Get("pageSize"))
+
+	list, err := h.inspector.ListTasks(ctx, filter, page)
+	if err != nil {
+		h.Error(ctx, w, err)
+		return
+	}
+
+	h.Write(ctx, w, http.StatusOK, newTaskList(list))
+}
+
+func (h *handler) getTask(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	taskID, err := pathID(r, "taskID", queue.ErrTaskNotFound)
+	if err != nil {
+		h.Error(ctx, w, err)
+		return
+	}
+
+	task, err := h.inspector.GetTask(ctx, taskID)
+	if err != nil {
+		h.Error(ctx, w, err)
+		return
+	}
+
+	h.Write(ctx, w, http.StatusOK, newTask(*task))
+}
+
+func (h *handler) cancelTask(w http.ResponseWriter, r *http.Request) {
+	h.do(w, r, func(ctx context.Context) error {
+		taskID, err := pathID(r, "taskID", queue.ErrTaskNotFound)
+		if err != nil {
+			return err
+		}
+		return h.manager.Cancel(ctx, taskID)
+	})
+}
+
+func (h *handler) retryTask(w http.ResponseWriter, r *http.Request) {
+	h.do(w, r, func(ctx context.Context) error {
+		taskID, err := pathID(r, "taskID", queue.ErrTaskNotFound)
+		if err != nil {
+			return err
+		}
+		return h.manager.Retry(ctx, taskID)
+	})
+}
+
+func (h *handler) getWorkflow(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	workflow, err := h.inspector.GetWorkflow(ctx, chi.URLParam(r, "workflowID"))
+	if err != nil {
+		h.Error(ctx, w, err)
+		return
+	}
+
+	h.Write(ctx, w, http.StatusOK, newWorkflow(*workflow))
+}
+
+func (h *handler) listSchedules(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	query := r.URL.Query()
+
+	filter := queue.ScheduleFilter{
+		Queue: query.Get("queue"),
+		Type:  queue.TaskType(query.Get("type")),
+	}
+
+	page := parameters.NormalizePagination(query.Get("page"), query.Get("pageSize"))
+
+	list, err := h.schedules.ListSchedules(ctx, filter, page)
+	if err != nil {
+		h.Error(ctx, w, err)
+		return
+	}
+
+	h.Write(ctx, w, http.StatusOK, newScheduleList(list))
+}
+
+func (h *handler) getSchedule(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	scheduleID, err := pathID(r, "scheduleID", queue.ErrScheduleNotFound)
+	if err != nil {
+		h.Error(ctx, w, err)
+		return
+	}
+
+	schedule, err := h.schedules.GetSchedule(ctx, scheduleID)
+	if err != nil {
+		h.Error(ctx, w, err)
+		return
+	}
+
+	h.Write(ctx, w, http.StatusOK, newSchedule(*schedule))
+}
+
+func (h *handler) updateSchedule(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	scheduleID, err := pathID(r, "scheduleID", queue.ErrScheduleNotFound)
+	if err != nil {
+		h.Error(ctx, w, err)
+		return
+	}
+
+	var update ScheduleUpdate
+	err = h.Parse(r, &update)
+	if err != nil {
+		h.Error(ctx, w, err)
+		return
+	}
+
+	schedule, err := h.schedules.UpdateSchedule(ctx, scheduleID, update.toScheduleUpdate())
+	if err != nil {
+		h.Error(ctx, w, err)
+		return
+	}
+
+	h.Write(ctx, w, http.StatusOK, newSchedule(*schedule))
+}
+
+func (h *handler) pauseSchedule(w http.ResponseWriter, r *http.Request) {
+	h.do(w, r, func(ctx context.Context) error {
+		scheduleID, err := pathID(r, "scheduleID", queue.ErrScheduleNotFound)
+		if err != nil {
+			return err
+		}
+		return h.schedules.PauseSchedule(ctx, scheduleID)
+	})
+}
+
+func (h *handler) resumeSchedule(w http.ResponseWriter, r *http.Request) {
+	h.do(w, r, func(ctx context.Context) error {
+		scheduleID, err := pathID(r, "scheduleID", queue.ErrScheduleNotFound)
+		if err != nil {
+			return err
+		}
+		return h.schedules.ResumeSchedule(ctx, scheduleID)
+	})
+}
+
+func (h *handler) triggerSchedule(w http.ResponseWriter, r *http.Request) {
+	h.do(w, r, func(ctx context.Context) error {
+		scheduleID, err := pathID(r, "scheduleID", queue.ErrScheduleNotFound)
+		if err != nil {
+			return err
+		}
+		return h.schedules.TriggerSchedule(ctx, scheduleID)
+	})
+}
+
+func (h *handler) deleteSchedule(w http.ResponseWriter, r *http.Request) {
+	h.do(w, r, func(ctx context.Context) error {
+		scheduleID, err := pathID(r, "scheduleID", queue.ErrScheduleNotFound)
+		if err != nil {
+			return err
+		}
+		return h.schedules.DeleteSchedule(ctx, scheduleID)
+	})
+}
+
+// pathID returns the ID from the URL path parameter with the given name.
+// The tasks and schedules have UUIDs, so a malformed ID is reported as the notFound error.
+func pathID(r *http.Request, name string, notFound error) (string, error) {
+	id := chi.URLParam(r, name)
+	_, err := uuid.FromString(id)
+	if err != nil {
+		return "", notFound
+	}
+	return id, nil
+}
+
+// do runs the action and responds with `204 No Content` when it succeeds
+func (h *handler) do(w http.ResponseWriter, r *http.Request, action func(ctx context.Context) error) {
+	ctx := r.Context()
+
+	err := action(ctx)
+	if err != nil {
+		h.Error(ctx, w, err)
+		return
+	}
+
+	h.Write(ctx, w, http.StatusNoContent, nil)
+}
+
+// parseStatuses parses the task statuses from the query values,
+// every value can contain a comma-separated list of statuses.
+func parseStatuses(values []string) (statuses []queue.TaskStatus, err error) {
+	for _, value := range values {
+		for _, status := range strings.Split(value, ",") {
+			status = strings.TrimSpace(status)
+			if status == "" {
+				continue
+			}
+			if !isValidStatus(queue.TaskStatus(status)) {
+				return nil, cerrors.ValidationErrors{
+					"status": fmt.Errorf("unknown task status %q", status),
+				}
+			}
+			statuses = append(statuses, queue.TaskStatus(status))
+		}
+	}
+
+	return statuses, nil
+}
+
+func isValidStatus(status queue.TaskStatus) bool {
+	for _, valid := range validStatuses {
+		if status == valid {
+			return true
+		}
+	}
+	return false
+}
+
+// parseError extends the default error parser with the queue errors
+func parseError(ctx context.Context, err error, debug bool) (int, interface{}) {
+	var status int
+	switch {
+	case errors.Is(err, queue.ErrTaskNotFound),
+		errors.Is(err, queue.ErrScheduleNotFound),
+		errors.Is(err, queue.ErrWorkflowNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, queue.ErrTaskFinished),
+		errors.Is(err, queue.ErrTaskNotRetryable),
+		errors.Is(err, queue.ErrDuplicateIdempotencyKey):
+		status = http.StatusConflict
+	case errors.Is(err, queue.ErrInvalidSchedule), errors.Is(err, queue.ErrInvalidTimezone):
+		status = http.StatusUnprocessableEntity
+	default:
+		return handlers.DefaultErrorParser(ctx, err, debug)
+	}
+
+	return status, cerrors.ErrorResponse{
+		Errors: []cerrors.APIErrorMessenger{
+			&cerrors.GeneralError{
+				Type:    cerrors.GeneralErrorType,
+				Message: err.Error(),
+			},
+		},
+	}
+}
@@ -0,0 +1,507 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/contiamo/go-base/v4/pkg/data/managers"
+	cerrors "github.com/contiamo/go-base/v4/pkg/errors"
+	"github.com/contiamo/go-base/v4/pkg/http/middlewares/authorization"
+	"github.com/contiamo/go-base/v4/pkg/http/parameters"
+	"github.com/contiamo/go-base/v4/pkg/queue"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandler(t *testing.T) {
+	createdAt := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	task := queue.Task{
+		TaskBase: queue.TaskBase{
+			Queue: "queue1",
+			Type:  "test",
+			Spec:  queue.Spec(`{"field":"value"}`),
+		},
+		ID:        "0c6e8f4a-5d1b-4a3e-9f27-8b1c2d3e4f50",
+		Status:    queue.Failed,
+		Error:     &queue.TaskError{Message: "boom", Type: "*errors.errorString"},
+		Progress:  queue.Progress(`{}`),
+		Attempts:  3,
+		LastError: "boom",
+		CreatedAt: createdAt,
+		UpdatedAt: createdAt,
+		NotBefore: createdAt,
+	}
+	skip := queue.OverlapSkip
+	schedule := queue.Schedule{
+		TaskBase: queue.TaskBase{
+			Queue: "queue1",
+			Type:  "test",
+		},
+		ID:           "7a9b1c2d-3e4f-4a5b-8c6d-9e0f1a2b3c4d",
+		CronSchedule: "@hourly",
+		PausedAt:     &createdAt,
+		CreatedAt:    createdAt,
+		UpdatedAt:    createdAt,
+	}
+
+	cases := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		anonymous  bool
+		inspector  fakeInspector
+		manager    fakeManager
+		schedules  fakeScheduleManager
+		expStatus  int
+		expBody    string
+		expAction  Action
+		expTaskID  string
+		expFilter  *queue.TaskFilter
+		expPage    *parameters.Page
+		expSchedID string
+		expFlowID  string
+		expUpdate  *queue.ScheduleUpdate
+	}{
+		{
+			name:      "returns 401 for unauthenticated requests",
+			method:    http.MethodGet,
+			path:      "/tasks",
+			anonymous: true,
+			expStatus: http.StatusUnauthorized,
+			expBody:   `{"errors":[{"type":"GeneralError","message":"User is unauthorized, make sure you've logged in"}]}`,
+		},
+		{
+			name:   "lists tasks with the filter and pagination",
+			method: http.MethodGet,
+			path:   "/tasks?queue=queue1&type=test&status=waiting,failed&page=2&pageSize=10",
+			inspector: fakeInspector{list: queue.TaskList{
+				Items:    []queue.Task{task},
+				PageInfo: managers.PageInfo{ItemCount: 11, ItemsPerPage: 10, UnfilteredItemCount: 20, Current: 2},
+			}},
+			expStatus: http.StatusOK,
+			expBody: `{"items":[{"id":"0c6e8f4a-5d1b-4a3e-9f27-8b1c2d3e4f50","queue":"queue1","type":"test","spec":{"field":"value"},"priority":0,"status":"failed","progress":{},"attempts":3,"lastError":"boom","error":{"message":"boom","type":"*errors.errorString"},"createdAt":"2020-01-01T00:00:00Z","updatedAt":"2020-01-01T00:00:00Z","notBefore":"2020-01-01T00:00:00Z"}],` +
+				`"pageInfo":{"itemCount":11,"itemsPerPage":10,"unfilteredItemCount":20,"current":2}}`,
+			expAction: ListTasksAction,
+			expFilter: &queue.TaskFilter{
+				Queue:    "queue1",
+				Type:     "test",
+				Statuses: []queue.TaskStatus{queue.Waiting, queue.Failed},
+			},
+			expPage: &parameters.Page{Number: 2, Size: 10},
+		},
+		{
+			name:      "returns 422 for unknown task statuses",
+			method:    http.MethodGet,
+			path:      "/tasks?status=unknown",
+			expStatus: http.StatusUnprocessableEntity,
+			expBody:   `{"errors":[{"type":"FieldError","message":"unknown task status \"unknown\"","key":"status"}]}`,
+			expAction: ListTasksAction,
+		},
+		{
+			name:      "gets a task",
+			method:    http.MethodGet,
+			path:      "/tasks/0c6e8f4a-5d1b-4a3e-9f27-8b1c2d3e4f50",
+			inspector: fakeInspector{task: &task},
+			expStatus: http.StatusOK,
+			expBody:   `{"id":"0c6e8f4a-5d1b-4a3e-9f27-8b1c2d3e4f50","queue":"queue1","type":"test","spec":{"field":"value"},"priority":0,"status":"failed","progress":{},"attempts":3,"lastError":"boom","error":{"message":"boom","type":"*errors.errorString"},"createdAt":"2020-01-01T00:00:00Z","updatedAt":"2020-01-01T00:00:00Z","notBefore":"2020-01-01T00:00:00Z"}`,
+			expAction: GetTaskAction,
+			expTaskID: "0c6e8f4a-5d1b-4a3e-9f27-8b1c2d3e4f50",
+		},
+		{
+			name:      "returns 404 when the task does not exist",
+			method:    http.MethodGet,
+			path:      "/tasks/0c6e8f4a-5d1b-4a3e-9f27-8b1c2d3e4f50",
+			inspector: fakeInspector{err: queue.ErrTaskNotFound},
+			expStatus: http.StatusNotFound,
+			expBody:   `{"errors":[{"type":"GeneralError","message":"task not found"}]}`,
+			expAction: GetTaskAction,
+			expTaskID: "0c6e8f4a-5d1b-4a3e-9f27-8b1c2d3e4f50",
+		},
+		{
+			name:      "returns 404 when the task ID is malformed",
+			method:    http.MethodPost,
+			path:      "/tasks/task1/retry",
+			expStatus: http.StatusNotFound,
+			expBody:   `{"errors":[{"type":"GeneralError","message":"task not found"}]}`,
+			expAction: RetryTaskAction,
+		},
+		{
+			name:      "lists the tasks of a workflow",
+			method:    http.MethodGet,
+			path:      "/tasks?workflowId=workflow1&status=blocked",
+			expStatus: http.StatusOK,
+			expBody:   `{"items":[],"pageInfo":{"itemCount":0,"itemsPerPage":0,"unfilteredItemCount":0,"current":0}}`,
+			expAction: ListTasksAction,
+			expFilter: &queue.TaskFilter{
+				Statuses:   []queue.TaskStatus{queue.Blocked},
+				WorkflowID: "workflow1",
+			},
+			expPage: &parameters.Page{Number: 1, Size: parameters.DefaultPageSize},
+		},
+		{
+			name:   "gets a workflow",
+			method: http.MethodGet,
+			path:   "/workflows/workflow1",
+			inspector: fakeInspector{workflow: &queue.Workflow{
+				ID:     "workflow1",
+				Status: queue.Running,
+				Tasks: []queue.Task{{
+					ID:         "task2",
+					TaskBase:   queue.TaskBase{Queue: "queue1", Type: "test"},
+					Status:     queue.Blocked,
+					DependsOn:  []string{"0c6e8f4a-5d1b-4a3e-9f27-8b1c2d3e4f50"},
+					WorkflowID: "workflow1",
+				}},
+			}},
+			expStatus: http.StatusOK,
+			expBody: `{"id":"workflow1","status":"running","tasks":[{"id":"task2","queue":"queue1","type":"test","spec":null,"priority":0,"status":"blocked","progress":null,"attempts":0,` +
+				`"createdAt":"0001-01-01T00:00:00Z","updatedAt":"0001-01-01T00:00:00Z","notBefore":"0001-01-01T00:00:00Z","dependsOn":["0c6e8f4a-5d1b-4a3e-9f27-8b1c2d3e4f50"],"workflowId":"workflow1"}]}`,
+			expAction: GetWorkflowAction,
+			expFlowID: "workflow1",
+		},
+		{
+			name:      "returns 404 when the workflow does not exist",
+			method:    http.MethodGet,
+			path:      "/workflows/workflow1",
+			inspector: fakeInspector{err: queue.ErrWorkflowNotFound},
+			expStatus: http.StatusNotFound,
+			expBody:   `{"errors":[{"type":"GeneralError","message":"workflow not found"}]}`,
+			expAction: GetWorkflowAction,
+			expFlowID: "workflow1",
+		},
+		{
+			name:      "cancels a task",
+			method:    http.MethodPost,
+			path:      "/tasks/0c6e8f4a-5d1b-4a3e-9f27-8b1c2d3e4f50/cancel",
+			expStatus: http.StatusNoContent,
+			expAction: CancelTaskAction,
+			expTaskID: "0c6e8f4a-5d1b-4a3e-9f27-8b1c2d3e4f50",
+		},
+		{
+			name:      "returns 409 when the task is already finished",
+			method:    http.MethodPost,
+			path:      "/tasks/0c6e8f4a-5d1b-4a3e-9f27-8b1c2d3e4f50/cancel",
+			manager:   fakeManager{err: queue.ErrTaskFinished},
+			expStatus: http.StatusConflict,
+			expBody:   `{"errors":[{"type":"GeneralError","message":"task has finished"}]}`,
+			expAction: CancelTaskAction,
+			expTaskID: "0c6e8f4a-5d1b-4a3e-9f27-8b1c2d3e4f50",
+		},
+		{
+			name:      "retries a task",
+			method:    http.MethodPost,
+			path:      "/tasks/0c6e8f4a-5d1b-4a3e-9f27-8b1c2d3e4f50/retry",
+			expStatus: http.StatusNoContent,
+			expAction: RetryTaskAction,
+			expTaskID: "0c6e8f4a-5d1b-4a3e-9f27-8b1c2d3e4f50",
+		},
+		{
+			name:      "returns 409 when the task can not be retried",
+			method:    http.MethodPost,
+			path:      "/tasks/0c6e8f4a-5d1b-4a3e-9f27-8b1c2d3e4f50/retry",
+			manager:   fakeManager{err: queue.ErrTaskNotRetryable},
+			expStatus: http.StatusConflict,
+			expBody:   `{"errors":[{"type":"GeneralError","message":"only failed or cancelled tasks can be retried"}]}`,
+			expAction: RetryTaskAction,
+			expTaskID: "0c6e8f4a-5d1b-4a3e-9f27-8b1c2d3e4f50",
+		},
+		{
+			name:      "returns 409 when another task has the same idempotency key",
+			method:    http.MethodPost,
+			path:      "/tasks/0c6e8f4a-5d1b-4a3e-9f27-8b1c2d3e4f50/retry",
+			manager:   fakeManager{err: queue.ErrDuplicateIdempotencyKey},
+			expStatus: http.StatusConflict,
+			expBody:   `{"errors":[{"type":"GeneralError","message":"another unfinished task has the same idempotency key"}]}`,
+			expAction: RetryTaskAction,
+			expTaskID: "0c6e8f4a-5d1b-4a3e-9f27-8b1c2d3e4f50",
+		},
+		{
+			name:   "lists schedules",
+			method: http.MethodGet,
+			path:   "/schedules?queue=queue1",
+			schedules: fakeScheduleManager{list: queue.ScheduleList{
+				Items:    []queue.Schedule{schedule},
+				PageInfo: managers.PageInfo{ItemCount: 1, ItemsPerPage: 20, UnfilteredItemCount: 1, Current: 1},
+			}},
+			expStatus: http.StatusOK,
+			expBody: `{"items":[{"id":"7a9b1c2d-3e4f-4a5b-8c6d-9e0f1a2b3c4d","queue":"queue1","type":"test","spec":null,"priority":0,"cronSchedule":"@hourly","timezone":"","executionCount":0,"paused":true,"pausedAt":"2020-01-01T00:00:00Z","createdAt":"2020-01-01T00:00:00Z","updatedAt":"2020-01-01T00:00:00Z"}],` +
+				`"pageInfo":{"itemCount":1,"itemsPerPage":20,"unfilteredItemCount":1,"current":1}}`,
+			expAction: ListSchedulesAction,
+			expPage:   &parameters.Page{Number: 1, Size: parameters.DefaultPageSize},
+		},
+		{
+			name:       "gets a schedule",
+			method:     http.MethodGet,
+			path:       "/schedules/7a9b1c2d-3e4f-4a5b-8c6d-9e0f1a2b3c4d",
+			schedules:  fakeScheduleManager{schedule: &schedule},
+			expStatus:  http.StatusOK,
+			expBody:    `{"id":"7a9b1c2d-3e4f-4a5b-8c6d-9e0f1a2b3c4d","queue":"queue1","type":"test","spec":null,"priority":0,"cronSchedule":"@hourly","timezone":"","executionCount":0,"paused":true,"pausedAt":"2020-01-01T00:00:00Z","createdAt":"2020-01-01T00:00:00Z","updatedAt":"2020-01-01T00:00:00Z"}`,
+			expAction:  GetScheduleAction,
+			expSchedID: "7a9b1c2d-3e4f-4a5b-8c6d-9e0f1a2b3c4d",
+		},
+		{
+			name:       "updates a schedule",
+			method:     http.MethodPatch,
+			path:       "/schedules/7a9b1c2d-3e4f-4a5b-8c6d-9e0f1a2b3c4d",
+			body:       `{"priority":2,"timing":{"intervalSeconds":90,"validUntil":"2020-01-02T00:00:00Z","maxExecutions":3},"overlapPolicy":"skip"}`,
+			schedules:  fakeScheduleManager{schedule: &schedule},
+			expStatus:  http.StatusOK,
+			expBody:    `{"id":"7a9b1c2d-3e4f-4a5b-8c6d-9e0f1a2b3c4d","queue":"queue1","type":"test","spec":null,"priority":0,"cronSchedule":"@hourly","timezone":"","executionCount":0,"paused":true,"pausedAt":"2020-01-01T00:00:00Z","createdAt":"2020-01-01T00:00:00Z","updatedAt":"2020-01-01T00:00:00Z"}`,
+			expAction:  UpdateScheduleAction,
+			expSchedID: "7a9b1c2d-3e4f-4a5b-8c6d-9e0f1a2b3c4d",
+			expUpdate: &queue.ScheduleUpdate{
+				Priority:      intPtr(2),
+				OverlapPolicy: &skip,
+				Timing: &queue.ScheduleTiming{
+					Interval:      90 * time.Second,
+					ValidUntil:    createdAt.Add(24 * time.Hour),
+					MaxExecutions: 3,
+				},
+			},
+		},
+		{
+			name:       "returns 422 when the schedule update is not valid",
+			method:     http.MethodPatch,
+			path:       "/schedules/7a9b1c2d-3e4f-4a5b-8c6d-9e0f1a2b3c4d",
+			body:       `{"timing":{"intervalSeconds":-1}}`,
+			schedules:  fakeScheduleManager{err: queue.ErrInvalidSchedule},
+			expStatus:  http.StatusUnprocessableEntity,
+			expBody:    `{"errors":[{"type":"GeneralError","message":"invalid schedule"}]}`,
+			expAction:  UpdateScheduleAction,
+			expSchedID: "7a9b1c2d-3e4f-4a5b-8c6d-9e0f1a2b3c4d",
+			expUpdate: &queue.ScheduleUpdate{
+				Timing: &queue.ScheduleTiming{Interval: -time.Second},
+			},
+		},
+		{
+			name:       "pauses a schedule",
+			method:     http.MethodPost,
+			path:       "/schedules/7a9b1c2d-3e4f-4a5b-8c6d-9e0f1a2b3c4d/pause",
+			expStatus:  http.StatusNoContent,
+			expAction:  PauseScheduleAction,
+			expSchedID: "7a9b1c2d-3e4f-4a5b-8c6d-9e0f1a2b3c4d",
+		},
+		{
+			name:       "resumes a schedule",
+			method:     http.MethodPost,
+			path:       "/schedules/7a9b1c2d-3e4f-4a5b-8c6d-9e0f1a2b3c4d/resume",
+			expStatus:  http.StatusNoContent,
+			expAction:  ResumeScheduleAction,
+			expSchedID: "7a9b1c2d-3e4f-4a5b-8c6d-9e0f1a2b3c4d",
+		},
+		{
+			name:       "triggers a schedule",
+			method:     http.MethodPost,
+			path:       "/schedules/7a9b1c2d-3e4f-4a5b-8c6d-9e0f1a2b3c4d/trigger",
+			expStatus:  http.StatusNoContent,
+			expAction:  TriggerScheduleAction,
+			expSchedID: "7a9b1c2d-3e4f-4a5b-8c6d-9e0f1a2b3c4d",
+		},
+		{
+			name:       "deletes a schedule",
+			method:     http.MethodDelete,
+			path:       "/schedules/7a9b1c2d-3e4f-4a5b-8c6d-9e0f1a2b3c4d",
+			expStatus:  http.StatusNoContent,
+			expAction:  DeleteScheduleAction,
+			expSchedID: "7a9b1c2d-3e4f-4a5b-8c6d-9e0f1a2b3c4d",
+		},
+		{
+			name:       "returns 404 when the schedule does not exist",
+			method:     http.MethodPost,
+			path:       "/schedules/7a9b1c2d-3e4f-4a5b-8c6d-9e0f1a2b3c4d/pause",
+			schedules:  fakeScheduleManager{err: queue.ErrScheduleNotFound},
+			expStatus:  http.StatusNotFound,
+			expBody:    `{"errors":[{"type":"GeneralError","message":"schedule not found"}]}`,
+			expAction:  PauseScheduleAction,
+			expSchedID: "7a9b1c2d-3e4f-4a5b-8c6d-9e0f1a2b3c4d",
+		},
+		{
+			name:      "returns 404 when the schedule ID is malformed",
+			method:    http.MethodGet,
+			path:      "/schedules/schedule1",
+			expStatus: http.StatusNotFound,
+			expBody:   `{"errors":[{"type":"GeneralError","message":"schedule not found"}]}`,
+			expAction: GetScheduleAction,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var action Action
+			authorizer := func(r *http.Request, a Action) error {
+				action = a
+				return RequireClaims(r, a)
+			}
+
+			h := NewHandler(&tc.inspector, &tc.manager, &tc.schedules, authorizer, false)
+
+			var body io.Reader
+			if tc.body != "" {
+				body = strings.NewReader(tc.body)
+			}
+			r := httptest.NewRequest(tc.method, tc.path, body)
+			if !tc.anonymous {
+				r = authorization.SetClaims(r, authorization.Claims{UserID: "admin"})
+			}
+			w := httptest.NewRecorder()
+
+			h.ServeHTTP(w, r)
+
+			require.Equal(t, tc.expStatus, w.Code)
+			if tc.expBody != "" {
+				require.JSONEq(t, tc.expBody, w.Body.String())
+			} else {
+				require.Empty(t, w.Body.String())
+			}
+
+			if tc.anonymous {
+				return
+			}
+			require.Equal(t, tc.expAction, action)
+
+			taskID := tc.inspector.taskID
+			if taskID == "" {
+				taskID = tc.manager.taskID
+			}
+			require.Equal(t, tc.expTaskID, taskID)
+			require.Equal(t, tc.expSchedID, tc.schedules.scheduleID)
+			require.Equal(t, tc.expFlowID, tc.inspector.workflowID)
+
+			if tc.expUpdate != nil {
+				require.Equal(t, *tc.expUpdate, tc.schedules.update)
+			}
+			if tc.expFilter != nil {
+				require.Equal(t, *tc.expFilter, tc.inspector.filter)
+			}
+			if tc.expPage != nil {
+				page := tc.inspector.page
+				if page == (parameters.Page{}) {
+					page = tc.schedules.page
+				}
+				require.Equal(t, *tc.expPage, page)
+			}
+		})
+	}
+
+	t.Run("uses the authorizer errors", func(t *testing.T) {
+		h := NewHandler(&fakeInspector{}, &fakeManager{}, &fakeScheduleManager{}, func(r *http.Request, a Action) error {
+			if a == DeleteScheduleAction {
+				return cerrors.ErrPermission
+			}
+			return nil
+		}, false)
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/schedules/7a9b1c2d-3e4f-4a5b-8c6d-9e0f1a2b3c4d", nil))
+		require.Equal(t, http.StatusForbidden, w.Code)
+
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/schedules/7a9b1c2d-3e4f-4a5b-8c6d-9e0f1a2b3c4d/pause", nil))
+		require.Equal(t, http.StatusNoContent, w.Code)
+	})
+
+	t.Run("empty spec and progress are encoded as null", func(t *testing.T) {
+		out, err := json.Marshal(newTask(queue.Task{}))
+		require.NoError(t, err)
+		require.Contains(t, string(out), `"spec":null`)
+		require.Contains(t, string(out), `"progress":null`)
+	})
+}
+
+type fakeInspector struct {
+	queue.Inspector
+	task   *queue.Task
+	list   queue.TaskList
+	err    error
+	taskID string
+	filter queue.TaskFilter
+	page   parameters.Page
+
+	workflow   *queue.Workflow
+	workflowID string
+}
+
+func (i *fakeInspector) GetTask(ctx context.Context, taskID string) (*queue.Task, error) {
+	i.taskID = taskID
+	return i.task, i.err
+}
+
+func (i *fakeInspector) ListTasks(ctx context.Context, filter queue.TaskFilter, page parameters.Page) (queue.TaskList, error) {
+	i.filter, i.page = filter, page
+	return i.list, i.err
+}
+
+func (i *fakeInspector) GetWorkflow(ctx context.Context, workflowID string) (*queue.Workflow, error) {
+	i.workflowID = workflowID
+	return i.workflow, i.err
+}
+
+type fakeManager struct {
+	queue.Manager
+	err    error
+	taskID string
+}
+
+func (m *fakeManager) Cancel(ctx context.Context, taskID string) error {
+	m.taskID = taskID
+	return m.err
+}
+
+func (m *fakeManager) Retry(ctx context.Context, taskID string) error {
+	m.taskID = taskID
+	return m.err
+}
+
+type fakeScheduleManager struct {
+	schedule   *queue.Schedule
+	list       queue.ScheduleList
+	err        error
+	scheduleID string
+	page       parameters.Page
+	update     queue.ScheduleUpdate
+}
+
+func (m *fakeScheduleManager) GetSchedule(ctx context.Context, scheduleID string) (*queue.Schedule, error) {
+	m.scheduleID = scheduleID
+	return m.schedule, m.err
+}
+
+func (m *fakeScheduleManager) UpdateSchedule(ctx context.Context, scheduleID string, update queue.ScheduleUpdate) (*queue.Schedule, error) {
+	m.scheduleID, m.update = scheduleID, update
+	return m.schedule, m.err
+}
+
+func (m *fakeScheduleManager) ListSchedules(ctx context.Context, filter queue.ScheduleFilter, page parameters.Page) (queue.ScheduleList, error) {
+	m.page = page
+	return m.list, m.err
+}
+
+func (m *fakeScheduleManager) PauseSchedule(ctx context.Context, scheduleID string) error {
+	m.scheduleID = scheduleID
+	return m.err
+}
+
+func (m *fakeScheduleManager) ResumeSchedule(ctx context.Context, scheduleID string) error {
+	m.scheduleID = scheduleID
+	return m.err
+}
+
+func (m *fakeScheduleManager) DeleteSchedule(ctx context.Context, scheduleID string) error {
+	m.scheduleID = scheduleID
+	return m.err
+}
+
+func (m *fakeScheduleManager) TriggerSchedule(ctx context.Context, scheduleID string) error {
+	m.scheduleID = scheduleID
+	return m.err
+}
+
+func intPtr(value int) *int {
+	return &value
+}
@@ -0,0 +1,230 @@
+package admin
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/contiamo/go-base/v4/pkg/data/managers"
+	"github.com/contiamo/go-base/v4/pkg/queue"
+)
+
+// Task is the JSON representation of a queued task
+type Task struct {
+	ID              string           `json:"id"`
+	Queue           string           `json:"queue"`
+	Type            queue.TaskType   `json:"type"`
+	Spec            json.RawMessage  `json:"spec"`
+	Priority        int              `json:"priority"`
+	Status          queue.TaskStatus `json:"status"`
+	Progress        json.RawMessage  `json:"progress"`
+	Attempts        int              `json:"attempts"`
+	LastError       string           `json:"lastError,omitempty"`
+	CreatedAt       time.Time        `json:"createdAt"`
+	UpdatedAt       time.Time        `json:"updatedAt"`
+	NotBefore       time.Time        `json:"notBefore"`
+	StartedAt       *time.Time       `json:"startedAt,omitempty"`
+	FinishedAt      *time.Time       `json:"finishedAt,omitempty"`
+	LastHeartbeatAt *time.Time       `json:"lastHeartbeatAt,omitempty"`
+	DeadLetteredAt  *time.Time       `json:"deadLetteredAt,omitempty"`
+	Result          json.RawMessage  `json:"result,omitempty"`
+	Error           *queue.TaskError `json:"error,omitempty"`
+	DependsOn       []string         `json:"dependsOn,omitempty"`
+	WorkflowID      string           `json:"workflowId,omitempty"`
+	ScheduledAt     *time.Time       `json:"scheduledAt,omitempty"`
+}
+
+// TaskList is the JSON representation of a page of tasks
+type TaskList struct {
+	Items    []Task            `json:"items"`
+	PageInfo managers.PageInfo `json:"pageInfo"`
+}
+
+// Workflow is the JSON representation of a workflow
+type Workflow struct {
+	ID     string           `json:"id"`
+	Status queue.TaskStatus `json:"status"`
+	Tasks  []Task           `json:"tasks"`
+}
+
+// Schedule is the JSON representation of a task schedule
+type Schedule struct {
+	ID                string              `json:"id"`
+	Queue             string              `json:"queue"`
+	Type              queue.TaskType      `json:"type"`
+	Spec              json.RawMessage     `json:"spec"`
+	Priority          int                 `json:"priority"`
+	CronSchedule      string              `json:"cronSchedule"`
+	Timezone          string              `json:"timezone"`
+	IntervalSeconds   float64             `json:"intervalSeconds,omitempty"`
+	ValidFrom         *time.Time          `json:"validFrom,omitempty"`
+	ValidUntil        *time.Time          `json:"validUntil,omitempty"`
+	MaxExecutions     int                 `json:"maxExecutions,omitempty"`
+	MisfirePolicy     queue.MisfirePolicy `json:"misfirePolicy,omitempty"`
+	MisfireLimit      int                 `json:"misfireLimit,omitempty"`
+	OverlapPolicy     queue.OverlapPolicy `json:"overlapPolicy,omitempty"`
+	ExecutionCount    int                 `json:"executionCount"`
+	NextExecutionTime *time.Time          `json:"nextExecutionTime,omitempty"`
+	Paused            bool                `json:"paused"`
+	PausedAt          *time.Time          `json:"pausedAt,omitempty"`
+	CreatedAt         time.Time           `json:"createdAt"`
+	UpdatedAt         time.Time           `json:"updatedAt"`
+}
+
+// ScheduleUpdate is the JSON request changing a schedule, the omitted fields are not changed
+type ScheduleUpdate struct {
+	Spec     json.RawMessage `json:"spec,omitempty"`
+	Priority *int            `json:"priority,omitempty"`
+	// Timing replaces all the timing settings of the schedule
+	Timing        *ScheduleTiming      `json:"timing,omitempty"`
+	OverlapPolicy *queue.OverlapPolicy `json:"overlapPolicy,omitempty"`
+}
+
+// ScheduleTiming is the JSON representation of the schedule timing settings
+type ScheduleTiming struct {
+	CronSchedule    string              `json:"cronSchedule"`
+	Timezone        string              `json:"timezone"`
+	IntervalSeconds float64             `json:"intervalSeconds"`
+	ValidFrom       *time.Time          `json:"validFrom"`
+	ValidUntil      *time.Time          `json:"validUntil"`
+	MaxExecutions   int                 `json:"maxExecutions"`
+	MisfirePolicy   queue.MisfirePolicy `json:"misfirePolicy"`
+	MisfireLimit    int                 `json:"misfireLimit"`
+}
+
+// ScheduleList is the JSON representation of a page of schedules
+type ScheduleList struct {
+	Items    []Schedule        `json:"items"`
+	PageInfo managers.PageInfo `json:"pageInfo"`
+}
+
+// newTask converts the queue task to its JSON representation
+func newTask(task queue.Task) Task {
+	return Task{
+		ID:              task.ID,
+		Queue:           task.Queue,
+		Type:            task.Type,
+		Spec:            rawJSON(task.Spec),
+		Priority:        task.Priority,
+		Status:          task.Status,
+		Progress:        rawJSON(task.Progress),
+		Attempts:        task.Attempts,
+		LastError:       task.LastError,
+		CreatedAt:       task.CreatedAt,
+		UpdatedAt:       task.UpdatedAt,
+		NotBefore:       task.NotBefore,
+		StartedAt:       task.StartedAt,
+		FinishedAt:      task.FinishedAt,
+		LastHeartbeatAt: task.LastHeartbeatAt,
+		DeadLetteredAt:  task.DeadLetteredAt,
+		Result:          rawJSON(task.Result),
+		Error:           task.Error,
+		DependsOn:       task.DependsOn,
+		WorkflowID:      task.WorkflowID,
+		ScheduledAt:     task.ScheduledAt,
+	}
+}
+
+// newTaskList converts the queue task list to its JSON representation
+func newTaskList(list queue.TaskList) TaskList {
+	items := make([]Task, 0, len(list.Items))
+	for _, task := range list.Items {
+		items = append(items, newTask(task))
+	}
+
+	return TaskList{
+		Items:    items,
+		PageInfo: list.PageInfo,
+	}
+}
+
+// newWorkflow converts the queue workflow to its JSON representation
+func newWorkflow(workflow queue.Workflow) Workflow {
+	tasks := make([]Task, 0, len(workflow.Tasks))
+	for _, task := range workflow.Tasks {
+		tasks = append(tasks, newTask(task))
+	}
+
+	return Workflow{
+		ID:     workflow.ID,
+		Status: workflow.Status,
+		Tasks:  tasks,
+	}
+}
+
+// newSchedule converts the queue schedule to its JSON representation
+func newSchedule(schedule queue.Schedule) Schedule {
+	return Schedule{
+		ID:                schedule.ID,
+		Queue:             schedule.Queue,
+		Type:              schedule.Type,
+		Spec:              rawJSON(schedule.Spec),
+		Priority:          schedule.Priority,
+		CronSchedule:      schedule.CronSchedule,
+		Timezone:          schedule.Timezone,
+		IntervalSeconds:   schedule.Interval.Seconds(),
+		ValidFrom:         schedule.ValidFrom,
+		ValidUntil:        schedule.ValidUntil,
+		MaxExecutions:     schedule.MaxExecutions,
+		MisfirePolicy:     schedule.MisfirePolicy,
+		MisfireLimit:      schedule.MisfireLimit,
+		OverlapPolicy:     schedule.OverlapPolicy,
+		ExecutionCount:    schedule.ExecutionCount,
+		NextExecutionTime: schedule.NextExecutionTime,
+		Paused:            schedule.PausedAt != nil,
+		PausedAt:          schedule.PausedAt,
+		CreatedAt:         schedule.CreatedAt,
+		UpdatedAt:         schedule.UpdatedAt,
+	}
+}
+
+// newScheduleList converts the queue schedule list to its JSON representation
+func newScheduleList(list queue.ScheduleList) ScheduleList {
+	items := make([]Schedule, 0, len(list.Items))
+	for _, schedule := range list.Items {
+		items = append(items, newSchedule(schedule))
+	}
+
+	return ScheduleList{
+		Items:    items,
+		PageInfo: list.PageInfo,
+	}
+}
+
+// toScheduleUpdate converts the JSON request to the queue schedule update
+func (u ScheduleUpdate) toScheduleUpdate() queue.ScheduleUpdate {
+	update := queue.ScheduleUpdate{
+		Priority:      u.Priority,
+		OverlapPolicy: u.OverlapPolicy,
+	}
+	if len(u.Spec) > 0 {
+		update.Spec = queue.Spec(u.Spec)
+	}
+
+	if u.Timing != nil {
+		timing := queue.ScheduleTiming{
+			CronSchedule:  u.Timing.CronSchedule,
+			Timezone:      u.Timing.Timezone,
+			Interval:      time.Duration(u.Timing.IntervalSeconds * float64(time.Second)),
+			MaxExecutions: u.Timing.MaxExecutions,
+			MisfirePolicy: u.Timing.MisfirePolicy,
+			MisfireLimit:  u.Timing.MisfireLimit,
+		}
+		if u.Timing.ValidFrom != nil {
+			timing.ValidFrom = *u.Timing.ValidFrom
+		}
+		if u.Timing.ValidUntil != nil {
+			timing.ValidUntil = *u.Timing.ValidUntil
+		}
+		update.Timing = &timing
+	}
+
+	return update
+}
+
+// rawJSON embeds the serialized value as it is, empty values are encoded as `null`
+func rawJSON(value []byte) json.RawMessage {
+	if len(value) == 0 {
+		return nil
+	}
+	return json.RawMessage(value)
+}
@@ -0,0 +1,29 @@
+package queue
+
+import (
+	"context"
+	"errors"
+)
+
+const (
+	// DefaultQueueConcurrency is the concurrency limit of the queues without explicit settings,
+	// only one task of such queue can run at the same time.
+	DefaultQueueConcurrency = 1
+	// UnlimitedQueueConcurrency means that any number of tasks of the queue can run at the same time
+	UnlimitedQueueConcurrency = 0
+)
+
+// ErrInvalidConcurrency indicates that the given concurrency limit is not valid
+var ErrInvalidConcurrency = errors.New("queue concurrency can not be negative")
+
+// ConcurrencyLimiter manages the number of tasks of a queue that can run at the same time
+type ConcurrencyLimiter interface {
+	// SetQueueConcurrency sets the maximum number of tasks of the queue that can run at the same time.
+	// Use UnlimitedQueueConcurrency to remove the limit.
+	// ErrTaskQueueNotSpecified is returned if the queue name is empty and ErrInvalidConcurrency
+	// is returned if the limit is negative.
+	SetQueueConcurrency(ctx context.Context, queueName string, limit int) error
+	// GetQueueConcurrency returns the maximum number of tasks of the queue that can run at the same time.
+	// DefaultQueueConcurrency is returned when the queue has no explicit setting.
+	GetQueueConcurrency(ctx context.Context, queueName string) (int, error)
+}
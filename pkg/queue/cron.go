@@ -0,0 +1,118 @@
+package queue
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	cvalidation "github.com/contiamo/go-base/v4/pkg/validation"
+	"github.com/robfig/cron"
+)
+
+// ErrInvalidTimezone indicates that the schedule timezone is not a known IANA time zone
+var ErrInvalidTimezone = errors.New("invalid schedule timezone")
+
+// CronSchedule is a parsed cron expression evaluated in a time zone
+type CronSchedule struct {
+	schedule cron.Schedule
+	location *time.Location
+}
+
+// ParseCronSchedule parses the cron expression of a schedule, the expression can have
+// the leading seconds field, see validation.CronTabWithSeconds.
+// The timezone is an IANA time zone name like "Europe/Berlin",
+// the empty timezone means the local time zone of the server.
+func ParseCronSchedule(expression, timezone string) (*CronSchedule, error) {
+	location, err := LoadTimezone(timezone)
+	if err != nil {
+		return nil, err
+	}
+
+	schedule, err := cron.NewParser(cvalidation.CronFormat(expression)).Parse(expression)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse crontab: %w", err)
+	}
+
+	return &CronSchedule{schedule: schedule, location: location}, nil
+}
+
+// LoadTimezone returns the time zone with the given IANA name,
+// the empty name means the local time zone of the server.
+func LoadTimezone(timezone string) (*time.Location, error) {
+	if timezone == "" {
+		return time.Local, nil
+	}
+
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, fmt.Errorf("%w %q: %s", ErrInvalidTimezone, timezone, err)
+	}
+
+	return location, nil
+}
+
+// Next returns the first execution time after the given time, the zero time means there
+// is no execution time within the next five years.
+//
+// The expression is matched against the wall clock of the schedule time zone,
+// so "0 8 * * *" is always 08:00 local time regardless of the daylight saving time:
+//   - a wall clock time skipped when the clocks go forward is executed
+//     as if the clocks did not change yet, e.g. 02:30 becomes 03:30 in the summer time;
+//   - a wall clock time repeated when the clocks go back is executed only once,
+//     at its first occurrence.
+//
+// Fixed intervals like "@every 90s" don't depend on the time zone.
+func (s *CronSchedule) Next(after time.Time) time.Time {
+	if _, ok := s.schedule.(cron.ConstantDelaySchedule); ok {
+		return s.schedule.Next(after)
+	}
+
+	after = after.In(s.location)
+	wall := s.schedule.Next(wallClock(after))
+	for !wall.IsZero() {
+		for _, t := range instants(wall, s.location) {
+			if t.After(after) {
+				return t
+			}
+		}
+		// the only occurrence of the wall clock time is already over
+		wall = s.schedule.Next(wall)
+	}
+
+	return time.Time{}
+}
+
+// wallClock returns the wall clock time of t as UTC time, UTC has no daylight saving time,
+// so the cron expressions can be matched against it without skipping or repeating any time
+func wallClock(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+}
+
+// instants returns the ordered instants showing the wall clock time in the location:
+// one instant for the most of the times, two instants for the times repeated when the clocks go back.
+// The time skipped when the clocks go forward is converted using the offset before the change.
+func instants(wall time.Time, location *time.Location) []time.Time {
+	// the daylight saving time changes are months apart, the offsets before and after
+	// a change near the wall clock time are the offsets a day before and after it
+	_, before := wall.Add(-24 * time.Hour).In(location).Zone()
+	_, after := wall.Add(24 * time.Hour).In(location).Zone()
+
+	result := []time.Time{}
+	for _, offset := range []int{before, after} {
+		t := wall.Add(-time.Duration(offset) * time.Second).In(location)
+		if wallClock(t).Equal(wall) && (len(result) == 0 || !result[0].Equal(t)) {
+			result = append(result, t)
+		}
+	}
+
+	if len(result) == 0 {
+		// the time is skipped
+		return []time.Time{wall.Add(-time.Duration(before) * time.Second).In(location)}
+	}
+
+	if len(result) == 2 && result[1].Before(result[0]) {
+		result[0], result[1] = result[1], result[0]
+	}
+
+	return result
+}
@@ -0,0 +1,95 @@
+package queue
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCronSchedule(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	require.NoError(t, err)
+
+	next := func(t *testing.T, expression, timezone string, after time.Time, count int) []time.Time {
+		schedule, err := ParseCronSchedule(expression, timezone)
+		require.NoError(t, err)
+
+		times := []time.Time{}
+		for i := 0; i < count; i++ {
+			after = schedule.Next(after)
+			times = append(times, after)
+		}
+		return times
+	}
+
+	at := func(year int, month time.Month, day, hour, min int, location *time.Location) time.Time {
+		return time.Date(year, month, day, hour, min, 0, 0, location)
+	}
+
+	t.Run("rejects invalid expressions and time zones", func(t *testing.T) {
+		_, err := ParseCronSchedule("invalid", "")
+		require.Error(t, err)
+
+		_, err = ParseCronSchedule("0 8 * * *", "Mars/Olympus")
+		require.Error(t, err)
+		require.True(t, errors.Is(err, ErrInvalidTimezone))
+	})
+
+	t.Run("evaluates the expression in the time zone", func(t *testing.T) {
+		times := next(t, "0 8 * * *", "Europe/Berlin", at(2021, time.January, 1, 12, 0, time.UTC), 2)
+		require.Equal(t, at(2021, time.January, 2, 7, 0, time.UTC), times[0].UTC())
+		require.Equal(t, at(2021, time.January, 3, 7, 0, time.UTC), times[1].UTC())
+	})
+
+	t.Run("keeps the wall clock time when the clocks go forward", func(t *testing.T) {
+		// the clocks go forward on 28 March 2021 at 02:00
+		times := next(t, "0 8 * * *", "Europe/Berlin", at(2021, time.March, 27, 12, 0, berlin), 2)
+		require.Equal(t, at(2021, time.March, 28, 8, 0, berlin), times[0])
+		require.Equal(t, at(2021, time.March, 28, 6, 0, time.UTC), times[0].UTC())
+		require.Equal(t, at(2021, time.March, 29, 8, 0, berlin), times[1])
+	})
+
+	t.Run("keeps the wall clock time when the clocks go back", func(t *testing.T) {
+		// the clocks go back on 31 October 2021 at 03:00
+		times := next(t, "0 8 * * *", "Europe/Berlin", at(2021, time.October, 30, 12, 0, berlin), 2)
+		require.Equal(t, at(2021, time.October, 31, 7, 0, time.UTC), times[0].UTC())
+		require.Equal(t, at(2021, time.November, 1, 7, 0, time.UTC), times[1].UTC())
+	})
+
+	t.Run("executes the skipped time after the clocks go forward", func(t *testing.T) {
+		times := next(t, "30 2 * * *", "Europe/Berlin", at(2021, time.March, 27, 12, 0, berlin), 2)
+		require.Equal(t, at(2021, time.March, 28, 3, 30, berlin), times[0])
+		require.Equal(t, at(2021, time.March, 29, 2, 30, berlin), times[1])
+	})
+
+	t.Run("executes the repeated time once when the clocks go back", func(t *testing.T) {
+		times := next(t, "30 2 * * *", "Europe/Berlin", at(2021, time.October, 30, 12, 0, berlin), 2)
+		// the first 02:30 is still in the summer time
+		require.Equal(t, at(2021, time.October, 31, 0, 30, time.UTC), times[0].UTC())
+		require.Equal(t, at(2021, time.November, 1, 1, 30, time.UTC), times[1].UTC())
+	})
+
+	t.Run("repeated time is executed when the schedule starts in between", func(t *testing.T) {
+		// 02:10 in the winter time, the first 02:30 is over already
+		after := at(2021, time.October, 31, 1, 10, time.UTC)
+		times := next(t, "30 2 * * *", "Europe/Berlin", after, 1)
+		require.Equal(t, at(2021, time.October, 31, 1, 30, time.UTC), times[0].UTC())
+	})
+
+	t.Run("supports seconds", func(t *testing.T) {
+		times := next(t, "*/20 * * * * *", "UTC", at(2021, time.January, 1, 12, 0, time.UTC), 3)
+		require.Equal(t, at(2021, time.January, 1, 12, 0, time.UTC).Add(20*time.Second), times[0])
+		require.Equal(t, at(2021, time.January, 1, 12, 0, time.UTC).Add(40*time.Second), times[1])
+		require.Equal(t, at(2021, time.January, 1, 12, 1, time.UTC), times[2])
+	})
+
+	t.Run("fixed intervals ignore the time zone", func(t *testing.T) {
+		// the clocks go back within the interval
+		after := at(2021, time.October, 31, 0, 30, time.UTC)
+		times := next(t, "@every 1h", "Europe/Berlin", after, 2)
+		require.Equal(t, after.Add(time.Hour), times[0].UTC())
+		require.Equal(t, after.Add(2*time.Hour), times[1].UTC())
+	})
+}
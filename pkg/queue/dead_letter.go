@@ -0,0 +1,39 @@
+package queue
+
+import (
+	"context"
+	"time"
+)
+
+// DeadLetterFilter narrows down the set of dead-lettered tasks, zero values are ignored
+type DeadLetterFilter struct {
+	// Queue matches tasks from the given queue
+	Queue string
+	// Type matches tasks of the given type
+	Type TaskType
+	// DeadLetteredBefore matches tasks that were dead-lettered before the given time,
+	// e.g. `time.Now().Add(-7 * 24 * time.Hour)` matches dead letters older than a week
+	DeadLetteredBefore time.Time
+}
+
+// DeadLetterQueue gives access to the tasks that exhausted their attempts.
+//
+// A task is dead-lettered when it fails after using up all the attempts of its retry policy,
+// the task keeps its final progress and the last error for the triage.
+// Dead-lettered tasks are not removed by the regular retention, unlike the other failed tasks.
+type DeadLetterQueue interface {
+	// List returns the dead-lettered tasks matching the filter, the most recently
+	// dead-lettered tasks come first. Zero limit means there is no limit.
+	List(ctx context.Context, filter DeadLetterFilter, limit uint64) ([]Task, error)
+	// Get returns the dead-lettered task with the given ID.
+	// ErrTaskNotFound is returned if the task does not exist or it's not dead-lettered.
+	Get(ctx context.Context, taskID string) (*Task, error)
+	// Requeue puts the dead-lettered task back into its queue, the task is
+	// available for dequeuing immediately and its attempts start over.
+	// ErrTaskNotFound is returned if the task does not exist or it's not dead-lettered,
+	// ErrDuplicateIdempotencyKey is returned if another unfinished task in the queue has the same idempotency key.
+	Requeue(ctx context.Context, taskID string) error
+	// Purge deletes the dead-lettered tasks matching the filter
+	// and returns the number of deleted tasks.
+	Purge(ctx context.Context, filter DeadLetterFilter) (int64, error)
+}
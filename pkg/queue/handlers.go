@@ -16,3 +16,26 @@ type TaskHandlerFunc func(context.Context, Task, chan<- Progress) error
 func (f TaskHandlerFunc) Process(ctx context.Context, task Task, heartbeats chan<- Progress) error {
 	return f(ctx, task, heartbeats)
 }
+
+// ResultHandler is implemented by task handlers that produce a result.
+// Workers call ProcessWithResult instead of Process for such handlers
+// and store the returned result when the task is finished.
+type ResultHandler interface {
+	// ProcessWithResult implements the specific Task parsing logic and returns the task result
+	ProcessWithResult(ctx context.Context, task Task, heartbeats chan<- Progress) (Result, error)
+}
+
+// ResultHandlerFunc is an adapter that allows the use of a normal function
+// as a TaskHandler that produces a result
+type ResultHandlerFunc func(context.Context, Task, chan<- Progress) (Result, error)
+
+// Process implements the specific Task parsing logic, the result is discarded
+func (f ResultHandlerFunc) Process(ctx context.Context, task Task, heartbeats chan<- Progress) error {
+	_, err := f(ctx, task, heartbeats)
+	return err
+}
+
+// ProcessWithResult implements ResultHandler
+func (f ResultHandlerFunc) ProcessWithResult(ctx context.Context, task Task, heartbeats chan<- Progress) (Result, error) {
+	return f(ctx, task, heartbeats)
+}
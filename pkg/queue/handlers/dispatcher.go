@@ -28,6 +28,13 @@ type dispatchHandler struct {
 }
 
 func (h *dispatchHandler) Process(ctx context.Context, task queue.Task, heartbeats chan<- queue.Progress) (err error) {
+	_, err = h.ProcessWithResult(ctx, task, heartbeats)
+	return err
+}
+
+// ProcessWithResult implements queue.ResultHandler, the result is nil
+// when the task handler does not produce results
+func (h *dispatchHandler) ProcessWithResult(ctx context.Context, task queue.Task, heartbeats chan<- queue.Progress) (result queue.Result, err error) {
 	span, ctx := h.StartSpan(ctx, "Process")
 	defer func() {
 		h.FinishSpan(span, err)
@@ -44,7 +51,12 @@ func (h *dispatchHandler) Process(ctx context.Context, task queue.Task, heartbea
 	if !ok {
 		logrus.Error("there is no handler for this task type")
 		close(heartbeats)
-		return ErrNoHandlerFound
+		return nil, ErrNoHandlerFound
+	}
+
+	resultHandler, ok := handler.(queue.ResultHandler)
+	if !ok {
+		return nil, handler.Process(ctx, task, heartbeats)
 	}
-	return handler.Process(ctx, task, heartbeats)
+	return resultHandler.ProcessWithResult(ctx, task, heartbeats)
 }
@@ -42,6 +42,26 @@ func TestDispatcherProcess(t *testing.T) {
 		require.Equal(t, "invalid", err.Error())
 	})
 
+	t.Run("propagates the underlying handler's result", func(t *testing.T) {
+		task := queue.Task{TaskBase: queue.TaskBase{Type: "test"}}
+		otherTask := queue.Task{TaskBase: queue.TaskBase{Type: "other"}}
+
+		h := NewDispatchHandler(map[queue.TaskType]queue.TaskHandler{
+			"test": queue.ResultHandlerFunc(func(ctx context.Context, task queue.Task, heartbeats chan<- queue.Progress) (queue.Result, error) {
+				return queue.Result(`{"rows":42}`), nil
+			}),
+			"other": errHandler{t: t, expTask: otherTask},
+		})
+
+		result, err := h.(queue.ResultHandler).ProcessWithResult(ctx, task, nil)
+		require.NoError(t, err)
+		require.Equal(t, queue.Result(`{"rows":42}`), result)
+
+		result, err = h.(queue.ResultHandler).ProcessWithResult(ctx, otherTask, nil)
+		require.Error(t, err)
+		require.Nil(t, result)
+	})
+
 	t.Run("returns ErrNoHandlerFound when there is no handler and closes heartbeats", func(t *testing.T) {
 		task := queue.Task{TaskBase: queue.TaskBase{Type: "test"}}
 
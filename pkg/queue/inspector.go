@@ -0,0 +1,41 @@
+package queue
+
+import (
+	"context"
+
+	"github.com/contiamo/go-base/v4/pkg/data/managers"
+	"github.com/contiamo/go-base/v4/pkg/http/parameters"
+)
+
+// TaskList is a page of tasks
+type TaskList struct {
+	// Items contains the tasks on the requested page
+	Items []Task
+	// PageInfo contains the pagination metadata
+	PageInfo managers.PageInfo
+}
+
+// TaskCount is the number of tasks in the queue with the same status
+type TaskCount struct {
+	// Queue is the name of the queue
+	Queue string
+	// Status is the status of the counted tasks
+	Status TaskStatus
+	// Count is the number of tasks
+	Count uint32
+}
+
+// Inspector is a read-only interface for looking into the queue state
+type Inspector interface {
+	// GetTask returns the task with the given ID.
+	// ErrTaskNotFound is returned if the task does not exist.
+	GetTask(ctx context.Context, taskID string) (*Task, error)
+	// ListTasks returns the requested page of tasks matching the filter,
+	// the most recently created tasks come first.
+	ListTasks(ctx context.Context, filter TaskFilter, page parameters.Page) (TaskList, error)
+	// CountTasks returns the number of tasks matching the filter per queue and status
+	CountTasks(ctx context.Context, filter TaskFilter) ([]TaskCount, error)
+	// GetWorkflow returns the workflow with the given ID and all its tasks.
+	// ErrWorkflowNotFound is returned if the workflow has no tasks.
+	GetWorkflow(ctx context.Context, workflowID string) (*Workflow, error)
+}
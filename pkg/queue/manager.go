@@ -0,0 +1,76 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrEmptyTaskFilter indicates that the filter does not narrow down the set of tasks,
+// it's returned by the bulk operations to prevent modifying all the tasks by accident
+var ErrEmptyTaskFilter = errors.New("task filter can not be empty")
+
+// TimeRange is a time interval, the zero value of a bound means the interval is open on that side
+type TimeRange struct {
+	// From is the inclusive start of the interval
+	From time.Time
+	// To is the exclusive end of the interval
+	To time.Time
+}
+
+// IsEmpty returns true if the range has no bounds
+func (r TimeRange) IsEmpty() bool {
+	return r.From.IsZero() && r.To.IsZero()
+}
+
+// TaskFilter narrows down the set of tasks, zero values are ignored
+type TaskFilter struct {
+	// Queue matches tasks from the given queue
+	Queue string
+	// Type matches tasks of the given type
+	Type TaskType
+	// Statuses matches tasks with any of the given statuses
+	Statuses []TaskStatus
+	// References matches tasks with all the given reference values
+	References References
+	// CreatedAt matches tasks created within the time range
+	CreatedAt TimeRange
+	// FinishedAt matches tasks finished within the time range
+	FinishedAt TimeRange
+	// WorkflowID matches tasks of the given workflow
+	WorkflowID string
+}
+
+// IsEmpty returns true if the filter matches all the tasks
+func (f TaskFilter) IsEmpty() bool {
+	return f.Queue == "" &&
+		f.Type == "" &&
+		len(f.Statuses) == 0 &&
+		len(f.References) == 0 &&
+		f.CreatedAt.IsEmpty() &&
+		f.FinishedAt.IsEmpty() &&
+		f.WorkflowID == ""
+}
+
+// Manager manages the tasks in the queue
+type Manager interface {
+	// Cancel cancels the task with the given ID.
+	//
+	// A waiting task is never dequeued after this. A running task is stopped by the worker
+	// once its next heartbeat reports ErrTaskCancelled.
+	// Cancelling an already cancelled task has no effect.
+	// ErrTaskNotFound is returned if the task does not exist and ErrTaskFinished is returned
+	// if the task has already finished or failed.
+	Cancel(ctx context.Context, taskID string) error
+
+	// CancelTasks cancels all the waiting and running tasks matching the filter and returns
+	// the number of cancelled tasks. ErrEmptyTaskFilter is returned if the filter is empty.
+	CancelTasks(ctx context.Context, filter TaskFilter) (int64, error)
+
+	// Retry puts the failed or cancelled task back into its queue, the task is
+	// available for dequeuing immediately and its attempts start over.
+	// ErrTaskNotFound is returned if the task does not exist, ErrTaskNotRetryable
+	// is returned if the task has not failed or has not been cancelled and ErrDuplicateIdempotencyKey
+	// is returned if another unfinished task in the queue has the same idempotency key.
+	Retry(ctx context.Context, taskID string) error
+}
@@ -0,0 +1,350 @@
+package memory
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/contiamo/go-base/v4/pkg/queue"
+)
+
+// Dequeue implements queue.Dequeuer
+//
+// It blocks until a task is available in one of the given queues or in any queue
+// if no queues are given. The task with the highest priority is returned,
+// the oldest task wins when priorities are equal.
+func (q *Queue) Dequeue(ctx context.Context, queues ...string) (*queue.Task, error) {
+	for {
+		q.mu.Lock()
+		task, wakeup := q.claim(time.Now(), queues)
+		changed := q.changed
+		q.mu.Unlock()
+
+		if task != nil {
+			return task, nil
+		}
+
+		err := wait(ctx, changed, wakeup)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// claim starts the next available task and returns it. If there is no available task,
+// it returns the time when a task becomes available without any change,
+// e.g. a delayed task, a running task without a heartbeat or a refilled rate limit token,
+// the zero time means only a change can make a task available.
+// Must be called with the lock held.
+func (q *Queue) claim(now time.Time, queues []string) (task *queue.Task, wakeup time.Time) {
+	// assume a task has failed if there's no heartbeat after twice the ttl
+	doubleTTL := now.Add(-2 * q.cfg.HeartbeatTTL)
+
+	later := func(t time.Time) {
+		if wakeup.IsZero() || t.Before(wakeup) {
+			wakeup = t
+		}
+	}
+
+	// an abandoned task keeps its slot of the queue until it's dequeued again
+	inflight := map[string]int{}
+	for _, record := range q.tasks {
+		t := record.task
+		if t.FinishedAt != nil || t.LastHeartbeatAt == nil {
+			continue
+		}
+		inflight[strings.ToLower(t.Queue)]++
+		if t.LastHeartbeatAt.After(doubleTTL) {
+			// the running task expires and becomes available again
+			later(t.LastHeartbeatAt.Add(2 * q.cfg.HeartbeatTTL))
+		}
+	}
+
+	var candidate *taskRecord
+	for _, record := range q.tasks {
+		t := record.task
+		if t.FinishedAt != nil || t.Status == queue.Blocked || !inQueues(t.Queue, queues) || q.isPaused(t) {
+			continue
+		}
+
+		if t.StartedAt != nil && t.LastHeartbeatAt != nil && t.LastHeartbeatAt.After(doubleTTL) {
+			continue
+		}
+
+		if t.NotBefore.After(now) {
+			later(t.NotBefore)
+			continue
+		}
+
+		limit := q.queueConcurrency(t.Queue)
+		if limit != queue.UnlimitedQueueConcurrency && t.Status != queue.Running && inflight[strings.ToLower(t.Queue)] >= limit {
+			continue
+		}
+
+		queueBucket, typeBucket := q.rateBuckets(t)
+		if !hasToken(now, queueBucket, later) || !hasToken(now, typeBucket, later) {
+			continue
+		}
+
+		if candidate == nil ||
+			t.Priority > candidate.task.Priority ||
+			(t.Priority == candidate.task.Priority && record.seq < candidate.seq) {
+			candidate = record
+		}
+	}
+
+	if candidate == nil {
+		return nil, wakeup
+	}
+
+	// the claimed task takes a token from the rate limits of its queue and type
+	queueBucket, typeBucket := q.rateBuckets(candidate.task)
+	if queueBucket != nil {
+		queueBucket.take(now)
+	}
+	if typeBucket != nil {
+		typeBucket.take(now)
+	}
+
+	candidate.task.StartedAt = &now
+	candidate.task.LastHeartbeatAt = &now
+	candidate.task.UpdatedAt = now
+	candidate.task.Status = queue.Running
+	candidate.task.Attempts++
+
+	claimed := candidate.task
+	return &claimed, time.Time{}
+}
+
+// hasToken returns true if the bucket is not set or has a token available,
+// otherwise it reports the time of the next token to `later`
+func hasToken(now time.Time, bucket *rateBucket, later func(time.Time)) bool {
+	if bucket == nil || bucket.available(now) >= 1 {
+		return true
+	}
+
+	later(bucket.nextToken(now))
+	return false
+}
+
+// queueConcurrency returns the concurrency limit of the queue.
+// Must be called with the lock held.
+func (q *Queue) queueConcurrency(queueName string) int {
+	limit, ok := q.concurrency[strings.ToLower(queueName)]
+	if !ok {
+		return queue.DefaultQueueConcurrency
+	}
+	return limit
+}
+
+// Heartbeat implements queue.Dequeuer
+func (q *Queue) Heartbeat(ctx context.Context, taskID string, progress queue.Progress) error {
+	return q.updateProgress(taskID, progress, false, false, taskOutcome{})
+}
+
+// Finish implements queue.Dequeuer
+func (q *Queue) Finish(ctx context.Context, taskID string, progress queue.Progress) error {
+	return q.updateProgress(taskID, progress, true, false, taskOutcome{})
+}
+
+// Fail implements queue.Dequeuer
+func (q *Queue) Fail(ctx context.Context, taskID string, progress queue.Progress) error {
+	return q.updateProgress(taskID, progress, true, true, taskOutcome{})
+}
+
+// FinishWithResult implements queue.ResultWriter
+func (q *Queue) FinishWithResult(ctx context.Context, taskID string, progress queue.Progress, result queue.Result) error {
+	return q.updateProgress(taskID, progress, true, false, taskOutcome{result: result})
+}
+
+// FailWithError implements queue.ResultWriter
+func (q *Queue) FailWithError(ctx context.Context, taskID string, progress queue.Progress, taskErr *queue.TaskError) error {
+	return q.updateProgress(taskID, progress, true, true, taskOutcome{err: taskErr})
+}
+
+// FailAttempt implements queue.Retrier,
+// the task is moved to the dead-letter queue when it used up all the attempts of its retry policy
+func (q *Queue) FailAttempt(ctx context.Context, taskID string, progress queue.Progress, taskErr error) error {
+	// ensure that progress is always a valid JSON object
+	if len(progress) == 0 {
+		progress = emptyJSON
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	record, err := q.getRunning(taskID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	t := &record.task
+	t.Progress = progress
+	t.UpdatedAt = now
+	t.Error = queue.NewTaskError(taskErr)
+	t.LastError = ""
+	if taskErr != nil {
+		t.LastError = taskErr.Error()
+	}
+
+	policy := t.RetryPolicy
+	if policy == nil || queue.IsPermanent(taskErr) || !policy.ShouldRetry(t.Attempts) {
+		t.LastHeartbeatAt = &now
+		t.FinishedAt = &now
+		if policy != nil && !policy.ShouldRetry(t.Attempts) {
+			t.DeadLetteredAt = &now
+		}
+		q.setStatus(record, queue.Failed, now)
+		return nil
+	}
+
+	// the task becomes a regular waiting task that is delayed by the backoff
+	t.StartedAt = nil
+	t.LastHeartbeatAt = nil
+	t.NotBefore = now.Add(policy.Backoff(t.Attempts))
+	q.setStatus(record, queue.Waiting, now)
+
+	return nil
+}
+
+// taskOutcome contains the optional values stored together with the final progress
+type taskOutcome struct {
+	// result is stored when the task is finished
+	result queue.Result
+	// err is stored when the task is failed
+	err *queue.TaskError
+}
+
+func (q *Queue) updateProgress(taskID string, progress queue.Progress, isFinal, isFailed bool, outcome taskOutcome) error {
+	// ensure that progress is always a valid JSON object
+	if len(progress) == 0 {
+		progress = emptyJSON
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	record, err := q.getRunning(taskID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	t := &record.task
+	t.LastHeartbeatAt = &now
+	t.Progress = progress
+	t.UpdatedAt = now
+
+	if isFinal {
+		t.FinishedAt = &now
+	}
+
+	if isFinal && !isFailed && outcome.result != nil {
+		t.Result = outcome.result
+	}
+
+	if isFailed && outcome.err != nil {
+		t.LastError = outcome.err.Message
+		t.Error = outcome.err
+	}
+
+	status := queue.Running
+	switch {
+	case isFailed:
+		status = queue.Failed
+	case isFinal:
+		status = queue.Finished
+	}
+	q.setStatus(record, status, now)
+
+	return nil
+}
+
+// getRunning returns the task that can be worked on.
+// Must be called with the lock held.
+func (q *Queue) getRunning(taskID string) (*taskRecord, error) {
+	record := q.tasks[strings.ToLower(taskID)]
+	if record == nil {
+		return nil, queue.ErrTaskNotFound
+	}
+
+	switch record.task.Status {
+	case queue.Waiting, queue.Blocked:
+		return nil, queue.ErrTaskNotRunning
+	case queue.Cancelled:
+		return nil, queue.ErrTaskCancelled
+	case queue.Finished, queue.Failed:
+		return nil, queue.ErrTaskFinished
+	}
+
+	return record, nil
+}
+
+// setStatus changes the status of the task, the tasks depending on the task
+// are resolved when the task is over: a blocked task becomes waiting when all the tasks
+// it depends on are finished and it's cancelled as soon as any of them fails or is cancelled.
+// Must be called with the lock held.
+func (q *Queue) setStatus(record *taskRecord, status queue.TaskStatus, now time.Time) {
+	previous := record.task.Status
+	record.task.Status = status
+	record.task.UpdatedAt = now
+	q.notify()
+
+	if previous == status || !status.IsFinal() {
+		return
+	}
+
+	for _, dependant := range q.tasks {
+		if dependant.task.Status != queue.Blocked || !dependsOn(dependant.task, record.task.ID) {
+			continue
+		}
+
+		if status != queue.Finished {
+			dependant.task.FinishedAt = &now
+			dependant.task.LastError = "dependency " + record.task.ID + " is " + string(status)
+			q.setStatus(dependant, queue.Cancelled, now)
+			continue
+		}
+
+		if q.dependenciesFinished(dependant.task) {
+			q.setStatus(dependant, queue.Waiting, now)
+		}
+	}
+}
+
+// dependenciesFinished returns true when all the tasks the task depends on are finished.
+// Must be called with the lock held.
+func (q *Queue) dependenciesFinished(task queue.Task) bool {
+	for _, dependency := range task.DependsOn {
+		parent := q.tasks[strings.ToLower(dependency)]
+		if parent != nil && parent.task.Status != queue.Finished {
+			return false
+		}
+	}
+	return true
+}
+
+// dependsOn returns true if the task depends on the task with the given ID
+func dependsOn(task queue.Task, taskID string) bool {
+	for _, dependency := range task.DependsOn {
+		if strings.EqualFold(dependency, taskID) {
+			return true
+		}
+	}
+	return false
+}
+
+// inQueues returns true if the queue is one of the given queues,
+// all the queues match when no queues are given
+func inQueues(queueName string, queues []string) bool {
+	if len(queues) == 0 {
+		return true
+	}
+	for _, name := range queues {
+		if strings.EqualFold(name, queueName) {
+			return true
+		}
+	}
+	return false
+}
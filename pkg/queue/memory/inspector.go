@@ -0,0 +1,479 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/contiamo/go-base/v4/pkg/http/parameters"
+	"github.com/contiamo/go-base/v4/pkg/queue"
+)
+
+// GetTask implements queue.Inspector
+func (q *Queue) GetTask(ctx context.Context, taskID string) (*queue.Task, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	record := q.tasks[strings.ToLower(taskID)]
+	if record == nil {
+		return nil, queue.ErrTaskNotFound
+	}
+
+	task := record.task
+	return &task, nil
+}
+
+// ListTasks implements queue.Inspector
+func (q *Queue) ListTasks(ctx context.Context, filter queue.TaskFilter, page parameters.Page) (list queue.TaskList, err error) {
+	if page.Number < 1 {
+		page.Number = 1
+	}
+	if page.Size < 1 {
+		page.Size = parameters.DefaultPageSize
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	records := q.filterTasks(func(record *taskRecord) bool {
+		return matchesTaskFilter(record, filter)
+	})
+
+	// the most recently created tasks come first
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].seq > records[j].seq
+	})
+
+	list.PageInfo.ItemsPerPage = page.Size
+	list.PageInfo.Current = page.Number
+	list.PageInfo.ItemCount = uint32(len(records))
+	list.PageInfo.UnfilteredItemCount = uint32(len(q.tasks))
+
+	list.Items = make([]queue.Task, 0, page.Size)
+	for i := int((page.Number - 1) * page.Size); i < len(records) && len(list.Items) < int(page.Size); i++ {
+		list.Items = append(list.Items, records[i].task)
+	}
+
+	return list, nil
+}
+
+// CountTasks implements queue.Inspector
+func (q *Queue) CountTasks(ctx context.Context, filter queue.TaskFilter) ([]queue.TaskCount, error) {
+	type countKey struct {
+		queue  string
+		status queue.TaskStatus
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	// queue names are case-insensitive, the first seen name is reported
+	names := map[string]string{}
+	counts := map[countKey]uint32{}
+	for _, record := range q.tasks {
+		if !matchesTaskFilter(record, filter) {
+			continue
+		}
+
+		name := strings.ToLower(record.task.Queue)
+		if _, ok := names[name]; !ok {
+			names[name] = record.task.Queue
+		}
+		counts[countKey{queue: name, status: record.task.Status}]++
+	}
+
+	result := make([]queue.TaskCount, 0, len(counts))
+	for key, count := range counts {
+		result = append(result, queue.TaskCount{
+			Queue:  names[key.queue],
+			Status: key.status,
+			Count:  count,
+		})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if !strings.EqualFold(result[i].Queue, result[j].Queue) {
+			return strings.ToLower(result[i].Queue) < strings.ToLower(result[j].Queue)
+		}
+		return result[i].Status < result[j].Status
+	})
+
+	return result, nil
+}
+
+// GetWorkflow implements queue.Inspector
+func (q *Queue) GetWorkflow(ctx context.Context, workflowID string) (*queue.Workflow, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	records := q.filterTasks(func(record *taskRecord) bool {
+		return record.task.WorkflowID == workflowID
+	})
+
+	if len(records) == 0 {
+		return nil, queue.ErrWorkflowNotFound
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].seq < records[j].seq
+	})
+
+	tasks := make([]queue.Task, 0, len(records))
+	for _, record := range records {
+		tasks = append(tasks, record.task)
+	}
+
+	return queue.NewWorkflow(workflowID, tasks), nil
+}
+
+// WaitFor implements queue.Waiter
+func (q *Queue) WaitFor(ctx context.Context, taskID string) (*queue.Task, error) {
+	for {
+		q.mu.Lock()
+		record := q.tasks[strings.ToLower(taskID)]
+		changed := q.changed
+		var task queue.Task
+		if record != nil {
+			task = record.task
+		}
+		q.mu.Unlock()
+
+		if record == nil {
+			return nil, queue.ErrTaskNotFound
+		}
+
+		if task.Status.IsFinal() {
+			return &task, nil
+		}
+
+		err := wait(ctx, changed, time.Time{})
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Cancel implements queue.Manager
+func (q *Queue) Cancel(ctx context.Context, taskID string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	record := q.tasks[strings.ToLower(taskID)]
+	if record == nil {
+		return queue.ErrTaskNotFound
+	}
+
+	switch record.task.Status {
+	case queue.Cancelled:
+		return nil
+	case queue.Finished, queue.Failed:
+		return queue.ErrTaskFinished
+	}
+
+	now := time.Now()
+	record.task.FinishedAt = &now
+	q.setStatus(record, queue.Cancelled, now)
+
+	return nil
+}
+
+// CancelTasks implements queue.Manager
+func (q *Queue) CancelTasks(ctx context.Context, filter queue.TaskFilter) (int64, error) {
+	if filter.IsEmpty() {
+		return 0, queue.ErrEmptyTaskFilter
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.cancelTasks(filter, time.Now()), nil
+}
+
+// cancelTasks cancels the unfinished tasks matching the filter and returns their number.
+// Must be called with the lock held.
+func (q *Queue) cancelTasks(filter queue.TaskFilter, now time.Time) int64 {
+	// the matching tasks are selected before any of them is cancelled,
+	// the dependants cancelled together with their dependencies are not counted
+	records := q.filterTasks(func(record *taskRecord) bool {
+		switch record.task.Status {
+		case queue.Waiting, queue.Running, queue.Blocked:
+			return record.task.FinishedAt == nil && matchesTaskFilter(record, filter)
+		}
+		return false
+	})
+
+	var cancelled int64
+	for _, record := range records {
+		if record.task.FinishedAt != nil {
+			continue
+		}
+		record.task.FinishedAt = &now
+		q.setStatus(record, queue.Cancelled, now)
+		cancelled++
+	}
+
+	return cancelled
+}
+
+// Retry implements queue.Manager
+func (q *Queue) Retry(ctx context.Context, taskID string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	record := q.tasks[strings.ToLower(taskID)]
+	if record == nil {
+		return queue.ErrTaskNotFound
+	}
+
+	if record.task.Status != queue.Failed && record.task.Status != queue.Cancelled {
+		return queue.ErrTaskNotRetryable
+	}
+
+	if q.hasUnfinishedDuplicate(record) {
+		return queue.ErrDuplicateIdempotencyKey
+	}
+
+	q.reset(record, time.Now())
+
+	return nil
+}
+
+// DeadLetters returns the dead-letter queue of the failed tasks
+func (q *Queue) DeadLetters() queue.DeadLetterQueue {
+	return &deadLetterQueue{q: q}
+}
+
+// deadLetterQueue is an in-memory implementation of the queue DeadLetterQueue,
+// the dead-lettered tasks are the failed tasks with a dead-lettered timestamp.
+type deadLetterQueue struct {
+	q *Queue
+}
+
+func (d *deadLetterQueue) List(ctx context.Context, filter queue.DeadLetterFilter, limit uint64) ([]queue.Task, error) {
+	d.q.mu.Lock()
+	defer d.q.mu.Unlock()
+
+	records := d.q.filterTasks(func(record *taskRecord) bool {
+		return matchesDeadLetterFilter(record, filter)
+	})
+
+	// the most recently dead-lettered tasks come first
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].task.DeadLetteredAt.After(*records[j].task.DeadLetteredAt)
+	})
+
+	if limit > 0 && uint64(len(records)) > limit {
+		records = records[:limit]
+	}
+
+	var tasks []queue.Task
+	for _, record := range records {
+		tasks = append(tasks, record.task)
+	}
+
+	return tasks, nil
+}
+
+func (d *deadLetterQueue) Get(ctx context.Context, taskID string) (*queue.Task, error) {
+	d.q.mu.Lock()
+	defer d.q.mu.Unlock()
+
+	record := d.q.tasks[strings.ToLower(taskID)]
+	if record == nil || record.task.DeadLetteredAt == nil {
+		return nil, queue.ErrTaskNotFound
+	}
+
+	task := record.task
+	return &task, nil
+}
+
+func (d *deadLetterQueue) Requeue(ctx context.Context, taskID string) error {
+	d.q.mu.Lock()
+	defer d.q.mu.Unlock()
+
+	record := d.q.tasks[strings.ToLower(taskID)]
+	if record == nil || record.task.DeadLetteredAt == nil {
+		return queue.ErrTaskNotFound
+	}
+
+	if d.q.hasUnfinishedDuplicate(record) {
+		return queue.ErrDuplicateIdempotencyKey
+	}
+
+	d.q.reset(record, time.Now())
+
+	return nil
+}
+
+func (d *deadLetterQueue) Purge(ctx context.Context, filter queue.DeadLetterFilter) (int64, error) {
+	d.q.mu.Lock()
+	defer d.q.mu.Unlock()
+
+	records := d.q.filterTasks(func(record *taskRecord) bool {
+		return matchesDeadLetterFilter(record, filter)
+	})
+
+	for _, record := range records {
+		delete(d.q.tasks, record.task.ID)
+	}
+
+	return int64(len(records)), nil
+}
+
+// reset sets the task to the state of a newly enqueued task,
+// the last error is kept for the reference until the next failure.
+// The task is blocked again until all the tasks it depends on are finished.
+// Must be called with the lock held.
+func (q *Queue) reset(record *taskRecord, now time.Time) {
+	t := &record.task
+	t.Progress = emptyJSON
+	t.Attempts = 0
+	t.NotBefore = now
+	t.StartedAt = nil
+	t.FinishedAt = nil
+	t.LastHeartbeatAt = nil
+	t.DeadLetteredAt = nil
+	t.Result = nil
+	t.Error = nil
+
+	status := queue.Waiting
+	if !q.dependenciesFinished(*t) {
+		status = queue.Blocked
+	}
+	q.setStatus(record, status, now)
+}
+
+// hasUnfinishedDuplicate returns true if another unfinished task in the queue of the task
+// has the same idempotency key, so the task can not be reset.
+// Must be called with the lock held.
+func (q *Queue) hasUnfinishedDuplicate(record *taskRecord) bool {
+	if record.idempotencyKey == "" {
+		return false
+	}
+
+	for _, other := range q.tasks {
+		if other != record &&
+			other.task.FinishedAt == nil &&
+			other.idempotencyKey == record.idempotencyKey &&
+			strings.EqualFold(other.task.Queue, record.task.Queue) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// filterTasks returns the tasks matching the predicate.
+// Must be called with the lock held.
+func (q *Queue) filterTasks(matches func(*taskRecord) bool) []*taskRecord {
+	records := []*taskRecord{}
+	for _, record := range q.tasks {
+		if matches(record) {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+// matchesTaskFilter returns true if the task is selected by the filter
+func matchesTaskFilter(record *taskRecord, filter queue.TaskFilter) bool {
+	t := record.task
+
+	if filter.Queue != "" && !strings.EqualFold(filter.Queue, t.Queue) {
+		return false
+	}
+
+	if filter.Type != "" && !strings.EqualFold(filter.Type.String(), t.Type.String()) {
+		return false
+	}
+
+	if len(filter.Statuses) > 0 && !hasStatus(filter.Statuses, t.Status) {
+		return false
+	}
+
+	if !matchesReferences(record.references, filter.References) {
+		return false
+	}
+
+	if filter.WorkflowID != "" && filter.WorkflowID != t.WorkflowID {
+		return false
+	}
+
+	if !inTimeRange(&t.CreatedAt, filter.CreatedAt) {
+		return false
+	}
+
+	if !inTimeRange(t.FinishedAt, filter.FinishedAt) {
+		return false
+	}
+
+	return true
+}
+
+// matchesDeadLetterFilter returns true if the task is dead-lettered and selected by the filter
+func matchesDeadLetterFilter(record *taskRecord, filter queue.DeadLetterFilter) bool {
+	t := record.task
+
+	if t.DeadLetteredAt == nil {
+		return false
+	}
+
+	if filter.Queue != "" && !strings.EqualFold(filter.Queue, t.Queue) {
+		return false
+	}
+
+	if filter.Type != "" && !strings.EqualFold(filter.Type.String(), t.Type.String()) {
+		return false
+	}
+
+	if !filter.DeadLetteredBefore.IsZero() && !t.DeadLetteredAt.Before(filter.DeadLetteredBefore) {
+		return false
+	}
+
+	return true
+}
+
+// matchesReferences returns true if the references contain all the expected values,
+// the values are compared by their string representation like they are stored in SQL columns
+func matchesReferences(references, expected queue.References) bool {
+	for name, value := range expected {
+		actual, ok := references[name]
+		if !ok || fmt.Sprint(actual) != fmt.Sprint(value) {
+			return false
+		}
+	}
+	return true
+}
+
+// inTimeRange returns true if the time is within the range, nil time is never within a range
+func inTimeRange(t *time.Time, r queue.TimeRange) bool {
+	if r.IsEmpty() {
+		return true
+	}
+
+	if t == nil {
+		return false
+	}
+
+	if !r.From.IsZero() && t.Before(r.From) {
+		return false
+	}
+
+	if !r.To.IsZero() && !t.Before(r.To) {
+		return false
+	}
+
+	return true
+}
+
+// hasStatus returns true if the status is one of the given statuses
+func hasStatus(statuses []queue.TaskStatus, status queue.TaskStatus) bool {
+	for _, s := range statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,85 @@
+package memory
+
+import (
+	"context"
+	"strings"
+
+	"github.com/contiamo/go-base/v4/pkg/queue"
+)
+
+// PauseQueue implements queue.Pauser
+func (q *Queue) PauseQueue(ctx context.Context, queueName string) error {
+	if queueName == "" {
+		return queue.ErrTaskQueueNotSpecified
+	}
+
+	q.setPaused(q.pausedQueues, queueName, true)
+	return nil
+}
+
+// ResumeQueue implements queue.Pauser
+func (q *Queue) ResumeQueue(ctx context.Context, queueName string) error {
+	if queueName == "" {
+		return queue.ErrTaskQueueNotSpecified
+	}
+
+	q.setPaused(q.pausedQueues, queueName, false)
+	return nil
+}
+
+// IsQueuePaused implements queue.Pauser
+func (q *Queue) IsQueuePaused(ctx context.Context, queueName string) (bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.pausedQueues[strings.ToLower(queueName)], nil
+}
+
+// PauseTaskType implements queue.Pauser
+func (q *Queue) PauseTaskType(ctx context.Context, taskType queue.TaskType) error {
+	if taskType == "" {
+		return queue.ErrTaskTypeNotSpecified
+	}
+
+	q.setPaused(q.pausedTypes, taskType.String(), true)
+	return nil
+}
+
+// ResumeTaskType implements queue.Pauser
+func (q *Queue) ResumeTaskType(ctx context.Context, taskType queue.TaskType) error {
+	if taskType == "" {
+		return queue.ErrTaskTypeNotSpecified
+	}
+
+	q.setPaused(q.pausedTypes, taskType.String(), false)
+	return nil
+}
+
+// IsTaskTypePaused implements queue.Pauser
+func (q *Queue) IsTaskTypePaused(ctx context.Context, taskType queue.TaskType) (bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.pausedTypes[strings.ToLower(taskType.String())], nil
+}
+
+// setPaused pauses or resumes the queue or task type with the given name,
+// the waiting dequeuers are woken up when it's resumed
+func (q *Queue) setPaused(paused map[string]bool, name string, pause bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if pause {
+		paused[strings.ToLower(name)] = true
+		return
+	}
+
+	delete(paused, strings.ToLower(name))
+	q.notify()
+}
+
+// isPaused returns true if the queue or the type of the task is paused.
+// Must be called with the lock held.
+func (q *Queue) isPaused(t queue.Task) bool {
+	return q.pausedQueues[strings.ToLower(t.Queue)] || q.pausedTypes[strings.ToLower(t.Type.String())]
+}
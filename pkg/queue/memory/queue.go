@@ -0,0 +1,340 @@
+// Package memory provides an in-memory implementation of the task queue.
+//
+// The in-memory queue has the same semantics as the Postgres queue: tasks are dequeued
+// by priority and age, one task per queue runs at a time unless the queue has a different
+// concurrency limit, the tasks of the paused queues and task types are not dequeued,
+// the tasks of the rate limited queues and task types wait for a token,
+// running tasks without a heartbeat expire and become available again,
+// tasks can be cancelled, retried, depend on each other and be scheduled with cron expressions.
+//
+// It's meant for unit tests and local development, the tasks are lost when the process exits.
+package memory
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/contiamo/go-base/v4/pkg/config"
+	cdb "github.com/contiamo/go-base/v4/pkg/db"
+	"github.com/contiamo/go-base/v4/pkg/queue"
+	"github.com/pkg/errors"
+	uuid "github.com/satori/go.uuid"
+)
+
+// defaultHeartbeatTTL is used when the heartbeat TTL is not configured
+const defaultHeartbeatTTL = 15 * time.Second
+
+var emptyJSON = []byte("{}")
+
+// Options controls how the in-memory queue behaves
+type Options struct {
+	// RetryPolicies contains the default retry policies per task type.
+	// A default policy is used when the enqueued task does not specify its own retry policy.
+	RetryPolicies map[queue.TaskType]queue.RetryPolicy
+	// IdempotencyWindow is how long a finished task keeps deduplicating
+	// the enqueued tasks with the same idempotency key.
+	// The zero value means only the tasks that are not finished yet are deduplicated.
+	// Failed and cancelled tasks never deduplicate after they are over, so they can be re-enqueued.
+	IdempotencyWindow time.Duration
+}
+
+// NewQueue creates a new empty in-memory queue.
+//
+// A running task is considered abandoned when it has no heartbeat for twice `cfg.HeartbeatTTL`,
+// such task becomes available for dequeuing again.
+func NewQueue(cfg config.Queue, opts Options) *Queue {
+	if cfg.HeartbeatTTL <= 0 {
+		cfg.HeartbeatTTL = defaultHeartbeatTTL
+	}
+
+	return &Queue{
+		cfg:          cfg,
+		opts:         opts,
+		tasks:        map[string]*taskRecord{},
+		schedules:    map[string]*scheduleRecord{},
+		concurrency:  map[string]int{},
+		pausedQueues: map[string]bool{},
+		pausedTypes:  map[string]bool{},
+		queueRates:   map[string]*rateBucket{},
+		typeRates:    map[string]*rateBucket{},
+		changed:      make(chan struct{}),
+	}
+}
+
+// Queue is an in-memory task queue, it implements queue.Queuer, queue.Dequeuer,
+// queue.Retrier, queue.ResultWriter, queue.Waiter, queue.Inspector, queue.Manager,
+// queue.ConcurrencyLimiter, queue.Pauser, queue.RateLimiter, queue.Scheduler and queue.ScheduleManager
+// using the same storage.
+// See DeadLetters for the dead-letter queue.
+type Queue struct {
+	cfg  config.Queue
+	opts Options
+
+	mu sync.Mutex
+	// tasks contains the tasks by their ID
+	tasks map[string]*taskRecord
+	// schedules contains the schedules by their ID
+	schedules map[string]*scheduleRecord
+	// concurrency contains the explicit concurrency limits by the lower-cased queue name
+	concurrency map[string]int
+	// pausedQueues contains the lower-cased names of the paused queues
+	pausedQueues map[string]bool
+	// pausedTypes contains the lower-cased paused task types
+	pausedTypes map[string]bool
+	// queueRates contains the token buckets of the rate limited queues by the lower-cased queue name
+	queueRates map[string]*rateBucket
+	// typeRates contains the token buckets of the rate limited task types by the lower-cased type
+	typeRates map[string]*rateBucket
+	// seq is the sequence number of the last created task or schedule,
+	// it orders the items created at the same time
+	seq uint64
+	// changed is closed and replaced on every change of the tasks,
+	// it wakes up the waiting dequeuers and waiters
+	changed chan struct{}
+}
+
+// taskRecord is a stored task with the values that are not exposed by queue.Task
+type taskRecord struct {
+	task           queue.Task
+	references     queue.References
+	idempotencyKey string
+	seq            uint64
+}
+
+// SetQueueConcurrency implements queue.ConcurrencyLimiter
+func (q *Queue) SetQueueConcurrency(ctx context.Context, queueName string, limit int) error {
+	if queueName == "" {
+		return queue.ErrTaskQueueNotSpecified
+	}
+
+	if limit < 0 {
+		return queue.ErrInvalidConcurrency
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.concurrency[strings.ToLower(queueName)] = limit
+	q.notify()
+
+	return nil
+}
+
+// GetQueueConcurrency implements queue.ConcurrencyLimiter
+func (q *Queue) GetQueueConcurrency(ctx context.Context, queueName string) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.queueConcurrency(queueName), nil
+}
+
+// Enqueue implements queue.Queuer
+//
+// When the task has an idempotency key and a task with the same key exists in the queue,
+// the ID of the existing task is returned and nothing is added.
+func (q *Queue) Enqueue(ctx context.Context, task queue.TaskEnqueueRequest) (taskID string, err error) {
+	taskIDs, err := q.EnqueueBatch(ctx, []queue.TaskEnqueueRequest{task})
+	if err != nil {
+		return "", errors.Cause(err)
+	}
+
+	return taskIDs[0], nil
+}
+
+// EnqueueTx implements queue.Queuer
+//
+// The in-memory queue can't take part in a SQL transaction,
+// the builder is ignored and the task is added right away.
+func (q *Queue) EnqueueTx(ctx context.Context, builder cdb.SQLBuilder, task queue.TaskEnqueueRequest) (taskID string, err error) {
+	return q.Enqueue(ctx, task)
+}
+
+// EnqueueBatch implements queue.Queuer
+func (q *Queue) EnqueueBatch(ctx context.Context, tasks []queue.TaskEnqueueRequest) (taskIDs []string, err error) {
+	prepared := make([]queue.TaskEnqueueRequest, 0, len(tasks))
+	for i, task := range tasks {
+		p, err := q.prepare(task)
+		if err != nil {
+			return nil, errors.Wrapf(err, "task %d", i)
+		}
+		prepared = append(prepared, p)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	// all the dependencies are checked before anything is added
+	for i, task := range prepared {
+		for _, dependency := range task.DependsOn {
+			if q.tasks[strings.ToLower(dependency)] == nil {
+				return nil, errors.Wrapf(queue.ErrTaskDependencyNotFound, "task %d", i)
+			}
+		}
+	}
+
+	now := time.Now()
+	taskIDs = make([]string, 0, len(prepared))
+	for _, task := range prepared {
+		existing := q.findDuplicate(task, now)
+		if existing != nil {
+			taskIDs = append(taskIDs, existing.task.ID)
+			continue
+		}
+
+		record := q.add(task, now)
+		taskIDs = append(taskIDs, record.task.ID)
+	}
+
+	if len(taskIDs) > 0 {
+		q.notify()
+	}
+
+	return taskIDs, nil
+}
+
+// prepare validates the task and sets the default values
+func (q *Queue) prepare(task queue.TaskEnqueueRequest) (queue.TaskEnqueueRequest, error) {
+	if task.Queue == "" {
+		return task, queue.ErrTaskQueueNotSpecified
+	}
+
+	if task.Type == "" {
+		return task, queue.ErrTaskTypeNotSpecified
+	}
+
+	if task.Spec == nil {
+		task.Spec = emptyJSON
+	}
+
+	if task.NotBefore.IsZero() {
+		task.NotBefore = time.Now()
+	}
+
+	// a malformed ID can't belong to an existing task
+	for _, dependency := range task.DependsOn {
+		_, err := uuid.FromString(dependency)
+		if err != nil {
+			return task, queue.ErrTaskDependencyNotFound
+		}
+	}
+
+	if task.RetryPolicy == nil {
+		policy, ok := q.opts.RetryPolicies[task.Type]
+		if ok {
+			task.RetryPolicy = &policy
+		}
+	}
+
+	if task.RetryPolicy != nil {
+		err := task.RetryPolicy.Validate()
+		if err != nil {
+			return task, err
+		}
+	}
+
+	return task, nil
+}
+
+// findDuplicate returns the latest task deduplicating the given task, nil if there is none.
+// Must be called with the lock held.
+func (q *Queue) findDuplicate(task queue.TaskEnqueueRequest, now time.Time) *taskRecord {
+	if task.IdempotencyKey == "" {
+		return nil
+	}
+
+	var latest *taskRecord
+	for _, record := range q.tasks {
+		if record.idempotencyKey != task.IdempotencyKey || !strings.EqualFold(record.task.Queue, task.Queue) {
+			continue
+		}
+
+		deduplicating := record.task.FinishedAt == nil ||
+			(q.opts.IdempotencyWindow > 0 &&
+				record.task.Status == queue.Finished &&
+				record.task.FinishedAt.After(now.Add(-q.opts.IdempotencyWindow)))
+		if !deduplicating {
+			continue
+		}
+
+		if latest == nil || record.seq > latest.seq {
+			latest = record
+		}
+	}
+
+	return latest
+}
+
+// add stores the new task, its initial status depends on the status of its dependencies:
+// the task is blocked until all of them are finished and it's cancelled right away
+// if any of them has failed or has been cancelled.
+// Must be called with the lock held.
+func (q *Queue) add(task queue.TaskEnqueueRequest, now time.Time) *taskRecord {
+	q.seq++
+	record := &taskRecord{
+		task: queue.Task{
+			TaskBase:    task.TaskBase,
+			ID:          uuid.NewV4().String(),
+			Status:      queue.Waiting,
+			Progress:    emptyJSON,
+			CreatedAt:   now,
+			UpdatedAt:   now,
+			NotBefore:   task.NotBefore,
+			RetryPolicy: task.RetryPolicy,
+			DependsOn:   task.DependsOn,
+			WorkflowID:  task.WorkflowID,
+		},
+		references:     task.References,
+		idempotencyKey: task.IdempotencyKey,
+		seq:            q.seq,
+	}
+	if !task.ScheduledAt.IsZero() {
+		scheduledAt := task.ScheduledAt
+		record.task.ScheduledAt = &scheduledAt
+	}
+
+	for _, dependency := range task.DependsOn {
+		status := q.tasks[strings.ToLower(dependency)].task.Status
+		switch {
+		case status == queue.Failed || status == queue.Cancelled:
+			record.task.Status = queue.Cancelled
+			record.task.FinishedAt = &now
+			record.task.LastError = fmt.Sprintf("dependency %s is %s", dependency, status)
+		case status != queue.Finished && record.task.Status != queue.Cancelled:
+			record.task.Status = queue.Blocked
+		}
+	}
+
+	q.tasks[record.task.ID] = record
+
+	return record
+}
+
+// notify wakes up everyone waiting for a change.
+// Must be called with the lock held.
+func (q *Queue) notify() {
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
+
+// wait blocks until the next change, the given time or until the context is done.
+// The zero time means there is no time limit.
+func wait(ctx context.Context, changed <-chan struct{}, until time.Time) error {
+	var timeout <-chan time.Time
+	if !until.IsZero() {
+		timer := time.NewTimer(time.Until(until))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-changed:
+	case <-timeout:
+	}
+
+	return nil
+}
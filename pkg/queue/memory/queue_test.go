@@ -0,0 +1,196 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/contiamo/go-base/v4/pkg/config"
+	"github.com/contiamo/go-base/v4/pkg/http/parameters"
+	"github.com/contiamo/go-base/v4/pkg/queue"
+	"github.com/contiamo/go-base/v4/pkg/queue/queuetest"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
+)
+
+func TestConformance(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	queuetest.Run(t, func(t *testing.T, cfg config.Queue) queuetest.Backend {
+		q := NewQueue(cfg, Options{})
+		return queuetest.Backend{
+			Queuer:             q,
+			Dequeuer:           q,
+			Inspector:          q,
+			Manager:            q,
+			ConcurrencyLimiter: q,
+			Pauser:             q,
+			RateLimiter:        q,
+			Scheduler:          q,
+			ScheduleManager:    q,
+			ScheduleWorker:     NewScheduleWorker(q, cfg.PollFrequency),
+		}
+	})
+}
+
+func TestHeartbeatExpiry(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	q := NewQueue(config.Queue{HeartbeatTTL: time.Minute}, Options{})
+
+	taskID, err := q.Enqueue(ctx, queue.TaskEnqueueRequest{
+		TaskBase: queue.TaskBase{Queue: "queue1", Type: "test"},
+	})
+	require.NoError(t, err)
+
+	now := time.Now()
+	task, _ := q.claim(now, nil)
+	require.NotNil(t, task)
+
+	task, wakeup := q.claim(now, nil)
+	require.Nil(t, task)
+	require.Equal(t, now.Add(2*time.Minute), wakeup)
+
+	// the task without a heartbeat for twice the ttl is dequeued again
+	task, _ = q.claim(now.Add(2*time.Minute+time.Second), nil)
+	require.NotNil(t, task)
+	require.Equal(t, taskID, task.ID)
+	require.Equal(t, 2, task.Attempts)
+}
+
+func TestDeadLetters(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	q := NewQueue(config.Queue{}, Options{
+		RetryPolicies: map[queue.TaskType]queue.RetryPolicy{
+			"retried": {MaxAttempts: 2},
+		},
+	})
+	dlq := q.DeadLetters()
+
+	enqueue := func(queueName string, taskType queue.TaskType) string {
+		taskID, err := q.Enqueue(ctx, queue.TaskEnqueueRequest{
+			TaskBase: queue.TaskBase{Queue: queueName, Type: taskType},
+		})
+		require.NoError(t, err)
+		return taskID
+	}
+
+	dequeue := func(queueName string) *queue.Task {
+		task, _ := q.claim(time.Now(), []string{queueName})
+		require.NotNil(t, task)
+		return task
+	}
+
+	failedID := enqueue("queue1", "test")
+	retriedID := enqueue("queue2", "retried")
+	purgedID := enqueue("queue3", "retried")
+
+	// the task without a retry policy fails without being dead-lettered
+	dequeue("queue1")
+	require.NoError(t, q.Fail(ctx, failedID, nil))
+	task, err := q.GetTask(ctx, failedID)
+	require.NoError(t, err)
+	require.Equal(t, queue.Failed, task.Status)
+	require.Nil(t, task.DeadLetteredAt)
+	_, err = dlq.Get(ctx, failedID)
+	require.Equal(t, queue.ErrTaskNotFound, err)
+
+	dequeue("queue2")
+	require.NoError(t, q.FailAttempt(ctx, retriedID, nil, errors.New("first")))
+	task, err = q.GetTask(ctx, retriedID)
+	require.NoError(t, err)
+	require.Equal(t, queue.Waiting, task.Status)
+	require.Nil(t, task.DeadLetteredAt)
+
+	// the second attempt is the last one
+	q.mu.Lock()
+	q.tasks[retriedID].task.NotBefore = time.Now()
+	q.mu.Unlock()
+	dequeue("queue2")
+	require.NoError(t, q.FailAttempt(ctx, retriedID, nil, errors.New("second")))
+
+	tasks, err := dlq.List(ctx, queue.DeadLetterFilter{}, 0)
+	require.NoError(t, err)
+	require.Len(t, tasks, 1)
+	require.Equal(t, retriedID, tasks[0].ID)
+	require.Equal(t, "second", tasks[0].LastError)
+
+	require.NoError(t, dlq.Requeue(ctx, retriedID))
+	_, err = dlq.Get(ctx, retriedID)
+	require.Equal(t, queue.ErrTaskNotFound, err)
+	task, err = q.GetTask(ctx, retriedID)
+	require.NoError(t, err)
+	require.Equal(t, queue.Waiting, task.Status)
+
+	for _, taskErr := range []string{"first", "second"} {
+		q.mu.Lock()
+		q.tasks[purgedID].task.NotBefore = time.Now()
+		q.mu.Unlock()
+		dequeue("queue3")
+		require.NoError(t, q.FailAttempt(ctx, purgedID, nil, errors.New(taskErr)))
+	}
+
+	purged, err := dlq.Purge(ctx, queue.DeadLetterFilter{Type: "retried"})
+	require.NoError(t, err)
+	require.EqualValues(t, 1, purged)
+	_, err = q.GetTask(ctx, purgedID)
+	require.Equal(t, queue.ErrTaskNotFound, err)
+}
+
+func TestEnqueueScheduled(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	q := NewQueue(config.Queue{}, Options{})
+
+	cronTask := queue.TaskScheduleRequest{
+		TaskBase:     queue.TaskBase{Queue: "queue1", Type: "cron"},
+		CronSchedule: "@every 1h",
+	}
+	require.NoError(t, q.AssertSchedule(ctx, cronTask))
+	// the same schedule is not added twice
+	require.NoError(t, q.AssertSchedule(ctx, cronTask))
+	require.NoError(t, q.Schedule(ctx, nil, queue.TaskScheduleRequest{
+		TaskBase: queue.TaskBase{Queue: "queue1", Type: "once"},
+	}))
+	require.NoError(t, q.EnsureSchedule(ctx, nil, cronTask))
+
+	schedules, err := q.ListSchedules(ctx, queue.ScheduleFilter{}, parameters.Page{})
+	require.NoError(t, err)
+	require.Len(t, schedules.Items, 2)
+
+	enqueued, err := q.EnqueueScheduled(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 2, enqueued)
+
+	// nothing is due anymore
+	enqueued, err = q.EnqueueScheduled(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 0, enqueued)
+
+	schedules, err = q.ListSchedules(ctx, queue.ScheduleFilter{Type: "cron"}, parameters.Page{})
+	require.NoError(t, err)
+	require.Len(t, schedules.Items, 1)
+	cronSchedule := schedules.Items[0]
+	require.NotNil(t, cronSchedule.NextExecutionTime)
+	require.True(t, cronSchedule.NextExecutionTime.After(time.Now()))
+
+	tasks, err := q.ListTasks(ctx, queue.TaskFilter{
+		References: queue.References{"schedule_id": cronSchedule.ID},
+	}, parameters.Page{})
+	require.NoError(t, err)
+	require.Len(t, tasks.Items, 1)
+	require.Equal(t, queue.TaskType("cron"), tasks.Items[0].Type)
+
+	require.NoError(t, q.TriggerSchedule(ctx, cronSchedule.ID))
+	require.NoError(t, q.DeleteSchedule(ctx, cronSchedule.ID))
+	require.Equal(t, queue.ErrScheduleNotFound, q.TriggerSchedule(ctx, cronSchedule.ID))
+
+	tasks, err = q.ListTasks(ctx, queue.TaskFilter{}, parameters.Page{})
+	require.NoError(t, err)
+	require.Len(t, tasks.Items, 3)
+}
@@ -0,0 +1,116 @@
+package memory
+
+import (
+	"context"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/contiamo/go-base/v4/pkg/queue"
+)
+
+// rateBucket is the token bucket of a rate limited queue or task type
+type rateBucket struct {
+	limit queue.RateLimit
+	// tokens is the number of tokens in the bucket at updatedAt
+	tokens    float64
+	updatedAt time.Time
+}
+
+// available returns the number of tokens in the bucket at the given time
+func (b *rateBucket) available(now time.Time) float64 {
+	refill := b.limit.Rate * math.Max(0, now.Sub(b.updatedAt).Seconds())
+	return math.Min(float64(b.limit.Burst), b.tokens+refill)
+}
+
+// nextToken returns the time when the bucket has a token available
+func (b *rateBucket) nextToken(now time.Time) time.Time {
+	missing := 1 - b.available(now)
+	if missing <= 0 {
+		return now
+	}
+	return now.Add(time.Duration(math.Ceil(missing / b.limit.Rate * float64(time.Second))))
+}
+
+// take takes one token from the bucket at the given time
+func (b *rateBucket) take(now time.Time) {
+	b.tokens = b.available(now) - 1
+	b.updatedAt = now
+}
+
+// SetQueueRateLimit implements queue.RateLimiter
+func (q *Queue) SetQueueRateLimit(ctx context.Context, queueName string, limit *queue.RateLimit) error {
+	if queueName == "" {
+		return queue.ErrTaskQueueNotSpecified
+	}
+
+	return q.setRateLimit(q.queueRates, queueName, limit)
+}
+
+// GetQueueRateLimit implements queue.RateLimiter
+func (q *Queue) GetQueueRateLimit(ctx context.Context, queueName string) (*queue.RateLimit, error) {
+	return q.getRateLimit(q.queueRates, queueName), nil
+}
+
+// SetTaskTypeRateLimit implements queue.RateLimiter
+func (q *Queue) SetTaskTypeRateLimit(ctx context.Context, taskType queue.TaskType, limit *queue.RateLimit) error {
+	if taskType == "" {
+		return queue.ErrTaskTypeNotSpecified
+	}
+
+	return q.setRateLimit(q.typeRates, taskType.String(), limit)
+}
+
+// GetTaskTypeRateLimit implements queue.RateLimiter
+func (q *Queue) GetTaskTypeRateLimit(ctx context.Context, taskType queue.TaskType) (*queue.RateLimit, error) {
+	return q.getRateLimit(q.typeRates, taskType.String()), nil
+}
+
+// setRateLimit sets or removes the rate limit with the given name, the bucket of the changed limit
+// starts full and the waiting dequeuers are woken up
+func (q *Queue) setRateLimit(buckets map[string]*rateBucket, name string, limit *queue.RateLimit) error {
+	if limit != nil {
+		err := limit.Validate()
+		if err != nil {
+			return err
+		}
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	defer q.notify()
+
+	if limit == nil {
+		delete(buckets, strings.ToLower(name))
+		return nil
+	}
+
+	buckets[strings.ToLower(name)] = &rateBucket{
+		limit:     *limit,
+		tokens:    float64(limit.Burst),
+		updatedAt: time.Now(),
+	}
+
+	return nil
+}
+
+func (q *Queue) getRateLimit(buckets map[string]*rateBucket, name string) *queue.RateLimit {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	bucket, ok := buckets[strings.ToLower(name)]
+	if !ok {
+		return nil
+	}
+
+	limit := bucket.limit
+	return &limit
+}
+
+// rateBuckets returns the token buckets of the queue and the type of the task,
+// the values are nil when they are not rate limited.
+// Must be called with the lock held.
+func (q *Queue) rateBuckets(t queue.Task) (queueBucket, typeBucket *rateBucket) {
+	return q.queueRates[strings.ToLower(t.Queue)], q.typeRates[strings.ToLower(t.Type.String())]
+}
@@ -0,0 +1,420 @@
+package memory
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	cdb "github.com/contiamo/go-base/v4/pkg/db"
+	"github.com/contiamo/go-base/v4/pkg/http/parameters"
+	"github.com/contiamo/go-base/v4/pkg/queue"
+	uuid "github.com/satori/go.uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// scheduleRecord is a stored schedule with the values that are not exposed by queue.Schedule
+type scheduleRecord struct {
+	schedule   queue.Schedule
+	references queue.References
+	seq        uint64
+}
+
+// Schedule implements queue.Scheduler
+//
+// The in-memory queue can't take part in a SQL transaction,
+// the builder is ignored and the schedule is added right away.
+func (q *Queue) Schedule(ctx context.Context, builder cdb.SQLBuilder, task queue.TaskScheduleRequest) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.schedule(task)
+}
+
+// EnsureSchedule implements queue.Scheduler
+func (q *Queue) EnsureSchedule(ctx context.Context, builder cdb.SQLBuilder, task queue.TaskScheduleRequest) error {
+	if task.Queue == "" {
+		return queue.ErrTaskQueueNotSpecified
+	}
+
+	if task.Type == "" {
+		return queue.ErrTaskTypeNotSpecified
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.findSchedule(task) == nil {
+		return queue.ErrNotScheduled
+	}
+
+	return nil
+}
+
+// AssertSchedule implements queue.Scheduler
+func (q *Queue) AssertSchedule(ctx context.Context, task queue.TaskScheduleRequest) error {
+	if task.Queue == "" {
+		return queue.ErrTaskQueueNotSpecified
+	}
+
+	if task.Type == "" {
+		return queue.ErrTaskTypeNotSpecified
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.findSchedule(task) != nil {
+		return nil
+	}
+
+	return q.schedule(task)
+}
+
+// schedule validates and stores the new schedule.
+// Must be called with the lock held.
+func (q *Queue) schedule(task queue.TaskScheduleRequest) error {
+	if task.Queue == "" {
+		return queue.ErrTaskQueueNotSpecified
+	}
+
+	if task.Type == "" {
+		return queue.ErrTaskTypeNotSpecified
+	}
+
+	if task.Spec == nil {
+		task.Spec = emptyJSON
+	}
+
+	timing := task.Timing()
+	err := timing.Validate()
+	if err != nil {
+		return err
+	}
+
+	err = task.OverlapPolicy.ValidateFor(timing)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	q.seq++
+	record := &scheduleRecord{
+		schedule: queue.Schedule{
+			TaskBase:          task.TaskBase,
+			ID:                uuid.NewV4().String(),
+			OverlapPolicy:     task.OverlapPolicy,
+			NextExecutionTime: timing.FirstExecution(now),
+			CreatedAt:         now,
+			UpdatedAt:         now,
+		},
+		references: task.References,
+		seq:        q.seq,
+	}
+	record.schedule.SetTiming(timing)
+	q.schedules[record.schedule.ID] = record
+
+	return nil
+}
+
+// findSchedule returns the schedule of the task with the same queue, type, spec and references.
+// Must be called with the lock held.
+func (q *Queue) findSchedule(task queue.TaskScheduleRequest) *scheduleRecord {
+	if task.Spec == nil {
+		task.Spec = emptyJSON
+	}
+
+	for _, record := range q.schedules {
+		s := record.schedule
+		if strings.EqualFold(s.Queue, task.Queue) &&
+			strings.EqualFold(s.Type.String(), task.Type.String()) &&
+			bytes.Equal(s.Spec, task.Spec) &&
+			matchesReferences(record.references, task.References) {
+			return record
+		}
+	}
+	return nil
+}
+
+// GetSchedule implements queue.ScheduleManager
+func (q *Queue) GetSchedule(ctx context.Context, scheduleID string) (*queue.Schedule, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	record := q.schedules[strings.ToLower(scheduleID)]
+	if record == nil {
+		return nil, queue.ErrScheduleNotFound
+	}
+
+	schedule := record.schedule
+	return &schedule, nil
+}
+
+// ListSchedules implements queue.ScheduleManager
+func (q *Queue) ListSchedules(ctx context.Context, filter queue.ScheduleFilter, page parameters.Page) (list queue.ScheduleList, err error) {
+	if page.Number < 1 {
+		page.Number = 1
+	}
+	if page.Size < 1 {
+		page.Size = parameters.DefaultPageSize
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	records := []*scheduleRecord{}
+	for _, record := range q.schedules {
+		s := record.schedule
+		if filter.Queue != "" && !strings.EqualFold(filter.Queue, s.Queue) {
+			continue
+		}
+		if filter.Type != "" && !strings.EqualFold(filter.Type.String(), s.Type.String()) {
+			continue
+		}
+		if !matchesReferences(record.references, filter.References) {
+			continue
+		}
+		records = append(records, record)
+	}
+
+	// the most recently created schedules come first
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].seq > records[j].seq
+	})
+
+	list.PageInfo.ItemsPerPage = page.Size
+	list.PageInfo.Current = page.Number
+	list.PageInfo.ItemCount = uint32(len(records))
+	list.PageInfo.UnfilteredItemCount = uint32(len(q.schedules))
+
+	list.Items = make([]queue.Schedule, 0, page.Size)
+	for i := int((page.Number - 1) * page.Size); i < len(records) && len(list.Items) < int(page.Size); i++ {
+		list.Items = append(list.Items, records[i].schedule)
+	}
+
+	return list, nil
+}
+
+// UpdateSchedule implements queue.ScheduleManager
+func (q *Queue) UpdateSchedule(ctx context.Context, scheduleID string, update queue.ScheduleUpdate) (*queue.Schedule, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	record := q.schedules[strings.ToLower(scheduleID)]
+	if record == nil {
+		return nil, queue.ErrScheduleNotFound
+	}
+
+	schedule := record.schedule
+	err := update.Apply(&schedule, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	record.schedule = schedule
+
+	return &schedule, nil
+}
+
+// PauseSchedule implements queue.ScheduleManager
+func (q *Queue) PauseSchedule(ctx context.Context, scheduleID string) error {
+	return q.updateSchedule(scheduleID, func(s *queue.Schedule, now time.Time) {
+		if s.PausedAt == nil {
+			s.PausedAt = &now
+		}
+	})
+}
+
+// ResumeSchedule implements queue.ScheduleManager
+func (q *Queue) ResumeSchedule(ctx context.Context, scheduleID string) error {
+	return q.updateSchedule(scheduleID, func(s *queue.Schedule, now time.Time) {
+		s.PausedAt = nil
+	})
+}
+
+// DeleteSchedule implements queue.ScheduleManager
+func (q *Queue) DeleteSchedule(ctx context.Context, scheduleID string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	id := strings.ToLower(scheduleID)
+	if q.schedules[id] == nil {
+		return queue.ErrScheduleNotFound
+	}
+	delete(q.schedules, id)
+
+	return nil
+}
+
+// TriggerSchedule implements queue.ScheduleManager
+func (q *Queue) TriggerSchedule(ctx context.Context, scheduleID string) error {
+	q.mu.Lock()
+	record := q.schedules[strings.ToLower(scheduleID)]
+	var schedule queue.Schedule
+	if record != nil {
+		schedule = record.schedule
+	}
+	q.mu.Unlock()
+
+	if record == nil {
+		return queue.ErrScheduleNotFound
+	}
+
+	_, err := q.Enqueue(ctx, scheduledTask(schedule, time.Now()))
+	return err
+}
+
+// EnqueueScheduled enqueues the tasks of all the schedules with the next execution time
+// in the past and returns the number of enqueued tasks. The next execution time
+// is moved according to the schedule timing, the missed executions are handled
+// according to the misfire policy, see queue.ScheduleTiming.Fire, and the unfinished
+// previous tasks according to the overlap policy.
+// Paused schedules are skipped.
+func (q *Queue) EnqueueScheduled(ctx context.Context) (enqueued int, err error) {
+	for {
+		err = ctx.Err()
+		if err != nil {
+			return enqueued, err
+		}
+
+		now := time.Now()
+
+		q.mu.Lock()
+		var due *scheduleRecord
+		for _, record := range q.schedules {
+			s := record.schedule
+			if s.PausedAt != nil || s.NextExecutionTime == nil || s.NextExecutionTime.After(now) {
+				continue
+			}
+			if due == nil || s.NextExecutionTime.Before(*due.schedule.NextExecutionTime) {
+				due = record
+			}
+		}
+
+		if due == nil {
+			q.mu.Unlock()
+			return enqueued, nil
+		}
+
+		fire, nextExecution, err := due.schedule.Timing().Fire(*due.schedule.NextExecutionTime, due.schedule.ExecutionCount, now)
+		if err != nil {
+			q.mu.Unlock()
+			return enqueued, err
+		}
+
+		// the tasks are validated before anything is changed and added together with
+		// the schedule update under the same lock, so a firing is never half applied
+		tasks := make([]queue.TaskEnqueueRequest, 0, len(fire))
+		for _, scheduledAt := range fire {
+			task, err := q.prepare(scheduledTask(due.schedule, scheduledAt))
+			if err != nil {
+				q.mu.Unlock()
+				return enqueued, err
+			}
+			tasks = append(tasks, task)
+		}
+
+		added := 0
+		for _, task := range tasks {
+			if q.resolveOverlap(due.schedule, now) {
+				continue
+			}
+			q.add(task, now)
+			added++
+		}
+
+		due.schedule.NextExecutionTime = nextExecution
+		due.schedule.ExecutionCount += len(fire)
+		due.schedule.UpdatedAt = now
+		if added > 0 {
+			q.notify()
+		}
+		q.mu.Unlock()
+		enqueued += added
+	}
+}
+
+// resolveOverlap applies the overlap policy of the schedule before its next task is enqueued,
+// returns true if the execution must be skipped because the previous task is not finished.
+// Must be called with the lock held.
+func (q *Queue) resolveOverlap(schedule queue.Schedule, now time.Time) bool {
+	filter := queue.TaskFilter{
+		References: queue.References{"schedule_id": schedule.ID},
+	}
+
+	switch schedule.OverlapPolicy {
+	case queue.OverlapSkip:
+		unfinished := q.filterTasks(func(record *taskRecord) bool {
+			return !record.task.Status.IsFinal() && matchesTaskFilter(record, filter)
+		})
+		return len(unfinished) > 0
+	case queue.OverlapCancel:
+		q.cancelTasks(filter, now)
+		return false
+	default:
+		return false
+	}
+}
+
+// updateSchedule changes the schedule with the given ID
+func (q *Queue) updateSchedule(scheduleID string, update func(*queue.Schedule, time.Time)) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	record := q.schedules[strings.ToLower(scheduleID)]
+	if record == nil {
+		return queue.ErrScheduleNotFound
+	}
+
+	now := time.Now()
+	update(&record.schedule, now)
+	record.schedule.UpdatedAt = now
+
+	return nil
+}
+
+// scheduledTask returns the task enqueued by the schedule for the execution time,
+// the task references the schedule the same way as the tasks enqueued by the Postgres schedule worker
+func scheduledTask(schedule queue.Schedule, scheduledAt time.Time) queue.TaskEnqueueRequest {
+	return queue.TaskEnqueueRequest{
+		TaskBase: schedule.TaskBase,
+		References: queue.References{
+			"schedule_id": schedule.ID,
+		},
+		ScheduledAt: scheduledAt,
+	}
+}
+
+// NewScheduleWorker creates a worker enqueuing the tasks of the schedules
+// of the in-memory queue, it checks the schedules every interval.
+func NewScheduleWorker(q *Queue, interval time.Duration) queue.Worker {
+	return &scheduleWorker{
+		queue:    q,
+		interval: interval,
+	}
+}
+
+type scheduleWorker struct {
+	queue    *Queue
+	interval time.Duration
+}
+
+// Work enqueues the scheduled tasks until the context is cancelled
+func (w *scheduleWorker) Work(ctx context.Context) error {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		// the error should not stop the work
+		_, err := w.queue.EnqueueScheduled(ctx)
+		if err != nil && ctx.Err() == nil {
+			logrus.Error(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
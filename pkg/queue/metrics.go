@@ -77,6 +77,7 @@ var (
 	}
 	queueMetricLabels = []string{"queue"}
 	taskMetricLabels  = []string{"queue", "type"}
+	workerStateLabels = []string{"pool", "worker", "state"}
 
 	// handler metrics definitions
 
@@ -180,6 +181,13 @@ var (
 		Help:        "total count of errors",
 		ConstLabels: constLabels,
 	}
+	defTaskWorkerStateGaugeOpts = prometheus.GaugeOpts{
+		Namespace:   "queue",
+		Subsystem:   "task_worker",
+		Name:        "state_gauge",
+		Help:        "state of the pool workers, 1 for the current state of the worker",
+		ConstLabels: constLabels,
+	}
 
 	// schedule worker
 
@@ -288,6 +296,10 @@ var (
 		ErrorsCounter:           promauto.NewCounter(defTaskWorkerErrorsCounterOpts),
 	}
 
+	// TaskWorkerStateGauge reports the current state of every worker of the worker pools,
+	// the gauge of the current state of a worker is 1 and the gauges of its other states are 0.
+	TaskWorkerStateGauge = promauto.NewGaugeVec(defTaskWorkerStateGaugeOpts, workerStateLabels)
+
 	// SchedulerMetrics is the global metrics instance for the scheduler of this instance
 	SchedulerMetrics = SchedulerMetricsType{
 		Labels: queueMetricLabels,
@@ -377,6 +389,9 @@ func SwitchMetricsServiceName(serviceName string) {
 	newTaskWorkerErrorsCounterOpts := defTaskWorkerErrorsCounterOpts
 	newTaskWorkerErrorsCounterOpts.ConstLabels = newConstLabels
 
+	newTaskWorkerStateGaugeOpts := defTaskWorkerStateGaugeOpts
+	newTaskWorkerStateGaugeOpts.ConstLabels = newConstLabels
+
 	// schedule worker
 
 	newScheduleWorkerActiveGaugeOpts := defScheduleWorkerActiveGaugeOpts
@@ -470,6 +485,8 @@ func SwitchMetricsServiceName(serviceName string) {
 		ProcessingErrorsCounter: promauto.NewCounterVec(newTaskWorkerProcessingErrorsCounterOpts, taskMetricLabels),
 		ErrorsCounter:           promauto.NewCounter(newTaskWorkerErrorsCounterOpts),
 	}
+	prometheus.Unregister(TaskWorkerStateGauge)
+	TaskWorkerStateGauge = promauto.NewGaugeVec(newTaskWorkerStateGaugeOpts, workerStateLabels)
 
 	// schedule worker
 
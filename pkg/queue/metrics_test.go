@@ -51,7 +51,7 @@ func TestQueueMetrics(t *testing.T) {
 	})
 
 	for i := 0; i < numOfTasks; i++ {
-		err := q.Enqueue(ctx, testTask)
+		_, err := q.Enqueue(ctx, testTask)
 		require.NoError(t, err)
 	}
 
@@ -69,6 +69,45 @@ func TestQueueMetrics(t *testing.T) {
 	})
 }
 
+func TestQueueBatchMetrics(t *testing.T) {
+	defer goleak.VerifyNone(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	qCh := make(chan Task, 3)
+	rawQ := QueueMock{Queue: qCh}
+	q := QueuerWithMetrics(&rawQ)
+
+	tasks := []TaskEnqueueRequest{
+		{TaskBase: TaskBase{Queue: "batchQueue1", Type: "tester"}},
+		{TaskBase: TaskBase{Queue: "batchQueue1", Type: "tester"}},
+		{TaskBase: TaskBase{Queue: "batchQueue2", Type: "tester"}},
+	}
+
+	observedQueues := testutil.CollectAndCount(TaskQueueMetrics.EnqueueDuration)
+
+	taskIDs, err := q.EnqueueBatch(ctx, tasks)
+	require.NoError(t, err)
+	require.Len(t, taskIDs, len(tasks))
+
+	t.Run("task count inc for every task in the batch", func(t *testing.T) {
+		require.Equal(t, len(tasks), rawQ.EnqueueCount)
+		require.Equal(t, float64(2), testutil.ToFloat64(TaskQueueMetrics.TaskCounter.With(prometheus.Labels{"queue": "batchQueue1", "type": "tester"})))
+		require.Equal(t, float64(1), testutil.ToFloat64(TaskQueueMetrics.TaskCounter.With(prometheus.Labels{"queue": "batchQueue2", "type": "tester"})))
+	})
+
+	t.Run("enqueue duration is observed for every queue in the batch", func(t *testing.T) {
+		require.Equal(t, observedQueues+2, testutil.CollectAndCount(TaskQueueMetrics.EnqueueDuration))
+	})
+
+	t.Run("metrics wrapped queue calls the internal queue method", func(t *testing.T) {
+		for _, task := range tasks {
+			actualTask := <-qCh
+			require.Equal(t, task.Queue, actualTask.Queue)
+		}
+	})
+}
+
 func TestSchedulerMetrics(t *testing.T) {
 	defer goleak.VerifyNone(t)
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
@@ -107,3 +146,42 @@ func TestSchedulerMetrics(t *testing.T) {
 		require.Equal(t, float64(1), testutil.ToFloat64(SchedulerMetrics.ErrorCounter.With(promLabels)))
 	})
 }
+
+// resultWriterMock stores the task errors but does not support retries
+type resultWriterMock struct {
+	QueueMock
+	taskErr *TaskError
+}
+
+func (q *resultWriterMock) FinishWithResult(ctx context.Context, taskID string, progress Progress, result Result) error {
+	return q.Finish(ctx, taskID, progress)
+}
+
+func (q *resultWriterMock) FailWithError(ctx context.Context, taskID string, progress Progress, taskErr *TaskError) error {
+	q.taskErr = taskErr
+	return q.Fail(ctx, taskID, progress)
+}
+
+func TestDequeuerMetricsFailAttempt(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("stores the task error when the dequeuer does not support retries", func(t *testing.T) {
+		rawQ := &resultWriterMock{}
+		q := DequeuerWithMetrics(rawQ)
+
+		err := q.(Retrier).FailAttempt(ctx, "task", Progress(`{}`), errors.New("failed"))
+		require.NoError(t, err)
+		require.Equal(t, 1, rawQ.FailCount)
+		require.NotNil(t, rawQ.taskErr)
+		require.Equal(t, "failed", rawQ.taskErr.Message)
+	})
+
+	t.Run("fails the task when the dequeuer does not store errors", func(t *testing.T) {
+		rawQ := &QueueMock{}
+		q := DequeuerWithMetrics(rawQ)
+
+		err := q.(Retrier).FailAttempt(ctx, "task", Progress(`{}`), errors.New("failed"))
+		require.NoError(t, err)
+		require.Equal(t, 1, rawQ.FailCount)
+	})
+}
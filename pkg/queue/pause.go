@@ -0,0 +1,28 @@
+package queue
+
+import "context"
+
+// Pauser stops and resumes the dequeuing of the tasks per queue and per task type.
+//
+// The tasks of a paused queue or type stay in the queue until it's resumed,
+// the tasks that are already running are not affected and can be finished.
+// Pausing an already paused queue or type has no effect.
+type Pauser interface {
+	// PauseQueue stops the dequeuing of the tasks from the queue until it's resumed.
+	// ErrTaskQueueNotSpecified is returned if the queue name is empty.
+	PauseQueue(ctx context.Context, queueName string) error
+	// ResumeQueue resumes the dequeuing of the tasks from the paused queue.
+	// ErrTaskQueueNotSpecified is returned if the queue name is empty.
+	ResumeQueue(ctx context.Context, queueName string) error
+	// IsQueuePaused returns true if the queue is paused
+	IsQueuePaused(ctx context.Context, queueName string) (bool, error)
+
+	// PauseTaskType stops the dequeuing of the tasks of the given type from all the queues
+	// until it's resumed. ErrTaskTypeNotSpecified is returned if the task type is empty.
+	PauseTaskType(ctx context.Context, taskType TaskType) error
+	// ResumeTaskType resumes the dequeuing of the tasks of the paused type.
+	// ErrTaskTypeNotSpecified is returned if the task type is empty.
+	ResumeTaskType(ctx context.Context, taskType TaskType) error
+	// IsTaskTypePaused returns true if the task type is paused
+	IsTaskTypePaused(ctx context.Context, taskType TaskType) (bool, error)
+}
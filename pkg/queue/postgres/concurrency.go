@@ -0,0 +1,95 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/contiamo/go-base/v4/pkg/data/managers"
+	"github.com/contiamo/go-base/v4/pkg/queue"
+)
+
+// NewConcurrencyLimiter creates a new postgres queue concurrency limiter.
+// The settings are stored in the database, so they are respected by all the dequeuers
+// using the same database.
+func NewConcurrencyLimiter(db *sql.DB) queue.ConcurrencyLimiter {
+	return &concurrencyLimiter{
+		BaseManager: managers.NewBaseManager(db, "PostgresConcurrencyLimiter"),
+	}
+}
+
+// concurrencyLimiter is a postgres-backed implementation of the queue ConcurrencyLimiter
+type concurrencyLimiter struct {
+	managers.BaseManager
+}
+
+func (l *concurrencyLimiter) SetQueueConcurrency(ctx context.Context, queueName string, limit int) (err error) {
+	span, ctx := l.StartSpan(ctx, "SetQueueConcurrency")
+	defer func() {
+		l.FinishSpan(span, err)
+	}()
+
+	span.SetTag("queue", queueName)
+	span.SetTag("concurrency", limit)
+
+	if queueName == "" {
+		return queue.ErrTaskQueueNotSpecified
+	}
+
+	if limit < 0 {
+		return queue.ErrInvalidConcurrency
+	}
+
+	// the running tasks are recounted, because the queues with unlimited concurrency don't count them
+	running := squirrel.
+		Select("COUNT(*)").
+		From(TasksTable).
+		Where(squirrel.Eq{
+			"queue":  queueName,
+			"status": queue.Running,
+		})
+
+	_, err = l.GetQueryBuilder().
+		Insert(QueuesTable).
+		Columns("queue", "concurrency", "inflight").
+		Values(queueName, limit, squirrel.Expr("(?)", running)).
+		Suffix(`
+			ON CONFLICT (queue)
+			DO UPDATE SET
+				updated_at=?,
+				concurrency=EXCLUDED.concurrency,
+				inflight=EXCLUDED.inflight
+		`, time.Now()).
+		ExecContext(ctx)
+	if err != nil {
+		return fmt.Errorf("can not upsert the queue concurrency: %w", err)
+	}
+
+	return nil
+}
+
+func (l *concurrencyLimiter) GetQueueConcurrency(ctx context.Context, queueName string) (limit int, err error) {
+	span, ctx := l.StartSpan(ctx, "GetQueueConcurrency")
+	defer func() {
+		l.FinishSpan(span, err)
+	}()
+
+	span.SetTag("queue", queueName)
+
+	err = l.GetQueryBuilder().
+		Select("concurrency").
+		From(QueuesTable).
+		Where(squirrel.Eq{"queue": queueName}).
+		QueryRowContext(ctx).
+		Scan(&limit)
+	if err == sql.ErrNoRows {
+		return queue.DefaultQueueConcurrency, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return limit, nil
+}
@@ -0,0 +1,177 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/contiamo/go-base/v4/pkg/config"
+	dbtest "github.com/contiamo/go-base/v4/pkg/db/test"
+	"github.com/contiamo/go-base/v4/pkg/queue"
+	uuid "github.com/satori/go.uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueueConcurrency(t *testing.T) {
+	verifyLeak(t)
+
+	logrus.SetOutput(io.Discard)
+	defer logrus.SetOutput(os.Stdout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	t.Run("sets and gets the concurrency limit", func(t *testing.T) {
+		_, db := dbtest.GetDatabase(t)
+		defer db.Close()
+		require.NoError(t, SetupTables(ctx, db, nil))
+
+		limiter := NewConcurrencyLimiter(db)
+		limit, err := limiter.GetQueueConcurrency(ctx, queueID1)
+		require.NoError(t, err)
+		require.Equal(t, queue.DefaultQueueConcurrency, limit)
+
+		require.NoError(t, limiter.SetQueueConcurrency(ctx, queueID1, 5))
+		limit, err = limiter.GetQueueConcurrency(ctx, queueID1)
+		require.NoError(t, err)
+		require.Equal(t, 5, limit)
+
+		require.NoError(t, limiter.SetQueueConcurrency(ctx, queueID1, queue.UnlimitedQueueConcurrency))
+		limit, err = limiter.GetQueueConcurrency(ctx, queueID1)
+		require.NoError(t, err)
+		require.Equal(t, queue.UnlimitedQueueConcurrency, limit)
+
+		dbtest.EqualCount(t, db, 1, QueuesTable, squirrel.Eq{"queue": queueID1})
+	})
+
+	t.Run("returns an error for invalid settings", func(t *testing.T) {
+		_, db := dbtest.GetDatabase(t)
+		defer db.Close()
+		require.NoError(t, SetupTables(ctx, db, nil))
+
+		limiter := NewConcurrencyLimiter(db)
+		require.Equal(t, queue.ErrInvalidConcurrency, limiter.SetQueueConcurrency(ctx, queueID1, -1))
+		require.Equal(t, queue.ErrTaskQueueNotSpecified, limiter.SetQueueConcurrency(ctx, "", 1))
+	})
+
+	cases := []struct {
+		name        string
+		concurrency *int
+		expRunning  int
+	}{
+		{
+			name:       "runs one task at a time by default",
+			expRunning: 1,
+		},
+		{
+			name:        "runs up to the concurrency limit",
+			concurrency: intPtr(2),
+			expRunning:  2,
+		},
+		{
+			name:        "runs all tasks when the concurrency is unlimited",
+			concurrency: intPtr(queue.UnlimitedQueueConcurrency),
+			expRunning:  3,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, db := dbtest.GetDatabase(t)
+			defer db.Close()
+			require.NoError(t, SetupTables(ctx, db, nil))
+
+			if tc.concurrency != nil {
+				require.NoError(t, NewConcurrencyLimiter(db).SetQueueConcurrency(ctx, queueID1, *tc.concurrency))
+			}
+
+			q := NewDequeuer(db, nil, config.Queue{
+				HeartbeatTTL:  10 * time.Second,
+				PollFrequency: 50 * time.Millisecond,
+			})
+
+			for i := 0; i < 3; i++ {
+				_, err := squirrel.StatementBuilder.
+					PlaceholderFormat(squirrel.Dollar).
+					RunWith(db).
+					Insert("tasks").
+					Columns("task_id", "queue", "type", "spec", "progress", "status").
+					Values(uuid.NewV4().String(), queueID1, "test", emptyJSON, emptyJSON, queue.Waiting).
+					ExecContext(ctx)
+				require.NoError(t, err)
+			}
+
+			for i := 0; i < tc.expRunning; i++ {
+				task, err := q.(*dequeuer).attemptDequeue(ctx, queueID1)
+				require.NoError(t, err)
+				require.NotNil(t, task)
+			}
+
+			task, err := q.(*dequeuer).attemptDequeue(ctx, queueID1)
+			require.NoError(t, err)
+			if tc.expRunning < 3 {
+				require.Nil(t, task, "the concurrency limit must be respected")
+			}
+
+			dbtest.EqualCount(t, db, tc.expRunning, "tasks", squirrel.Eq{
+				"queue":  queueID1,
+				"status": queue.Running,
+			})
+		})
+	}
+
+	t.Run("counts the running tasks in the queue row", func(t *testing.T) {
+		_, db := dbtest.GetDatabase(t)
+		defer db.Close()
+		require.NoError(t, SetupTables(ctx, db, nil))
+		require.NoError(t, NewConcurrencyLimiter(db).SetQueueConcurrency(ctx, queueID1, 2))
+
+		q := NewDequeuer(db, nil, config.Queue{
+			HeartbeatTTL:  10 * time.Second,
+			PollFrequency: 50 * time.Millisecond,
+		})
+
+		for i := 0; i < 3; i++ {
+			_, err := squirrel.StatementBuilder.
+				PlaceholderFormat(squirrel.Dollar).
+				RunWith(db).
+				Insert("tasks").
+				Columns("task_id", "queue", "type", "spec", "progress", "status").
+				Values(uuid.NewV4().String(), queueID1, "test", emptyJSON, emptyJSON, queue.Waiting).
+				ExecContext(ctx)
+			require.NoError(t, err)
+		}
+
+		first, err := q.(*dequeuer).attemptDequeue(ctx, queueID1)
+		require.NoError(t, err)
+		require.NotNil(t, first)
+		second, err := q.(*dequeuer).attemptDequeue(ctx, queueID1)
+		require.NoError(t, err)
+		require.NotNil(t, second)
+		dbtest.EqualCount(t, db, 1, QueuesTable, squirrel.Eq{"queue": queueID1, "inflight": 2})
+
+		// the failed attempt releases the slot and the task waits for the retry
+		require.NoError(t, q.Finish(ctx, first.ID, progress))
+		require.NoError(t, q.(queue.Retrier).FailAttempt(ctx, second.ID, progress, errors.New("retry")))
+		dbtest.EqualCount(t, db, 1, QueuesTable, squirrel.Eq{"queue": queueID1, "inflight": 0})
+
+		// the running tasks are recounted when the limit changes
+		third, err := q.(*dequeuer).attemptDequeue(ctx, queueID1)
+		require.NoError(t, err)
+		require.NotNil(t, third)
+		require.NoError(t, NewConcurrencyLimiter(db).SetQueueConcurrency(ctx, queueID1, queue.UnlimitedQueueConcurrency))
+		_, err = db.ExecContext(ctx, "UPDATE queues SET inflight = 0")
+		require.NoError(t, err)
+		require.NoError(t, NewConcurrencyLimiter(db).SetQueueConcurrency(ctx, queueID1, 1))
+		dbtest.EqualCount(t, db, 1, QueuesTable, squirrel.Eq{"queue": queueID1, "inflight": 1})
+	})
+}
+
+func intPtr(i int) *int {
+	return &i
+}
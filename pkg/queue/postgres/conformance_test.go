@@ -0,0 +1,62 @@
+package postgres
+
+import (
+	"context"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/contiamo/go-base/v4/pkg/config"
+	dbtest "github.com/contiamo/go-base/v4/pkg/db/test"
+	"github.com/contiamo/go-base/v4/pkg/queue/queuetest"
+	"github.com/contiamo/go-base/v4/pkg/queue/workers"
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConformance(t *testing.T) {
+	verifyLeak(t)
+
+	logrus.SetOutput(io.Discard)
+	defer logrus.SetOutput(os.Stdout)
+
+	queuetest.Run(t, func(t *testing.T, cfg config.Queue) queuetest.Backend {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		name, db := dbtest.GetDatabase(t)
+		t.Cleanup(func() { db.Close() })
+		require.NoError(t, SetupTables(ctx, db, nil))
+
+		connStr := "user=contiamo_test password=localdev sslmode=disable dbname=" + name
+		dbListener := pq.NewListener(
+			connStr,
+			10*time.Second,
+			time.Minute,
+			func(ev pq.ListenerEventType, err error) {
+				if err != nil {
+					logrus.Error(err)
+				}
+			},
+		)
+		t.Cleanup(func() { dbListener.Close() })
+
+		queuer := NewQueuer(db)
+		return queuetest.Backend{
+			Queuer:             queuer,
+			Dequeuer:           NewDequeuer(db, dbListener, cfg),
+			Inspector:          NewInspector(db),
+			Manager:            NewManager(db),
+			ConcurrencyLimiter: NewConcurrencyLimiter(db),
+			Pauser:             NewPauser(db),
+			RateLimiter:        NewRateLimiter(db),
+			Scheduler:          NewScheduler(db),
+			ScheduleManager:    NewScheduleManager(db, queuer),
+			ScheduleWorker:     workers.NewScheduleWorker(db, queuer, cfg.PollFrequency),
+			SQLBuilder:         squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar).RunWith(db),
+		}
+	})
+}
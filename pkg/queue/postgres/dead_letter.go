@@ -0,0 +1,187 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/contiamo/go-base/v4/pkg/data/managers"
+	"github.com/contiamo/go-base/v4/pkg/queue"
+	"github.com/opentracing/opentracing-go"
+)
+
+// NewDeadLetterQueue creates a new postgres dead-letter queue.
+// Postgres keeps the dead-lettered tasks in the `tasks` table,
+// these are the failed tasks with a non-empty `dead_lettered_at` timestamp.
+func NewDeadLetterQueue(db *sql.DB) queue.DeadLetterQueue {
+	return &deadLetterQueue{
+		BaseManager: managers.NewBaseManager(db, "PostgresDeadLetterQueue"),
+	}
+}
+
+// deadLetterQueue is a postgres-backed implementation of the queue DeadLetterQueue
+type deadLetterQueue struct {
+	managers.BaseManager
+}
+
+func (q *deadLetterQueue) List(ctx context.Context, filter queue.DeadLetterFilter, limit uint64) (tasks []queue.Task, err error) {
+	span, ctx := q.StartSpan(ctx, "List")
+	defer func() {
+		q.FinishSpan(span, err)
+	}()
+
+	setFilterTags(span, filter)
+	span.SetTag("limit", limit)
+
+	query := q.GetQueryBuilder().
+		Select(taskSelectColumns()...).
+		From(TasksTable).
+		Where(deadLetterCondition(filter)).
+		OrderBy("dead_lettered_at DESC")
+
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+
+	rows, err := query.QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		task, err := scanTask(rows)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, *task)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	span.SetTag("count", len(tasks))
+
+	return tasks, nil
+}
+
+func (q *deadLetterQueue) Get(ctx context.Context, taskID string) (task *queue.Task, err error) {
+	span, ctx := q.StartSpan(ctx, "Get")
+	defer func() {
+		q.FinishSpan(span, err)
+	}()
+
+	span.SetTag("task.ID", taskID)
+
+	if !isValidID(taskID) {
+		return nil, queue.ErrTaskNotFound
+	}
+
+	row := q.GetQueryBuilder().
+		Select(taskSelectColumns()...).
+		From(TasksTable).
+		Where(squirrel.Eq{"task_id": taskID}).
+		Where(squirrel.NotEq{"dead_lettered_at": nil}).
+		QueryRowContext(ctx)
+
+	task, err = scanTask(row)
+	if err == sql.ErrNoRows {
+		return nil, queue.ErrTaskNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return task, nil
+}
+
+func (q *deadLetterQueue) Requeue(ctx context.Context, taskID string) (err error) {
+	span, ctx := q.StartSpan(ctx, "Requeue")
+	defer func() {
+		q.FinishSpan(span, err)
+	}()
+
+	span.SetTag("task.ID", taskID)
+
+	if !isValidID(taskID) {
+		return queue.ErrTaskNotFound
+	}
+
+	res, err := resetTask(q.GetQueryBuilder().Update(TasksTable), time.Now()).
+		Where(squirrel.Eq{"task_id": taskID}).
+		Where(squirrel.NotEq{"dead_lettered_at": nil}).
+		ExecContext(ctx)
+	if isUniqueViolation(err) {
+		return queue.ErrDuplicateIdempotencyKey
+	}
+	if err != nil {
+		return err
+	}
+
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if updated == 0 {
+		return queue.ErrTaskNotFound
+	}
+
+	return nil
+}
+
+func (q *deadLetterQueue) Purge(ctx context.Context, filter queue.DeadLetterFilter) (deleted int64, err error) {
+	span, ctx := q.StartSpan(ctx, "Purge")
+	defer func() {
+		q.FinishSpan(span, err)
+	}()
+
+	setFilterTags(span, filter)
+
+	res, err := q.GetQueryBuilder().
+		Delete(TasksTable).
+		Where(deadLetterCondition(filter)).
+		ExecContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	deleted, err = res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	span.SetTag("deleted", deleted)
+
+	return deleted, nil
+}
+
+// deadLetterCondition builds the WHERE condition matching the dead-lettered tasks
+func deadLetterCondition(filter queue.DeadLetterFilter) squirrel.Sqlizer {
+	where := squirrel.And{
+		squirrel.NotEq{"dead_lettered_at": nil},
+	}
+
+	if filter.Queue != "" {
+		where = append(where, squirrel.Eq{"queue": filter.Queue})
+	}
+
+	if filter.Type != "" {
+		where = append(where, squirrel.Eq{"type": filter.Type})
+	}
+
+	if !filter.DeadLetteredBefore.IsZero() {
+		where = append(where, squirrel.Lt{"dead_lettered_at": filter.DeadLetteredBefore})
+	}
+
+	return where
+}
+
+// setFilterTags adds the dead-letter filter values to the span
+func setFilterTags(span opentracing.Span, filter queue.DeadLetterFilter) {
+	span.SetTag("filter.queue", filter.Queue)
+	span.SetTag("filter.type", filter.Type)
+	span.SetTag("filter.deadLetteredBefore", filter.DeadLetteredBefore)
+}
@@ -0,0 +1,203 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	dbtest "github.com/contiamo/go-base/v4/pkg/db/test"
+	"github.com/contiamo/go-base/v4/pkg/queue"
+	uuid "github.com/satori/go.uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeadLetterCondition(t *testing.T) {
+	before := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		name    string
+		filter  queue.DeadLetterFilter
+		expSQL  string
+		expArgs []interface{}
+	}{
+		{
+			name:   "matches all dead letters when the filter is empty",
+			expSQL: "(dead_lettered_at IS NOT NULL)",
+		},
+		{
+			name: "matches dead letters by all filter values",
+			filter: queue.DeadLetterFilter{
+				Queue:              "queue1",
+				Type:               "test",
+				DeadLetteredBefore: before,
+			},
+			expSQL:  "(dead_lettered_at IS NOT NULL AND queue = ? AND type = ? AND dead_lettered_at < ?)",
+			expArgs: []interface{}{"queue1", queue.TaskType("test"), before},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			query, args, err := deadLetterCondition(tc.filter).ToSql()
+			require.NoError(t, err)
+			require.Equal(t, tc.expSQL, query)
+			require.Equal(t, tc.expArgs, args)
+		})
+	}
+}
+
+func TestDeadLetterQueue(t *testing.T) {
+	verifyLeak(t)
+
+	logrus.SetOutput(io.Discard)
+	defer logrus.SetOutput(os.Stdout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	weekAgo := now.Add(-7 * 24 * time.Hour)
+
+	// inserts the dead letters and a regular failed task
+	setup := func(t *testing.T, db *sql.DB) (recentID, oldID, failedID string) {
+		recentID = uuid.NewV4().String()
+		oldID = uuid.NewV4().String()
+		failedID = uuid.NewV4().String()
+
+		_, err := squirrel.StatementBuilder.
+			PlaceholderFormat(squirrel.Dollar).
+			RunWith(db).
+			Insert("tasks").
+			Columns(
+				"task_id",
+				"queue",
+				"type",
+				"spec",
+				"progress",
+				"status",
+				"attempts",
+				"last_error",
+				"finished_at",
+				"dead_lettered_at",
+			).
+			Values(recentID, queueID1, "test", emptyJSON, progress, queue.Failed, 3, "recent error", now, now).
+			Values(oldID, queueID2, "test", emptyJSON, progress, queue.Failed, 3, "old error", weekAgo, weekAgo).
+			Values(failedID, queueID1, "test", emptyJSON, progress, queue.Failed, 1, "", now, nil).
+			ExecContext(ctx)
+		require.NoError(t, err)
+
+		return recentID, oldID, failedID
+	}
+
+	t.Run("lists dead letters, the most recent first", func(t *testing.T) {
+		_, db := dbtest.GetDatabase(t)
+		defer db.Close()
+		require.NoError(t, SetupTables(ctx, db, nil))
+
+		recentID, oldID, _ := setup(t, db)
+		dlq := NewDeadLetterQueue(db)
+
+		tasks, err := dlq.List(ctx, queue.DeadLetterFilter{}, 0)
+		require.NoError(t, err)
+		require.Len(t, tasks, 2)
+		require.Equal(t, recentID, tasks[0].ID)
+		require.Equal(t, oldID, tasks[1].ID)
+		require.Equal(t, "recent error", tasks[0].LastError)
+		require.Equal(t, queue.Progress(progress), tasks[0].Progress)
+		require.Equal(t, 3, tasks[0].Attempts)
+		require.NotNil(t, tasks[0].DeadLetteredAt)
+
+		tasks, err = dlq.List(ctx, queue.DeadLetterFilter{}, 1)
+		require.NoError(t, err)
+		require.Len(t, tasks, 1)
+		require.Equal(t, recentID, tasks[0].ID)
+
+		tasks, err = dlq.List(ctx, queue.DeadLetterFilter{Queue: queueID2}, 0)
+		require.NoError(t, err)
+		require.Len(t, tasks, 1)
+		require.Equal(t, oldID, tasks[0].ID)
+
+		tasks, err = dlq.List(ctx, queue.DeadLetterFilter{DeadLetteredBefore: now.Add(-time.Hour)}, 0)
+		require.NoError(t, err)
+		require.Len(t, tasks, 1)
+		require.Equal(t, oldID, tasks[0].ID)
+
+		tasks, err = dlq.List(ctx, queue.DeadLetterFilter{Type: "unknown"}, 0)
+		require.NoError(t, err)
+		require.Len(t, tasks, 0)
+	})
+
+	t.Run("gets a dead letter", func(t *testing.T) {
+		_, db := dbtest.GetDatabase(t)
+		defer db.Close()
+		require.NoError(t, SetupTables(ctx, db, nil))
+
+		recentID, _, failedID := setup(t, db)
+		dlq := NewDeadLetterQueue(db)
+
+		task, err := dlq.Get(ctx, recentID)
+		require.NoError(t, err)
+		require.Equal(t, recentID, task.ID)
+		require.Equal(t, queue.Failed, task.Status)
+		require.Equal(t, "recent error", task.LastError)
+
+		_, err = dlq.Get(ctx, failedID)
+		require.Equal(t, queue.ErrTaskNotFound, err)
+
+		_, err = dlq.Get(ctx, uuid.NewV4().String())
+		require.Equal(t, queue.ErrTaskNotFound, err)
+	})
+
+	t.Run("requeues a dead letter", func(t *testing.T) {
+		_, db := dbtest.GetDatabase(t)
+		defer db.Close()
+		require.NoError(t, SetupTables(ctx, db, nil))
+
+		recentID, _, failedID := setup(t, db)
+		dlq := NewDeadLetterQueue(db)
+
+		require.NoError(t, dlq.Requeue(ctx, recentID))
+		dbtest.EqualCount(t, db, 1, "tasks", squirrel.And{
+			squirrel.Eq{
+				"task_id":           recentID,
+				"status":            queue.Waiting,
+				"progress":          emptyJSON,
+				"attempts":          0,
+				"started_at":        nil,
+				"finished_at":       nil,
+				"last_heartbeat_at": nil,
+				"dead_lettered_at":  nil,
+			},
+			squirrel.LtOrEq{"not_before": time.Now()},
+		})
+
+		require.Equal(t, queue.ErrTaskNotFound, dlq.Requeue(ctx, recentID), "the task is not a dead letter anymore")
+		require.Equal(t, queue.ErrTaskNotFound, dlq.Requeue(ctx, failedID))
+	})
+
+	t.Run("purges dead letters matching the filter", func(t *testing.T) {
+		_, db := dbtest.GetDatabase(t)
+		defer db.Close()
+		require.NoError(t, SetupTables(ctx, db, nil))
+
+		recentID, oldID, failedID := setup(t, db)
+		dlq := NewDeadLetterQueue(db)
+
+		deleted, err := dlq.Purge(ctx, queue.DeadLetterFilter{DeadLetteredBefore: now.Add(-time.Hour)})
+		require.NoError(t, err)
+		require.Equal(t, int64(1), deleted)
+		dbtest.EqualCount(t, db, 0, "tasks", squirrel.Eq{"task_id": oldID})
+
+		deleted, err = dlq.Purge(ctx, queue.DeadLetterFilter{})
+		require.NoError(t, err)
+		require.Equal(t, int64(1), deleted)
+		dbtest.EqualCount(t, db, 0, "tasks", squirrel.Eq{"task_id": recentID})
+
+		dbtest.EqualCount(t, db, 1, "tasks", squirrel.Eq{"task_id": failedID}, "regular failed tasks must be kept")
+	})
+}
@@ -3,7 +3,10 @@ package postgres
 import (
 	"context"
 	"database/sql"
-	"math/rand"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/Masterminds/squirrel"
@@ -16,12 +19,22 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-// NewDequeuer creates a new postgres queue dequeuer
+// taskUpdateChannel is the notification channel used when a task becomes available
+// for dequeuing, see notifySetup
+const taskUpdateChannel = "task_update"
+
+// NewDequeuer creates a new postgres queue dequeuer.
+//
+// The dequeuer can be shared by several workers, e.g. the workers of a pool,
+// the task update notifications of the listener are delivered to all the waiting
+// Dequeue calls. The listener must not be shared with other dequeuers or waiters
+// because they all consume the listener notifications.
 func NewDequeuer(db *sql.DB, dbListener *pq.Listener, cfg config.Queue) queue.Dequeuer {
 	return &dequeuer{
 		BaseManager: managers.NewBaseManager(db, "PostgresDequeuer"),
 		cfg:         cfg,
 		listener:    dbListener,
+		subscribers: map[chan struct{}]struct{}{},
 	}
 }
 
@@ -35,12 +48,24 @@ type dequeuer struct {
 	cfg      config.Queue
 	listener *pq.Listener
 	managers.BaseManager
+
+	mu sync.Mutex
+	// subscribers contains the notification channels of the waiting Dequeue calls
+	subscribers map[chan struct{}]struct{}
+	// stop stops the notification dispatching, it's nil when nobody is waiting
+	stop chan struct{}
 }
 
 // Dequeue implements queue.Dequeue
 // It's expected to block until work is available
-// Allows only one task per queue to be worked on at once
-// Returns the oldest task in the queue that hasn't started or has had a failure
+// Allows only one task per queue to be worked on at once unless
+// the queue has a different concurrency limit, see NewConcurrencyLimiter
+// Returns the task with the highest priority across the processable queues,
+// the oldest task that hasn't started or has had a failure is returned
+// when there are several tasks with the same priority.
+// Tasks with `not_before` in the future are skipped until their time has come.
+// Tasks of the paused queues and task types are skipped, see NewPauser,
+// and tasks of the rate limited ones wait for a token, see NewRateLimiter.
 func (q *dequeuer) Dequeue(ctx context.Context, queues ...string) (task *queue.Task, err error) {
 	span, ctx := q.StartSpan(ctx, "Dequeue")
 	defer func() {
@@ -54,18 +79,29 @@ func (q *dequeuer) Dequeue(ctx context.Context, queues ...string) (task *queue.T
 	task, err = q.attemptDequeue(ctx, queues...)
 
 	if task == nil && err == nil {
-		err = q.listener.Listen("task_update")
+		var notifications chan struct{}
+		notifications, err = q.subscribe()
 		if err != nil {
 			return nil, err
 		}
 		defer func() {
-			unlistenError := q.listener.Unlisten("task_update")
-			if unlistenError != nil && err == nil {
-				err = unlistenError
+			unsubscribeErr := q.unsubscribe(notifications)
+			if unsubscribeErr != nil && err == nil {
+				err = unsubscribeErr
 			}
 		}()
 		ticker := time.NewTicker(q.cfg.PollFrequency)
 		defer ticker.Stop()
+
+		// wakeup fires when the next delayed task becomes available,
+		// so we don't have to wait for the next tick
+		wakeup := time.NewTimer(0)
+		defer wakeup.Stop()
+		err = q.resetWakeup(ctx, wakeup, queues...)
+		if err != nil {
+			return nil, err
+		}
+
 		for {
 			select {
 			case <-ctx.Done():
@@ -76,124 +112,333 @@ func (q *dequeuer) Dequeue(ctx context.Context, queues ...string) (task *queue.T
 				if task != nil || err != nil {
 					return task, err
 				}
-				err = q.listener.Ping()
+			case <-notifications:
+				logrus.Debug("attempt dequeue because of notification")
+				task, err = q.attemptDequeue(ctx, queues...)
+				if task != nil || err != nil {
+					return task, err
+				}
+				// the notification might be caused by a new delayed task
+				err = q.resetWakeup(ctx, wakeup, queues...)
 				if err != nil {
 					return nil, err
 				}
-			case <-q.listener.Notify:
-				logrus.Debug("attempt dequeue because of notification")
+			case <-wakeup.C:
+				logrus.Debug("attempt dequeue because of a delayed task")
 				task, err = q.attemptDequeue(ctx, queues...)
 				if task != nil || err != nil {
 					return task, err
 				}
-				err = q.listener.Ping()
+				err = q.resetWakeup(ctx, wakeup, queues...)
 				if err != nil {
 					return nil, err
 				}
 			}
+
+			err = q.listener.Ping()
+			if err != nil {
+				return nil, err
+			}
 		}
 	}
 
 	return task, err
 }
 
-func (q *dequeuer) attemptDequeue(ctx context.Context, queues ...string) (task *queue.Task, err error) {
-	span, ctx := q.StartSpan(ctx, "attemptDequeue")
-	defer func() {
-		q.FinishSpan(span, err)
-	}()
+// subscribe registers a notification channel for the waiting Dequeue call,
+// the first subscriber starts listening to the task update notifications.
+func (q *dequeuer) subscribe() (chan struct{}, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
 
-	now := time.Now()
-	doubleTTL := now.Add(-2 * q.cfg.HeartbeatTTL) // assume a task has failed if there's no heartbeat after twice the ttl
+	if q.stop == nil {
+		err := q.listener.Listen(taskUpdateChannel)
+		if err != nil {
+			return nil, err
+		}
+		q.stop = make(chan struct{})
+		go q.dispatch(q.stop)
+	}
 
-	builder, tx, err := q.GetTxQueryBuilder(ctx, nil)
-	if err != nil {
-		return nil, err
+	notifications := make(chan struct{}, 1)
+	q.subscribers[notifications] = struct{}{}
+
+	return notifications, nil
+}
+
+// unsubscribe removes the notification channel of the Dequeue call,
+// the last subscriber stops listening to the task update notifications.
+func (q *dequeuer) unsubscribe(notifications chan struct{}) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	delete(q.subscribers, notifications)
+	if len(q.subscribers) > 0 || q.stop == nil {
+		return nil
 	}
 
-	defer func() {
-		if err == nil {
-			err = tx.Commit()
+	close(q.stop)
+	q.stop = nil
+
+	return q.listener.Unlisten(taskUpdateChannel)
+}
+
+// dispatch delivers every listener notification to all the subscribers until it's stopped,
+// any of them might be able to dequeue the updated task.
+func (q *dequeuer) dispatch(stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
 			return
+		case <-q.listener.Notify:
+			q.mu.Lock()
+			for notifications := range q.subscribers {
+				// the subscriber has a pending notification already
+				select {
+				case notifications <- struct{}{}:
+				default:
+				}
+			}
+			q.mu.Unlock()
 		}
+	}
+}
 
-		rollbackErr := tx.Rollback()
-		if rollbackErr != nil {
-			err = errors.Wrap(err, rollbackErr.Error())
+// resetWakeup sets the timer to fire when the next delayed task in the given queues
+// becomes available. The timer is stopped when there are no delayed tasks.
+func (q *dequeuer) resetWakeup(ctx context.Context, timer *time.Timer, queues ...string) (err error) {
+	if !timer.Stop() {
+		// the timer has fired and we need to drain the channel
+		select {
+		case <-timer.C:
+		default:
 		}
+	}
+
+	next, err := q.nextDelayedTaskTime(ctx, queues...)
+	if err != nil {
+		return err
+	}
+
+	if next != nil {
+		timer.Reset(time.Until(*next))
+	}
+
+	return nil
+}
+
+// nextDelayedTaskTime returns the earliest time when one of the waiting delayed tasks
+// becomes available, nil is returned when there are no delayed tasks.
+func (q *dequeuer) nextDelayedTaskTime(ctx context.Context, queues ...string) (next *time.Time, err error) {
+	span, ctx := q.StartSpan(ctx, "nextDelayedTaskTime")
+	defer func() {
+		q.FinishSpan(span, err)
 	}()
 
-	// if zero length q is passed in, get a list of all queues with unfinished tasks
-	if len(queues) == 0 {
-		queues, err = q.generateUnfinishedQueueList(ctx)
-		if err != nil {
-			return nil, err
-		}
+	query := q.GetQueryBuilder().
+		Select("MIN(not_before)").
+		From("tasks").
+		Where(squirrel.Eq{
+			"started_at":  nil,
+			"finished_at": nil,
+		}).
+		Where(squirrel.Gt{"not_before": time.Now()})
+
+	if len(queues) > 0 {
+		query = query.Where(squirrel.Eq{"queue": queues})
 	}
 
-	inflight, err := q.generateInflightQueueList(ctx, doubleTTL)
+	err = query.QueryRowContext(ctx).Scan(&next)
 	if err != nil {
 		return nil, err
 	}
 
-	processableQs := processableQueues(inflight, queues)
+	span.SetTag("task.nextNotBefore", next)
+
+	return next, nil
+}
 
-	span.SetTag("queue.inflightQueues", len(inflight))
-	span.SetTag("queue.potentialQueues", len(processableQs))
+// attemptDequeue claims the next available task in a single statement.
+//
+// The candidate tasks are locked with `SKIP LOCKED`, so concurrent workers never wait
+// for each other and just pick the next available task instead.
+// Tasks of the queues with a concurrency limit also lock the queue row, this serializes
+// the claims within such queue. The running tasks of such queue are counted in the `inflight`
+// column of the queue row, the claim increments it and the tasks decrement it when they stop
+// running. Because the limit is checked against the queue row itself, a worker that locks the row
+// after a concurrent claim re-checks the limit with the new count instead of its older snapshot.
+// An abandoned task keeps its slot until it's dequeued again.
+// Tasks of the queues with unlimited concurrency are claimed without locking the queue.
+// The rate limited queues and task types are locked the same way, see NewRateLimiter.
+func (q *dequeuer) attemptDequeue(ctx context.Context, queues ...string) (task *queue.Task, err error) {
+	span, ctx := q.StartSpan(ctx, "attemptDequeue")
+	defer func() {
+		q.FinishSpan(span, err)
+	}()
 
-	// no tasks available
-	if len(processableQs) == 0 {
-		return nil, nil
+	now := time.Now()
+	doubleTTL := now.Add(-2 * q.cfg.HeartbeatTTL) // assume a task has failed if there's no heartbeat after twice the ttl
+
+	// non-started tasks or tasks that have had a failure,
+	// cancelled tasks are finished and never dequeued
+	available := squirrel.And{
+		squirrel.LtOrEq{"t.not_before": now},
+		squirrel.Eq{"t.finished_at": nil},
+		squirrel.NotEq{"t.status": queue.Blocked},
+		squirrel.Or{
+			squirrel.Eq{"t.started_at": nil},
+			squirrel.Lt{"t.last_heartbeat_at": doubleTTL},
+		},
 	}
 
-	// choose a random queue
-	//nolint: gosec // this random value is not involved in any security related logic
-	n := rand.Intn(len(processableQs))
-	queueName := processableQs[n]
-	logrus.Debugf("choosing random queue `%s`", queueName)
+	// if zero length q is passed in, any queue is processable
+	if len(queues) > 0 {
+		available = append(available, squirrel.Eq{"t.queue": queues})
+	}
 
-	// find the oldest non-started or failed task
-	row := builder.
-		Select(q.columns()...).
-		From("tasks").
+	candidates := squirrel.
+		Select("t.task_id", "t.queue", "t.type", "t.status", "t.priority", "t.created_at").
+		From("tasks t").
+		Join("queues q ON q.queue = t.queue").
+		Where(available).
+		// the tasks of the paused queues are not available
+		Where(squirrel.Eq{"q.paused_at": nil}).
 		Where(squirrel.Or{
-			squirrel.Eq{"queue": queueName, "started_at": nil},
-			squirrel.And{
-				squirrel.Eq{"queue": queueName, "finished_at": nil},
-				squirrel.Lt{"last_heartbeat_at": doubleTTL},
-			},
+			squirrel.Eq{"q.rate_limit": nil},
+			squirrel.Expr(availableTokens("q") + " >= 1"),
 		}).
-		OrderBy("created_at DESC").
-		Limit(1).
-		Suffix("FOR UPDATE").
-		QueryRowContext(ctx)
+		OrderBy("t.priority DESC", "t.created_at").
+		Limit(1)
 
-	task, err = q.scan(row)
-	if err == sql.ErrNoRows {
-		logrus.Debugf("queue `%s` does not have an available task", queueName)
-		return nil, nil
+	// the queue row is locked when the queue has a concurrency limit or a rate limit
+	lockedQueue := candidates.
+		Where(squirrel.Or{
+			squirrel.Gt{"q.concurrency": queue.UnlimitedQueueConcurrency},
+			squirrel.NotEq{"q.rate_limit": nil},
+		}).
+		Where(squirrel.Or{
+			squirrel.Eq{"q.concurrency": queue.UnlimitedQueueConcurrency},
+			squirrel.Expr("q.inflight < q.concurrency"),
+			// the abandoned task already holds a slot of the queue
+			squirrel.Eq{"t.status": queue.Running},
+		})
+
+	unlockedQueue := candidates.
+		Where(squirrel.Eq{
+			"q.concurrency": queue.UnlimitedQueueConcurrency,
+			"q.rate_limit":  nil,
+		})
+
+	// the tasks of the paused task types are not available,
+	// the rate limited task types are claimed with their type row locked
+	restrictedType := squirrel.
+		Select("1").
+		From(TaskTypesTable + " tt").
+		Where("tt.type = t.type").
+		Where(squirrel.Or{
+			squirrel.NotEq{"tt.paused_at": nil},
+			squirrel.NotEq{"tt.rate_limit": nil},
+		})
+
+	unlimitedType := func(b squirrel.SelectBuilder) squirrel.SelectBuilder {
+		return b.Where(squirrel.Expr("NOT EXISTS (?)", restrictedType))
 	}
-	if err != nil {
-		return nil, err
+
+	limitedType := func(b squirrel.SelectBuilder) squirrel.SelectBuilder {
+		return b.
+			Join(TaskTypesTable + " tt ON tt.type = t.type").
+			Where(squirrel.Eq{"tt.paused_at": nil}).
+			Where(squirrel.NotEq{"tt.rate_limit": nil}).
+			Where(availableTokens("tt") + " >= 1")
 	}
 
-	span.SetTag("task.timeSpentWaiting", now.Sub(task.CreatedAt))
+	limited := unlimitedType(lockedQueue).
+		Suffix("FOR UPDATE OF t, q SKIP LOCKED")
+
+	unlimited := unlimitedType(unlockedQueue).
+		Suffix("FOR UPDATE OF t SKIP LOCKED")
+
+	limitedByType := limitedType(lockedQueue).
+		Suffix("FOR UPDATE OF t, q, tt SKIP LOCKED")
+
+	unlimitedByType := limitedType(unlockedQueue).
+		Suffix("FOR UPDATE OF t, tt SKIP LOCKED")
+
+	// the task with the highest priority wins,
+	// the oldest task wins when priorities are equal.
+	// The claimed task takes a slot of its queue with a concurrency limit
+	// and a token from the rate limits of its queue and type,
+	// their rows are locked by the candidate selection.
+	// The queue row is updated once, so the slot and the token are taken together.
+	cte := squirrel.Expr(`
+		WITH limited AS (?), unlimited AS (?), limited_by_type AS (?), unlimited_by_type AS (?), candidate AS (
+			SELECT task_id, queue, type, status FROM (
+				SELECT * FROM limited
+				UNION ALL SELECT * FROM unlimited
+				UNION ALL SELECT * FROM limited_by_type
+				UNION ALL SELECT * FROM unlimited_by_type
+			) c
+			ORDER BY priority DESC, created_at
+			LIMIT 1
+		), queue_slot AS (
+			UPDATE `+QueuesTable+` q
+			SET
+				inflight = CASE
+					WHEN q.concurrency > 0 AND candidate.status <> ? THEN q.inflight + 1
+					ELSE q.inflight
+				END,
+				rate_tokens = CASE
+					WHEN q.rate_limit IS NOT NULL THEN `+availableTokens("q")+` - 1
+					ELSE q.rate_tokens
+				END,
+				rate_updated_at = CASE WHEN q.rate_limit IS NOT NULL THEN NOW() ELSE q.rate_updated_at END
+			FROM candidate
+			WHERE q.queue = candidate.queue AND (q.concurrency > 0 OR q.rate_limit IS NOT NULL)
+		), type_tokens AS (
+			UPDATE `+TaskTypesTable+` tt
+			SET rate_tokens = `+availableTokens("tt")+` - 1, rate_updated_at = NOW()
+			FROM candidate
+			WHERE tt.type = candidate.type AND tt.rate_limit IS NOT NULL
+		)`,
+		limited,
+		unlimited,
+		limitedByType,
+		unlimitedByType,
+		queue.Running,
+	)
+
+	returning := taskSelectColumns()
+	for i, column := range returning {
+		returning[i] = "tasks." + column
+	}
 
-	// update started_at time
-	_, err = builder.
+	// set started_at time and count the attempt
+	row := q.GetQueryBuilder().
 		Update("tasks").
+		PrefixExpr(cte).
 		Set("started_at", now).
 		Set("last_heartbeat_at", now).
 		Set("status", queue.Running).
-		Where("task_id=?", task.ID).
-		ExecContext(ctx)
+		Set("attempts", squirrel.Expr("attempts + 1")).
+		From("candidate").
+		Where("tasks.task_id = candidate.task_id").
+		Suffix("RETURNING " + strings.Join(returning, ", ")).
+		QueryRowContext(ctx)
+
+	task, err = scanTask(row)
+	if err == sql.ErrNoRows {
+		logrus.Debugf("queues %v do not have an available task", queues)
+		return nil, nil
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	task.StartedAt = &now
-	task.LastHeartbeatAt = &now
-	return task, err
+	span.SetTag("task.queue", task.Queue)
+	span.SetTag("task.priority", task.Priority)
+	span.SetTag("task.timeSpentWaiting", now.Sub(task.CreatedAt))
+
+	return task, nil
 }
 
 func (q *dequeuer) Heartbeat(ctx context.Context, taskID string, progress queue.Progress) (err error) {
@@ -203,7 +448,7 @@ func (q *dequeuer) Heartbeat(ctx context.Context, taskID string, progress queue.
 	}()
 	span.SetTag("task.ID", taskID)
 	span.SetTag("progress", string(progress))
-	err = q.updateProgress(ctx, taskID, progress, false, false)
+	err = q.updateProgress(ctx, taskID, progress, false, false, taskOutcome{})
 
 	return err
 }
@@ -217,11 +462,12 @@ func (q *dequeuer) Finish(ctx context.Context, taskID string, progress queue.Pro
 	span.SetTag("task.ID", taskID)
 	span.SetTag("progress", string(progress))
 
-	err = q.updateProgress(ctx, taskID, progress, true, false)
+	err = q.updateProgress(ctx, taskID, progress, true, false, taskOutcome{})
 
 	return err
 }
 
+// Fail implements queue.Dequeuer
 func (q *dequeuer) Fail(ctx context.Context, taskID string, progress queue.Progress) (err error) {
 	span, ctx := q.StartSpan(ctx, "Fail")
 	defer func() {
@@ -231,12 +477,165 @@ func (q *dequeuer) Fail(ctx context.Context, taskID string, progress queue.Progr
 	span.SetTag("task.ID", taskID)
 	span.SetTag("progress", string(progress))
 
-	err = q.updateProgress(ctx, taskID, progress, true, true)
+	err = q.updateProgress(ctx, taskID, progress, true, true, taskOutcome{})
+
+	return err
+}
+
+// FinishWithResult implements queue.ResultWriter
+func (q *dequeuer) FinishWithResult(ctx context.Context, taskID string, progress queue.Progress, result queue.Result) (err error) {
+	span, ctx := q.StartSpan(ctx, "FinishWithResult")
+	defer func() {
+		q.FinishSpan(span, err)
+	}()
+
+	span.SetTag("task.ID", taskID)
+	span.SetTag("progress", string(progress))
+
+	err = q.updateProgress(ctx, taskID, progress, true, false, taskOutcome{result: result})
 
 	return err
 }
 
-func (q *dequeuer) updateProgress(ctx context.Context, taskID string, progress queue.Progress, isFinal, isFailed bool) (err error) {
+// FailWithError implements queue.ResultWriter
+func (q *dequeuer) FailWithError(ctx context.Context, taskID string, progress queue.Progress, taskErr *queue.TaskError) (err error) {
+	span, ctx := q.StartSpan(ctx, "FailWithError")
+	defer func() {
+		q.FinishSpan(span, err)
+	}()
+
+	span.SetTag("task.ID", taskID)
+	span.SetTag("progress", string(progress))
+	if taskErr != nil {
+		span.SetTag("task.error", taskErr.Message)
+	}
+
+	err = q.updateProgress(ctx, taskID, progress, true, true, taskOutcome{err: taskErr})
+
+	return err
+}
+
+// FailAttempt implements queue.Retrier,
+// the task is moved to the dead-letter queue when it used up all the attempts of its retry policy
+func (q *dequeuer) FailAttempt(ctx context.Context, taskID string, progress queue.Progress, taskErr error) (err error) {
+	span, ctx := q.StartSpan(ctx, "FailAttempt")
+	defer func() {
+		q.FinishSpan(span, err)
+	}()
+
+	span.SetTag("task.ID", taskID)
+	span.SetTag("progress", string(progress))
+	span.SetTag("task.error", taskErr)
+
+	// ensure that progress is always a valid JSON object
+	if len(progress) == 0 {
+		progress = emptyJSON
+	}
+
+	var lastError string
+	if taskErr != nil {
+		lastError = taskErr.Error()
+	}
+
+	errorBytes, err := encodeTaskError(queue.NewTaskError(taskErr))
+	if err != nil {
+		return err
+	}
+
+	builder, tx, err := q.GetTxQueryBuilder(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err == nil {
+			err = tx.Commit()
+			return
+		}
+
+		rollbackErr := tx.Rollback()
+		if rollbackErr != nil {
+			err = errors.Wrap(err, rollbackErr.Error())
+		}
+	}()
+
+	var (
+		status      queue.TaskStatus
+		attempts    int
+		policyBytes []byte
+	)
+	err = builder.
+		Select("status", "attempts", "retry_policy").
+		From("tasks").
+		Where("task_id = ?", taskID).
+		Suffix("FOR UPDATE").
+		QueryRowContext(ctx).
+		Scan(&status, &attempts, &policyBytes)
+
+	if err == sql.ErrNoRows {
+		return queue.ErrTaskNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	err = checkRunning(status)
+	if err != nil {
+		return err
+	}
+
+	policy, err := decodeRetryPolicy(policyBytes)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	stmt := builder.
+		Update("tasks").
+		Set("progress", progress).
+		Set("last_error", lastError).
+		Set("error", errorBytes).
+		Where("task_id = ?", taskID)
+
+	if policy == nil || queue.IsPermanent(taskErr) || !policy.ShouldRetry(attempts) {
+		span.SetTag("task.retry", false)
+		stmt = stmt.
+			Set("last_heartbeat_at", now).
+			Set("finished_at", now).
+			Set("status", queue.Failed)
+		// only the tasks that used up all the attempts of their retry policy are dead-lettered,
+		// the other failed tasks are removed by the retention
+		if policy != nil && !policy.ShouldRetry(attempts) {
+			stmt = stmt.Set("dead_lettered_at", now)
+		}
+		_, err = stmt.ExecContext(ctx)
+		return err
+	}
+
+	delay := policy.Backoff(attempts)
+	span.SetTag("task.retry", true)
+	span.SetTag("task.retryDelay", delay)
+
+	// the task becomes a regular waiting task that is delayed by the backoff
+	_, err = stmt.
+		Set("started_at", nil).
+		Set("last_heartbeat_at", nil).
+		Set("not_before", now.Add(delay)).
+		Set("status", queue.Waiting).
+		ExecContext(ctx)
+
+	return err
+}
+
+// taskOutcome contains the optional values stored together with the final progress
+type taskOutcome struct {
+	// result is stored when the task is finished
+	result queue.Result
+	// err is stored when the task is failed
+	err *queue.TaskError
+}
+
+func (q *dequeuer) updateProgress(ctx context.Context, taskID string, progress queue.Progress, isFinal, isFailed bool, outcome taskOutcome) (err error) {
 	span, ctx := q.StartSpan(ctx, "updateProgress")
 	defer func() {
 		q.FinishSpan(span, err)
@@ -256,6 +655,11 @@ func (q *dequeuer) updateProgress(ctx context.Context, taskID string, progress q
 		progress = emptyJSON
 	}
 
+	errorBytes, err := encodeTaskError(outcome.err)
+	if err != nil {
+		return err
+	}
+
 	builder, tx, err := q.GetTxQueryBuilder(ctx, nil)
 	if err != nil {
 		return err
@@ -292,13 +696,9 @@ func (q *dequeuer) updateProgress(ctx context.Context, taskID string, progress q
 	}
 
 	// we should proceed ONLY if the task is actually running
-	switch status {
-	case queue.Waiting:
-		return queue.ErrTaskNotRunning
-	case queue.Cancelled:
-		return queue.ErrTaskCancelled
-	case queue.Finished:
-		return queue.ErrTaskFinished
+	err = checkRunning(status)
+	if err != nil {
+		return err
 	}
 
 	stmt := builder.
@@ -318,43 +718,149 @@ func (q *dequeuer) updateProgress(ctx context.Context, taskID string, progress q
 		stmt = stmt.Set("status", queue.Running)
 	}
 
+	if isFinal && !isFailed && outcome.result != nil {
+		stmt = stmt.Set("result", []byte(outcome.result))
+	}
+
+	if isFailed && outcome.err != nil {
+		stmt = stmt.
+			Set("last_error", outcome.err.Message).
+			Set("error", errorBytes)
+	}
+
 	_, err = stmt.ExecContext(ctx)
 
 	return err
 }
 
-func (q *dequeuer) columns() []string {
+// checkRunning returns an error if the task with the given status can not be worked on
+func checkRunning(status queue.TaskStatus) error {
+	switch status {
+	case queue.Waiting, queue.Blocked:
+		return queue.ErrTaskNotRunning
+	case queue.Cancelled:
+		return queue.ErrTaskCancelled
+	case queue.Finished, queue.Failed:
+		return queue.ErrTaskFinished
+	}
+	return nil
+}
+
+// decodeRetryPolicy parses the stored retry policy, nil is returned when the task has no policy
+func decodeRetryPolicy(value []byte) (*queue.RetryPolicy, error) {
+	if len(value) == 0 {
+		return nil, nil
+	}
+
+	var policy queue.RetryPolicy
+	err := json.Unmarshal(value, &policy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode the task retry policy: %w", err)
+	}
+
+	return &policy, nil
+}
+
+// encodeTaskError serializes the task error for storing, nil is returned for nil errors
+func encodeTaskError(taskErr *queue.TaskError) ([]byte, error) {
+	if taskErr == nil {
+		return nil, nil
+	}
+
+	value, err := json.Marshal(taskErr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode the task error: %w", err)
+	}
+
+	return value, nil
+}
+
+// decodeTaskError parses the stored task error, nil is returned when the task has no error
+func decodeTaskError(value []byte) (*queue.TaskError, error) {
+	if len(value) == 0 {
+		return nil, nil
+	}
+
+	var taskErr queue.TaskError
+	err := json.Unmarshal(value, &taskErr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode the task error: %w", err)
+	}
+
+	return &taskErr, nil
+}
+
+// taskSelectColumns returns the list of task columns expected by scanTask
+func taskSelectColumns() []string {
 	return []string{
 		"task_id",
 		"queue",
 		"type",
 		"spec",
+		"priority",
 		"status",
 		"progress",
 		"created_at",
 		"updated_at",
+		"not_before",
 		"started_at",
 		"finished_at",
 		"last_heartbeat_at",
+		"attempts",
+		"last_error",
+		"retry_policy",
+		"dead_lettered_at",
+		"result",
+		"error",
+		"depends_on",
+		"workflow_id",
+		"scheduled_at",
 	}
 }
 
-func (q *dequeuer) scan(row squirrel.RowScanner) (task *queue.Task, err error) {
-	var t queue.Task
+// scanTask scans a task row selected using taskSelectColumns
+func scanTask(row squirrel.RowScanner) (task *queue.Task, err error) {
+	var (
+		t           queue.Task
+		policyBytes []byte
+		errorBytes  []byte
+		dependsOn   pq.StringArray
+		workflowID  sql.NullString
+	)
 	err = row.Scan(
 		&t.ID,
 		&t.Queue,
 		&t.Type,
 		&t.Spec,
+		&t.Priority,
 		&t.Status,
 		&t.Progress,
 		&t.CreatedAt,
 		&t.UpdatedAt,
+		&t.NotBefore,
 		&t.StartedAt,
 		&t.FinishedAt,
 		&t.LastHeartbeatAt,
+		&t.Attempts,
+		&t.LastError,
+		&policyBytes,
+		&t.DeadLetteredAt,
+		&t.Result,
+		&errorBytes,
+		&dependsOn,
+		&workflowID,
+		&t.ScheduledAt,
 	)
 	if err == nil {
+		t.RetryPolicy, err = decodeRetryPolicy(policyBytes)
+	}
+	if err == nil {
+		t.Error, err = decodeTaskError(errorBytes)
+	}
+	if err == nil {
+		t.DependsOn = dependsOn
+		t.WorkflowID = workflowID.String
+
 		task = &t
 		// ensure that we always have at least an empty json object
 		if len(task.Progress) == 0 {
@@ -367,86 +873,3 @@ func (q *dequeuer) scan(row squirrel.RowScanner) (task *queue.Task, err error) {
 
 	return task, err
 }
-
-// generateUnfinishedQueueList returns a distinct list of queues with unfinished tasks
-func (q *dequeuer) generateUnfinishedQueueList(ctx context.Context) (queueList []string, err error) {
-	span, ctx := q.StartSpan(ctx, "generateUnfinishedQueueList")
-	defer func() {
-		q.FinishSpan(span, err)
-	}()
-
-	where := squirrel.Eq{
-		"finished_at": nil,
-	}
-
-	return q.generateQueueList(ctx, where)
-}
-
-// generateInflightQueueList returns a distinct list of queues with tasks that are currently inflight
-func (q *dequeuer) generateInflightQueueList(ctx context.Context, beforeDoubleTTL time.Time) (queueList []string, err error) {
-	span, ctx := q.StartSpan(ctx, "generateInflightQueueList")
-	defer func() {
-		q.FinishSpan(span, err)
-	}()
-
-	where := squirrel.And{
-		squirrel.Gt{"last_heartbeat_at": beforeDoubleTTL},
-		squirrel.Eq{"finished_at": nil},
-	}
-
-	return q.generateQueueList(ctx, where)
-}
-
-// generateQueueList returns a distinct list of queues
-func (q *dequeuer) generateQueueList(ctx context.Context, where squirrel.Sqlizer) (queueList []string, err error) {
-	span, ctx := q.StartSpan(ctx, "generateQueueList")
-	defer func() {
-		q.FinishSpan(span, err)
-	}()
-
-	rows, err := q.GetQueryBuilder().
-		Select("queue").
-		Distinct().
-		From("tasks").
-		Where(where).
-		QueryContext(ctx)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var q string
-		err = rows.Scan(&q)
-		if err != nil {
-			return nil, err
-		}
-
-		queueList = append(queueList, q)
-	}
-	err = rows.Err()
-	if err != nil {
-		return nil, err
-	}
-
-	span.SetTag("queue.list", queueList)
-
-	return queueList, nil
-}
-
-// processableQueues removes all inflight items from potential items
-func processableQueues(inflight, potential []string) []string {
-	inflightMap := make(map[string]bool, len(inflight))
-	for _, s := range inflight {
-		inflightMap[s] = true
-	}
-	var processableQs []string
-	for _, s := range potential {
-		_, ok := inflightMap[s]
-		if !ok {
-			processableQs = append(processableQs, s)
-		}
-	}
-
-	return processableQs
-}
@@ -2,9 +2,10 @@ package postgres
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"os"
-	"sort"
 	"testing"
 	"time"
 
@@ -90,6 +91,88 @@ func TestFinish(t *testing.T) {
 	})
 }
 
+func TestResultWriter(t *testing.T) {
+	verifyLeak(t)
+
+	logrus.SetOutput(io.Discard)
+	defer logrus.SetOutput(os.Stdout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, db := dbtest.GetDatabase(t)
+	defer db.Close()
+	require.NoError(t, SetupTables(ctx, db, nil))
+
+	q := NewDequeuer(db, nil, config.Queue{
+		HeartbeatTTL:  10 * time.Second,
+		PollFrequency: 50 * time.Millisecond,
+	})
+	writer := q.(queue.ResultWriter)
+	i := NewInspector(db)
+
+	insertTask := func(t *testing.T) string {
+		taskID := uuid.NewV4().String()
+		_, err := squirrel.StatementBuilder.
+			PlaceholderFormat(squirrel.Dollar).
+			RunWith(db).
+			Insert("tasks").
+			Columns("task_id", "queue", "type", "spec", "progress", "status").
+			Values(taskID, queueID1, "test", emptyJSON, emptyJSON, queue.Running).
+			ExecContext(ctx)
+		require.NoError(t, err)
+		return taskID
+	}
+
+	t.Run("stores the result separately from the progress", func(t *testing.T) {
+		taskID := insertTask(t)
+		result := queue.Result(`{"rows": 42}`)
+
+		require.NoError(t, writer.FinishWithResult(ctx, taskID, progress, result))
+
+		task, err := i.GetTask(ctx, taskID)
+		require.NoError(t, err)
+		require.Equal(t, queue.Finished, task.Status)
+		require.Equal(t, queue.Progress(progress), task.Progress)
+		require.JSONEq(t, string(result), string(task.Result))
+		require.Nil(t, task.Error)
+	})
+
+	t.Run("stores the structured error of the failed task", func(t *testing.T) {
+		taskID := insertTask(t)
+		taskErr := &queue.TaskError{
+			Message: "can not process: boom",
+			Type:    "*errors.errorString",
+			Causes:  []string{"boom"},
+		}
+
+		require.NoError(t, writer.FailWithError(ctx, taskID, progress, taskErr))
+
+		task, err := i.GetTask(ctx, taskID)
+		require.NoError(t, err)
+		require.Equal(t, queue.Failed, task.Status)
+		require.Equal(t, queue.Progress(progress), task.Progress)
+		require.Equal(t, taskErr, task.Error)
+		require.Equal(t, taskErr.Message, task.LastError)
+		require.Nil(t, task.Result)
+	})
+
+	t.Run("stores the structured error of the failed attempt", func(t *testing.T) {
+		taskID := insertTask(t)
+
+		err := q.(queue.Retrier).FailAttempt(ctx, taskID, progress, fmt.Errorf("can not process: %w", errors.New("boom")))
+		require.NoError(t, err)
+
+		task, err := i.GetTask(ctx, taskID)
+		require.NoError(t, err)
+		require.Equal(t, &queue.TaskError{
+			Message: "can not process: boom",
+			Type:    "*errors.errorString",
+			Causes:  []string{"boom"},
+		}, task.Error)
+	})
+}
+
 func TestFail(t *testing.T) {
 	verifyLeak(t)
 
@@ -147,20 +230,194 @@ func TestFail(t *testing.T) {
 		).
 		ExecContext(ctx)
 
-	t.Run("sets the progress, failed status and finished timestamp", func(t *testing.T) {
+	t.Run("sets the progress, failed status and finished timestamp without dead-lettering", func(t *testing.T) {
 		err = q.Fail(ctx, taskID, progress)
 		require.NoError(t, err)
 		dbtest.EqualCount(t, db, 1, "tasks", squirrel.And{
 			squirrel.Eq{
-				"task_id":  taskID,
-				"status":   queue.Failed,
-				"progress": progress,
+				"task_id":          taskID,
+				"status":           queue.Failed,
+				"progress":         progress,
+				"dead_lettered_at": nil,
 			},
 			squirrel.NotEq{"finished_at": nil},
 		})
 	})
 }
 
+func TestFailAttempt(t *testing.T) {
+	verifyLeak(t)
+
+	logrus.SetOutput(io.Discard)
+	defer logrus.SetOutput(os.Stdout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	retryPolicy := []byte(`{"maxAttempts": 3, "initialBackoff": 60000000000}`)
+
+	cases := []struct {
+		name            string
+		attempts        int
+		retryPolicy     []byte
+		taskErr         error
+		expStatus       queue.TaskStatus
+		expDeadLettered bool
+	}{
+		{
+			name:        "puts the task back to the queue when attempts are left",
+			attempts:    1,
+			retryPolicy: retryPolicy,
+			taskErr:     errors.New("temporary error"),
+			expStatus:   queue.Waiting,
+		},
+		{
+			name:            "dead-letters the task when attempts are exhausted",
+			attempts:        3,
+			retryPolicy:     retryPolicy,
+			taskErr:         errors.New("temporary error"),
+			expStatus:       queue.Failed,
+			expDeadLettered: true,
+		},
+		{
+			name:        "fails the task when the error is permanent",
+			attempts:    1,
+			retryPolicy: retryPolicy,
+			taskErr:     queue.Permanent(errors.New("permanent error")),
+			expStatus:   queue.Failed,
+		},
+		{
+			name:        "fails the task when the error is ErrTaskFailed",
+			attempts:    1,
+			retryPolicy: retryPolicy,
+			taskErr:     queue.ErrTaskFailed,
+			expStatus:   queue.Failed,
+		},
+		{
+			name:      "fails the task when it has no retry policy",
+			attempts:  1,
+			taskErr:   errors.New("temporary error"),
+			expStatus: queue.Failed,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, db := dbtest.GetDatabase(t)
+			defer db.Close()
+			require.NoError(t, SetupTables(ctx, db, nil))
+
+			q := NewDequeuer(db, nil, config.Queue{
+				HeartbeatTTL:  10 * time.Second,
+				PollFrequency: 50 * time.Millisecond,
+			})
+
+			var policy interface{}
+			if tc.retryPolicy != nil {
+				policy = tc.retryPolicy
+			}
+
+			now := time.Now()
+			taskID := uuid.NewV4().String()
+			_, err := squirrel.StatementBuilder.
+				PlaceholderFormat(squirrel.Dollar).
+				RunWith(db).
+				Insert("tasks").
+				Columns(
+					"task_id",
+					"queue",
+					"type",
+					"spec",
+					"progress",
+					"status",
+					"started_at",
+					"last_heartbeat_at",
+					"attempts",
+					"retry_policy",
+				).
+				Values(
+					taskID,
+					queueID1,
+					"test",
+					emptyJSON,
+					emptyJSON,
+					queue.Running,
+					now,
+					now,
+					tc.attempts,
+					policy,
+				).
+				ExecContext(ctx)
+			require.NoError(t, err)
+
+			err = q.(queue.Retrier).FailAttempt(ctx, taskID, progress, tc.taskErr)
+			require.NoError(t, err)
+
+			dbtest.EqualCount(t, db, 1, "tasks", squirrel.Eq{
+				"task_id":    taskID,
+				"status":     tc.expStatus,
+				"progress":   progress,
+				"last_error": tc.taskErr.Error(),
+			})
+
+			if tc.expStatus == queue.Waiting {
+				dbtest.EqualCount(t, db, 1, "tasks", squirrel.And{
+					squirrel.Eq{
+						"task_id":           taskID,
+						"started_at":        nil,
+						"last_heartbeat_at": nil,
+						"finished_at":       nil,
+						"dead_lettered_at":  nil,
+					},
+					squirrel.Gt{"not_before": now.Add(59 * time.Second)},
+				}, "the task must be delayed by the backoff")
+				return
+			}
+
+			dbtest.EqualCount(t, db, 1, "tasks", squirrel.And{
+				squirrel.Eq{"task_id": taskID},
+				squirrel.NotEq{"finished_at": nil},
+			}, "the task must be finished")
+
+			deadLettered := squirrel.Sqlizer(squirrel.Eq{"dead_lettered_at": nil})
+			if tc.expDeadLettered {
+				deadLettered = squirrel.NotEq{"dead_lettered_at": nil}
+			}
+			dbtest.EqualCount(t, db, 1, "tasks", squirrel.And{
+				squirrel.Eq{"task_id": taskID},
+				deadLettered,
+			}, "unexpected dead-lettering")
+		})
+	}
+
+	t.Run("returns an error when the task is not running", func(t *testing.T) {
+		_, db := dbtest.GetDatabase(t)
+		defer db.Close()
+		require.NoError(t, SetupTables(ctx, db, nil))
+
+		q := NewDequeuer(db, nil, config.Queue{
+			HeartbeatTTL:  10 * time.Second,
+			PollFrequency: 50 * time.Millisecond,
+		})
+
+		taskID := uuid.NewV4().String()
+		_, err := squirrel.StatementBuilder.
+			PlaceholderFormat(squirrel.Dollar).
+			RunWith(db).
+			Insert("tasks").
+			Columns("task_id", "queue", "type", "spec", "progress", "status").
+			Values(taskID, queueID1, "test", emptyJSON, emptyJSON, queue.Finished).
+			ExecContext(ctx)
+		require.NoError(t, err)
+
+		err = q.(queue.Retrier).FailAttempt(ctx, taskID, progress, errors.New("some error"))
+		require.Equal(t, queue.ErrTaskFinished, err)
+
+		err = q.(queue.Retrier).FailAttempt(ctx, uuid.NewV4().String(), progress, errors.New("some error"))
+		require.Equal(t, queue.ErrTaskNotFound, err)
+	})
+}
+
 func TestHeartbeat(t *testing.T) {
 	verifyLeak(t)
 
@@ -435,6 +692,7 @@ func TestDequeue(t *testing.T) {
 
 	now := time.Now()
 	old := time.Now().Add(-10 * time.Minute)
+	future := time.Now().Add(10 * time.Minute)
 
 	cases := []struct {
 		name     string
@@ -486,6 +744,17 @@ func TestDequeue(t *testing.T) {
 				{StartedAt: &now, LastHeartbeatAt: &now},
 			},
 		},
+		{
+			name:  "queue has one delayed task",
+			queue: queueID1,
+			tasks: []queue.Task{{NotBefore: future}},
+		},
+		{
+			name:    "queue has one delayed task which time has come",
+			queue:   queueID1,
+			tasks:   []queue.Task{{NotBefore: old}},
+			expTask: true,
+		},
 	}
 
 	for _, tc := range cases {
@@ -517,6 +786,10 @@ func TestDequeue(t *testing.T) {
 				RunWith(db)
 
 			for _, task := range tc.tasks {
+				notBefore := task.NotBefore
+				if notBefore.IsZero() {
+					notBefore = old
+				}
 				_, err := builder.Insert("tasks").
 					Columns(
 						"task_id",
@@ -525,6 +798,7 @@ func TestDequeue(t *testing.T) {
 						"spec",
 						"progress",
 						"status",
+						"not_before",
 						"started_at",
 						"last_heartbeat_at",
 						"finished_at").
@@ -535,6 +809,7 @@ func TestDequeue(t *testing.T) {
 						emptyJSON,
 						emptyJSON,
 						queue.Waiting,
+						notBefore,
 						task.StartedAt,
 						task.LastHeartbeatAt,
 						task.FinishedAt).
@@ -558,113 +833,149 @@ func TestDequeue(t *testing.T) {
 	}
 }
 
-func TestProcessableQueues(t *testing.T) {
+func TestDequeueDelayed(t *testing.T) {
 	verifyLeak(t)
 
-	tests := []struct {
-		name      string
-		inflight  []string
-		potential []string
-		wanted    []string
-	}{
-		{
-			name:      "empty inflight; empty potential",
-			inflight:  nil,
-			potential: nil,
-			wanted:    nil,
-		},
-		{
-			name:      "empty inflight; some potential",
-			inflight:  nil,
-			potential: []string{"a", "b"},
-			wanted:    []string{"a", "b"},
-		},
-		{
-			name:      "some inflight; empty potential",
-			inflight:  []string{"a", "b"},
-			potential: nil,
-			wanted:    nil,
-		},
-		{
-			name:      "same inflight and potential",
-			inflight:  []string{"a", "b"},
-			potential: []string{"a", "b"},
-			wanted:    nil,
+	logrus.SetOutput(io.Discard)
+	defer logrus.SetOutput(os.Stdout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	name, db := dbtest.GetDatabase(t)
+	defer db.Close()
+	require.NoError(t, SetupTables(ctx, db, nil))
+
+	connStr := "user=contiamo_test password=localdev sslmode=disable dbname=" + name
+	dbListener := pq.NewListener(
+		connStr,
+		10*time.Second,
+		time.Minute,
+		func(ev pq.ListenerEventType, err error) {
+			if err != nil {
+				logrus.Error(err)
+			}
 		},
-		{
-			name:      "some similar elements in inflight and potential",
-			inflight:  []string{"a", "b"},
-			potential: []string{"a", "b", "c", "d"},
-			wanted:    []string{"c", "d"},
+	)
+	defer dbListener.Close()
+
+	// the poll frequency is much longer than the delay, so only the wakeup
+	// timer can deliver the task in time
+	q := NewDequeuer(db, dbListener, config.Queue{
+		HeartbeatTTL:  10 * time.Second,
+		PollFrequency: time.Minute,
+	})
+
+	delay := 500 * time.Millisecond
+	notBefore := time.Now().Add(delay)
+	_, err := NewQueuer(db).Enqueue(ctx, queue.TaskEnqueueRequest{
+		TaskBase: queue.TaskBase{
+			Queue: queueID1,
+			Type:  "test",
+			Spec:  spec,
 		},
-	}
+		NotBefore: notBefore,
+	})
+	require.NoError(t, err)
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			result := processableQueues(tt.inflight, tt.potential)
-			require.Equal(t, tt.wanted, result)
-		})
-	}
+	t.Run("delayed task is not available before its time", func(t *testing.T) {
+		task, err := q.(*dequeuer).attemptDequeue(ctx, queueID1)
+		require.NoError(t, err)
+		require.Nil(t, task)
+	})
+
+	t.Run("dequeue wakes up when the delayed task becomes available", func(t *testing.T) {
+		dequeueCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+
+		task, err := q.Dequeue(dequeueCtx, queueID1)
+		require.NoError(t, err)
+		require.NotNil(t, task)
+		require.False(t, time.Now().Before(notBefore))
+		require.WithinDuration(t, notBefore, task.NotBefore, time.Millisecond)
+	})
 }
 
-func TestQueueList(t *testing.T) {
+func TestDequeuePriority(t *testing.T) {
 	verifyLeak(t)
 
+	logrus.SetOutput(io.Discard)
+	defer logrus.SetOutput(os.Stdout)
+
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
-	qs := []string{
-		queueID1,
-		queueID2,
-		queueID3,
+
+	old := time.Now().Add(-10 * time.Minute)
+	older := time.Now().Add(-20 * time.Minute)
+
+	type dbTask struct {
+		id        string
+		queue     string
+		priority  int
+		createdAt time.Time
 	}
-	sort.Strings(qs)
 
-	tests := []struct {
-		name    string
-		db      string
-		queues  []string
-		where   squirrel.Sqlizer
-		expList []string
+	cases := []struct {
+		name   string
+		queues []string
+		tasks  []dbTask
+		expID  string
 	}{
 		{
-			name: "no queues",
-			db:   "postgres_queue_queue_list_no_queues",
+			name:   "picks the task with the highest priority within a queue",
+			queues: []string{queueID1},
+			tasks: []dbTask{
+				{id: "a0000000-0000-0000-0000-000000000001", queue: queueID1, priority: 0, createdAt: older},
+				{id: "a0000000-0000-0000-0000-000000000002", queue: queueID1, priority: 10, createdAt: old},
+			},
+			expID: "a0000000-0000-0000-0000-000000000002",
 		},
 		{
-			name:    "queues; no where statement",
-			db:      "postgres_queue_queue_list_no_where_stmt",
-			queues:  qs,
-			expList: qs,
+			name:   "picks the oldest task when priorities are equal",
+			queues: []string{queueID1},
+			tasks: []dbTask{
+				{id: "a0000000-0000-0000-0000-000000000001", queue: queueID1, priority: 5, createdAt: old},
+				{id: "a0000000-0000-0000-0000-000000000002", queue: queueID1, priority: 5, createdAt: older},
+			},
+			expID: "a0000000-0000-0000-0000-000000000002",
 		},
 		{
-			name:    "queues; where statement",
-			db:      "postgres_queue_queue_list_where_stmt",
-			queues:  qs,
-			where:   squirrel.Eq{"queue": qs[0]},
-			expList: []string{qs[0]},
+			name:   "picks the queue with the highest priority task",
+			queues: []string{queueID1, queueID2},
+			tasks: []dbTask{
+				{id: "a0000000-0000-0000-0000-000000000001", queue: queueID1, priority: 1, createdAt: older},
+				{id: "a0000000-0000-0000-0000-000000000002", queue: queueID2, priority: 2, createdAt: old},
+			},
+			expID: "a0000000-0000-0000-0000-000000000002",
+		},
+		{
+			name: "picks the highest priority task from any queue if queues are not specified",
+			tasks: []dbTask{
+				{id: "a0000000-0000-0000-0000-000000000001", queue: queueID1, priority: -1, createdAt: older},
+				{id: "a0000000-0000-0000-0000-000000000002", queue: queueID2, priority: 0, createdAt: old},
+				{id: "a0000000-0000-0000-0000-000000000003", queue: queueID3, priority: -5, createdAt: older},
+			},
+			expID: "a0000000-0000-0000-0000-000000000002",
 		},
 	}
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
 			_, db := dbtest.GetDatabase(t)
 			defer db.Close()
 			require.NoError(t, SetupTables(ctx, db, nil))
 
-			q := NewDequeuer(
-				db,
-				nil, // listener is not needed for this test
-				config.Queue{
-					HeartbeatTTL:  10 * time.Second,
-					PollFrequency: 50 * time.Millisecond,
-				})
+			q := NewDequeuer(db, nil, config.Queue{
+				HeartbeatTTL:  10 * time.Second,
+				PollFrequency: 50 * time.Millisecond,
+			})
 
-			pd, ok := q.(*dequeuer)
-			require.True(t, ok)
+			builder := squirrel.StatementBuilder.
+				PlaceholderFormat(squirrel.Dollar).
+				RunWith(db)
 
-			for _, queueName := range tt.queues {
-				_, err := pd.GetQueryBuilder().
-					Insert("tasks").
+			for _, task := range tc.tasks {
+				_, err := builder.Insert("tasks").
 					Columns(
 						"task_id",
 						"queue",
@@ -672,24 +983,132 @@ func TestQueueList(t *testing.T) {
 						"spec",
 						"progress",
 						"status",
+						"priority",
+						"created_at",
 					).
 					Values(
-						uuid.NewV4(),
-						queueName,
+						task.id,
+						task.queue,
 						"test",
 						emptyJSON,
 						emptyJSON,
 						queue.Waiting,
+						task.priority,
+						task.createdAt,
 					).
 					ExecContext(ctx)
-
 				require.NoError(t, err)
 			}
 
-			qList, err := pd.generateQueueList(ctx, tt.where)
-			sort.Strings(qList)
+			task, err := q.(*dequeuer).attemptDequeue(ctx, tc.queues...)
 			require.NoError(t, err)
-			require.Equal(t, tt.expList, qList)
+			require.NotNil(t, task)
+			require.Equal(t, tc.expID, task.ID)
+		})
+	}
+}
+
+func BenchmarkDequeueContention(b *testing.B) {
+	logrus.SetOutput(io.Discard)
+	defer logrus.SetOutput(os.Stdout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	cases := []struct {
+		name string
+		// concurrency is the limit of every queue, nil keeps the default
+		concurrency *int
+	}{
+		{
+			name:        "unlimited concurrency",
+			concurrency: intPtr(queue.UnlimitedQueueConcurrency),
+		},
+		{
+			name: "default concurrency",
+		},
+	}
+
+	for _, tc := range cases {
+		b.Run(tc.name, func(b *testing.B) {
+			_, db := dbtest.GetDatabase(b)
+			defer db.Close()
+			require.NoError(b, SetupTables(ctx, db, nil))
+
+			queues := make([]string, 10)
+			for i := range queues {
+				queues[i] = uuid.NewV4().String()
+				if tc.concurrency != nil {
+					require.NoError(b, NewConcurrencyLimiter(db).SetQueueConcurrency(ctx, queues[i], *tc.concurrency))
+				}
+			}
+
+			builder := squirrel.StatementBuilder.
+				PlaceholderFormat(squirrel.Dollar).
+				RunWith(db)
+
+			// insert one task per iteration in batches
+			for i := 0; i < b.N; i += 1000 {
+				insert := builder.
+					Insert("tasks").
+					Columns("task_id", "queue", "type", "spec", "progress", "status")
+				for j := i; j < i+1000 && j < b.N; j++ {
+					insert = insert.Values(uuid.NewV4().String(), queues[j%len(queues)], "test", emptyJSON, emptyJSON, queue.Waiting)
+				}
+				_, err := insert.ExecContext(ctx)
+				require.NoError(b, err)
+			}
+
+			q := NewDequeuer(db, nil, config.Queue{
+				HeartbeatTTL:  time.Minute,
+				PollFrequency: time.Second,
+			}).(*dequeuer)
+
+			// many workers per CPU competing for the same tasks
+			b.SetParallelism(8)
+			b.ResetTimer()
+
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					var task *queue.Task
+					for task == nil {
+						var err error
+						task, err = q.attemptDequeue(ctx)
+						if err != nil {
+							b.Error(err)
+							return
+						}
+						// all remaining tasks can be locked by other workers for a moment
+						// or wait for a free slot of their queue
+					}
+
+					if tc.concurrency != nil {
+						continue
+					}
+
+					// the limited queues run one task at a time, the task frees the slot when it's finished
+					var running int
+					err := builder.
+						Select("COUNT(*)").
+						From("tasks").
+						Where(squirrel.Eq{"queue": task.Queue, "status": queue.Running}).
+						QueryRowContext(ctx).
+						Scan(&running)
+					if err != nil {
+						b.Error(err)
+						return
+					}
+					if running > queue.DefaultQueueConcurrency {
+						b.Errorf("queue %s has %d running tasks", task.Queue, running)
+					}
+
+					err = q.Finish(ctx, task.ID, emptyJSON)
+					if err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
 		})
 	}
 }
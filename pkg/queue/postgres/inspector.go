@@ -0,0 +1,184 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/contiamo/go-base/v4/pkg/data/managers"
+	"github.com/contiamo/go-base/v4/pkg/http/parameters"
+	"github.com/contiamo/go-base/v4/pkg/queue"
+	"github.com/opentracing/opentracing-go"
+)
+
+// NewInspector creates a new postgres queue inspector
+func NewInspector(db *sql.DB) queue.Inspector {
+	return &inspector{
+		BaseManager: managers.NewBaseManager(db, "PostgresInspector"),
+	}
+}
+
+// inspector is a postgres-backed implementation of the queue Inspector
+type inspector struct {
+	managers.BaseManager
+}
+
+func (i *inspector) GetTask(ctx context.Context, taskID string) (task *queue.Task, err error) {
+	span, ctx := i.StartSpan(ctx, "GetTask")
+	defer func() {
+		i.FinishSpan(span, err)
+	}()
+
+	span.SetTag("task.ID", taskID)
+
+	if !isValidID(taskID) {
+		return nil, queue.ErrTaskNotFound
+	}
+
+	row := i.GetQueryBuilder().
+		Select(taskSelectColumns()...).
+		From(TasksTable).
+		Where("task_id = ?", taskID).
+		QueryRowContext(ctx)
+
+	task, err = scanTask(row)
+	if err == sql.ErrNoRows {
+		return nil, queue.ErrTaskNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return task, nil
+}
+
+func (i *inspector) ListTasks(ctx context.Context, filter queue.TaskFilter, page parameters.Page) (list queue.TaskList, err error) {
+	span, ctx := i.StartSpan(ctx, "ListTasks")
+	defer func() {
+		i.FinishSpan(span, err)
+	}()
+
+	setTaskFilterTags(span, filter)
+
+	if page.Number < 1 {
+		page.Number = 1
+	}
+	if page.Size < 1 {
+		page.Size = parameters.DefaultPageSize
+	}
+
+	where := taskFilterCondition(filter)
+
+	list.PageInfo, err = i.GetPageInfo(ctx, TasksTable, page, nil, where)
+	if err != nil {
+		return list, err
+	}
+
+	rows, err := i.GetQueryBuilder().
+		Select(taskSelectColumns()...).
+		From(TasksTable).
+		Where(where).
+		OrderBy("created_at DESC", "task_id").
+		Offset(uint64((page.Number - 1) * page.Size)).
+		Limit(uint64(page.Size)).
+		QueryContext(ctx)
+	if err != nil {
+		return list, err
+	}
+	defer rows.Close()
+
+	list.Items = make([]queue.Task, 0, page.Size)
+	for rows.Next() {
+		task, err := scanTask(rows)
+		if err != nil {
+			return list, err
+		}
+		list.Items = append(list.Items, *task)
+	}
+
+	return list, rows.Err()
+}
+
+func (i *inspector) CountTasks(ctx context.Context, filter queue.TaskFilter) (counts []queue.TaskCount, err error) {
+	span, ctx := i.StartSpan(ctx, "CountTasks")
+	defer func() {
+		i.FinishSpan(span, err)
+	}()
+
+	setTaskFilterTags(span, filter)
+
+	rows, err := i.GetQueryBuilder().
+		Select("queue", "status", "COUNT(*)").
+		From(TasksTable).
+		Where(taskFilterCondition(filter)).
+		GroupBy("queue", "status").
+		OrderBy("queue", "status").
+		QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts = []queue.TaskCount{}
+	for rows.Next() {
+		var count queue.TaskCount
+		err = rows.Scan(&count.Queue, &count.Status, &count.Count)
+		if err != nil {
+			return nil, err
+		}
+		counts = append(counts, count)
+	}
+
+	return counts, rows.Err()
+}
+
+func (i *inspector) GetWorkflow(ctx context.Context, workflowID string) (workflow *queue.Workflow, err error) {
+	span, ctx := i.StartSpan(ctx, "GetWorkflow")
+	defer func() {
+		i.FinishSpan(span, err)
+	}()
+
+	span.SetTag("workflow.ID", workflowID)
+
+	rows, err := i.GetQueryBuilder().
+		Select(taskSelectColumns()...).
+		From(TasksTable).
+		Where(squirrel.Eq{"workflow_id": workflowID}).
+		OrderBy("created_at", "task_id").
+		QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tasks := []queue.Task{}
+	for rows.Next() {
+		task, err := scanTask(rows)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, *task)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(tasks) == 0 {
+		return nil, queue.ErrWorkflowNotFound
+	}
+
+	workflow = queue.NewWorkflow(workflowID, tasks)
+	span.SetTag("workflow.status", workflow.Status)
+
+	return workflow, nil
+}
+
+// setTaskFilterTags adds the task filter values to the span
+func setTaskFilterTags(span opentracing.Span, filter queue.TaskFilter) {
+	span.SetTag("filter.queue", filter.Queue)
+	span.SetTag("filter.type", filter.Type)
+	span.SetTag("filter.statuses", filter.Statuses)
+	span.SetTag("filter.workflowID", filter.WorkflowID)
+}
@@ -0,0 +1,115 @@
+package postgres
+
+import (
+	"context"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	dbtest "github.com/contiamo/go-base/v4/pkg/db/test"
+	"github.com/contiamo/go-base/v4/pkg/http/parameters"
+	"github.com/contiamo/go-base/v4/pkg/queue"
+	uuid "github.com/satori/go.uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInspector(t *testing.T) {
+	verifyLeak(t)
+
+	logrus.SetOutput(io.Discard)
+	defer logrus.SetOutput(os.Stdout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, db := dbtest.GetDatabase(t)
+	defer db.Close()
+	require.NoError(t, SetupTables(ctx, db, nil))
+
+	now := time.Now()
+	tasks := []struct {
+		id        string
+		queue     string
+		status    queue.TaskStatus
+		createdAt time.Time
+	}{
+		{uuid.NewV4().String(), queueID1, queue.Waiting, now.Add(-time.Minute)},
+		{uuid.NewV4().String(), queueID1, queue.Running, now.Add(-2 * time.Minute)},
+		{uuid.NewV4().String(), queueID1, queue.Waiting, now.Add(-3 * time.Minute)},
+		{uuid.NewV4().String(), queueID2, queue.Finished, now.Add(-time.Hour)},
+	}
+
+	for _, task := range tasks {
+		_, err := squirrel.StatementBuilder.
+			PlaceholderFormat(squirrel.Dollar).
+			RunWith(db).
+			Insert("tasks").
+			Columns("task_id", "queue", "type", "spec", "progress", "status", "created_at").
+			Values(task.id, task.queue, "test", spec, progress, task.status, task.createdAt).
+			ExecContext(ctx)
+		require.NoError(t, err)
+	}
+
+	i := NewInspector(db)
+
+	t.Run("gets a task by ID", func(t *testing.T) {
+		task, err := i.GetTask(ctx, tasks[0].id)
+		require.NoError(t, err)
+		require.Equal(t, tasks[0].id, task.ID)
+		require.Equal(t, queueID1, task.Queue)
+		require.Equal(t, queue.Waiting, task.Status)
+		require.Equal(t, queue.Spec(spec), task.Spec)
+		require.Equal(t, queue.Progress(progress), task.Progress)
+	})
+
+	t.Run("returns an error when the task does not exist", func(t *testing.T) {
+		_, err := i.GetTask(ctx, uuid.NewV4().String())
+		require.Equal(t, queue.ErrTaskNotFound, err)
+	})
+
+	t.Run("lists tasks with pagination, the most recent first", func(t *testing.T) {
+		list, err := i.ListTasks(ctx, queue.TaskFilter{Queue: queueID1}, parameters.Page{Number: 1, Size: 2})
+		require.NoError(t, err)
+		require.Len(t, list.Items, 2)
+		require.Equal(t, tasks[0].id, list.Items[0].ID)
+		require.Equal(t, tasks[1].id, list.Items[1].ID)
+		require.Equal(t, uint32(3), list.PageInfo.ItemCount)
+		require.Equal(t, uint32(4), list.PageInfo.UnfilteredItemCount)
+		require.Equal(t, uint32(2), list.PageInfo.ItemsPerPage)
+		require.Equal(t, uint32(1), list.PageInfo.Current)
+
+		list, err = i.ListTasks(ctx, queue.TaskFilter{Queue: queueID1}, parameters.Page{Number: 2, Size: 2})
+		require.NoError(t, err)
+		require.Len(t, list.Items, 1)
+		require.Equal(t, tasks[2].id, list.Items[0].ID)
+		require.Equal(t, uint32(2), list.PageInfo.Current)
+	})
+
+	t.Run("lists tasks filtered by status and creation time", func(t *testing.T) {
+		list, err := i.ListTasks(ctx, queue.TaskFilter{
+			Statuses:  []queue.TaskStatus{queue.Waiting, queue.Finished},
+			CreatedAt: queue.TimeRange{From: now.Add(-2 * time.Hour), To: now.Add(-2 * time.Minute)},
+		}, parameters.Page{Number: 1, Size: 10})
+		require.NoError(t, err)
+		require.Len(t, list.Items, 2)
+		require.Equal(t, tasks[2].id, list.Items[0].ID)
+		require.Equal(t, tasks[3].id, list.Items[1].ID)
+		require.Equal(t, uint32(2), list.PageInfo.ItemCount)
+	})
+
+	t.Run("counts tasks per queue and status", func(t *testing.T) {
+		counts, err := i.CountTasks(ctx, queue.TaskFilter{Queue: queueID1})
+		require.NoError(t, err)
+		require.Equal(t, []queue.TaskCount{
+			{Queue: queueID1, Status: queue.Running, Count: 1},
+			{Queue: queueID1, Status: queue.Waiting, Count: 2},
+		}, counts)
+
+		counts, err = i.CountTasks(ctx, queue.TaskFilter{Type: "unknown"})
+		require.NoError(t, err)
+		require.Len(t, counts, 0)
+	})
+}
@@ -0,0 +1,263 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/contiamo/go-base/v4/pkg/data/managers"
+	"github.com/contiamo/go-base/v4/pkg/queue"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+// NewManager creates a new postgres task manager
+func NewManager(db *sql.DB) queue.Manager {
+	return &manager{
+		BaseManager: managers.NewBaseManager(db, "PostgresManager"),
+	}
+}
+
+// manager is a postgres-backed implementation of the queue Manager
+type manager struct {
+	managers.BaseManager
+}
+
+func (m *manager) Cancel(ctx context.Context, taskID string) (err error) {
+	span, ctx := m.StartSpan(ctx, "Cancel")
+	defer func() {
+		m.FinishSpan(span, err)
+	}()
+
+	span.SetTag("task.ID", taskID)
+
+	if !isValidID(taskID) {
+		return queue.ErrTaskNotFound
+	}
+
+	builder, tx, err := m.GetTxQueryBuilder(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err == nil {
+			err = tx.Commit()
+			return
+		}
+
+		rollbackErr := tx.Rollback()
+		if rollbackErr != nil {
+			err = errors.Wrap(err, rollbackErr.Error())
+		}
+	}()
+
+	var status queue.TaskStatus
+	err = builder.
+		Select("status").
+		From(TasksTable).
+		Where("task_id = ?", taskID).
+		Suffix("FOR UPDATE").
+		QueryRowContext(ctx).
+		Scan(&status)
+
+	if err == sql.ErrNoRows {
+		return queue.ErrTaskNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	switch status {
+	case queue.Cancelled:
+		return nil
+	case queue.Finished, queue.Failed:
+		return queue.ErrTaskFinished
+	}
+
+	now := time.Now()
+	_, err = builder.
+		Update(TasksTable).
+		Set("status", queue.Cancelled).
+		Set("finished_at", now).
+		Set("updated_at", now).
+		Where("task_id = ?", taskID).
+		ExecContext(ctx)
+
+	return err
+}
+
+func (m *manager) CancelTasks(ctx context.Context, filter queue.TaskFilter) (cancelled int64, err error) {
+	span, ctx := m.StartSpan(ctx, "CancelTasks")
+	defer func() {
+		m.FinishSpan(span, err)
+	}()
+
+	setTaskFilterTags(span, filter)
+
+	if filter.IsEmpty() {
+		return 0, queue.ErrEmptyTaskFilter
+	}
+
+	now := time.Now()
+	res, err := m.GetQueryBuilder().
+		Update(TasksTable).
+		Set("status", queue.Cancelled).
+		Set("finished_at", now).
+		Set("updated_at", now).
+		Where(taskFilterCondition(filter)).
+		Where(squirrel.Eq{
+			"status":      []queue.TaskStatus{queue.Waiting, queue.Running, queue.Blocked},
+			"finished_at": nil,
+		}).
+		ExecContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	cancelled, err = res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	span.SetTag("cancelled", cancelled)
+
+	return cancelled, nil
+}
+
+func (m *manager) Retry(ctx context.Context, taskID string) (err error) {
+	span, ctx := m.StartSpan(ctx, "Retry")
+	defer func() {
+		m.FinishSpan(span, err)
+	}()
+
+	span.SetTag("task.ID", taskID)
+
+	if !isValidID(taskID) {
+		return queue.ErrTaskNotFound
+	}
+
+	builder, tx, err := m.GetTxQueryBuilder(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err == nil {
+			err = tx.Commit()
+			return
+		}
+
+		rollbackErr := tx.Rollback()
+		if rollbackErr != nil {
+			err = errors.Wrap(err, rollbackErr.Error())
+		}
+	}()
+
+	var status queue.TaskStatus
+	err = builder.
+		Select("status").
+		From(TasksTable).
+		Where("task_id = ?", taskID).
+		Suffix("FOR UPDATE").
+		QueryRowContext(ctx).
+		Scan(&status)
+
+	if err == sql.ErrNoRows {
+		return queue.ErrTaskNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if status != queue.Failed && status != queue.Cancelled {
+		return queue.ErrTaskNotRetryable
+	}
+
+	_, err = resetTask(builder.Update(TasksTable), time.Now()).
+		Where("task_id = ?", taskID).
+		ExecContext(ctx)
+	if isUniqueViolation(err) {
+		return queue.ErrDuplicateIdempotencyKey
+	}
+
+	return err
+}
+
+// resetStatus is the status of the reset task depending on the status of its dependencies
+const resetStatus = `CASE WHEN EXISTS (
+	SELECT 1 FROM tasks parent
+	WHERE parent.task_id = ANY (tasks.depends_on) AND parent.status <> 'finished'
+) THEN ? ELSE ? END`
+
+// resetTask sets the task columns to the state of a newly enqueued task,
+// the last error is kept for the reference until the next failure.
+// The task is blocked again until all the tasks it depends on are finished.
+// The reset task violates the unique idempotency key index when another unfinished task
+// has the same key, see isUniqueViolation.
+func resetTask(stmt squirrel.UpdateBuilder, now time.Time) squirrel.UpdateBuilder {
+	return stmt.
+		Set("status", squirrel.Expr(resetStatus, queue.Blocked, queue.Waiting)).
+		Set("progress", emptyJSON).
+		Set("attempts", 0).
+		Set("not_before", now).
+		Set("updated_at", now).
+		Set("started_at", nil).
+		Set("finished_at", nil).
+		Set("last_heartbeat_at", nil).
+		Set("dead_lettered_at", nil).
+		Set("result", nil).
+		Set("error", nil)
+}
+
+// isUniqueViolation returns true if the error is caused by a unique index
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code.Name() == "unique_violation"
+}
+
+// taskFilterCondition builds the WHERE condition matching the tasks selected by the filter
+func taskFilterCondition(filter queue.TaskFilter) squirrel.And {
+	where := squirrel.And{}
+
+	if filter.Queue != "" {
+		where = append(where, squirrel.Eq{"queue": filter.Queue})
+	}
+
+	if filter.Type != "" {
+		where = append(where, squirrel.Eq{"type": filter.Type})
+	}
+
+	if len(filter.Statuses) > 0 {
+		where = append(where, squirrel.Eq{"status": filter.Statuses})
+	}
+
+	if len(filter.References) > 0 {
+		where = append(where, squirrel.Eq(filter.References))
+	}
+
+	if filter.WorkflowID != "" {
+		where = append(where, squirrel.Eq{"workflow_id": filter.WorkflowID})
+	}
+
+	where = append(where, timeRangeCondition("created_at", filter.CreatedAt)...)
+	where = append(where, timeRangeCondition("finished_at", filter.FinishedAt)...)
+
+	return where
+}
+
+// timeRangeCondition builds the WHERE condition matching the column values within the time range
+func timeRangeCondition(column string, r queue.TimeRange) squirrel.And {
+	where := squirrel.And{}
+
+	if !r.From.IsZero() {
+		where = append(where, squirrel.GtOrEq{column: r.From})
+	}
+
+	if !r.To.IsZero() {
+		where = append(where, squirrel.Lt{column: r.To})
+	}
+
+	return where
+}
@@ -0,0 +1,362 @@
+package postgres
+
+import (
+	"context"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/contiamo/go-base/v4/pkg/config"
+	dbtest "github.com/contiamo/go-base/v4/pkg/db/test"
+	"github.com/contiamo/go-base/v4/pkg/queue"
+	uuid "github.com/satori/go.uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTaskFilterCondition(t *testing.T) {
+	from := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		name    string
+		filter  queue.TaskFilter
+		expSQL  string
+		expArgs []interface{}
+	}{
+		{
+			name:    "empty filter matches all tasks",
+			expSQL:  "(1=1)",
+			expArgs: []interface{}{},
+		},
+		{
+			name: "matches tasks by all filter values",
+			filter: queue.TaskFilter{
+				Queue:      "queue1",
+				Type:       "test",
+				References: queue.References{"test_id": "some-id"},
+				WorkflowID: "workflow1",
+			},
+			expSQL:  "(queue = ? AND type = ? AND test_id = ? AND workflow_id = ?)",
+			expArgs: []interface{}{"queue1", queue.TaskType("test"), "some-id", "workflow1"},
+		},
+		{
+			name: "matches tasks by statuses and time ranges",
+			filter: queue.TaskFilter{
+				Statuses:   []queue.TaskStatus{queue.Waiting, queue.Running},
+				CreatedAt:  queue.TimeRange{From: from, To: to},
+				FinishedAt: queue.TimeRange{To: to},
+			},
+			expSQL:  "(status IN (?,?) AND created_at >= ? AND created_at < ? AND finished_at < ?)",
+			expArgs: []interface{}{queue.Waiting, queue.Running, from, to, to},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			query, args, err := taskFilterCondition(tc.filter).ToSql()
+			require.NoError(t, err)
+			require.Equal(t, tc.expSQL, query)
+			require.Equal(t, tc.expArgs, args)
+		})
+	}
+}
+
+func TestCancel(t *testing.T) {
+	verifyLeak(t)
+
+	logrus.SetOutput(io.Discard)
+	defer logrus.SetOutput(os.Stdout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, db := dbtest.GetDatabase(t)
+	defer db.Close()
+	require.NoError(t, SetupTables(ctx, db, nil))
+
+	insertTask := func(t *testing.T, status queue.TaskStatus) string {
+		taskID := uuid.NewV4().String()
+		_, err := squirrel.StatementBuilder.
+			PlaceholderFormat(squirrel.Dollar).
+			RunWith(db).
+			Insert("tasks").
+			Columns("task_id", "queue", "type", "spec", "progress", "status").
+			Values(taskID, queueID1, "test", emptyJSON, emptyJSON, status).
+			ExecContext(ctx)
+		require.NoError(t, err)
+		return taskID
+	}
+
+	m := NewManager(db)
+
+	cases := []struct {
+		name      string
+		status    queue.TaskStatus
+		expError  error
+		expStatus queue.TaskStatus
+	}{
+		{
+			name:      "cancels a waiting task",
+			status:    queue.Waiting,
+			expStatus: queue.Cancelled,
+		},
+		{
+			name:      "cancels a running task",
+			status:    queue.Running,
+			expStatus: queue.Cancelled,
+		},
+		{
+			name:      "ignores a cancelled task",
+			status:    queue.Cancelled,
+			expStatus: queue.Cancelled,
+		},
+		{
+			name:      "returns an error for a finished task",
+			status:    queue.Finished,
+			expError:  queue.ErrTaskFinished,
+			expStatus: queue.Finished,
+		},
+		{
+			name:      "returns an error for a failed task",
+			status:    queue.Failed,
+			expError:  queue.ErrTaskFinished,
+			expStatus: queue.Failed,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			taskID := insertTask(t, tc.status)
+
+			err := m.Cancel(ctx, taskID)
+			require.Equal(t, tc.expError, err)
+
+			dbtest.EqualCount(t, db, 1, "tasks", squirrel.Eq{
+				"task_id": taskID,
+				"status":  tc.expStatus,
+			})
+		})
+	}
+
+	t.Run("returns an error when the task does not exist", func(t *testing.T) {
+		err := m.Cancel(ctx, uuid.NewV4().String())
+		require.Equal(t, queue.ErrTaskNotFound, err)
+	})
+
+	t.Run("cancelled task is not dequeued and can not heartbeat", func(t *testing.T) {
+		_, db := dbtest.GetDatabase(t)
+		defer db.Close()
+		require.NoError(t, SetupTables(ctx, db, nil))
+
+		d := NewDequeuer(db, nil, config.Queue{
+			HeartbeatTTL:  10 * time.Second,
+			PollFrequency: 50 * time.Millisecond,
+		})
+
+		waitingID := uuid.NewV4().String()
+		runningID := uuid.NewV4().String()
+		_, err := squirrel.StatementBuilder.
+			PlaceholderFormat(squirrel.Dollar).
+			RunWith(db).
+			Insert("tasks").
+			Columns("task_id", "queue", "type", "spec", "progress", "status").
+			Values(waitingID, queueID1, "test", emptyJSON, emptyJSON, queue.Waiting).
+			Values(runningID, queueID2, "test", emptyJSON, emptyJSON, queue.Waiting).
+			ExecContext(ctx)
+		require.NoError(t, err)
+
+		task, err := d.(*dequeuer).attemptDequeue(ctx, queueID2)
+		require.NoError(t, err)
+		require.Equal(t, runningID, task.ID)
+
+		m := NewManager(db)
+		require.NoError(t, m.Cancel(ctx, waitingID))
+		require.NoError(t, m.Cancel(ctx, runningID))
+
+		task, err = d.(*dequeuer).attemptDequeue(ctx)
+		require.NoError(t, err)
+		require.Nil(t, task)
+
+		err = d.Heartbeat(ctx, runningID, progress)
+		require.Equal(t, queue.ErrTaskCancelled, err)
+	})
+}
+
+func TestCancelTasks(t *testing.T) {
+	verifyLeak(t)
+
+	logrus.SetOutput(io.Discard)
+	defer logrus.SetOutput(os.Stdout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, db := dbtest.GetDatabase(t)
+	defer db.Close()
+	require.NoError(t, SetupTables(ctx, db, nil))
+	_, err := db.ExecContext(ctx, `ALTER TABLE tasks ADD column test_id uuid;`)
+	require.NoError(t, err)
+
+	testID := uuid.NewV4().String()
+	_, err = squirrel.StatementBuilder.
+		PlaceholderFormat(squirrel.Dollar).
+		RunWith(db).
+		Insert("tasks").
+		Columns("task_id", "queue", "type", "spec", "progress", "status", "test_id").
+		Values(uuid.NewV4().String(), queueID1, "test", emptyJSON, emptyJSON, queue.Waiting, testID).
+		Values(uuid.NewV4().String(), queueID1, "test", emptyJSON, emptyJSON, queue.Running, nil).
+		Values(uuid.NewV4().String(), queueID1, "other", emptyJSON, emptyJSON, queue.Waiting, nil).
+		Values(uuid.NewV4().String(), queueID1, "test", emptyJSON, emptyJSON, queue.Finished, nil).
+		Values(uuid.NewV4().String(), queueID2, "test", emptyJSON, emptyJSON, queue.Waiting, testID).
+		ExecContext(ctx)
+	require.NoError(t, err)
+
+	m := NewManager(db)
+
+	t.Run("returns an error when the filter is empty", func(t *testing.T) {
+		_, err := m.CancelTasks(ctx, queue.TaskFilter{})
+		require.Equal(t, queue.ErrEmptyTaskFilter, err)
+	})
+
+	t.Run("cancels tasks by references", func(t *testing.T) {
+		cancelled, err := m.CancelTasks(ctx, queue.TaskFilter{References: queue.References{"test_id": testID}})
+		require.NoError(t, err)
+		require.Equal(t, int64(2), cancelled)
+	})
+
+	t.Run("cancels unfinished tasks by queue and type", func(t *testing.T) {
+		cancelled, err := m.CancelTasks(ctx, queue.TaskFilter{Queue: queueID1, Type: "test"})
+		require.NoError(t, err)
+		require.Equal(t, int64(1), cancelled)
+
+		dbtest.EqualCount(t, db, 3, "tasks", squirrel.And{
+			squirrel.Eq{"status": queue.Cancelled},
+			squirrel.NotEq{"finished_at": nil},
+		})
+		dbtest.EqualCount(t, db, 1, "tasks", squirrel.Eq{"status": queue.Finished})
+		dbtest.EqualCount(t, db, 1, "tasks", squirrel.Eq{"type": "other", "status": queue.Waiting})
+	})
+}
+
+func TestRetry(t *testing.T) {
+	verifyLeak(t)
+
+	logrus.SetOutput(io.Discard)
+	defer logrus.SetOutput(os.Stdout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, db := dbtest.GetDatabase(t)
+	defer db.Close()
+	require.NoError(t, SetupTables(ctx, db, nil))
+
+	m := NewManager(db)
+
+	cases := []struct {
+		name      string
+		status    queue.TaskStatus
+		expError  error
+		expStatus queue.TaskStatus
+	}{
+		{
+			name:      "retries a failed task",
+			status:    queue.Failed,
+			expStatus: queue.Waiting,
+		},
+		{
+			name:      "retries a cancelled task",
+			status:    queue.Cancelled,
+			expStatus: queue.Waiting,
+		},
+		{
+			name:      "returns an error for a waiting task",
+			status:    queue.Waiting,
+			expError:  queue.ErrTaskNotRetryable,
+			expStatus: queue.Waiting,
+		},
+		{
+			name:      "returns an error for a running task",
+			status:    queue.Running,
+			expError:  queue.ErrTaskNotRetryable,
+			expStatus: queue.Running,
+		},
+		{
+			name:      "returns an error for a finished task",
+			status:    queue.Finished,
+			expError:  queue.ErrTaskNotRetryable,
+			expStatus: queue.Finished,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			taskID := uuid.NewV4().String()
+			now := time.Now()
+			_, err := squirrel.StatementBuilder.
+				PlaceholderFormat(squirrel.Dollar).
+				RunWith(db).
+				Insert("tasks").
+				Columns("task_id", "queue", "type", "spec", "progress", "status", "attempts", "last_error", "result", "error", "started_at", "finished_at").
+				Values(taskID, queueID1, "test", emptyJSON, progress, tc.status, 3, "some error", `{"value": 1}`, `{"message": "some error"}`, now, now).
+				ExecContext(ctx)
+			require.NoError(t, err)
+
+			err = m.Retry(ctx, taskID)
+			require.Equal(t, tc.expError, err)
+
+			dbtest.EqualCount(t, db, 1, "tasks", squirrel.Eq{
+				"task_id": taskID,
+				"status":  tc.expStatus,
+			})
+
+			if tc.expError != nil {
+				return
+			}
+
+			dbtest.EqualCount(t, db, 1, "tasks", squirrel.Eq{
+				"task_id":          taskID,
+				"attempts":         0,
+				"started_at":       nil,
+				"finished_at":      nil,
+				"dead_lettered_at": nil,
+				"result":           nil,
+				"error":            nil,
+				"last_error":       "some error",
+			})
+		})
+	}
+
+	t.Run("returns an error when the task does not exist", func(t *testing.T) {
+		err := m.Retry(ctx, uuid.NewV4().String())
+		require.Equal(t, queue.ErrTaskNotFound, err)
+
+		err = m.Retry(ctx, "malformed")
+		require.Equal(t, queue.ErrTaskNotFound, err)
+	})
+
+	t.Run("returns an error when an unfinished task has the same idempotency key", func(t *testing.T) {
+		failedID := uuid.NewV4().String()
+		now := time.Now()
+		_, err := squirrel.StatementBuilder.
+			PlaceholderFormat(squirrel.Dollar).
+			RunWith(db).
+			Insert("tasks").
+			Columns("task_id", "queue", "type", "spec", "progress", "status", "idempotency_key", "finished_at").
+			Values(failedID, queueID1, "test", emptyJSON, progress, queue.Failed, "retry-key", now).
+			Values(uuid.NewV4().String(), queueID1, "test", emptyJSON, progress, queue.Waiting, "retry-key", nil).
+			ExecContext(ctx)
+		require.NoError(t, err)
+
+		err = m.Retry(ctx, failedID)
+		require.Equal(t, queue.ErrDuplicateIdempotencyKey, err)
+
+		dbtest.EqualCount(t, db, 1, "tasks", squirrel.Eq{
+			"task_id": failedID,
+			"status":  queue.Failed,
+		})
+	})
+}
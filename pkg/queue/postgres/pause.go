@@ -0,0 +1,182 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/contiamo/go-base/v4/pkg/data/managers"
+	cdb "github.com/contiamo/go-base/v4/pkg/db"
+	"github.com/contiamo/go-base/v4/pkg/queue"
+)
+
+// NewPauser creates a new postgres queue pauser.
+// The settings are stored in the database, so they are respected by all the dequeuers
+// using the same database.
+func NewPauser(db *sql.DB) queue.Pauser {
+	return &pauser{
+		BaseManager: managers.NewBaseManager(db, "PostgresPauser"),
+	}
+}
+
+// pauser is a postgres-backed implementation of the queue Pauser
+type pauser struct {
+	managers.BaseManager
+}
+
+func (p *pauser) PauseQueue(ctx context.Context, queueName string) (err error) {
+	span, ctx := p.StartSpan(ctx, "PauseQueue")
+	defer func() {
+		p.FinishSpan(span, err)
+	}()
+
+	span.SetTag("queue", queueName)
+
+	if queueName == "" {
+		return queue.ErrTaskQueueNotSpecified
+	}
+
+	err = setPaused(ctx, p.GetQueryBuilder(), QueuesTable, "queue", queueName, true)
+	if err != nil {
+		return fmt.Errorf("can not pause the queue: %w", err)
+	}
+
+	return nil
+}
+
+func (p *pauser) ResumeQueue(ctx context.Context, queueName string) (err error) {
+	span, ctx := p.StartSpan(ctx, "ResumeQueue")
+	defer func() {
+		p.FinishSpan(span, err)
+	}()
+
+	span.SetTag("queue", queueName)
+
+	if queueName == "" {
+		return queue.ErrTaskQueueNotSpecified
+	}
+
+	err = setPaused(ctx, p.GetQueryBuilder(), QueuesTable, "queue", queueName, false)
+	if err != nil {
+		return fmt.Errorf("can not resume the queue: %w", err)
+	}
+
+	return nil
+}
+
+func (p *pauser) IsQueuePaused(ctx context.Context, queueName string) (paused bool, err error) {
+	span, ctx := p.StartSpan(ctx, "IsQueuePaused")
+	defer func() {
+		p.FinishSpan(span, err)
+	}()
+
+	span.SetTag("queue", queueName)
+
+	return isPaused(ctx, p.GetQueryBuilder(), QueuesTable, "queue", queueName)
+}
+
+func (p *pauser) PauseTaskType(ctx context.Context, taskType queue.TaskType) (err error) {
+	span, ctx := p.StartSpan(ctx, "PauseTaskType")
+	defer func() {
+		p.FinishSpan(span, err)
+	}()
+
+	span.SetTag("type", taskType)
+
+	if taskType == "" {
+		return queue.ErrTaskTypeNotSpecified
+	}
+
+	err = setPaused(ctx, p.GetQueryBuilder(), TaskTypesTable, "type", taskType.String(), true)
+	if err != nil {
+		return fmt.Errorf("can not pause the task type: %w", err)
+	}
+
+	return nil
+}
+
+func (p *pauser) ResumeTaskType(ctx context.Context, taskType queue.TaskType) (err error) {
+	span, ctx := p.StartSpan(ctx, "ResumeTaskType")
+	defer func() {
+		p.FinishSpan(span, err)
+	}()
+
+	span.SetTag("type", taskType)
+
+	if taskType == "" {
+		return queue.ErrTaskTypeNotSpecified
+	}
+
+	err = setPaused(ctx, p.GetQueryBuilder(), TaskTypesTable, "type", taskType.String(), false)
+	if err != nil {
+		return fmt.Errorf("can not resume the task type: %w", err)
+	}
+
+	return nil
+}
+
+func (p *pauser) IsTaskTypePaused(ctx context.Context, taskType queue.TaskType) (paused bool, err error) {
+	span, ctx := p.StartSpan(ctx, "IsTaskTypePaused")
+	defer func() {
+		p.FinishSpan(span, err)
+	}()
+
+	span.SetTag("type", taskType)
+
+	return isPaused(ctx, p.GetQueryBuilder(), TaskTypesTable, "type", taskType.String())
+}
+
+// setPaused upserts the `paused_at` value of the settings row with the given key.
+// The pause time of an already paused row is kept. The dequeuers waiting for tasks
+// are notified when a row is resumed, so they don't have to wait for the next poll.
+func setPaused(ctx context.Context, builder cdb.SQLBuilder, table, keyColumn, key string, paused bool) error {
+	now := time.Now()
+
+	var pausedAt interface{}
+	if paused {
+		pausedAt = now
+	}
+
+	_, err := builder.
+		Insert(table).
+		Columns(keyColumn, "paused_at").
+		Values(key, pausedAt).
+		Suffix(fmt.Sprintf(`
+			ON CONFLICT (%s)
+			DO UPDATE SET
+				updated_at=?,
+				paused_at=CASE WHEN EXCLUDED.paused_at IS NULL THEN NULL ELSE COALESCE(%s.paused_at, EXCLUDED.paused_at) END
+		`, keyColumn, table), now).
+		ExecContext(ctx)
+	if err != nil || paused {
+		return err
+	}
+
+	_, err = builder.
+		Select("pg_notify('task_update', '')").
+		ExecContext(ctx)
+
+	return err
+}
+
+// isPaused returns true if the settings row with the given key is paused,
+// the rows that do not exist are not paused.
+func isPaused(ctx context.Context, builder cdb.SQLBuilder, table, keyColumn, key string) (paused bool, err error) {
+	var pausedAt *time.Time
+	err = builder.
+		Select("paused_at").
+		From(table).
+		Where(squirrel.Eq{keyColumn: key}).
+		QueryRowContext(ctx).
+		Scan(&pausedAt)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return pausedAt != nil, nil
+}
@@ -0,0 +1,164 @@
+package postgres
+
+import (
+	"context"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/contiamo/go-base/v4/pkg/config"
+	dbtest "github.com/contiamo/go-base/v4/pkg/db/test"
+	"github.com/contiamo/go-base/v4/pkg/queue"
+	uuid "github.com/satori/go.uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPause(t *testing.T) {
+	verifyLeak(t)
+
+	logrus.SetOutput(io.Discard)
+	defer logrus.SetOutput(os.Stdout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	insertTask := func(t *testing.T, db squirrel.BaseRunner, queueName string, taskType queue.TaskType) {
+		_, err := squirrel.StatementBuilder.
+			PlaceholderFormat(squirrel.Dollar).
+			RunWith(db).
+			Insert("tasks").
+			Columns("task_id", "queue", "type", "spec", "progress", "status").
+			Values(uuid.NewV4().String(), queueName, taskType, emptyJSON, emptyJSON, queue.Waiting).
+			ExecContext(ctx)
+		require.NoError(t, err)
+	}
+
+	t.Run("pauses and resumes the queue", func(t *testing.T) {
+		_, db := dbtest.GetDatabase(t)
+		defer db.Close()
+		require.NoError(t, SetupTables(ctx, db, nil))
+		p := NewPauser(db)
+
+		paused, err := p.IsQueuePaused(ctx, queueID1)
+		require.NoError(t, err)
+		require.False(t, paused)
+
+		require.NoError(t, p.PauseQueue(ctx, queueID1))
+		// pausing twice is not an error
+		require.NoError(t, p.PauseQueue(ctx, queueID1))
+		paused, err = p.IsQueuePaused(ctx, queueID1)
+		require.NoError(t, err)
+		require.True(t, paused)
+
+		require.NoError(t, p.ResumeQueue(ctx, queueID1))
+		paused, err = p.IsQueuePaused(ctx, queueID1)
+		require.NoError(t, err)
+		require.False(t, paused)
+
+		dbtest.EqualCount(t, db, 1, QueuesTable, squirrel.Eq{"queue": queueID1})
+	})
+
+	t.Run("pauses and resumes the task type", func(t *testing.T) {
+		_, db := dbtest.GetDatabase(t)
+		defer db.Close()
+		require.NoError(t, SetupTables(ctx, db, nil))
+		p := NewPauser(db)
+
+		paused, err := p.IsTaskTypePaused(ctx, "test")
+		require.NoError(t, err)
+		require.False(t, paused)
+
+		require.NoError(t, p.PauseTaskType(ctx, "test"))
+		paused, err = p.IsTaskTypePaused(ctx, "test")
+		require.NoError(t, err)
+		require.True(t, paused)
+
+		require.NoError(t, p.ResumeTaskType(ctx, "test"))
+		paused, err = p.IsTaskTypePaused(ctx, "test")
+		require.NoError(t, err)
+		require.False(t, paused)
+
+		dbtest.EqualCount(t, db, 1, TaskTypesTable, squirrel.Eq{"type": "test"})
+	})
+
+	t.Run("returns an error for invalid settings", func(t *testing.T) {
+		_, db := dbtest.GetDatabase(t)
+		defer db.Close()
+		require.NoError(t, SetupTables(ctx, db, nil))
+		p := NewPauser(db)
+
+		require.Equal(t, queue.ErrTaskQueueNotSpecified, p.PauseQueue(ctx, ""))
+		require.Equal(t, queue.ErrTaskQueueNotSpecified, p.ResumeQueue(ctx, ""))
+		require.Equal(t, queue.ErrTaskTypeNotSpecified, p.PauseTaskType(ctx, ""))
+		require.Equal(t, queue.ErrTaskTypeNotSpecified, p.ResumeTaskType(ctx, ""))
+	})
+
+	t.Run("does not dequeue tasks from the paused queue", func(t *testing.T) {
+		_, db := dbtest.GetDatabase(t)
+		defer db.Close()
+		require.NoError(t, SetupTables(ctx, db, nil))
+		p := NewPauser(db)
+
+		q := NewDequeuer(db, nil, config.Queue{
+			HeartbeatTTL:  10 * time.Second,
+			PollFrequency: 50 * time.Millisecond,
+		})
+
+		insertTask(t, db, queueID1, "test")
+		insertTask(t, db, queueID2, "test")
+		require.NoError(t, NewConcurrencyLimiter(db).SetQueueConcurrency(ctx, queueID2, queue.UnlimitedQueueConcurrency))
+		require.NoError(t, p.PauseQueue(ctx, queueID1))
+
+		task, err := q.(*dequeuer).attemptDequeue(ctx, queueID1, queueID2)
+		require.NoError(t, err)
+		require.NotNil(t, task)
+		require.Equal(t, queueID2, task.Queue)
+
+		task, err = q.(*dequeuer).attemptDequeue(ctx, queueID1, queueID2)
+		require.NoError(t, err)
+		require.Nil(t, task, "the paused queue must be skipped")
+
+		require.NoError(t, p.ResumeQueue(ctx, queueID1))
+
+		task, err = q.(*dequeuer).attemptDequeue(ctx, queueID1, queueID2)
+		require.NoError(t, err)
+		require.NotNil(t, task)
+		require.Equal(t, queueID1, task.Queue)
+	})
+
+	t.Run("does not dequeue tasks of the paused type", func(t *testing.T) {
+		_, db := dbtest.GetDatabase(t)
+		defer db.Close()
+		require.NoError(t, SetupTables(ctx, db, nil))
+		p := NewPauser(db)
+
+		q := NewDequeuer(db, nil, config.Queue{
+			HeartbeatTTL:  10 * time.Second,
+			PollFrequency: 50 * time.Millisecond,
+		})
+
+		require.NoError(t, NewConcurrencyLimiter(db).SetQueueConcurrency(ctx, queueID1, queue.UnlimitedQueueConcurrency))
+		insertTask(t, db, queueID1, "paused")
+		insertTask(t, db, queueID1, "test")
+		require.NoError(t, p.PauseTaskType(ctx, "paused"))
+
+		task, err := q.(*dequeuer).attemptDequeue(ctx, queueID1)
+		require.NoError(t, err)
+		require.NotNil(t, task)
+		require.Equal(t, queue.TaskType("test"), task.Type)
+
+		task, err = q.(*dequeuer).attemptDequeue(ctx, queueID1)
+		require.NoError(t, err)
+		require.Nil(t, task, "the paused task type must be skipped")
+
+		require.NoError(t, p.ResumeTaskType(ctx, "paused"))
+
+		task, err = q.(*dequeuer).attemptDequeue(ctx, queueID1)
+		require.NoError(t, err)
+		require.NotNil(t, task)
+		require.Equal(t, queue.TaskType("paused"), task.Type)
+	})
+}
@@ -3,18 +3,51 @@ package postgres
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
 
+	"github.com/Masterminds/squirrel"
 	"github.com/contiamo/go-base/v4/pkg/data/managers"
+	cdb "github.com/contiamo/go-base/v4/pkg/db"
 	"github.com/contiamo/go-base/v4/pkg/queue"
+	"github.com/lib/pq"
+	"github.com/opentracing/opentracing-go"
+	"github.com/pkg/errors"
 	uuid "github.com/satori/go.uuid"
 )
 
 var emptyJSON = []byte("{}")
 
+// maxBatchRows is the maximum number of rows in a single statement,
+// it keeps the number of arguments below the Postgres limit of 65535
+const maxBatchRows = 1000
+
+// QueuerOptions controls how the queuer behaves
+type QueuerOptions struct {
+	// RetryPolicies contains the default retry policies per task type.
+	// A default policy is used when the enqueued task does not specify its own retry policy.
+	RetryPolicies map[queue.TaskType]queue.RetryPolicy
+	// IdempotencyWindow is how long a finished task keeps deduplicating
+	// the enqueued tasks with the same idempotency key.
+	// The zero value means only the tasks that are not finished yet are deduplicated.
+	// Failed and cancelled tasks never deduplicate after they are over, so they can be re-enqueued.
+	IdempotencyWindow time.Duration
+}
+
 // NewQueuer creates a new postgres queue queuer
 func NewQueuer(db *sql.DB) queue.Queuer {
+	return NewQueuerWithOpts(db, QueuerOptions{})
+}
+
+// NewQueuerWithOpts creates a new postgres queue queuer with the specified options
+func NewQueuerWithOpts(db *sql.DB, opts QueuerOptions) queue.Queuer {
 	return &queuer{
-		BaseManager: managers.NewBaseManager(db, "PostgresQueuer"),
+		BaseManager:       managers.NewBaseManager(db, "PostgresQueuer"),
+		retryPolicies:     opts.RetryPolicies,
+		idempotencyWindow: opts.IdempotencyWindow,
 	}
 }
 
@@ -26,39 +59,282 @@ func NewQueuerWithMetrics(db *sql.DB) queue.Queuer {
 // pgQueue is a postgres backed implementation of the queue manager
 type queuer struct {
 	managers.BaseManager
+	retryPolicies     map[queue.TaskType]queue.RetryPolicy
+	idempotencyWindow time.Duration
 }
 
 // Enqueue implements queue.Enqueue
 //
 // `task` must contain a Queue name and task type.
-func (q *queuer) Enqueue(ctx context.Context, task queue.TaskEnqueueRequest) (err error) {
+// When the task has an idempotency key and a task with the same key exists in the queue,
+// the ID of the existing task is returned and nothing is inserted.
+// When the task depends on other tasks, it's inserted in a transaction, see EnqueueTx.
+func (q *queuer) Enqueue(ctx context.Context, task queue.TaskEnqueueRequest) (taskID string, err error) {
 	span, ctx := q.StartSpan(ctx, "Enqueue")
 	defer func() {
 		q.FinishSpan(span, err)
 	}()
 
+	if len(task.DependsOn) == 0 {
+		return q.enqueue(ctx, span, q.GetQueryBuilder(), task)
+	}
+
+	builder, tx, err := q.GetTxQueryBuilder(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+
+	defer func() {
+		if err == nil {
+			err = tx.Commit()
+			if err != nil {
+				taskID = ""
+			}
+			return
+		}
+
+		rollbackErr := tx.Rollback()
+		if rollbackErr != nil {
+			err = errors.Wrap(err, rollbackErr.Error())
+		}
+	}()
+
+	return q.enqueue(ctx, span, builder, task)
+}
+
+// EnqueueTx implements queue.EnqueueTx
+//
+// The task is inserted using the given builder, so it's added only
+// when the transaction of the builder is committed.
+//
+// The tasks the task depends on are locked until the transaction ends,
+// so they can't finish before the task becomes visible to the dependency resolution.
+// The builder must belong to a transaction when the task has dependencies.
+func (q *queuer) EnqueueTx(ctx context.Context, builder cdb.SQLBuilder, task queue.TaskEnqueueRequest) (taskID string, err error) {
+	span, ctx := q.StartSpan(ctx, "EnqueueTx")
+	defer func() {
+		q.FinishSpan(span, err)
+	}()
+
+	return q.enqueue(ctx, span, builder, task)
+}
+
+// enqueue validates the task and inserts it using the builder
+func (q *queuer) enqueue(ctx context.Context, span opentracing.Span, builder cdb.SQLBuilder, task queue.TaskEnqueueRequest) (taskID string, err error) {
+	prepared, err := q.prepare(task)
+	if err != nil {
+		return "", err
+	}
+
+	span.SetTag("task.queue", prepared.Queue)
+	span.SetTag("task.type", prepared.Type)
+	span.SetTag("task.spec", string(prepared.Spec))
+	span.SetTag("task.priority", prepared.Priority)
+	span.SetTag("task.notBefore", prepared.NotBefore)
+	span.SetTag("task.references", prepared.References)
+	span.SetTag("task.idempotencyKey", prepared.IdempotencyKey)
+	span.SetTag("task.dependsOn", prepared.DependsOn)
+	span.SetTag("task.workflowID", prepared.WorkflowID)
+
+	taskIDs, err := q.insertTasks(ctx, builder, []preparedTask{prepared})
+	if err != nil {
+		return "", err
+	}
+
+	taskID = taskIDs[0]
+	span.SetTag("task.ID", taskID)
+
+	return taskID, nil
+}
+
+// EnqueueBatch implements queue.EnqueueBatch
+//
+// All the tasks are validated before anything is inserted,
+// then they are inserted in a single transaction using multi-row inserts.
+func (q *queuer) EnqueueBatch(ctx context.Context, tasks []queue.TaskEnqueueRequest) (taskIDs []string, err error) {
+	span, ctx := q.StartSpan(ctx, "EnqueueBatch")
+	defer func() {
+		q.FinishSpan(span, err)
+	}()
+
+	span.SetTag("tasks.count", len(tasks))
+
+	prepared := make([]preparedTask, 0, len(tasks))
+	for i, task := range tasks {
+		p, err := q.prepare(task)
+		if err != nil {
+			return nil, errors.Wrapf(err, "task %d", i)
+		}
+		prepared = append(prepared, p)
+	}
+
+	if len(prepared) == 0 {
+		return []string{}, nil
+	}
+
+	builder, tx, err := q.GetTxQueryBuilder(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if err == nil {
+			err = tx.Commit()
+			if err != nil {
+				taskIDs = nil
+			}
+			return
+		}
+
+		rollbackErr := tx.Rollback()
+		if rollbackErr != nil {
+			err = errors.Wrap(err, rollbackErr.Error())
+		}
+	}()
+
+	return q.insertTasks(ctx, builder, prepared)
+}
+
+// preparedTask is a validated task ready to be inserted
+type preparedTask struct {
+	queue.TaskEnqueueRequest
+	// retryPolicy is the serialized retry policy of the task
+	retryPolicy interface{}
+	// status is the initial status of the task, it depends on the status of its dependencies
+	status queue.TaskStatus
+	// finishedAt is set when the task is cancelled because of its dependencies
+	finishedAt *time.Time
+	// lastError explains why the task is cancelled because of its dependencies
+	lastError string
+}
+
+// idempotencyKey identifies the tasks that deduplicate each other,
+// the queue names are case-insensitive like the `queue` column.
+type idempotencyKey struct {
+	queue string
+	key   string
+}
+
+// idempotencyKey returns the key of the task, the zero value means the task is never deduplicated
+func (t preparedTask) idempotencyKey() idempotencyKey {
+	if t.IdempotencyKey == "" {
+		return idempotencyKey{}
+	}
+	return idempotencyKey{queue: strings.ToLower(t.Queue), key: t.IdempotencyKey}
+}
+
+// prepare validates the task and sets the default values
+func (q *queuer) prepare(task queue.TaskEnqueueRequest) (preparedTask, error) {
 	if task.Queue == "" {
-		return queue.ErrTaskQueueNotSpecified
+		return preparedTask{}, queue.ErrTaskQueueNotSpecified
 	}
 
 	if task.Type == "" {
-		return queue.ErrTaskTypeNotSpecified
+		return preparedTask{}, queue.ErrTaskTypeNotSpecified
 	}
 
 	if task.Spec == nil {
 		task.Spec = emptyJSON
 	}
 
-	taskID := uuid.NewV4().String()
-	span.SetTag("task.queue", task.Queue)
-	span.SetTag("task.type", task.Type)
-	span.SetTag("task.spec", string(task.Spec))
-	span.SetTag("task.references", task.References)
+	if task.NotBefore.IsZero() {
+		task.NotBefore = time.Now()
+	}
+
+	for _, dependency := range task.DependsOn {
+		if !isValidID(dependency) {
+			return preparedTask{}, queue.ErrTaskDependencyNotFound
+		}
+	}
+
+	retryPolicy, err := q.encodeRetryPolicy(task)
+	if err != nil {
+		return preparedTask{}, err
+	}
+
+	return preparedTask{
+		TaskEnqueueRequest: task,
+		retryPolicy:        retryPolicy,
+		status:             queue.Waiting,
+	}, nil
+}
+
+// insertTasks inserts the tasks using the builder and returns their IDs in the same order.
+// The tasks with an idempotency key get the ID of the existing task with the same key, if any.
+func (q *queuer) insertTasks(ctx context.Context, builder cdb.SQLBuilder, tasks []preparedTask) ([]string, error) {
+	taskIDs := make([]string, len(tasks))
+
+	// pending contains the indexes of the tasks without an ID yet
+	pending := make([]int, 0, len(tasks))
+	for i := range tasks {
+		pending = append(pending, i)
+	}
+
+	// the unique index guarantees there is only one unfinished task with the key,
+	// if a concurrent enqueue wins the insert, the next lookup finds its task
+	for len(pending) > 0 {
+		err := q.findDuplicates(ctx, builder, tasks, pending, taskIDs)
+		if err != nil {
+			return nil, err
+		}
+		pending = withoutID(pending, taskIDs)
+
+		// only the first of the tasks with the same key is inserted,
+		// the others are deduplicated by the next lookup
+		inserting := make([]int, 0, len(pending))
+		seen := map[idempotencyKey]bool{}
+		for _, i := range pending {
+			key := tasks[i].idempotencyKey()
+			if key != (idempotencyKey{}) {
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+			}
+			inserting = append(inserting, i)
+		}
+
+		for start := 0; start < len(inserting); start += maxBatchRows {
+			end := start + maxBatchRows
+			if end > len(inserting) {
+				end = len(inserting)
+			}
+
+			err = q.resolveDependencies(ctx, builder, tasks, inserting[start:end])
+			if err != nil {
+				return nil, err
+			}
+
+			err = q.insertRows(ctx, builder, tasks, inserting[start:end], taskIDs)
+			if err != nil {
+				return nil, err
+			}
+		}
+		pending = withoutID(pending, taskIDs)
+	}
+
+	return taskIDs, nil
+}
 
-	refColumns, refValues := task.References.GetNamesAndValues()
+// insertRows inserts the tasks with the given indexes in a single statement
+// and sets the IDs of the inserted tasks, the conflicting tasks are skipped.
+//
+// The reference columns of all the tasks are inserted, the missing references are set to NULL.
+func (q *queuer) insertRows(ctx context.Context, builder cdb.SQLBuilder, tasks []preparedTask, indexes []int, taskIDs []string) error {
+	refColumnSet := map[string]bool{}
+	for _, i := range indexes {
+		for name := range tasks[i].References {
+			refColumnSet[name] = true
+		}
+	}
+	refColumns := make([]string, 0, len(refColumnSet))
+	for name := range refColumnSet {
+		refColumns = append(refColumns, name)
+	}
+	sort.Strings(refColumns)
 
-	_, err = q.GetQueryBuilder().
-		Insert("tasks").
+	stmt := builder.
+		Insert(TasksTable).
 		Columns(
 			append(
 				refColumns,
@@ -66,25 +342,268 @@ func (q *queuer) Enqueue(ctx context.Context, task queue.TaskEnqueueRequest) (er
 				"queue",
 				"type",
 				"spec",
+				"priority",
+				"not_before",
 				"status",
 				"progress",
+				"retry_policy",
+				"idempotency_key",
+				"depends_on",
+				"workflow_id",
+				"scheduled_at",
+				"finished_at",
+				"last_error",
 			)...,
 		).
-		Values(
-			append(
-				refValues,
-				taskID,
-				task.Queue,
-				task.Type,
-				task.Spec,
-				queue.Waiting,
-				emptyJSON,
-			)...,
-		).
-		ExecContext(ctx)
+		Suffix("ON CONFLICT (queue, idempotency_key) WHERE " + idempotencyCondition + " DO NOTHING RETURNING task_id")
+
+	candidates := make(map[string]int, len(indexes))
+	for _, i := range indexes {
+		task := tasks[i]
+		taskID := uuid.NewV4().String()
+		candidates[taskID] = i
+
+		var key, dependsOn, workflowID, scheduledAt interface{}
+		if task.IdempotencyKey != "" {
+			key = task.IdempotencyKey
+		}
+		if len(task.DependsOn) > 0 {
+			dependsOn = pq.Array(task.DependsOn)
+		}
+		if task.WorkflowID != "" {
+			workflowID = task.WorkflowID
+		}
+		if !task.ScheduledAt.IsZero() {
+			scheduledAt = task.ScheduledAt
+		}
+
+		values := make([]interface{}, 0, len(refColumns)+15)
+		for _, name := range refColumns {
+			values = append(values, task.References[name])
+		}
+		values = append(values,
+			taskID,
+			task.Queue,
+			task.Type,
+			task.Spec,
+			task.Priority,
+			task.NotBefore,
+			task.status,
+			emptyJSON,
+			task.retryPolicy,
+			key,
+			dependsOn,
+			workflowID,
+			scheduledAt,
+			task.finishedAt,
+			task.lastError,
+		)
+		stmt = stmt.Values(values...)
+	}
+
+	rows, err := stmt.QueryContext(ctx)
 	if err != nil {
 		return err
 	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var taskID string
+		err = rows.Scan(&taskID)
+		if err != nil {
+			return err
+		}
+		taskIDs[candidates[taskID]] = taskID
+	}
+
+	return rows.Err()
+}
+
+// resolveDependencies sets the initial status of the tasks with the given indexes:
+// a task is blocked until all the tasks it depends on are finished and
+// it's cancelled right away if any of them has failed or has been cancelled.
+//
+// The tasks it depends on are locked, so their status can't change
+// until the transaction inserting the task ends.
+func (q *queuer) resolveDependencies(ctx context.Context, builder cdb.SQLBuilder, tasks []preparedTask, indexes []int) error {
+	dependencySet := map[string]bool{}
+	for _, i := range indexes {
+		for _, dependency := range tasks[i].DependsOn {
+			dependencySet[strings.ToLower(dependency)] = true
+		}
+	}
+
+	if len(dependencySet) == 0 {
+		return nil
+	}
+
+	dependencies := make([]string, 0, len(dependencySet))
+	for dependency := range dependencySet {
+		dependencies = append(dependencies, dependency)
+	}
+	sort.Strings(dependencies)
+
+	rows, err := builder.
+		Select("task_id", "status").
+		From(TasksTable).
+		Where("task_id = ANY (?)", pq.Array(dependencies)).
+		OrderBy("task_id").
+		Suffix("FOR SHARE").
+		QueryContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	statuses := make(map[string]queue.TaskStatus, len(dependencies))
+	for rows.Next() {
+		var (
+			taskID string
+			status queue.TaskStatus
+		)
+		err = rows.Scan(&taskID, &status)
+		if err != nil {
+			return err
+		}
+		statuses[taskID] = status
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, i := range indexes {
+		task := &tasks[i]
+		task.status = queue.Waiting
+		for _, dependency := range task.DependsOn {
+			status, ok := statuses[strings.ToLower(dependency)]
+			switch {
+			case !ok:
+				return queue.ErrTaskDependencyNotFound
+			case status == queue.Failed || status == queue.Cancelled:
+				task.status = queue.Cancelled
+				task.finishedAt = &now
+				task.lastError = fmt.Sprintf("dependency %s is %s", dependency, status)
+			case status != queue.Finished && task.status != queue.Cancelled:
+				task.status = queue.Blocked
+			}
+		}
+	}
+
+	return nil
+}
+
+// findDuplicates sets the IDs of the existing tasks that deduplicate the tasks with the given indexes
+func (q *queuer) findDuplicates(ctx context.Context, builder cdb.SQLBuilder, tasks []preparedTask, indexes []int, taskIDs []string) error {
+	keys := make([]int, 0, len(indexes))
+	for _, i := range indexes {
+		if tasks[i].IdempotencyKey != "" {
+			keys = append(keys, i)
+		}
+	}
+
+	deduplicating := squirrel.Or{squirrel.Eq{"finished_at": nil}}
+	if q.idempotencyWindow > 0 {
+		deduplicating = append(deduplicating, squirrel.And{
+			squirrel.Eq{"status": queue.Finished},
+			squirrel.Gt{"finished_at": time.Now().Add(-q.idempotencyWindow)},
+		})
+	}
+
+	existing := map[idempotencyKey]string{}
+	for start := 0; start < len(keys); start += maxBatchRows {
+		end := start + maxBatchRows
+		if end > len(keys) {
+			end = len(keys)
+		}
+
+		matching := make(squirrel.Or, 0, end-start)
+		for _, i := range keys[start:end] {
+			matching = append(matching, squirrel.Eq{
+				"queue":           tasks[i].Queue,
+				"idempotency_key": tasks[i].IdempotencyKey,
+			})
+		}
+
+		err := q.scanDuplicates(ctx, builder.
+			Select("DISTINCT ON (lower(queue), idempotency_key) task_id", "queue", "idempotency_key").
+			From(TasksTable).
+			Where(matching).
+			Where(deduplicating).
+			OrderBy("lower(queue)", "idempotency_key", "created_at DESC"),
+			existing,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, i := range keys {
+		taskID, ok := existing[tasks[i].idempotencyKey()]
+		if ok {
+			taskIDs[i] = taskID
+		}
+	}
 
 	return nil
 }
+
+// scanDuplicates adds the task IDs selected by the statement to the map of existing tasks
+func (q *queuer) scanDuplicates(ctx context.Context, stmt squirrel.SelectBuilder, existing map[idempotencyKey]string) error {
+	rows, err := stmt.QueryContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var taskID, queueName, key string
+		err = rows.Scan(&taskID, &queueName, &key)
+		if err != nil {
+			return err
+		}
+		existing[idempotencyKey{queue: strings.ToLower(queueName), key: key}] = taskID
+	}
+
+	return rows.Err()
+}
+
+// withoutID returns the indexes of the tasks without an ID
+func withoutID(indexes []int, taskIDs []string) []int {
+	filtered := indexes[:0]
+	for _, i := range indexes {
+		if taskIDs[i] == "" {
+			filtered = append(filtered, i)
+		}
+	}
+	return filtered
+}
+
+// encodeRetryPolicy returns the serialized retry policy of the task falling back to
+// the default policy of the task type, nil is returned when the task has no policy
+func (q *queuer) encodeRetryPolicy(task queue.TaskEnqueueRequest) (interface{}, error) {
+	policy := task.RetryPolicy
+	if policy == nil {
+		defaultPolicy, ok := q.retryPolicies[task.Type]
+		if !ok {
+			return nil, nil
+		}
+		policy = &defaultPolicy
+	}
+
+	err := policy.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(policy)
+}
+
+// isValidID returns true if the ID can belong to an existing task or schedule.
+// The IDs are stored in `uuid` columns, a malformed ID would fail the query instead of not matching.
+func isValidID(id string) bool {
+	_, err := uuid.FromString(id)
+	return err == nil
+}
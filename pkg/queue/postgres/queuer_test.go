@@ -7,6 +7,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/Masterminds/squirrel"
 	dbtest "github.com/contiamo/go-base/v4/pkg/db/test"
 	"github.com/contiamo/go-base/v4/pkg/queue"
 	uuid "github.com/satori/go.uuid"
@@ -88,6 +89,17 @@ func TestEnqueue(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "Returns no error when a task is delayed",
+			task: queue.TaskEnqueueRequest{
+				TaskBase: queue.TaskBase{
+					Queue: "queue2",
+					Type:  "test",
+					Spec:  spec,
+				},
+				NotBefore: time.Now().Add(time.Hour),
+			},
+		},
 		{
 			name: "Returns error when a task has invalid references",
 			task: queue.TaskEnqueueRequest{
@@ -107,15 +119,387 @@ func TestEnqueue(t *testing.T) {
 	for _, tc := range cases {
 		t.Run(tc.name, func(t *testing.T) {
 			q := NewQueuer(db)
-			err := q.Enqueue(ctx, tc.task)
+			taskID, err := q.Enqueue(ctx, tc.task)
 
 			if tc.expError != "" {
 				require.Error(t, err)
 				require.Equal(t, tc.expError, err.Error())
+				require.Empty(t, taskID)
 				return
 			}
 
 			require.NoError(t, err)
+			dbtest.EqualCount(t, db, 1, "tasks", squirrel.Eq{"task_id": taskID})
+		})
+	}
+}
+
+func TestEnqueueRetryPolicy(t *testing.T) {
+	verifyLeak(t)
+
+	logrus.SetOutput(io.Discard)
+	defer logrus.SetOutput(os.Stdout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, db := dbtest.GetDatabase(t)
+	defer db.Close()
+	require.NoError(t, SetupTables(ctx, db, nil))
+
+	typeDefault := queue.RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Second, Multiplier: 2}
+	own := queue.RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Minute}
+
+	q := NewQueuerWithOpts(db, QueuerOptions{
+		RetryPolicies: map[queue.TaskType]queue.RetryPolicy{
+			"retryable": typeDefault,
+		},
+	})
+
+	cases := []struct {
+		name      string
+		task      queue.TaskEnqueueRequest
+		expPolicy *queue.RetryPolicy
+		expError  string
+	}{
+		{
+			name: "task without a policy and a type default is never retried",
+			task: queue.TaskEnqueueRequest{
+				TaskBase: queue.TaskBase{Queue: queueID1, Type: "test"},
+			},
+		},
+		{
+			name: "task without a policy gets the type default",
+			task: queue.TaskEnqueueRequest{
+				TaskBase: queue.TaskBase{Queue: queueID1, Type: "retryable"},
+			},
+			expPolicy: &typeDefault,
+		},
+		{
+			name: "task policy overrides the type default",
+			task: queue.TaskEnqueueRequest{
+				TaskBase:    queue.TaskBase{Queue: queueID1, Type: "retryable"},
+				RetryPolicy: &own,
+			},
+			expPolicy: &own,
+		},
+		{
+			name: "returns an error when the policy is invalid",
+			task: queue.TaskEnqueueRequest{
+				TaskBase:    queue.TaskBase{Queue: queueID1, Type: "test"},
+				RetryPolicy: &queue.RetryPolicy{Jitter: 2},
+			},
+			expError: "invalid retry policy: jitter must be in the range [0, 1]",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := db.ExecContext(ctx, "DELETE FROM tasks;")
+			require.NoError(t, err)
+
+			_, err = q.Enqueue(ctx, tc.task)
+			if tc.expError != "" {
+				require.EqualError(t, err, tc.expError)
+				return
+			}
+			require.NoError(t, err)
+
+			var policy []byte
+			err = db.QueryRowContext(ctx, "SELECT retry_policy FROM tasks").Scan(&policy)
+			require.NoError(t, err)
+
+			stored, err := decodeRetryPolicy(policy)
+			require.NoError(t, err)
+			require.Equal(t, tc.expPolicy, stored)
+		})
+	}
+}
+
+func TestEnqueueIdempotencyKey(t *testing.T) {
+	verifyLeak(t)
+
+	logrus.SetOutput(io.Discard)
+	defer logrus.SetOutput(os.Stdout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, db := dbtest.GetDatabase(t)
+	defer db.Close()
+	require.NoError(t, SetupTables(ctx, db, nil))
+
+	request := func(queueName, key string) queue.TaskEnqueueRequest {
+		return queue.TaskEnqueueRequest{
+			TaskBase: queue.TaskBase{
+				Queue: queueName,
+				Type:  "test",
+				Spec:  spec,
+			},
+			IdempotencyKey: key,
+		}
+	}
+
+	setStatus := func(t *testing.T, taskID string, status queue.TaskStatus, finishedAt time.Time) {
+		_, err := squirrel.StatementBuilder.
+			PlaceholderFormat(squirrel.Dollar).
+			RunWith(db).
+			Update("tasks").
+			Set("status", status).
+			Set("finished_at", finishedAt).
+			Where(squirrel.Eq{"task_id": taskID}).
+			ExecContext(ctx)
+		require.NoError(t, err)
+	}
+
+	t.Run("returns the existing task when it is not finished", func(t *testing.T) {
+		q := NewQueuer(db)
+
+		taskID, err := q.Enqueue(ctx, request(queueID1, "webhook-1"))
+		require.NoError(t, err)
+
+		duplicateID, err := q.Enqueue(ctx, request(queueID1, "webhook-1"))
+		require.NoError(t, err)
+		require.Equal(t, taskID, duplicateID)
+
+		dbtest.EqualCount(t, db, 1, "tasks", squirrel.Eq{"idempotency_key": "webhook-1"})
+	})
+
+	t.Run("keys are unique within a queue", func(t *testing.T) {
+		q := NewQueuer(db)
+
+		taskID1, err := q.Enqueue(ctx, request(queueID1, "webhook-2"))
+		require.NoError(t, err)
+		taskID2, err := q.Enqueue(ctx, request(queueID2, "webhook-2"))
+		require.NoError(t, err)
+		require.NotEqual(t, taskID1, taskID2)
+	})
+
+	t.Run("tasks without a key are never deduplicated", func(t *testing.T) {
+		q := NewQueuer(db)
+
+		taskID1, err := q.Enqueue(ctx, request(queueID1, ""))
+		require.NoError(t, err)
+		taskID2, err := q.Enqueue(ctx, request(queueID1, ""))
+		require.NoError(t, err)
+		require.NotEqual(t, taskID1, taskID2)
+	})
+
+	t.Run("enqueues a new task when the existing one is finished", func(t *testing.T) {
+		q := NewQueuer(db)
+
+		taskID, err := q.Enqueue(ctx, request(queueID1, "webhook-3"))
+		require.NoError(t, err)
+		setStatus(t, taskID, queue.Finished, time.Now())
+
+		newID, err := q.Enqueue(ctx, request(queueID1, "webhook-3"))
+		require.NoError(t, err)
+		require.NotEqual(t, taskID, newID)
+	})
+
+	t.Run("returns the finished task within the deduplication window", func(t *testing.T) {
+		q := NewQueuerWithOpts(db, QueuerOptions{IdempotencyWindow: time.Hour})
+
+		taskID, err := q.Enqueue(ctx, request(queueID1, "webhook-4"))
+		require.NoError(t, err)
+		setStatus(t, taskID, queue.Finished, time.Now())
+
+		duplicateID, err := q.Enqueue(ctx, request(queueID1, "webhook-4"))
+		require.NoError(t, err)
+		require.Equal(t, taskID, duplicateID)
+	})
+
+	t.Run("enqueues a new task after the deduplication window", func(t *testing.T) {
+		q := NewQueuerWithOpts(db, QueuerOptions{IdempotencyWindow: time.Hour})
+
+		taskID, err := q.Enqueue(ctx, request(queueID1, "webhook-5"))
+		require.NoError(t, err)
+		setStatus(t, taskID, queue.Finished, time.Now().Add(-2*time.Hour))
+
+		newID, err := q.Enqueue(ctx, request(queueID1, "webhook-5"))
+		require.NoError(t, err)
+		require.NotEqual(t, taskID, newID)
+	})
+
+	t.Run("enqueues a new task when the existing one has failed", func(t *testing.T) {
+		q := NewQueuerWithOpts(db, QueuerOptions{IdempotencyWindow: time.Hour})
+
+		taskID, err := q.Enqueue(ctx, request(queueID1, "webhook-6"))
+		require.NoError(t, err)
+		setStatus(t, taskID, queue.Failed, time.Now())
+
+		newID, err := q.Enqueue(ctx, request(queueID1, "webhook-6"))
+		require.NoError(t, err)
+		require.NotEqual(t, taskID, newID)
+	})
+}
+
+func TestEnqueueBatch(t *testing.T) {
+	verifyLeak(t)
+
+	logrus.SetOutput(io.Discard)
+	defer logrus.SetOutput(os.Stdout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, db := dbtest.GetDatabase(t)
+	defer db.Close()
+	require.NoError(t, SetupTables(ctx, db, nil))
+	_, err := db.ExecContext(ctx, `ALTER TABLE tasks ADD column test_id uuid;`)
+	require.NoError(t, err)
+
+	q := NewQueuer(db)
+
+	task := func(queueName, key string) queue.TaskEnqueueRequest {
+		return queue.TaskEnqueueRequest{
+			TaskBase: queue.TaskBase{
+				Queue: queueName,
+				Type:  "test",
+				Spec:  spec,
+			},
+			IdempotencyKey: key,
+		}
+	}
+
+	t.Run("returns the IDs of the tasks in the same order", func(t *testing.T) {
+		testID := uuid.NewV4()
+		withRef := task(queueID1, "")
+		withRef.References = queue.References{"test_id": testID}
+
+		taskIDs, err := q.EnqueueBatch(ctx, []queue.TaskEnqueueRequest{
+			task(queueID1, ""),
+			withRef,
+			task(queueID2, ""),
+		})
+		require.NoError(t, err)
+		require.Len(t, taskIDs, 3)
+
+		dbtest.EqualCount(t, db, 1, "tasks", squirrel.Eq{"task_id": taskIDs[0], "queue": queueID1, "test_id": nil})
+		dbtest.EqualCount(t, db, 1, "tasks", squirrel.Eq{"task_id": taskIDs[1], "queue": queueID1, "test_id": testID})
+		dbtest.EqualCount(t, db, 1, "tasks", squirrel.Eq{"task_id": taskIDs[2], "queue": queueID2})
+	})
+
+	t.Run("does not enqueue anything when a task is invalid", func(t *testing.T) {
+		_, err := db.ExecContext(ctx, "DELETE FROM tasks;")
+		require.NoError(t, err)
+
+		_, err = q.EnqueueBatch(ctx, []queue.TaskEnqueueRequest{
+			task(queueID1, ""),
+			{TaskBase: queue.TaskBase{Queue: queueID1}},
+		})
+		require.EqualError(t, err, "task 1: "+queue.ErrTaskTypeNotSpecified.Error())
+		dbtest.EqualCount(t, db, 0, "tasks", nil)
+
+		invalidSpec := task(queueID1, "")
+		invalidSpec.Spec = []byte("invalid")
+		_, err = q.EnqueueBatch(ctx, []queue.TaskEnqueueRequest{
+			task(queueID1, ""),
+			invalidSpec,
+		})
+		require.Error(t, err)
+		dbtest.EqualCount(t, db, 0, "tasks", nil)
+	})
+
+	t.Run("deduplicates the tasks with the same idempotency key", func(t *testing.T) {
+		existingID, err := q.Enqueue(ctx, task(queueID1, "existing"))
+		require.NoError(t, err)
+
+		taskIDs, err := q.EnqueueBatch(ctx, []queue.TaskEnqueueRequest{
+			task(queueID1, "existing"),
+			task(queueID1, "new"),
+			task(queueID1, "new"),
+			task(queueID2, "new"),
 		})
+		require.NoError(t, err)
+		require.Len(t, taskIDs, 4)
+
+		require.Equal(t, existingID, taskIDs[0])
+		require.Equal(t, taskIDs[1], taskIDs[2])
+		require.NotEqual(t, taskIDs[1], taskIDs[3])
+
+		dbtest.EqualCount(t, db, 1, "tasks", squirrel.Eq{"idempotency_key": "existing"})
+		dbtest.EqualCount(t, db, 2, "tasks", squirrel.Eq{"idempotency_key": "new"})
+	})
+
+	t.Run("enqueues batches larger than a single statement", func(t *testing.T) {
+		_, err := db.ExecContext(ctx, "DELETE FROM tasks;")
+		require.NoError(t, err)
+
+		tasks := make([]queue.TaskEnqueueRequest, 0, 2*maxBatchRows+1)
+		for i := 0; i < cap(tasks); i++ {
+			tasks = append(tasks, task(queueID1, ""))
+		}
+
+		taskIDs, err := q.EnqueueBatch(ctx, tasks)
+		require.NoError(t, err)
+		require.Len(t, taskIDs, len(tasks))
+		dbtest.EqualCount(t, db, len(tasks), "tasks", nil)
+	})
+
+	t.Run("returns no IDs for an empty batch", func(t *testing.T) {
+		taskIDs, err := q.EnqueueBatch(ctx, nil)
+		require.NoError(t, err)
+		require.Empty(t, taskIDs)
+	})
+}
+
+func TestEnqueueTx(t *testing.T) {
+	verifyLeak(t)
+
+	logrus.SetOutput(io.Discard)
+	defer logrus.SetOutput(os.Stdout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, db := dbtest.GetDatabase(t)
+	defer db.Close()
+	require.NoError(t, SetupTables(ctx, db, nil))
+
+	q := NewQueuer(db)
+	task := queue.TaskEnqueueRequest{
+		TaskBase: queue.TaskBase{
+			Queue: queueID1,
+			Type:  "test",
+			Spec:  spec,
+		},
 	}
+
+	t.Run("adds the task when the transaction is committed", func(t *testing.T) {
+		tx, err := db.BeginTx(ctx, nil)
+		require.NoError(t, err)
+
+		builder := squirrel.StatementBuilder.
+			PlaceholderFormat(squirrel.Dollar).
+			RunWith(tx)
+		taskID, err := q.EnqueueTx(ctx, builder, task)
+		require.NoError(t, err)
+
+		dbtest.EqualCount(t, db, 0, "tasks", squirrel.Eq{"task_id": taskID})
+		require.NoError(t, tx.Commit())
+		dbtest.EqualCount(t, db, 1, "tasks", squirrel.Eq{"task_id": taskID})
+	})
+
+	t.Run("does not add the task when the transaction is rolled back", func(t *testing.T) {
+		tx, err := db.BeginTx(ctx, nil)
+		require.NoError(t, err)
+
+		builder := squirrel.StatementBuilder.
+			PlaceholderFormat(squirrel.Dollar).
+			RunWith(tx)
+		taskID, err := q.EnqueueTx(ctx, builder, task)
+		require.NoError(t, err)
+
+		require.NoError(t, tx.Rollback())
+		dbtest.EqualCount(t, db, 0, "tasks", squirrel.Eq{"task_id": taskID})
+	})
+
+	t.Run("returns an error when the task is invalid", func(t *testing.T) {
+		builder := squirrel.StatementBuilder.
+			PlaceholderFormat(squirrel.Dollar).
+			RunWith(db)
+		_, err := q.EnqueueTx(ctx, builder, queue.TaskEnqueueRequest{})
+		require.Equal(t, queue.ErrTaskQueueNotSpecified, err)
+	})
 }
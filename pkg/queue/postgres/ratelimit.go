@@ -0,0 +1,163 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/contiamo/go-base/v4/pkg/data/managers"
+	cdb "github.com/contiamo/go-base/v4/pkg/db"
+	"github.com/contiamo/go-base/v4/pkg/queue"
+)
+
+// NewRateLimiter creates a new postgres queue rate limiter.
+// The state of the limits is stored in the database, so the limits are shared
+// by all the dequeuers using the same database.
+func NewRateLimiter(db *sql.DB) queue.RateLimiter {
+	return &rateLimiter{
+		BaseManager: managers.NewBaseManager(db, "PostgresRateLimiter"),
+	}
+}
+
+// rateLimiter is a postgres-backed implementation of the queue RateLimiter
+type rateLimiter struct {
+	managers.BaseManager
+}
+
+func (l *rateLimiter) SetQueueRateLimit(ctx context.Context, queueName string, limit *queue.RateLimit) (err error) {
+	span, ctx := l.StartSpan(ctx, "SetQueueRateLimit")
+	defer func() {
+		l.FinishSpan(span, err)
+	}()
+
+	span.SetTag("queue", queueName)
+
+	if queueName == "" {
+		return queue.ErrTaskQueueNotSpecified
+	}
+
+	err = setRateLimit(ctx, l.GetQueryBuilder(), QueuesTable, "queue", queueName, limit)
+	if err != nil {
+		return fmt.Errorf("can not upsert the queue rate limit: %w", err)
+	}
+
+	return nil
+}
+
+func (l *rateLimiter) GetQueueRateLimit(ctx context.Context, queueName string) (limit *queue.RateLimit, err error) {
+	span, ctx := l.StartSpan(ctx, "GetQueueRateLimit")
+	defer func() {
+		l.FinishSpan(span, err)
+	}()
+
+	span.SetTag("queue", queueName)
+
+	return getRateLimit(ctx, l.GetQueryBuilder(), QueuesTable, "queue", queueName)
+}
+
+func (l *rateLimiter) SetTaskTypeRateLimit(ctx context.Context, taskType queue.TaskType, limit *queue.RateLimit) (err error) {
+	span, ctx := l.StartSpan(ctx, "SetTaskTypeRateLimit")
+	defer func() {
+		l.FinishSpan(span, err)
+	}()
+
+	span.SetTag("type", taskType)
+
+	if taskType == "" {
+		return queue.ErrTaskTypeNotSpecified
+	}
+
+	err = setRateLimit(ctx, l.GetQueryBuilder(), TaskTypesTable, "type", taskType.String(), limit)
+	if err != nil {
+		return fmt.Errorf("can not upsert the task type rate limit: %w", err)
+	}
+
+	return nil
+}
+
+func (l *rateLimiter) GetTaskTypeRateLimit(ctx context.Context, taskType queue.TaskType) (limit *queue.RateLimit, err error) {
+	span, ctx := l.StartSpan(ctx, "GetTaskTypeRateLimit")
+	defer func() {
+		l.FinishSpan(span, err)
+	}()
+
+	span.SetTag("type", taskType)
+
+	return getRateLimit(ctx, l.GetQueryBuilder(), TaskTypesTable, "type", taskType.String())
+}
+
+// setRateLimit upserts the rate limit of the settings row with the given key,
+// the bucket of the changed limit starts full.
+func setRateLimit(ctx context.Context, builder cdb.SQLBuilder, table, keyColumn, key string, limit *queue.RateLimit) error {
+	var (
+		rate  interface{}
+		burst = 1
+	)
+	if limit != nil {
+		err := limit.Validate()
+		if err != nil {
+			return err
+		}
+		rate, burst = limit.Rate, limit.Burst
+	}
+
+	_, err := builder.
+		Insert(table).
+		Columns(keyColumn, "rate_limit", "rate_burst").
+		Values(key, rate, burst).
+		Suffix(fmt.Sprintf(`
+			ON CONFLICT (%s)
+			DO UPDATE SET
+				updated_at=?,
+				rate_limit=EXCLUDED.rate_limit,
+				rate_burst=EXCLUDED.rate_burst,
+				rate_tokens=NULL,
+				rate_updated_at=NULL
+		`, keyColumn), time.Now()).
+		ExecContext(ctx)
+
+	return err
+}
+
+// getRateLimit returns the rate limit of the settings row with the given key,
+// the rows that do not exist are not limited.
+func getRateLimit(ctx context.Context, builder cdb.SQLBuilder, table, keyColumn, key string) (*queue.RateLimit, error) {
+	var (
+		rate  sql.NullFloat64
+		burst int
+	)
+	err := builder.
+		Select("rate_limit", "rate_burst").
+		From(table).
+		Where(squirrel.Eq{keyColumn: key}).
+		QueryRowContext(ctx).
+		Scan(&rate, &burst)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !rate.Valid {
+		return nil, nil
+	}
+
+	return &queue.RateLimit{Rate: rate.Float64, Burst: burst}, nil
+}
+
+// availableTokens returns the SQL expression of the tokens in the bucket of the settings row
+// with the given alias. The bucket is refilled since the last taken token using the database
+// clock, so the dequeuers don't depend on the clocks of their hosts.
+// An unused bucket is full.
+func availableTokens(alias string) string {
+	return strings.ReplaceAll(
+		"LEAST(x.rate_burst, COALESCE(x.rate_tokens, x.rate_burst) + "+
+			"x.rate_limit * GREATEST(0, EXTRACT(EPOCH FROM NOW() - COALESCE(x.rate_updated_at, NOW()))))",
+		"x.",
+		alias+".",
+	)
+}
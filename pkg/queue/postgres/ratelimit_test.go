@@ -0,0 +1,170 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/contiamo/go-base/v4/pkg/config"
+	dbtest "github.com/contiamo/go-base/v4/pkg/db/test"
+	"github.com/contiamo/go-base/v4/pkg/queue"
+	uuid "github.com/satori/go.uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimit(t *testing.T) {
+	verifyLeak(t)
+
+	logrus.SetOutput(io.Discard)
+	defer logrus.SetOutput(os.Stdout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	insertTask := func(t *testing.T, db squirrel.BaseRunner, taskType queue.TaskType) {
+		_, err := squirrel.StatementBuilder.
+			PlaceholderFormat(squirrel.Dollar).
+			RunWith(db).
+			Insert("tasks").
+			Columns("task_id", "queue", "type", "spec", "progress", "status").
+			Values(uuid.NewV4().String(), queueID1, taskType, emptyJSON, emptyJSON, queue.Waiting).
+			ExecContext(ctx)
+		require.NoError(t, err)
+	}
+
+	t.Run("sets and gets the rate limits", func(t *testing.T) {
+		_, db := dbtest.GetDatabase(t)
+		defer db.Close()
+		require.NoError(t, SetupTables(ctx, db, nil))
+		l := NewRateLimiter(db)
+
+		limit, err := l.GetQueueRateLimit(ctx, queueID1)
+		require.NoError(t, err)
+		require.Nil(t, limit)
+
+		require.NoError(t, l.SetQueueRateLimit(ctx, queueID1, &queue.RateLimit{Rate: 0.5, Burst: 3}))
+		limit, err = l.GetQueueRateLimit(ctx, queueID1)
+		require.NoError(t, err)
+		require.Equal(t, &queue.RateLimit{Rate: 0.5, Burst: 3}, limit)
+
+		require.NoError(t, l.SetQueueRateLimit(ctx, queueID1, nil))
+		limit, err = l.GetQueueRateLimit(ctx, queueID1)
+		require.NoError(t, err)
+		require.Nil(t, limit)
+
+		limit, err = l.GetTaskTypeRateLimit(ctx, "test")
+		require.NoError(t, err)
+		require.Nil(t, limit)
+
+		require.NoError(t, l.SetTaskTypeRateLimit(ctx, "test", &queue.RateLimit{Rate: 10, Burst: 1}))
+		limit, err = l.GetTaskTypeRateLimit(ctx, "test")
+		require.NoError(t, err)
+		require.Equal(t, &queue.RateLimit{Rate: 10, Burst: 1}, limit)
+
+		dbtest.EqualCount(t, db, 1, QueuesTable, squirrel.Eq{"queue": queueID1})
+		dbtest.EqualCount(t, db, 1, TaskTypesTable, squirrel.Eq{"type": "test"})
+	})
+
+	t.Run("returns an error for invalid settings", func(t *testing.T) {
+		_, db := dbtest.GetDatabase(t)
+		defer db.Close()
+		require.NoError(t, SetupTables(ctx, db, nil))
+		l := NewRateLimiter(db)
+
+		require.Equal(t, queue.ErrTaskQueueNotSpecified, l.SetQueueRateLimit(ctx, "", nil))
+		require.Equal(t, queue.ErrTaskTypeNotSpecified, l.SetTaskTypeRateLimit(ctx, "", nil))
+		require.ErrorIs(t, l.SetQueueRateLimit(ctx, queueID1, &queue.RateLimit{Rate: 0, Burst: 1}), queue.ErrInvalidRateLimit)
+		require.ErrorIs(t, l.SetTaskTypeRateLimit(ctx, "test", &queue.RateLimit{Rate: 1, Burst: 0}), queue.ErrInvalidRateLimit)
+	})
+
+	cases := []struct {
+		name  string
+		setup func(t *testing.T, db *sql.DB)
+	}{
+		{
+			name: "queue rate limit",
+			setup: func(t *testing.T, db *sql.DB) {
+				require.NoError(t, NewRateLimiter(db).SetQueueRateLimit(ctx, queueID1, &queue.RateLimit{Rate: 10, Burst: 2}))
+			},
+		},
+		{
+			name: "task type rate limit",
+			setup: func(t *testing.T, db *sql.DB) {
+				require.NoError(t, NewRateLimiter(db).SetTaskTypeRateLimit(ctx, "test", &queue.RateLimit{Rate: 10, Burst: 2}))
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run("dequeues a burst of tasks and waits for the tokens with "+tc.name, func(t *testing.T) {
+			_, db := dbtest.GetDatabase(t)
+			defer db.Close()
+			require.NoError(t, SetupTables(ctx, db, nil))
+			require.NoError(t, NewConcurrencyLimiter(db).SetQueueConcurrency(ctx, queueID1, queue.UnlimitedQueueConcurrency))
+			tc.setup(t, db)
+
+			q := NewDequeuer(db, nil, config.Queue{
+				HeartbeatTTL:  10 * time.Second,
+				PollFrequency: 50 * time.Millisecond,
+			})
+
+			for i := 0; i < 3; i++ {
+				insertTask(t, db, "test")
+			}
+
+			for i := 0; i < 2; i++ {
+				task, err := q.(*dequeuer).attemptDequeue(ctx, queueID1)
+				require.NoError(t, err)
+				require.NotNil(t, task)
+			}
+
+			task, err := q.(*dequeuer).attemptDequeue(ctx, queueID1)
+			require.NoError(t, err)
+			require.Nil(t, task, "the burst must be respected")
+
+			// the bucket is refilled with 10 tokens per second
+			time.Sleep(150 * time.Millisecond)
+
+			task, err = q.(*dequeuer).attemptDequeue(ctx, queueID1)
+			require.NoError(t, err)
+			require.NotNil(t, task)
+
+			dbtest.EqualCount(t, db, 3, "tasks", squirrel.Eq{"status": queue.Running})
+		})
+	}
+
+	t.Run("does not limit other task types", func(t *testing.T) {
+		_, db := dbtest.GetDatabase(t)
+		defer db.Close()
+		require.NoError(t, SetupTables(ctx, db, nil))
+		require.NoError(t, NewConcurrencyLimiter(db).SetQueueConcurrency(ctx, queueID1, queue.UnlimitedQueueConcurrency))
+		require.NoError(t, NewRateLimiter(db).SetTaskTypeRateLimit(ctx, "limited", &queue.RateLimit{Rate: 0.001, Burst: 1}))
+
+		q := NewDequeuer(db, nil, config.Queue{
+			HeartbeatTTL:  10 * time.Second,
+			PollFrequency: 50 * time.Millisecond,
+		})
+
+		insertTask(t, db, "limited")
+		insertTask(t, db, "limited")
+		insertTask(t, db, "test")
+
+		for i := 0; i < 2; i++ {
+			task, err := q.(*dequeuer).attemptDequeue(ctx, queueID1)
+			require.NoError(t, err)
+			require.NotNil(t, task)
+		}
+
+		task, err := q.(*dequeuer).attemptDequeue(ctx, queueID1)
+		require.NoError(t, err)
+		require.Nil(t, task)
+
+		dbtest.EqualCount(t, db, 1, "tasks", squirrel.Eq{"type": "limited", "status": queue.Running})
+		dbtest.EqualCount(t, db, 1, "tasks", squirrel.Eq{"type": "test", "status": queue.Running})
+	})
+}
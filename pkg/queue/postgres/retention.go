@@ -41,7 +41,8 @@ func NewRetentionHandler(db *sql.DB) queue.TaskHandler {
 }
 
 // AssertRetentionSchedule creates a new queue retention tasks for the supplied queue, finished tasks matching
-// the supplied parameters will be deleted
+// the supplied parameters will be deleted. Dead-lettered tasks are never deleted by the retention,
+// see NewDeadLetterQueue for purging them.
 func AssertRetentionSchedule(ctx context.Context, db *sql.DB, queueName string, taskType queue.TaskType, status queue.TaskStatus, age time.Duration) (err error) {
 	span, ctx := opentracing.StartSpanFromContext(ctx, "AssertRetentionSchedule")
 	span.SetTag("pkg.name", "postgres")
@@ -145,7 +146,9 @@ func createRetentionSpec(queueName string, taskType queue.TaskType, status queue
 			// note that using this comparison allows us to use the index on
 			// finished_at, if yo use `age(now(), finished_at)`, this can not use the index
 			fmt.Sprintf("finished_at <= now() - interval '%f minutes'", age.Minutes()),
-		)
+		).
+		// dead letters are kept until they are explicitly purged
+		Where(squirrel.Eq{"dead_lettered_at": nil})
 
 	if queueName != "" {
 		deletionSQL = deletionSQL.Where(squirrel.Eq{"queue": queueName})
@@ -4,11 +4,13 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"io"
 	"os"
 	"testing"
 	"time"
 
+	"github.com/contiamo/go-base/v4/pkg/config"
 	"github.com/contiamo/go-base/v4/pkg/queue/handlers"
 
 	"github.com/Masterminds/squirrel"
@@ -159,6 +161,77 @@ func TestRetentionHandler(t *testing.T) {
 	require.Equal(t, int64(1), *lastBeat.RowsAffected, "%+v", seenBeats)
 }
 
+func TestRetentionHandlerFailedTasks(t *testing.T) {
+	verifyLeak(t)
+
+	logrus.SetOutput(io.Discard)
+	defer logrus.SetOutput(os.Stdout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, db := dbtest.GetDatabase(t)
+	defer db.Close()
+	require.NoError(t, SetupTables(ctx, db, nil))
+
+	d := NewDequeuer(db, nil, config.Queue{
+		HeartbeatTTL:  10 * time.Second,
+		PollFrequency: 50 * time.Millisecond,
+	})
+
+	// insertRunning inserts a running task, the task with the retry policy is on its last attempt
+	insertRunning := func(retryPolicy interface{}) string {
+		now := time.Now()
+		taskID := uuid.NewV4().String()
+		_, err := squirrel.StatementBuilder.
+			PlaceholderFormat(squirrel.Dollar).
+			RunWith(db).
+			Insert("tasks").
+			Columns("task_id", "queue", "type", "spec", "progress", "status", "started_at", "last_heartbeat_at", "attempts", "retry_policy").
+			Values(taskID, "standard", "simple", emptyJSON, emptyJSON, queue.Running, now, now, 1, retryPolicy).
+			ExecContext(ctx)
+		require.NoError(t, err)
+		return taskID
+	}
+
+	failedID := insertRunning(nil)
+	require.NoError(t, d.Fail(ctx, failedID, progress))
+
+	deadLetteredID := insertRunning([]byte(`{"maxAttempts": 1}`))
+	require.NoError(t, d.(queue.Retrier).FailAttempt(ctx, deadLetteredID, progress, errors.New("last attempt")))
+
+	// both tasks failed two weeks ago
+	_, err := squirrel.StatementBuilder.
+		PlaceholderFormat(squirrel.Dollar).
+		RunWith(db).
+		Update("tasks").
+		Set("finished_at", time.Now().Add(-14*24*time.Hour)).
+		ExecContext(ctx)
+	require.NoError(t, err)
+
+	spec := createRetentionSpec("standard", "", queue.Failed, 7*24*time.Hour)
+	specBytes, err := json.Marshal(spec)
+	require.NoError(t, err)
+
+	heartbeats := make(chan queue.Progress, 10)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range heartbeats {
+		}
+	}()
+
+	err = NewRetentionHandler(db).Process(ctx, queue.Task{
+		TaskBase: queue.TaskBase{Queue: MaintenanceTaskQueue, Type: RetentionTask, Spec: specBytes},
+		ID:       uuid.NewV4().String(),
+	}, heartbeats)
+	require.NoError(t, err)
+	<-done
+
+	dbtest.EqualCount(t, db, 0, TasksTable, squirrel.Eq{"task_id": failedID}, "the failed task must be deleted")
+	dbtest.EqualCount(t, db, 1, TasksTable, squirrel.Eq{"task_id": deadLetteredID}, "the dead letter must be kept")
+}
+
 func insertTestTask(ctx context.Context, db *sql.DB, task *queue.Task) error {
 	_, err := squirrel.StatementBuilder.
 		PlaceholderFormat(squirrel.Dollar).
@@ -218,7 +291,7 @@ func TestAssertRetentionSchedule(t *testing.T) {
 			taskType:    queue.TaskType("throw-away"),
 			status:      queue.Finished,
 			age:         time.Minute,
-			expectedSQL: `DELETE FROM tasks WHERE status = 'finished' AND finished_at <= now() - interval '1.000000 minutes' AND queue = 'basic' AND type = 'throw-away'`,
+			expectedSQL: `DELETE FROM tasks WHERE status = 'finished' AND finished_at <= now() - interval '1.000000 minutes' AND dead_lettered_at IS NULL AND queue = 'basic' AND type = 'throw-away'`,
 		},
 		{
 			name:        "one week retention without any extra filters is successful",
@@ -226,7 +299,7 @@ func TestAssertRetentionSchedule(t *testing.T) {
 			taskType:    queue.TaskType("throw-away-2"),
 			status:      queue.Finished,
 			age:         7 * 24 * time.Hour,
-			expectedSQL: `DELETE FROM tasks WHERE status = 'finished' AND finished_at <= now() - interval '10080.000000 minutes' AND queue = 'advanced' AND type = 'throw-away-2'`,
+			expectedSQL: `DELETE FROM tasks WHERE status = 'finished' AND finished_at <= now() - interval '10080.000000 minutes' AND dead_lettered_at IS NULL AND queue = 'advanced' AND type = 'throw-away-2'`,
 		},
 		{
 			name:        "one month retention without any extra filters is successful",
@@ -234,27 +307,27 @@ func TestAssertRetentionSchedule(t *testing.T) {
 			taskType:    queue.TaskType("throw-away-3"),
 			status:      queue.Finished,
 			age:         30 * 24 * time.Hour,
-			expectedSQL: `DELETE FROM tasks WHERE status = 'finished' AND finished_at <= now() - interval '43200.000000 minutes' AND queue = 'super' AND type = 'throw-away-3'`,
+			expectedSQL: `DELETE FROM tasks WHERE status = 'finished' AND finished_at <= now() - interval '43200.000000 minutes' AND dead_lettered_at IS NULL AND queue = 'super' AND type = 'throw-away-3'`,
 		},
 		{
 			name:        "retention for _any_ finished task, without queue or task type restriction",
 			status:      queue.Finished,
 			age:         time.Minute,
-			expectedSQL: `DELETE FROM tasks WHERE status = 'finished' AND finished_at <= now() - interval '1.000000 minutes'`,
+			expectedSQL: `DELETE FROM tasks WHERE status = 'finished' AND finished_at <= now() - interval '1.000000 minutes' AND dead_lettered_at IS NULL`,
 		},
 		{
 			name:        "retention for _any_ failed task in a specific queue",
 			queueName:   "justme",
 			status:      queue.Failed,
 			age:         time.Minute,
-			expectedSQL: `DELETE FROM tasks WHERE status = 'failed' AND finished_at <= now() - interval '1.000000 minutes' AND queue = 'justme'`,
+			expectedSQL: `DELETE FROM tasks WHERE status = 'failed' AND finished_at <= now() - interval '1.000000 minutes' AND dead_lettered_at IS NULL AND queue = 'justme'`,
 		},
 		{
 			name:        "retention based on task type and status",
 			taskType:    "justme",
 			status:      queue.Failed,
 			age:         time.Minute,
-			expectedSQL: `DELETE FROM tasks WHERE status = 'failed' AND finished_at <= now() - interval '1.000000 minutes' AND type = 'justme'`,
+			expectedSQL: `DELETE FROM tasks WHERE status = 'failed' AND finished_at <= now() - interval '1.000000 minutes' AND dead_lettered_at IS NULL AND type = 'justme'`,
 		},
 	}
 
@@ -315,7 +388,7 @@ func TestAssertRetentionSchedule(t *testing.T) {
 		}, "initial retention task not found")
 
 		specSQL := ""
-		expectedSQL := `DELETE FROM tasks WHERE status = 'finished' AND finished_at <= now() - interval '5.000000 minutes' AND queue = 'super_important' AND type = 'update-test'`
+		expectedSQL := `DELETE FROM tasks WHERE status = 'finished' AND finished_at <= now() - interval '5.000000 minutes' AND dead_lettered_at IS NULL AND queue = 'super_important' AND type = 'update-test'`
 		err = squirrel.Select("task_spec->>'sql'").From("schedules").Where(
 			squirrel.Eq{
 				"task_queue":              MaintenanceTaskQueue,
@@ -347,7 +420,7 @@ func TestAssertRetentionSchedule(t *testing.T) {
 		}, "updated task with new age parameter not found")
 
 		specSQL = ""
-		expectedSQL = `DELETE FROM tasks WHERE status = 'finished' AND finished_at <= now() - interval '10.000000 minutes' AND queue = 'super_important' AND type = 'update-test'`
+		expectedSQL = `DELETE FROM tasks WHERE status = 'finished' AND finished_at <= now() - interval '10.000000 minutes' AND dead_lettered_at IS NULL AND queue = 'super_important' AND type = 'update-test'`
 		err = squirrel.Select("task_spec->>'sql'").From("schedules").Where(
 			squirrel.Eq{
 				"task_queue":              MaintenanceTaskQueue,
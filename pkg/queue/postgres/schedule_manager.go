@@ -0,0 +1,391 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/contiamo/go-base/v4/pkg/data/managers"
+	cdb "github.com/contiamo/go-base/v4/pkg/db"
+	"github.com/contiamo/go-base/v4/pkg/http/parameters"
+	"github.com/contiamo/go-base/v4/pkg/queue"
+	"github.com/opentracing/opentracing-go"
+	"github.com/pkg/errors"
+)
+
+// NewScheduleManager creates a new postgres schedule manager,
+// the queuer is used for enqueuing the tasks of the triggered schedules.
+func NewScheduleManager(db *sql.DB, queuer queue.Queuer) queue.ScheduleManager {
+	return &scheduleManager{
+		BaseManager: managers.NewBaseManager(db, "PostgresScheduleManager"),
+		queuer:      queuer,
+	}
+}
+
+// scheduleManager is a postgres-backed implementation of the queue ScheduleManager
+type scheduleManager struct {
+	managers.BaseManager
+	queuer queue.Queuer
+}
+
+func (m *scheduleManager) GetSchedule(ctx context.Context, scheduleID string) (schedule *queue.Schedule, err error) {
+	span, ctx := m.StartSpan(ctx, "GetSchedule")
+	defer func() {
+		m.FinishSpan(span, err)
+	}()
+
+	span.SetTag("schedule.id", scheduleID)
+
+	if !isValidID(scheduleID) {
+		return nil, queue.ErrScheduleNotFound
+	}
+
+	schedule, err = scanSchedule(selectSchedule(m.GetQueryBuilder(), scheduleID).QueryRowContext(ctx))
+	if err == sql.ErrNoRows {
+		return nil, queue.ErrScheduleNotFound
+	}
+
+	return schedule, err
+}
+
+func (m *scheduleManager) ListSchedules(ctx context.Context, filter queue.ScheduleFilter, page parameters.Page) (list queue.ScheduleList, err error) {
+	span, ctx := m.StartSpan(ctx, "ListSchedules")
+	defer func() {
+		m.FinishSpan(span, err)
+	}()
+
+	setScheduleFilterTags(span, filter)
+
+	if page.Number < 1 {
+		page.Number = 1
+	}
+	if page.Size < 1 {
+		page.Size = parameters.DefaultPageSize
+	}
+
+	where := scheduleFilterCondition(filter)
+
+	list.PageInfo, err = m.GetPageInfo(ctx, SchedulesTable, page, nil, where)
+	if err != nil {
+		return list, err
+	}
+
+	rows, err := m.GetQueryBuilder().
+		Select(scheduleSelectColumns()...).
+		From(SchedulesTable).
+		Where(where).
+		OrderBy("created_at DESC", "schedule_id").
+		Offset(uint64((page.Number - 1) * page.Size)).
+		Limit(uint64(page.Size)).
+		QueryContext(ctx)
+	if err != nil {
+		return list, err
+	}
+	defer rows.Close()
+
+	list.Items = make([]queue.Schedule, 0, page.Size)
+	for rows.Next() {
+		schedule, err := scanSchedule(rows)
+		if err != nil {
+			return list, err
+		}
+		list.Items = append(list.Items, *schedule)
+	}
+
+	return list, rows.Err()
+}
+
+func (m *scheduleManager) UpdateSchedule(ctx context.Context, scheduleID string, update queue.ScheduleUpdate) (schedule *queue.Schedule, err error) {
+	span, ctx := m.StartSpan(ctx, "UpdateSchedule")
+	defer func() {
+		m.FinishSpan(span, err)
+	}()
+
+	span.SetTag("schedule.id", scheduleID)
+
+	if !isValidID(scheduleID) {
+		return nil, queue.ErrScheduleNotFound
+	}
+
+	builder, tx, err := m.GetTxQueryBuilder(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if err == nil {
+			err = tx.Commit()
+			return
+		}
+
+		rollbackErr := tx.Rollback()
+		if rollbackErr != nil {
+			err = errors.Wrap(err, rollbackErr.Error())
+		}
+	}()
+
+	// the row is locked, so the schedule worker can't change the next execution time
+	// while the update is calculated
+	schedule, err = scanSchedule(selectSchedule(builder, scheduleID).Suffix("FOR UPDATE").QueryRowContext(ctx))
+	if err == sql.ErrNoRows {
+		return nil, queue.ErrScheduleNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = update.Apply(schedule, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	span.SetTag("schedule.cron", schedule.CronSchedule)
+	span.SetTag("schedule.interval", schedule.Interval.String())
+	span.SetTag("schedule.nextExecutionTime", schedule.NextExecutionTime)
+
+	_, err = builder.
+		Update(SchedulesTable).
+		Set("task_spec", schedule.Spec).
+		Set("task_priority", schedule.Priority).
+		Set("cron_schedule", schedule.CronSchedule).
+		Set("timezone", schedule.Timezone).
+		Set("interval_ms", schedule.Interval.Milliseconds()).
+		Set("valid_from", schedule.ValidFrom).
+		Set("valid_until", schedule.ValidUntil).
+		Set("max_executions", schedule.MaxExecutions).
+		Set("misfire_policy", schedule.MisfirePolicy).
+		Set("misfire_limit", schedule.MisfireLimit).
+		Set("overlap_policy", schedule.OverlapPolicy).
+		Set("next_execution_time", schedule.NextExecutionTime).
+		Set("updated_at", schedule.UpdatedAt).
+		Where(squirrel.Eq{"schedule_id": scheduleID}).
+		ExecContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return schedule, nil
+}
+
+func (m *scheduleManager) PauseSchedule(ctx context.Context, scheduleID string) (err error) {
+	span, ctx := m.StartSpan(ctx, "PauseSchedule")
+	defer func() {
+		m.FinishSpan(span, err)
+	}()
+
+	span.SetTag("schedule.id", scheduleID)
+
+	if !isValidID(scheduleID) {
+		return queue.ErrScheduleNotFound
+	}
+
+	now := time.Now()
+	return m.updateSchedule(ctx, scheduleID, m.GetQueryBuilder().
+		Update(SchedulesTable).
+		Set("paused_at", squirrel.Expr("COALESCE(paused_at, ?)", now)).
+		Set("updated_at", now),
+	)
+}
+
+func (m *scheduleManager) ResumeSchedule(ctx context.Context, scheduleID string) (err error) {
+	span, ctx := m.StartSpan(ctx, "ResumeSchedule")
+	defer func() {
+		m.FinishSpan(span, err)
+	}()
+
+	span.SetTag("schedule.id", scheduleID)
+
+	if !isValidID(scheduleID) {
+		return queue.ErrScheduleNotFound
+	}
+
+	return m.updateSchedule(ctx, scheduleID, m.GetQueryBuilder().
+		Update(SchedulesTable).
+		Set("paused_at", nil).
+		Set("updated_at", time.Now()),
+	)
+}
+
+func (m *scheduleManager) DeleteSchedule(ctx context.Context, scheduleID string) (err error) {
+	span, ctx := m.StartSpan(ctx, "DeleteSchedule")
+	defer func() {
+		m.FinishSpan(span, err)
+	}()
+
+	span.SetTag("schedule.id", scheduleID)
+
+	if !isValidID(scheduleID) {
+		return queue.ErrScheduleNotFound
+	}
+
+	res, err := m.GetQueryBuilder().
+		Delete(SchedulesTable).
+		Where(squirrel.Eq{"schedule_id": scheduleID}).
+		ExecContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	return requireAffected(res, queue.ErrScheduleNotFound)
+}
+
+func (m *scheduleManager) TriggerSchedule(ctx context.Context, scheduleID string) (err error) {
+	span, ctx := m.StartSpan(ctx, "TriggerSchedule")
+	defer func() {
+		m.FinishSpan(span, err)
+	}()
+
+	span.SetTag("schedule.id", scheduleID)
+
+	if !isValidID(scheduleID) {
+		return queue.ErrScheduleNotFound
+	}
+
+	schedule, err := scanSchedule(selectSchedule(m.GetQueryBuilder(), scheduleID).QueryRowContext(ctx))
+	if err == sql.ErrNoRows {
+		return queue.ErrScheduleNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	span.SetTag("task.queue", schedule.Queue)
+	span.SetTag("task.type", schedule.Type)
+
+	// the same reference the schedule worker sets, so the tasks
+	// are deleted together with the schedule
+	taskID, err := m.queuer.Enqueue(ctx, queue.TaskEnqueueRequest{
+		TaskBase: schedule.TaskBase,
+		References: queue.References{
+			"schedule_id": schedule.ID,
+		},
+		ScheduledAt: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	span.SetTag("task.ID", taskID)
+
+	return nil
+}
+
+// updateSchedule executes the update statement for the given schedule
+func (m *scheduleManager) updateSchedule(ctx context.Context, scheduleID string, stmt squirrel.UpdateBuilder) error {
+	res, err := stmt.
+		Where(squirrel.Eq{"schedule_id": scheduleID}).
+		ExecContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	return requireAffected(res, queue.ErrScheduleNotFound)
+}
+
+// requireAffected returns notFound when the statement did not affect any rows
+func requireAffected(res sql.Result, notFound error) error {
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return notFound
+	}
+
+	return nil
+}
+
+// scheduleFilterCondition builds the WHERE condition matching the schedules selected by the filter
+func scheduleFilterCondition(filter queue.ScheduleFilter) squirrel.And {
+	where := squirrel.And{}
+
+	if filter.Queue != "" {
+		where = append(where, squirrel.Eq{"task_queue": filter.Queue})
+	}
+
+	if filter.Type != "" {
+		where = append(where, squirrel.Eq{"task_type": filter.Type})
+	}
+
+	if len(filter.References) > 0 {
+		where = append(where, squirrel.Eq(filter.References))
+	}
+
+	return where
+}
+
+// setScheduleFilterTags sets the filter values as the span tags
+func setScheduleFilterTags(span opentracing.Span, filter queue.ScheduleFilter) {
+	span.SetTag("filter.queue", filter.Queue)
+	span.SetTag("filter.type", filter.Type)
+	span.SetTag("filter.references", filter.References)
+}
+
+// scheduleSelectColumns returns the list of columns selected for a schedule,
+// the order must match the order in scanSchedule
+func scheduleSelectColumns() []string {
+	return []string{
+		"schedule_id",
+		"task_queue",
+		"task_type",
+		"task_spec",
+		"task_priority",
+		"cron_schedule",
+		"timezone",
+		"interval_ms",
+		"valid_from",
+		"valid_until",
+		"max_executions",
+		"misfire_policy",
+		"misfire_limit",
+		"overlap_policy",
+		"execution_count",
+		"next_execution_time",
+		"paused_at",
+		"created_at",
+		"updated_at",
+	}
+}
+
+// selectSchedule builds the query selecting the schedule with the given ID
+func selectSchedule(builder cdb.SQLBuilder, scheduleID string) squirrel.SelectBuilder {
+	return builder.
+		Select(scheduleSelectColumns()...).
+		From(SchedulesTable).
+		Where(squirrel.Eq{"schedule_id": scheduleID})
+}
+
+// scanSchedule scans a schedule from the row selected with scheduleSelectColumns
+func scanSchedule(row squirrel.RowScanner) (*queue.Schedule, error) {
+	schedule := queue.Schedule{}
+	var intervalMs int64
+	err := row.Scan(
+		&schedule.ID,
+		&schedule.Queue,
+		&schedule.Type,
+		&schedule.Spec,
+		&schedule.Priority,
+		&schedule.CronSchedule,
+		&schedule.Timezone,
+		&intervalMs,
+		&schedule.ValidFrom,
+		&schedule.ValidUntil,
+		&schedule.MaxExecutions,
+		&schedule.MisfirePolicy,
+		&schedule.MisfireLimit,
+		&schedule.OverlapPolicy,
+		&schedule.ExecutionCount,
+		&schedule.NextExecutionTime,
+		&schedule.PausedAt,
+		&schedule.CreatedAt,
+		&schedule.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	schedule.Interval = time.Duration(intervalMs) * time.Millisecond
+
+	return &schedule, nil
+}
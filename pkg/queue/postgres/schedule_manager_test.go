@@ -0,0 +1,150 @@
+package postgres
+
+import (
+	"context"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	dbtest "github.com/contiamo/go-base/v4/pkg/db/test"
+	"github.com/contiamo/go-base/v4/pkg/http/parameters"
+	"github.com/contiamo/go-base/v4/pkg/queue"
+	uuid "github.com/satori/go.uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScheduleFilterCondition(t *testing.T) {
+	cases := []struct {
+		name    string
+		filter  queue.ScheduleFilter
+		expSQL  string
+		expArgs []interface{}
+	}{
+		{
+			name:    "empty filter matches all schedules",
+			expSQL:  "(1=1)",
+			expArgs: []interface{}{},
+		},
+		{
+			name: "matches schedules by all filter values",
+			filter: queue.ScheduleFilter{
+				Queue:      "queue1",
+				Type:       "test",
+				References: queue.References{"test_id": "some-id"},
+			},
+			expSQL:  "(task_queue = ? AND task_type = ? AND test_id = ?)",
+			expArgs: []interface{}{"queue1", queue.TaskType("test"), "some-id"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			query, args, err := scheduleFilterCondition(tc.filter).ToSql()
+			require.NoError(t, err)
+			require.Equal(t, tc.expSQL, query)
+			require.Equal(t, tc.expArgs, args)
+		})
+	}
+}
+
+func TestScheduleManager(t *testing.T) {
+	verifyLeak(t)
+
+	logrus.SetOutput(io.Discard)
+	defer logrus.SetOutput(os.Stdout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, db := dbtest.GetDatabase(t)
+	defer db.Close()
+	require.NoError(t, SetupTables(ctx, db, nil))
+
+	now := time.Now()
+	scheduleIDs := []string{uuid.NewV4().String(), uuid.NewV4().String(), uuid.NewV4().String()}
+	_, err := squirrel.StatementBuilder.
+		PlaceholderFormat(squirrel.Dollar).
+		RunWith(db).
+		Insert("schedules").
+		Columns("schedule_id", "task_queue", "task_type", "task_spec", "task_priority", "cron_schedule", "next_execution_time", "created_at").
+		Values(scheduleIDs[0], queueID1, "test", spec, 5, "@hourly", now.Add(time.Hour), now.Add(-time.Minute)).
+		Values(scheduleIDs[1], queueID1, "test", spec, 0, "@daily", now.Add(time.Hour), now.Add(-2*time.Minute)).
+		Values(scheduleIDs[2], queueID2, "other", spec, 0, "@weekly", now.Add(time.Hour), now.Add(-3*time.Minute)).
+		ExecContext(ctx)
+	require.NoError(t, err)
+
+	m := NewScheduleManager(db, NewQueuer(db))
+
+	t.Run("lists schedules with pagination, the most recent first", func(t *testing.T) {
+		list, err := m.ListSchedules(ctx, queue.ScheduleFilter{Queue: queueID1}, parameters.Page{Number: 1, Size: 1})
+		require.NoError(t, err)
+		require.Len(t, list.Items, 1)
+		require.Equal(t, scheduleIDs[0], list.Items[0].ID)
+		require.Equal(t, "@hourly", list.Items[0].CronSchedule)
+		require.Equal(t, 5, list.Items[0].Priority)
+		require.Equal(t, queue.Spec(spec), list.Items[0].Spec)
+		require.Nil(t, list.Items[0].PausedAt)
+		require.Equal(t, uint32(2), list.PageInfo.ItemCount)
+		require.Equal(t, uint32(3), list.PageInfo.UnfilteredItemCount)
+
+		list, err = m.ListSchedules(ctx, queue.ScheduleFilter{Type: "other"}, parameters.Page{})
+		require.NoError(t, err)
+		require.Len(t, list.Items, 1)
+		require.Equal(t, scheduleIDs[2], list.Items[0].ID)
+	})
+
+	t.Run("pauses and resumes a schedule", func(t *testing.T) {
+		require.NoError(t, m.PauseSchedule(ctx, scheduleIDs[0]))
+		dbtest.EqualCount(t, db, 1, SchedulesTable, squirrel.And{
+			squirrel.Eq{"schedule_id": scheduleIDs[0]},
+			squirrel.NotEq{"paused_at": nil},
+		})
+
+		// pausing twice keeps the initial pause time
+		var pausedAt time.Time
+		err := db.QueryRowContext(ctx, "SELECT paused_at FROM schedules WHERE schedule_id=$1", scheduleIDs[0]).Scan(&pausedAt)
+		require.NoError(t, err)
+		require.NoError(t, m.PauseSchedule(ctx, scheduleIDs[0]))
+		dbtest.EqualCount(t, db, 1, SchedulesTable, squirrel.Eq{
+			"schedule_id": scheduleIDs[0],
+			"paused_at":   pausedAt,
+		})
+
+		require.NoError(t, m.ResumeSchedule(ctx, scheduleIDs[0]))
+		dbtest.EqualCount(t, db, 1, SchedulesTable, squirrel.Eq{
+			"schedule_id": scheduleIDs[0],
+			"paused_at":   nil,
+		})
+	})
+
+	t.Run("triggers a schedule", func(t *testing.T) {
+		require.NoError(t, m.PauseSchedule(ctx, scheduleIDs[1]))
+		require.NoError(t, m.TriggerSchedule(ctx, scheduleIDs[1]))
+
+		dbtest.EqualCount(t, db, 1, TasksTable, squirrel.Eq{
+			"schedule_id": scheduleIDs[1],
+			"queue":       queueID1,
+			"type":        "test",
+			"status":      queue.Waiting,
+		})
+		// the next execution time is not affected
+		dbtest.EqualCount(t, db, 0, SchedulesTable, squirrel.LtOrEq{"next_execution_time": now})
+	})
+
+	t.Run("deletes a schedule", func(t *testing.T) {
+		require.NoError(t, m.DeleteSchedule(ctx, scheduleIDs[1]))
+		dbtest.EqualCount(t, db, 0, SchedulesTable, squirrel.Eq{"schedule_id": scheduleIDs[1]})
+		dbtest.EqualCount(t, db, 0, TasksTable, squirrel.Eq{"schedule_id": scheduleIDs[1]})
+	})
+
+	t.Run("returns an error when the schedule does not exist", func(t *testing.T) {
+		scheduleID := uuid.NewV4().String()
+		require.Equal(t, queue.ErrScheduleNotFound, m.PauseSchedule(ctx, scheduleID))
+		require.Equal(t, queue.ErrScheduleNotFound, m.ResumeSchedule(ctx, scheduleID))
+		require.Equal(t, queue.ErrScheduleNotFound, m.TriggerSchedule(ctx, scheduleID))
+		require.Equal(t, queue.ErrScheduleNotFound, m.DeleteSchedule(ctx, scheduleID))
+	})
+}
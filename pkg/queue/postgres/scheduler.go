@@ -10,7 +10,6 @@ import (
 	cdb "github.com/contiamo/go-base/v4/pkg/db"
 	"github.com/contiamo/go-base/v4/pkg/queue"
 	"github.com/contiamo/go-base/v4/pkg/tracing"
-	cvalidation "github.com/contiamo/go-base/v4/pkg/validation"
 	uuid "github.com/satori/go.uuid"
 )
 
@@ -51,22 +50,33 @@ func (q *scheduler) Schedule(ctx context.Context, builder cdb.SQLBuilder, task q
 		task.Spec = emptyJSON
 	}
 
-	// empty schedule means a one-time job
-	if task.CronSchedule != "" {
-		err = cvalidation.CronTab(task.CronSchedule)
-		if err != nil {
-			return err
-		}
+	timing := task.Timing()
+	err = timing.Validate()
+	if err != nil {
+		return err
+	}
+
+	err = task.OverlapPolicy.ValidateFor(timing)
+	if err != nil {
+		return err
 	}
 
 	scheduleID := uuid.NewV4().String()
 
 	span.SetTag("schedule.id", scheduleID)
 	span.SetTag("schedule.cron", task.CronSchedule)
+	span.SetTag("schedule.timezone", task.Timezone)
+	span.SetTag("schedule.interval", task.Interval.String())
+	span.SetTag("schedule.validFrom", task.ValidFrom)
+	span.SetTag("schedule.validUntil", task.ValidUntil)
+	span.SetTag("schedule.maxExecutions", task.MaxExecutions)
+	span.SetTag("schedule.misfirePolicy", task.MisfirePolicy)
+	span.SetTag("schedule.overlapPolicy", task.OverlapPolicy)
 	span.SetTag("schedule.references", task.References)
 	span.SetTag("task.queue", task.Queue)
 	span.SetTag("task.type", task.Type)
 	span.SetTag("task.spec", string(task.Spec))
+	span.SetTag("task.priority", task.Priority)
 
 	refColumns, refValues := task.References.GetNamesAndValues()
 
@@ -79,7 +89,16 @@ func (q *scheduler) Schedule(ctx context.Context, builder cdb.SQLBuilder, task q
 				"task_queue",
 				"task_type",
 				"task_spec",
+				"task_priority",
 				"cron_schedule",
+				"timezone",
+				"interval_ms",
+				"valid_from",
+				"valid_until",
+				"max_executions",
+				"misfire_policy",
+				"misfire_limit",
+				"overlap_policy",
 				"next_execution_time",
 			)...,
 		).
@@ -90,8 +109,17 @@ func (q *scheduler) Schedule(ctx context.Context, builder cdb.SQLBuilder, task q
 				task.Queue,
 				task.Type,
 				task.Spec,
+				task.Priority,
 				task.CronSchedule,
-				time.Now(), // the schedule will enqueue the task immediately
+				task.Timezone,
+				task.Interval.Milliseconds(),
+				nullableTime(task.ValidFrom),
+				nullableTime(task.ValidUntil),
+				task.MaxExecutions,
+				task.MisfirePolicy,
+				task.MisfireLimit,
+				task.OverlapPolicy,
+				timing.FirstExecution(time.Now()),
 			)...,
 		).
 		ExecContext(ctx)
@@ -196,3 +224,11 @@ func (q *scheduler) AssertSchedule(ctx context.Context, schedule queue.TaskSched
 
 	return nil
 }
+
+// nullableTime returns nil for the zero time, so it's stored as NULL
+func nullableTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
@@ -15,6 +15,14 @@ const (
 	TasksTable = "tasks"
 	// SchedulesTable is the name of the Postgres table used for schedules
 	SchedulesTable = "schedules"
+	// QueuesTable is the name of the Postgres table used for the queue settings
+	QueuesTable = "queues"
+	// TaskTypesTable is the name of the Postgres table used for the task type settings
+	TaskTypesTable = "task_types"
+
+	// idempotencyCondition selects the tasks that deduplicate the enqueued tasks
+	// with the same idempotency key, it's the condition of the unique index
+	idempotencyCondition = "idempotency_key IS NOT NULL AND finished_at IS NULL"
 
 	createTableTmpl = `
 CREATE EXTENSION IF NOT EXISTS citext;
@@ -30,22 +38,137 @@ ALTER TABLE %s
 %s;
 `
 	notifySetup = `
--- notify on channel 'task_update' on changes on the tasks table
+-- notify on channel 'task_update' when a task becomes available for dequeuing,
+-- notify on channel 'task_status' with the task ID when a task is finished, failed or cancelled
 CREATE OR REPLACE FUNCTION notify_task_update ()
     RETURNS TRIGGER
     AS $$
 BEGIN
-    PERFORM
-        pg_notify('task_update', '');
+    IF TG_OP = 'UPDATE' AND OLD.status IS NOT DISTINCT FROM NEW.status THEN
+        RETURN NULL;
+    END IF;
+    IF NEW.status = 'waiting' THEN
+        PERFORM
+            pg_notify('task_update', '');
+    END IF;
+    IF NEW.status IN ('finished', 'failed', 'cancelled') THEN
+        PERFORM
+            pg_notify('task_status', NEW.task_id::text);
+    END IF;
     RETURN NULL;
 END;
 $$
 LANGUAGE plpgsql;
 DROP TRIGGER IF EXISTS notify_task_update_trigger ON tasks;
 CREATE TRIGGER notify_task_update_trigger
-    AFTER INSERT ON tasks
+    AFTER INSERT OR UPDATE OF status ON tasks
     FOR EACH ROW
     EXECUTE PROCEDURE notify_task_update ();
+`
+	dependencySetup = `
+-- resolve the blocked tasks depending on a task when the task is over:
+-- a blocked task becomes 'waiting' when all the tasks it depends on are finished,
+-- it's cancelled as soon as any of them fails or is cancelled, which cancels its dependants too
+CREATE OR REPLACE FUNCTION resolve_task_dependencies ()
+    RETURNS TRIGGER
+    AS $$
+DECLARE
+    dependant RECORD;
+BEGIN
+    IF OLD.status IS NOT DISTINCT FROM NEW.status OR NEW.status NOT IN ('finished', 'failed', 'cancelled') THEN
+        RETURN NULL;
+    END IF;
+    -- the dependants are locked in the same order, so when the tasks they depend on
+    -- are over concurrently, the dependants are resolved one transaction after another
+    FOR dependant IN
+        SELECT task_id, depends_on FROM tasks
+        WHERE status = 'blocked' AND depends_on @> ARRAY[NEW.task_id]
+        ORDER BY task_id
+        FOR UPDATE
+    LOOP
+        IF NEW.status <> 'finished' THEN
+            UPDATE tasks
+            SET status = 'cancelled',
+                finished_at = NOW(),
+                updated_at = NOW(),
+                last_error = 'dependency ' || NEW.task_id || ' is ' || NEW.status
+            WHERE task_id = dependant.task_id;
+        ELSIF NOT EXISTS (
+            SELECT 1 FROM tasks
+            WHERE task_id = ANY (dependant.depends_on) AND status <> 'finished'
+        ) THEN
+            UPDATE tasks
+            SET status = 'waiting',
+                updated_at = NOW()
+            WHERE task_id = dependant.task_id;
+        END IF;
+    END LOOP;
+    RETURN NULL;
+END;
+$$
+LANGUAGE plpgsql;
+DROP TRIGGER IF EXISTS resolve_task_dependencies_trigger ON tasks;
+CREATE TRIGGER resolve_task_dependencies_trigger
+    AFTER UPDATE OF status ON tasks
+    FOR EACH ROW
+    EXECUTE PROCEDURE resolve_task_dependencies ();
+`
+	queueSetup = `
+-- every task queue must have a row in the 'queues' table, the dequeuer locks
+-- this row to respect the concurrency limit of the queue
+CREATE OR REPLACE FUNCTION ensure_task_queue ()
+    RETURNS TRIGGER
+    AS $$
+BEGIN
+    INSERT INTO queues (queue)
+        VALUES (NEW.queue)
+    ON CONFLICT (queue)
+        DO NOTHING;
+    RETURN NULL;
+END;
+$$
+LANGUAGE plpgsql;
+DROP TRIGGER IF EXISTS ensure_task_queue_trigger ON tasks;
+CREATE TRIGGER ensure_task_queue_trigger
+    AFTER INSERT ON tasks
+    FOR EACH ROW
+    EXECUTE PROCEDURE ensure_task_queue ();
+
+-- the queues of the tasks created before the trigger
+INSERT INTO queues (queue)
+SELECT DISTINCT
+    queue
+FROM
+    tasks
+ON CONFLICT (queue)
+    DO NOTHING;
+
+-- the dequeuer counts the running tasks of the queues with a concurrency limit in the 'inflight'
+-- column when it claims them, a running task releases its slot when it's over or waits for a retry
+CREATE OR REPLACE FUNCTION release_task_queue_slot ()
+    RETURNS TRIGGER
+    AS $$
+BEGIN
+    IF OLD.status <> 'running' OR (TG_OP = 'UPDATE' AND NEW.status = 'running') THEN
+        RETURN NULL;
+    END IF;
+    UPDATE queues
+    SET inflight = GREATEST(inflight - 1, 0)
+    WHERE queue = OLD.queue AND concurrency > 0;
+    RETURN NULL;
+END;
+$$
+LANGUAGE plpgsql;
+DROP TRIGGER IF EXISTS release_task_queue_slot_trigger ON tasks;
+CREATE TRIGGER release_task_queue_slot_trigger
+    AFTER UPDATE OF status OR DELETE ON tasks
+    FOR EACH ROW
+    EXECUTE PROCEDURE release_task_queue_slot ();
+
+-- the running tasks claimed before the trigger
+UPDATE queues q
+SET inflight = (SELECT COUNT(*) FROM tasks t WHERE t.queue = q.queue AND t.status = 'running')
+WHERE q.concurrency > 0;
 `
 )
 
@@ -59,24 +182,71 @@ var (
 		"task_queue":          "citext NOT NULL",
 		"task_type":           "citext NOT NULL",
 		"task_spec":           "jsonb NOT NULL",
+		"task_priority":       "integer NOT NULL DEFAULT 0",
 		"cron_schedule":       "citext NOT NULL DEFAULT ''",
+		"timezone":            "text NOT NULL DEFAULT ''",
+		"interval_ms":         "bigint NOT NULL DEFAULT 0",
+		"valid_from":          "timestamptz",
+		"valid_until":         "timestamptz",
+		"max_executions":      "integer NOT NULL DEFAULT 0",
+		"misfire_policy":      "text NOT NULL DEFAULT ''",
+		"misfire_limit":       "integer NOT NULL DEFAULT 0",
+		"overlap_policy":      "text NOT NULL DEFAULT ''",
+		"execution_count":     "integer NOT NULL DEFAULT 0",
 		"next_execution_time": "timestamptz",
+		"paused_at":           "timestamptz",
 		"created_at":          "timestamptz NOT NULL DEFAULT NOW()",
 		"updated_at":          "timestamptz NOT NULL DEFAULT NOW()",
 	}
 
+	queueColumns = tableColumnSet{
+		"queue":           "citext PRIMARY KEY",
+		"concurrency":     "integer NOT NULL DEFAULT 1",
+		"inflight":        "integer NOT NULL DEFAULT 0",
+		"paused_at":       "timestamptz",
+		"rate_limit":      "double precision",
+		"rate_burst":      "integer NOT NULL DEFAULT 1",
+		"rate_tokens":     "double precision",
+		"rate_updated_at": "timestamptz",
+		"created_at":      "timestamptz NOT NULL DEFAULT NOW()",
+		"updated_at":      "timestamptz NOT NULL DEFAULT NOW()",
+	}
+
+	taskTypeColumns = tableColumnSet{
+		"type":            "citext PRIMARY KEY",
+		"paused_at":       "timestamptz",
+		"rate_limit":      "double precision",
+		"rate_burst":      "integer NOT NULL DEFAULT 1",
+		"rate_tokens":     "double precision",
+		"rate_updated_at": "timestamptz",
+		"created_at":      "timestamptz NOT NULL DEFAULT NOW()",
+		"updated_at":      "timestamptz NOT NULL DEFAULT NOW()",
+	}
+
 	taskColumns = tableColumnSet{
 		"task_id":           "uuid PRIMARY KEY",
 		"queue":             "citext NOT NULL",
 		"type":              "citext NOT NULL",
 		"spec":              "jsonb NOT NULL",
+		"priority":          "integer NOT NULL DEFAULT 0",
 		"status":            "citext NOT NULL",
 		"progress":          "jsonb NOT NULL",
 		"created_at":        "timestamptz NOT NULL DEFAULT NOW()",
 		"updated_at":        "timestamptz NOT NULL DEFAULT NOW()",
+		"not_before":        "timestamptz NOT NULL DEFAULT NOW()",
 		"started_at":        "timestamptz",
 		"finished_at":       "timestamptz",
 		"last_heartbeat_at": "timestamptz",
+		"attempts":          "integer NOT NULL DEFAULT 0",
+		"retry_policy":      "jsonb",
+		"last_error":        "text NOT NULL DEFAULT ''",
+		"dead_lettered_at":  "timestamptz",
+		"result":            "jsonb",
+		"error":             "jsonb",
+		"idempotency_key":   "text",
+		"depends_on":        "uuid[]",
+		"workflow_id":       "text",
+		"scheduled_at":      "timestamptz",
 		"schedule_id":       "uuid REFERENCES schedules ON DELETE CASCADE",
 	}
 
@@ -155,10 +325,47 @@ var (
 			Table:   TasksTable,
 			Columns: []string{"started_at DESC"},
 		},
+		{
+			Table:   TasksTable,
+			Columns: []string{"priority DESC", "created_at"},
+		},
+		{
+			Table:   TasksTable,
+			Columns: []string{"not_before"},
+		},
 		{
 			Table:   TasksTable,
 			Columns: []string{"finished_at DESC"},
 		},
+		{
+			Table:     TasksTable,
+			Columns:   []string{"dead_lettered_at DESC"},
+			Condition: "dead_lettered_at IS NOT NULL",
+		},
+		{
+			Table:     TasksTable,
+			Columns:   []string{"queue", "idempotency_key"},
+			Condition: "idempotency_key IS NOT NULL",
+		},
+		{
+			Table:     TasksTable,
+			Name:      "unique_idempotency_key_idx",
+			Columns:   []string{"queue", "idempotency_key"},
+			Unique:    true,
+			Condition: idempotencyCondition,
+		},
+		{
+			Table:     TasksTable,
+			Columns:   []string{"depends_on"},
+			Type:      "gin",
+			Condition: "depends_on IS NOT NULL",
+		},
+		{
+			Table:     TasksTable,
+			Columns:   []string{"workflow_id"},
+			Type:      "hash",
+			Condition: "workflow_id IS NOT NULL",
+		},
 	}
 )
 
@@ -195,6 +402,30 @@ func SetupTables(ctx context.Context, db db.SQLDB, references []ForeignReference
 	}
 	logrus.Debug("`tasks` table is up to date")
 
+	logrus.Debug("checking `queues` table...")
+	// the queue settings are not referencing anything
+	err = syncTable(ctx, db, QueuesTable, queueColumns, nil)
+	if err != nil {
+		return err
+	}
+	logrus.Debug("`queues` table is up to date")
+
+	logrus.Debug("checking `task_types` table...")
+	// the task type settings are not referencing anything
+	err = syncTable(ctx, db, TaskTypesTable, taskTypeColumns, nil)
+	if err != nil {
+		return err
+	}
+	logrus.Debug("`task_types` table is up to date")
+
+	logrus.Debug("assert the queue trigger...")
+	logrus.Debug(queueSetup)
+	_, err = db.ExecContext(ctx, queueSetup)
+	if err != nil {
+		return err
+	}
+	logrus.Debug("the queue trigger is up to date")
+
 	logrus.Debug("assert the notification trigger...")
 	logrus.Debug(notifySetup)
 	_, err = db.ExecContext(ctx, notifySetup)
@@ -203,6 +434,14 @@ func SetupTables(ctx context.Context, db db.SQLDB, references []ForeignReference
 	}
 	logrus.Debug("the notification trigger is up to date")
 
+	logrus.Debug("assert the dependency trigger...")
+	logrus.Debug(dependencySetup)
+	_, err = db.ExecContext(ctx, dependencySetup)
+	if err != nil {
+		return err
+	}
+	logrus.Debug("the dependency trigger is up to date")
+
 	applyIndexes := make(indexList, 0, len(references)+len(indexes))
 	for _, ref := range references {
 		applyIndexes = append(applyIndexes, index{
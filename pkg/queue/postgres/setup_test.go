@@ -64,7 +64,7 @@ func TestSetupTables(t *testing.T) {
 
 		// create a task on the queue using this reference
 		queuer := NewQueuer(db)
-		err = queuer.Enqueue(ctx, queue.TaskEnqueueRequest{
+		_, err = queuer.Enqueue(ctx, queue.TaskEnqueueRequest{
 			TaskBase: queue.TaskBase{
 				Queue: "test-queue",
 				Type:  queue.TaskType("test-task-type"),
@@ -137,7 +137,7 @@ func TestSetupTables(t *testing.T) {
 		queuer := NewQueuer(db)
 
 		// try create a task on the queue using the old reference
-		err = queuer.Enqueue(ctx, queue.TaskEnqueueRequest{
+		_, err = queuer.Enqueue(ctx, queue.TaskEnqueueRequest{
 			TaskBase: queue.TaskBase{
 				Queue: "test-queue",
 				Type:  queue.TaskType("test-task-type"),
@@ -151,7 +151,7 @@ func TestSetupTables(t *testing.T) {
 		require.Equal(t, "pq: column \"first_id\" of relation \"tasks\" does not exist", err.Error())
 		dbtest.EqualCount(t, db, 0, "tasks", nil)
 
-		err = queuer.Enqueue(ctx, queue.TaskEnqueueRequest{
+		_, err = queuer.Enqueue(ctx, queue.TaskEnqueueRequest{
 			TaskBase: queue.TaskBase{
 				Queue: "test-queue",
 				Type:  queue.TaskType("test-task-type"),
@@ -0,0 +1,185 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/contiamo/go-base/v4/pkg/config"
+	"github.com/contiamo/go-base/v4/pkg/queue"
+	"github.com/contiamo/go-base/v4/pkg/tracing"
+	"github.com/lib/pq"
+	uuid "github.com/satori/go.uuid"
+)
+
+const (
+	// taskStatusChannel is the notification channel with the IDs of the tasks
+	// that have been finished, failed or cancelled, see notifySetup
+	taskStatusChannel = "task_status"
+	// defaultWaitPollFrequency is used when the poll frequency is not configured
+	defaultWaitPollFrequency = time.Second
+)
+
+// NewWaiter creates a new postgres task waiter.
+//
+// The waiter is notified about the task status changes using the given listener,
+// the listener must not be shared with a dequeuer because they both consume
+// the listener notifications. If the listener is nil, the waiter relies on polling only.
+// The task status is polled with `cfg.PollFrequency` in case a notification is lost.
+func NewWaiter(db *sql.DB, dbListener *pq.Listener, cfg config.Queue) queue.Waiter {
+	if cfg.PollFrequency <= 0 {
+		cfg.PollFrequency = defaultWaitPollFrequency
+	}
+
+	return &waiter{
+		Tracer:      tracing.NewTracer("queue", "PostgresWaiter"),
+		inspector:   NewInspector(db),
+		listener:    dbListener,
+		cfg:         cfg,
+		subscribers: map[string]map[chan struct{}]struct{}{},
+	}
+}
+
+// waiter is a postgres-backed implementation of the queue Waiter
+type waiter struct {
+	tracing.Tracer
+	inspector queue.Inspector
+	listener  *pq.Listener
+	cfg       config.Queue
+
+	mu sync.Mutex
+	// subscribers contains the notification channels of the waiting calls per task ID
+	subscribers map[string]map[chan struct{}]struct{}
+	// stop stops the notification dispatching, it's nil when nobody is waiting
+	stop chan struct{}
+}
+
+func (w *waiter) WaitFor(ctx context.Context, taskID string) (task *queue.Task, err error) {
+	span, ctx := w.StartSpan(ctx, "WaitFor")
+	defer func() {
+		w.FinishSpan(span, err)
+	}()
+
+	span.SetTag("task.ID", taskID)
+
+	// the notifications contain the canonical text of the ID
+	id, err := uuid.FromString(taskID)
+	if err != nil {
+		return nil, queue.ErrTaskNotFound
+	}
+	taskID = id.String()
+
+	// subscribe before checking the task status, so the notification can't be missed
+	notifications, err := w.subscribe(taskID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		unsubscribeErr := w.unsubscribe(taskID, notifications)
+		if unsubscribeErr != nil && err == nil {
+			err = unsubscribeErr
+		}
+	}()
+
+	ticker := time.NewTicker(w.cfg.PollFrequency)
+	defer ticker.Stop()
+
+	for {
+		task, err = w.inspector.GetTask(ctx, taskID)
+		if err != nil {
+			return nil, err
+		}
+
+		if task.Status.IsFinal() {
+			span.SetTag("task.status", task.Status)
+			return task, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-notifications:
+		case <-ticker.C:
+		}
+	}
+}
+
+// subscribe registers a notification channel for the task,
+// the first subscriber starts listening to the task status notifications.
+func (w *waiter) subscribe(taskID string) (chan struct{}, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.stop == nil && w.listener != nil {
+		err := w.listener.Listen(taskStatusChannel)
+		if err != nil {
+			return nil, err
+		}
+		w.stop = make(chan struct{})
+		go w.dispatch(w.stop)
+	}
+
+	notifications := make(chan struct{}, 1)
+	if w.subscribers[taskID] == nil {
+		w.subscribers[taskID] = map[chan struct{}]struct{}{}
+	}
+	w.subscribers[taskID][notifications] = struct{}{}
+
+	return notifications, nil
+}
+
+// unsubscribe removes the notification channel of the task,
+// the last subscriber stops listening to the task status notifications.
+func (w *waiter) unsubscribe(taskID string, notifications chan struct{}) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	delete(w.subscribers[taskID], notifications)
+	if len(w.subscribers[taskID]) == 0 {
+		delete(w.subscribers, taskID)
+	}
+
+	if len(w.subscribers) > 0 || w.stop == nil {
+		return nil
+	}
+
+	close(w.stop)
+	w.stop = nil
+
+	return w.listener.Unlisten(taskStatusChannel)
+}
+
+// dispatch delivers the listener notifications to the subscribers until it's stopped
+func (w *waiter) dispatch(stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case n := <-w.listener.Notify:
+			w.notify(n)
+		}
+	}
+}
+
+// notify wakes up the subscribers of the task from the notification.
+// A nil notification is sent by the listener after it has reconnected,
+// the notifications could be lost, so all the subscribers are woken up.
+func (w *waiter) notify(n *pq.Notification) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for taskID, subscribers := range w.subscribers {
+		if n != nil && (n.Channel != taskStatusChannel || n.Extra != taskID) {
+			continue
+		}
+
+		for notifications := range subscribers {
+			// the subscriber has a pending notification already
+			select {
+			case notifications <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
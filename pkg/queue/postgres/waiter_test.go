@@ -0,0 +1,227 @@
+package postgres
+
+import (
+	"context"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/contiamo/go-base/v4/pkg/config"
+	dbtest "github.com/contiamo/go-base/v4/pkg/db/test"
+	"github.com/contiamo/go-base/v4/pkg/queue"
+	"github.com/lib/pq"
+	uuid "github.com/satori/go.uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWaiterNotify(t *testing.T) {
+	w := NewWaiter(nil, nil, config.Queue{}).(*waiter)
+
+	task1, err := w.subscribe("task1")
+	require.NoError(t, err)
+	task2, err := w.subscribe("task2")
+	require.NoError(t, err)
+
+	received := func(notifications chan struct{}) bool {
+		select {
+		case <-notifications:
+			return true
+		default:
+			return false
+		}
+	}
+
+	t.Run("wakes up the subscribers of the notified task only", func(t *testing.T) {
+		w.notify(&pq.Notification{Channel: taskStatusChannel, Extra: "task1"})
+		require.True(t, received(task1))
+		require.False(t, received(task2))
+	})
+
+	t.Run("ignores notifications from other channels", func(t *testing.T) {
+		w.notify(&pq.Notification{Channel: "task_update", Extra: "task1"})
+		require.False(t, received(task1))
+		require.False(t, received(task2))
+	})
+
+	t.Run("wakes up all the subscribers after reconnecting", func(t *testing.T) {
+		w.notify(nil)
+		require.True(t, received(task1))
+		require.True(t, received(task2))
+	})
+
+	t.Run("does not block on pending notifications", func(t *testing.T) {
+		w.notify(&pq.Notification{Channel: taskStatusChannel, Extra: "task2"})
+		w.notify(&pq.Notification{Channel: taskStatusChannel, Extra: "task2"})
+		require.True(t, received(task2))
+		require.False(t, received(task2))
+	})
+
+	t.Run("removes the subscribers", func(t *testing.T) {
+		require.NoError(t, w.unsubscribe("task1", task1))
+		require.NoError(t, w.unsubscribe("task2", task2))
+		require.Len(t, w.subscribers, 0)
+	})
+}
+
+func TestWaitFor(t *testing.T) {
+	verifyLeak(t)
+
+	logrus.SetOutput(io.Discard)
+	defer logrus.SetOutput(os.Stdout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	name, db := dbtest.GetDatabase(t)
+	defer db.Close()
+	require.NoError(t, SetupTables(ctx, db, nil))
+
+	connStr := "user=contiamo_test password=localdev sslmode=disable dbname=" + name
+	dbListener := pq.NewListener(
+		connStr,
+		10*time.Second,
+		time.Minute,
+		func(ev pq.ListenerEventType, err error) {
+			if err != nil {
+				logrus.Error(err)
+			}
+		},
+	)
+	defer dbListener.Close()
+
+	// the poll frequency is long enough to make sure the waiter relies on notifications
+	cfg := config.Queue{
+		HeartbeatTTL:  10 * time.Second,
+		PollFrequency: time.Minute,
+	}
+	w := NewWaiter(db, dbListener, cfg)
+	d := NewDequeuer(db, nil, cfg)
+	q := NewQueuer(db)
+
+	enqueue := func(t *testing.T) string {
+		taskID, err := q.Enqueue(ctx, queue.TaskEnqueueRequest{
+			TaskBase: queue.TaskBase{
+				Queue: queueID1,
+				Type:  "test",
+				Spec:  spec,
+			},
+		})
+		require.NoError(t, err)
+		return taskID
+	}
+
+	t.Run("returns the finished task", func(t *testing.T) {
+		taskID := enqueue(t)
+
+		done := make(chan *queue.Task)
+		go func() {
+			task, err := w.WaitFor(ctx, taskID)
+			if err != nil {
+				logrus.Error(err)
+			}
+			done <- task
+		}()
+
+		task, err := d.(*dequeuer).attemptDequeue(ctx, queueID1)
+		require.NoError(t, err)
+		require.Equal(t, taskID, task.ID)
+
+		require.NoError(t, d.Finish(ctx, taskID, progress))
+
+		select {
+		case task := <-done:
+			require.NotNil(t, task)
+			require.Equal(t, queue.Finished, task.Status)
+			require.Equal(t, queue.Progress(progress), task.Progress)
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "the waiter was not notified")
+		}
+	})
+
+	t.Run("returns the cancelled task", func(t *testing.T) {
+		taskID := enqueue(t)
+
+		done := make(chan *queue.Task)
+		go func() {
+			task, err := w.WaitFor(ctx, taskID)
+			if err != nil {
+				logrus.Error(err)
+			}
+			done <- task
+		}()
+
+		time.Sleep(100 * time.Millisecond)
+		require.NoError(t, NewManager(db).Cancel(ctx, taskID))
+
+		select {
+		case task := <-done:
+			require.NotNil(t, task)
+			require.Equal(t, queue.Cancelled, task.Status)
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "the waiter was not notified")
+		}
+	})
+
+	t.Run("is notified about the task with a non-canonical ID", func(t *testing.T) {
+		taskID := enqueue(t)
+
+		done := make(chan *queue.Task)
+		go func() {
+			task, err := w.WaitFor(ctx, strings.ToUpper(taskID))
+			if err != nil {
+				logrus.Error(err)
+			}
+			done <- task
+		}()
+
+		time.Sleep(100 * time.Millisecond)
+		require.NoError(t, NewManager(db).Cancel(ctx, taskID))
+
+		select {
+		case task := <-done:
+			require.NotNil(t, task)
+			require.Equal(t, taskID, task.ID)
+			require.Equal(t, queue.Cancelled, task.Status)
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "the waiter was not notified")
+		}
+	})
+
+	t.Run("returns the task right away if it has already failed", func(t *testing.T) {
+		taskID := uuid.NewV4().String()
+		_, err := squirrel.StatementBuilder.
+			PlaceholderFormat(squirrel.Dollar).
+			RunWith(db).
+			Insert("tasks").
+			Columns("task_id", "queue", "type", "spec", "progress", "status").
+			Values(taskID, queueID2, "test", emptyJSON, emptyJSON, queue.Failed).
+			ExecContext(ctx)
+		require.NoError(t, err)
+
+		task, err := w.WaitFor(ctx, taskID)
+		require.NoError(t, err)
+		require.Equal(t, queue.Failed, task.Status)
+	})
+
+	t.Run("returns an error when the task does not exist", func(t *testing.T) {
+		_, err := w.WaitFor(ctx, uuid.NewV4().String())
+		require.Equal(t, queue.ErrTaskNotFound, err)
+
+		_, err = w.WaitFor(ctx, "malformed")
+		require.Equal(t, queue.ErrTaskNotFound, err)
+	})
+
+	t.Run("returns the context error when the waiting time is over", func(t *testing.T) {
+		taskID := enqueue(t)
+
+		ctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+		defer cancel()
+
+		_, err := w.WaitFor(ctx, taskID)
+		require.Equal(t, context.DeadlineExceeded, err)
+	})
+}
@@ -0,0 +1,214 @@
+package postgres
+
+import (
+	"context"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/contiamo/go-base/v4/pkg/config"
+	dbtest "github.com/contiamo/go-base/v4/pkg/db/test"
+	"github.com/contiamo/go-base/v4/pkg/http/parameters"
+	"github.com/contiamo/go-base/v4/pkg/queue"
+	uuid "github.com/satori/go.uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTaskDependencies(t *testing.T) {
+	verifyLeak(t)
+
+	logrus.SetOutput(io.Discard)
+	defer logrus.SetOutput(os.Stdout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, db := dbtest.GetDatabase(t)
+	defer db.Close()
+	require.NoError(t, SetupTables(ctx, db, nil))
+
+	q := NewQueuer(db)
+	d := NewDequeuer(db, nil, config.Queue{HeartbeatTTL: time.Minute}).(*dequeuer)
+	i := NewInspector(db)
+
+	enqueue := func(t *testing.T, workflowID string, dependsOn ...string) string {
+		taskID, err := q.Enqueue(ctx, queue.TaskEnqueueRequest{
+			TaskBase: queue.TaskBase{
+				Queue: queueID1,
+				Type:  "test",
+				Spec:  spec,
+			},
+			DependsOn:  dependsOn,
+			WorkflowID: workflowID,
+		})
+		require.NoError(t, err)
+		return taskID
+	}
+
+	status := func(t *testing.T, taskID string) queue.TaskStatus {
+		task, err := i.GetTask(ctx, taskID)
+		require.NoError(t, err)
+		return task.Status
+	}
+
+	// dequeue takes the next available task and makes sure it's the expected one
+	dequeue := func(t *testing.T, expTaskID string) {
+		task, err := d.attemptDequeue(ctx, queueID1)
+		require.NoError(t, err)
+		if expTaskID == "" {
+			require.Nil(t, task)
+			return
+		}
+		require.NotNil(t, task)
+		require.Equal(t, expTaskID, task.ID)
+	}
+
+	reset := func(t *testing.T) {
+		_, err := db.ExecContext(ctx, "DELETE FROM tasks;")
+		require.NoError(t, err)
+	}
+
+	t.Run("a task is blocked until all the tasks it depends on are finished", func(t *testing.T) {
+		reset(t)
+
+		parent1 := enqueue(t, "")
+		parent2 := enqueue(t, "")
+		child := enqueue(t, "", parent1, parent2)
+		require.Equal(t, queue.Blocked, status(t, child))
+
+		dequeue(t, parent1)
+		require.NoError(t, d.Finish(ctx, parent1, progress))
+		require.Equal(t, queue.Blocked, status(t, child))
+
+		dequeue(t, parent2)
+		dequeue(t, "")
+		require.NoError(t, d.Finish(ctx, parent2, progress))
+		require.Equal(t, queue.Waiting, status(t, child))
+
+		dequeue(t, child)
+	})
+
+	t.Run("a task is not blocked when the tasks it depends on are finished already", func(t *testing.T) {
+		reset(t)
+
+		parent := enqueue(t, "")
+		dequeue(t, parent)
+		require.NoError(t, d.Finish(ctx, parent, progress))
+
+		child := enqueue(t, "", parent)
+		require.Equal(t, queue.Waiting, status(t, child))
+	})
+
+	t.Run("the dependants of a failed task are cancelled", func(t *testing.T) {
+		reset(t)
+
+		parent := enqueue(t, "")
+		child := enqueue(t, "", parent)
+		grandchild := enqueue(t, "", child)
+
+		dequeue(t, parent)
+		require.NoError(t, d.Fail(ctx, parent, progress))
+
+		require.Equal(t, queue.Cancelled, status(t, child))
+		require.Equal(t, queue.Cancelled, status(t, grandchild))
+
+		task, err := i.GetTask(ctx, grandchild)
+		require.NoError(t, err)
+		require.NotNil(t, task.FinishedAt)
+		require.Equal(t, "dependency "+child+" is cancelled", task.LastError)
+
+		dequeue(t, "")
+	})
+
+	t.Run("the dependants of a cancelled task are cancelled", func(t *testing.T) {
+		reset(t)
+
+		parent := enqueue(t, "")
+		child := enqueue(t, "", parent)
+
+		require.NoError(t, NewManager(db).Cancel(ctx, parent))
+		require.Equal(t, queue.Cancelled, status(t, child))
+	})
+
+	t.Run("a task depending on a cancelled task is cancelled right away", func(t *testing.T) {
+		reset(t)
+
+		parent := enqueue(t, "")
+		require.NoError(t, NewManager(db).Cancel(ctx, parent))
+
+		child := enqueue(t, "", parent)
+		require.Equal(t, queue.Cancelled, status(t, child))
+	})
+
+	t.Run("a retried task is blocked until the tasks it depends on are finished", func(t *testing.T) {
+		reset(t)
+
+		parent := enqueue(t, "")
+		child := enqueue(t, "", parent)
+		require.NoError(t, NewManager(db).Cancel(ctx, parent))
+		require.Equal(t, queue.Cancelled, status(t, child))
+
+		require.NoError(t, NewManager(db).Retry(ctx, child))
+		require.Equal(t, queue.Blocked, status(t, child))
+
+		require.NoError(t, NewManager(db).Retry(ctx, parent))
+		dequeue(t, parent)
+		require.NoError(t, d.Finish(ctx, parent, progress))
+		require.Equal(t, queue.Waiting, status(t, child))
+	})
+
+	t.Run("returns an error when a dependency does not exist", func(t *testing.T) {
+		reset(t)
+
+		for _, dependency := range []string{uuid.NewV4().String(), "invalid"} {
+			_, err := q.Enqueue(ctx, queue.TaskEnqueueRequest{
+				TaskBase:  queue.TaskBase{Queue: queueID1, Type: "test"},
+				DependsOn: []string{dependency},
+			})
+			require.Equal(t, queue.ErrTaskDependencyNotFound, err)
+		}
+		dbtest.EqualCount(t, db, 0, "tasks", nil)
+	})
+
+	t.Run("gets the workflow tasks and status", func(t *testing.T) {
+		reset(t)
+
+		workflowID := uuid.NewV4().String()
+		step1 := enqueue(t, workflowID)
+		step2 := enqueue(t, workflowID, step1)
+		enqueue(t, "")
+
+		workflow, err := i.GetWorkflow(ctx, workflowID)
+		require.NoError(t, err)
+		require.Equal(t, queue.Waiting, workflow.Status)
+		require.Len(t, workflow.Tasks, 2)
+		require.Equal(t, step1, workflow.Tasks[0].ID)
+		require.Equal(t, step2, workflow.Tasks[1].ID)
+		require.Equal(t, []string{step1}, workflow.Tasks[1].DependsOn)
+		require.Equal(t, workflowID, workflow.Tasks[1].WorkflowID)
+
+		dequeue(t, step1)
+		workflow, err = i.GetWorkflow(ctx, workflowID)
+		require.NoError(t, err)
+		require.Equal(t, queue.Running, workflow.Status)
+
+		require.NoError(t, d.Finish(ctx, step1, progress))
+		dequeue(t, step2)
+		require.NoError(t, d.Finish(ctx, step2, progress))
+
+		workflow, err = i.GetWorkflow(ctx, workflowID)
+		require.NoError(t, err)
+		require.Equal(t, queue.Finished, workflow.Status)
+
+		list, err := i.ListTasks(ctx, queue.TaskFilter{WorkflowID: workflowID}, parameters.Page{})
+		require.NoError(t, err)
+		require.Len(t, list.Items, 2)
+	})
+
+	t.Run("returns an error when the workflow does not exist", func(t *testing.T) {
+		_, err := i.GetWorkflow(ctx, uuid.NewV4().String())
+		require.Equal(t, queue.ErrWorkflowNotFound, err)
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 
+	cdb "github.com/contiamo/go-base/v4/pkg/db"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -22,18 +23,37 @@ var (
 	ErrTaskNotFound = errors.New("task not found")
 	// ErrTaskFailed indicates that a task has failed and should not be restarted
 	ErrTaskFailed = errors.New("task failed")
+	// ErrTaskNotRetryable indicates that the task can not be retried because
+	// it has not failed or has not been cancelled
+	ErrTaskNotRetryable = errors.New("only failed or cancelled tasks can be retried")
+	// ErrDuplicateIdempotencyKey indicates that the task can not be retried because
+	// another unfinished task in the queue has the same idempotency key
+	ErrDuplicateIdempotencyKey = errors.New("another unfinished task has the same idempotency key")
 	// ErrTaskQueueNotSpecified indicates that the given task cannot be enqueued because
 	// the queue name is empty
 	ErrTaskQueueNotSpecified = errors.New("task queue name cannot be blank")
 	// ErrTaskTypeNotSpecified indicates that the given task cannot be enqueued because
 	// the task type is empty
 	ErrTaskTypeNotSpecified = errors.New("task type cannot be blank")
+	// ErrTaskDependencyNotFound indicates that the given task cannot be enqueued because
+	// one of the tasks it depends on does not exist
+	ErrTaskDependencyNotFound = errors.New("task dependency not found")
 )
 
 // Queuer is a write-only interface for the queue
 type Queuer interface {
 	// Enqueue adds a task to the provided queue within the Task object
-	Enqueue(ctx context.Context, task TaskEnqueueRequest) error
+	// and returns the ID of the new task
+	Enqueue(ctx context.Context, task TaskEnqueueRequest) (string, error)
+
+	// EnqueueTx is the same as Enqueue but the task is added using the given builder.
+	// If the builder belongs to a transaction, the task is added only when
+	// the transaction is committed, e.g. together with the entity the task processes.
+	EnqueueTx(ctx context.Context, builder cdb.SQLBuilder, task TaskEnqueueRequest) (string, error)
+
+	// EnqueueBatch adds all the tasks at once and returns their IDs in the same order.
+	// The tasks are validated as a whole, either all of them are added or none.
+	EnqueueBatch(ctx context.Context, tasks []TaskEnqueueRequest) ([]string, error)
 }
 
 // Dequeuer is a read only interface for the queue
@@ -62,6 +82,39 @@ type Dequeuer interface {
 	Fail(ctx context.Context, taskID string, progress Progress) error
 }
 
+// Retrier is implemented by dequeuers that support automatic retries of failed tasks
+type Retrier interface {
+	// FailAttempt records a failed attempt to process the task.
+	//
+	// If `taskErr` is not permanent (see IsPermanent) and the retry policy of the task
+	// allows another attempt, the task is put back into the waiting state and becomes
+	// available again after the backoff delay. Otherwise, the task is marked as failed
+	// the same way as `Fail` does it.
+	FailAttempt(ctx context.Context, taskID string, progress Progress, taskErr error) error
+}
+
+// Waiter waits for the tasks to be processed
+type Waiter interface {
+	// WaitFor blocks until the task is finished, failed or cancelled and returns
+	// the final state of the task, see TaskStatus.IsFinal.
+	// ErrTaskNotFound is returned if the task does not exist.
+	// Use the context deadline to limit the waiting time.
+	WaitFor(ctx context.Context, taskID string) (*Task, error)
+}
+
+// ResultWriter is implemented by dequeuers that store the task result and the structured
+// task error separately from the task progress.
+//
+// Dequeuers implementing both ResultWriter and Retrier must store the error
+// passed to FailAttempt the same way as FailWithError does.
+type ResultWriter interface {
+	// FinishWithResult marks the task as completed and stores its result
+	FinishWithResult(ctx context.Context, taskID string, progress Progress, result Result) error
+
+	// FailWithError marks the task as failed and stores the error it failed with
+	FailWithError(ctx context.Context, taskID string, progress Progress, taskErr *TaskError) error
+}
+
 type queuerWithMetrics struct {
 	q Queuer
 }
@@ -71,7 +124,7 @@ func QueuerWithMetrics(q Queuer) Queuer {
 	return &queuerWithMetrics{q}
 }
 
-func (q *queuerWithMetrics) Enqueue(ctx context.Context, task TaskEnqueueRequest) error {
+func (q *queuerWithMetrics) Enqueue(ctx context.Context, task TaskEnqueueRequest) (string, error) {
 	TaskQueueMetrics.TaskCounter.With(prometheus.Labels{"queue": task.Queue, "type": task.Type.String()}).Inc()
 	timer := prometheus.NewTimer(TaskQueueMetrics.EnqueueDuration.With(prometheus.Labels{"queue": task.Queue}))
 	defer timer.ObserveDuration()
@@ -79,6 +132,32 @@ func (q *queuerWithMetrics) Enqueue(ctx context.Context, task TaskEnqueueRequest
 	return q.q.Enqueue(ctx, task)
 }
 
+func (q *queuerWithMetrics) EnqueueTx(ctx context.Context, builder cdb.SQLBuilder, task TaskEnqueueRequest) (string, error) {
+	TaskQueueMetrics.TaskCounter.With(prometheus.Labels{"queue": task.Queue, "type": task.Type.String()}).Inc()
+	timer := prometheus.NewTimer(TaskQueueMetrics.EnqueueDuration.With(prometheus.Labels{"queue": task.Queue}))
+	defer timer.ObserveDuration()
+
+	return q.q.EnqueueTx(ctx, builder, task)
+}
+
+func (q *queuerWithMetrics) EnqueueBatch(ctx context.Context, tasks []TaskEnqueueRequest) ([]string, error) {
+	// the batch is enqueued at once, so its duration is observed once per queue
+	timers := map[string]*prometheus.Timer{}
+	for _, task := range tasks {
+		TaskQueueMetrics.TaskCounter.With(prometheus.Labels{"queue": task.Queue, "type": task.Type.String()}).Inc()
+		if timers[task.Queue] == nil {
+			timers[task.Queue] = prometheus.NewTimer(TaskQueueMetrics.EnqueueDuration.With(prometheus.Labels{"queue": task.Queue}))
+		}
+	}
+	defer func() {
+		for _, timer := range timers {
+			timer.ObserveDuration()
+		}
+	}()
+
+	return q.q.EnqueueBatch(ctx, tasks)
+}
+
 type dequeuerWithMetrics struct {
 	q Dequeuer
 }
@@ -103,3 +182,33 @@ func (q *dequeuerWithMetrics) Finish(ctx context.Context, taskID string, progres
 func (q *dequeuerWithMetrics) Fail(ctx context.Context, taskID string, progress Progress) error {
 	return q.q.Fail(ctx, taskID, progress)
 }
+
+// FailAttempt implements Retrier, it falls back to `FailWithError` when
+// the wrapped dequeuer does not support retries
+func (q *dequeuerWithMetrics) FailAttempt(ctx context.Context, taskID string, progress Progress, taskErr error) error {
+	retrier, ok := q.q.(Retrier)
+	if !ok {
+		return q.FailWithError(ctx, taskID, progress, NewTaskError(taskErr))
+	}
+	return retrier.FailAttempt(ctx, taskID, progress, taskErr)
+}
+
+// FinishWithResult implements ResultWriter, it falls back to `Finish` when
+// the wrapped dequeuer does not store results
+func (q *dequeuerWithMetrics) FinishWithResult(ctx context.Context, taskID string, progress Progress, result Result) error {
+	writer, ok := q.q.(ResultWriter)
+	if !ok {
+		return q.q.Finish(ctx, taskID, progress)
+	}
+	return writer.FinishWithResult(ctx, taskID, progress, result)
+}
+
+// FailWithError implements ResultWriter, it falls back to `Fail` when
+// the wrapped dequeuer does not store errors
+func (q *dequeuerWithMetrics) FailWithError(ctx context.Context, taskID string, progress Progress, taskErr *TaskError) error {
+	writer, ok := q.q.(ResultWriter)
+	if !ok {
+		return q.q.Fail(ctx, taskID, progress)
+	}
+	return writer.FailWithError(ctx, taskID, progress, taskErr)
+}
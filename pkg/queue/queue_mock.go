@@ -2,6 +2,9 @@ package queue
 
 import (
 	"context"
+
+	cdb "github.com/contiamo/go-base/v4/pkg/db"
+	uuid "github.com/satori/go.uuid"
 )
 
 // QueueMock implements queue with very simple counters for testing
@@ -22,10 +25,36 @@ type QueueMock struct {
 }
 
 // Enqueue implements queue Manager for testing
-func (q *QueueMock) Enqueue(ctx context.Context, task TaskEnqueueRequest) error {
+func (q *QueueMock) Enqueue(ctx context.Context, task TaskEnqueueRequest) (string, error) {
 	q.EnqueueCount = q.EnqueueCount + 1
-	q.Queue <- Task{TaskBase: task.TaskBase}
-	return q.EnqueueErr
+	if q.EnqueueErr != nil {
+		return "", q.EnqueueErr
+	}
+
+	taskID := uuid.NewV4().String()
+	q.Queue <- Task{TaskBase: task.TaskBase, ID: taskID}
+	return taskID, nil
+}
+
+// EnqueueTx implements queue Manager for testing
+func (q *QueueMock) EnqueueTx(ctx context.Context, builder cdb.SQLBuilder, task TaskEnqueueRequest) (string, error) {
+	return q.Enqueue(ctx, task)
+}
+
+// EnqueueBatch implements queue Manager for testing
+func (q *QueueMock) EnqueueBatch(ctx context.Context, tasks []TaskEnqueueRequest) ([]string, error) {
+	q.EnqueueCount = q.EnqueueCount + len(tasks)
+	if q.EnqueueErr != nil {
+		return nil, q.EnqueueErr
+	}
+
+	taskIDs := make([]string, 0, len(tasks))
+	for _, task := range tasks {
+		taskID := uuid.NewV4().String()
+		q.Queue <- Task{TaskBase: task.TaskBase, ID: taskID}
+		taskIDs = append(taskIDs, taskID)
+	}
+	return taskIDs, nil
 }
 
 // Dequeue implements queue Manager for testing
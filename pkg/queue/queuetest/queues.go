@@ -0,0 +1,206 @@
+package queuetest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/contiamo/go-base/v4/pkg/queue"
+	"github.com/stretchr/testify/require"
+)
+
+func testQueueConcurrency(t *testing.T, ctx context.Context, b Backend) {
+	requireConcurrencyLimiter(t, b)
+
+	limit, err := b.ConcurrencyLimiter.GetQueueConcurrency(ctx, "queue1")
+	require.NoError(t, err)
+	require.Equal(t, queue.DefaultQueueConcurrency, limit)
+
+	require.Equal(t, queue.ErrTaskQueueNotSpecified, b.ConcurrencyLimiter.SetQueueConcurrency(ctx, "", 1))
+	require.Equal(t, queue.ErrInvalidConcurrency, b.ConcurrencyLimiter.SetQueueConcurrency(ctx, "queue1", -1))
+
+	require.NoError(t, b.ConcurrencyLimiter.SetQueueConcurrency(ctx, "queue1", 2))
+	limit, err = b.ConcurrencyLimiter.GetQueueConcurrency(ctx, "queue1")
+	require.NoError(t, err)
+	require.Equal(t, 2, limit)
+
+	for i := 0; i < 3; i++ {
+		enqueue(t, ctx, b, queue.TaskEnqueueRequest{
+			TaskBase: queue.TaskBase{Queue: "queue1", Type: "test"},
+		})
+	}
+
+	first := dequeue(t, ctx, b, "queue1")
+	dequeue(t, ctx, b, "queue1")
+	// only two tasks of the queue can run at the same time
+	requireEmpty(t, ctx, b, "queue1")
+
+	require.NoError(t, b.Dequeuer.Finish(ctx, first.ID, progress))
+	dequeue(t, ctx, b, "queue1")
+
+	// the queue names are case-insensitive
+	require.NoError(t, b.ConcurrencyLimiter.SetQueueConcurrency(ctx, "QUEUE1", queue.UnlimitedQueueConcurrency))
+	enqueue(t, ctx, b, queue.TaskEnqueueRequest{
+		TaskBase: queue.TaskBase{Queue: "queue1", Type: "test"},
+	})
+	dequeue(t, ctx, b, "queue1")
+}
+
+func testPause(t *testing.T, ctx context.Context, b Backend) {
+	requirePauser(t, b)
+
+	require.Equal(t, queue.ErrTaskQueueNotSpecified, b.Pauser.PauseQueue(ctx, ""))
+	require.Equal(t, queue.ErrTaskQueueNotSpecified, b.Pauser.ResumeQueue(ctx, ""))
+	require.Equal(t, queue.ErrTaskTypeNotSpecified, b.Pauser.PauseTaskType(ctx, ""))
+	require.Equal(t, queue.ErrTaskTypeNotSpecified, b.Pauser.ResumeTaskType(ctx, ""))
+
+	running := enqueue(t, ctx, b, queue.TaskEnqueueRequest{
+		TaskBase: queue.TaskBase{Queue: "queue1", Type: "test"},
+	})
+	dequeue(t, ctx, b, "queue1")
+	waiting := enqueue(t, ctx, b, queue.TaskEnqueueRequest{
+		TaskBase: queue.TaskBase{Queue: "queue2", Type: "test"},
+	})
+	other := enqueue(t, ctx, b, queue.TaskEnqueueRequest{
+		TaskBase: queue.TaskBase{Queue: "queue3", Type: "test"},
+	})
+
+	paused, err := b.Pauser.IsQueuePaused(ctx, "queue2")
+	require.NoError(t, err)
+	require.False(t, paused)
+
+	require.NoError(t, b.Pauser.PauseQueue(ctx, "queue1"))
+	require.NoError(t, b.Pauser.PauseQueue(ctx, "queue2"))
+	// pausing twice has no effect
+	require.NoError(t, b.Pauser.PauseQueue(ctx, "queue2"))
+	paused, err = b.Pauser.IsQueuePaused(ctx, "queue2")
+	require.NoError(t, err)
+	require.True(t, paused)
+
+	// the running task can be finished
+	require.NoError(t, b.Dequeuer.Finish(ctx, running, progress))
+
+	// other queues are not paused
+	task := dequeue(t, ctx, b, "queue2", "queue3")
+	require.Equal(t, other, task.ID)
+	requireEmpty(t, ctx, b, "queue2")
+
+	require.NoError(t, b.Pauser.ResumeQueue(ctx, "queue2"))
+	paused, err = b.Pauser.IsQueuePaused(ctx, "queue2")
+	require.NoError(t, err)
+	require.False(t, paused)
+	task = dequeue(t, ctx, b, "queue2")
+	require.Equal(t, waiting, task.ID)
+
+	// the task type is paused in all the queues
+	pausedType := enqueue(t, ctx, b, queue.TaskEnqueueRequest{
+		TaskBase: queue.TaskBase{Queue: "queue4", Type: "paused"},
+	})
+	otherType := enqueue(t, ctx, b, queue.TaskEnqueueRequest{
+		TaskBase: queue.TaskBase{Queue: "queue5", Type: "test"},
+	})
+
+	require.NoError(t, b.Pauser.PauseTaskType(ctx, "paused"))
+	paused, err = b.Pauser.IsTaskTypePaused(ctx, "paused")
+	require.NoError(t, err)
+	require.True(t, paused)
+
+	task = dequeue(t, ctx, b, "queue4", "queue5")
+	require.Equal(t, otherType, task.ID)
+	requireEmpty(t, ctx, b, "queue4")
+
+	require.NoError(t, b.Pauser.ResumeTaskType(ctx, "paused"))
+	paused, err = b.Pauser.IsTaskTypePaused(ctx, "paused")
+	require.NoError(t, err)
+	require.False(t, paused)
+	task = dequeue(t, ctx, b, "queue4")
+	require.Equal(t, pausedType, task.ID)
+}
+
+func testRateLimit(t *testing.T, ctx context.Context, b Backend) {
+	requireRateLimiter(t, b)
+
+	require.Equal(t, queue.ErrTaskQueueNotSpecified, b.RateLimiter.SetQueueRateLimit(ctx, "", nil))
+	require.Equal(t, queue.ErrTaskTypeNotSpecified, b.RateLimiter.SetTaskTypeRateLimit(ctx, "", nil))
+	require.ErrorIs(t, b.RateLimiter.SetQueueRateLimit(ctx, "queue1", &queue.RateLimit{Rate: 0, Burst: 1}), queue.ErrInvalidRateLimit)
+	require.ErrorIs(t, b.RateLimiter.SetTaskTypeRateLimit(ctx, "test", &queue.RateLimit{Rate: 1, Burst: 0}), queue.ErrInvalidRateLimit)
+
+	limit, err := b.RateLimiter.GetQueueRateLimit(ctx, "queue1")
+	require.NoError(t, err)
+	require.Nil(t, limit)
+
+	// one token per second and a burst of two tasks
+	require.NoError(t, b.RateLimiter.SetQueueRateLimit(ctx, "queue1", &queue.RateLimit{Rate: 1, Burst: 2}))
+	limit, err = b.RateLimiter.GetQueueRateLimit(ctx, "QUEUE1")
+	require.NoError(t, err)
+	require.Equal(t, &queue.RateLimit{Rate: 1, Burst: 2}, limit)
+
+	for i := 0; i < 3; i++ {
+		enqueue(t, ctx, b, queue.TaskEnqueueRequest{
+			TaskBase: queue.TaskBase{Queue: "queue1", Type: "test"},
+		})
+	}
+	other := enqueue(t, ctx, b, queue.TaskEnqueueRequest{
+		TaskBase: queue.TaskBase{Queue: "queue2", Type: "test"},
+	})
+
+	for i := 0; i < 2; i++ {
+		task := dequeue(t, ctx, b, "queue1")
+		require.NoError(t, b.Dequeuer.Finish(ctx, task.ID, progress))
+	}
+	requireEmpty(t, ctx, b, "queue1")
+
+	// other queues are not limited
+	task := dequeue(t, ctx, b, "queue2")
+	require.Equal(t, other, task.ID)
+
+	// the bucket is refilled
+	dequeue(t, ctx, b, "queue1")
+
+	require.NoError(t, b.RateLimiter.SetQueueRateLimit(ctx, "queue1", nil))
+	limit, err = b.RateLimiter.GetQueueRateLimit(ctx, "queue1")
+	require.NoError(t, err)
+	require.Nil(t, limit)
+
+	// the task type is limited in all the queues
+	require.NoError(t, b.RateLimiter.SetTaskTypeRateLimit(ctx, "limited", &queue.RateLimit{Rate: 1, Burst: 1}))
+	limit, err = b.RateLimiter.GetTaskTypeRateLimit(ctx, "limited")
+	require.NoError(t, err)
+	require.Equal(t, &queue.RateLimit{Rate: 1, Burst: 1}, limit)
+
+	for _, queueName := range []string{"queue3", "queue4"} {
+		enqueue(t, ctx, b, queue.TaskEnqueueRequest{
+			TaskBase: queue.TaskBase{Queue: queueName, Type: "limited"},
+		})
+	}
+	otherType := enqueue(t, ctx, b, queue.TaskEnqueueRequest{
+		TaskBase: queue.TaskBase{Queue: "queue5", Type: "test"},
+	})
+
+	dequeue(t, ctx, b, "queue3", "queue4")
+	requireEmpty(t, ctx, b, "queue3", "queue4")
+
+	// other task types are not limited
+	task = dequeue(t, ctx, b, "queue5")
+	require.Equal(t, otherType, task.ID)
+
+	// the bucket is refilled
+	dequeue(t, ctx, b, "queue3", "queue4")
+}
+
+func requireConcurrencyLimiter(t *testing.T, b Backend) {
+	if b.ConcurrencyLimiter == nil {
+		t.Skip("the backend has no concurrency limiter")
+	}
+}
+
+func requirePauser(t *testing.T, b Backend) {
+	if b.Pauser == nil {
+		t.Skip("the backend has no pauser")
+	}
+}
+
+func requireRateLimiter(t *testing.T, b Backend) {
+	if b.RateLimiter == nil {
+		t.Skip("the backend has no rate limiter")
+	}
+}
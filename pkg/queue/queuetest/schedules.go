@@ -0,0 +1,500 @@
+package queuetest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/contiamo/go-base/v4/pkg/http/parameters"
+	"github.com/contiamo/go-base/v4/pkg/queue"
+	"github.com/stretchr/testify/require"
+)
+
+func testScheduleOnce(t *testing.T, ctx context.Context, b Backend) {
+	requireScheduler(t, b)
+
+	require.NoError(t, b.Scheduler.Schedule(ctx, b.SQLBuilder, queue.TaskScheduleRequest{
+		TaskBase: queue.TaskBase{Queue: "queue1", Type: "once", Spec: spec, Priority: 3},
+	}))
+	startScheduleWorker(t, ctx, b)
+
+	task := dequeue(t, ctx, b, "queue1")
+	require.Equal(t, queue.TaskType("once"), task.Type)
+	require.JSONEq(t, string(spec), string(task.Spec))
+	require.Equal(t, 3, task.Priority)
+	require.NoError(t, b.Dequeuer.Finish(ctx, task.ID, progress))
+
+	// the one-time schedule does not enqueue the task again
+	requireEmpty(t, ctx, b, "queue1")
+}
+
+func testScheduleCron(t *testing.T, ctx context.Context, b Backend) {
+	requireScheduler(t, b)
+
+	require.NoError(t, b.Scheduler.Schedule(ctx, b.SQLBuilder, queue.TaskScheduleRequest{
+		TaskBase:     queue.TaskBase{Queue: "queue1", Type: "cron", Spec: spec},
+		CronSchedule: "@every 1h",
+	}))
+	startScheduleWorker(t, ctx, b)
+
+	// the new schedule enqueues the task immediately
+	task := dequeue(t, ctx, b, "queue1")
+	require.Equal(t, queue.TaskType("cron"), task.Type)
+	require.NoError(t, b.Dequeuer.Finish(ctx, task.ID, progress))
+
+	// the next task is enqueued in an hour
+	requireEmpty(t, ctx, b, "queue1")
+
+	if b.ScheduleManager == nil {
+		return
+	}
+
+	schedule := getSchedule(t, ctx, b, "cron")
+	require.NotNil(t, schedule.NextExecutionTime)
+	require.True(t, schedule.NextExecutionTime.After(time.Now().Add(50*time.Minute)))
+	require.False(t, schedule.NextExecutionTime.After(time.Now().Add(time.Hour)))
+
+	tasks, err := b.Inspector.ListTasks(ctx, queue.TaskFilter{
+		References: queue.References{"schedule_id": schedule.ID},
+	}, parameters.Page{})
+	require.NoError(t, err)
+	require.Len(t, tasks.Items, 1)
+	require.Equal(t, task.ID, tasks.Items[0].ID)
+}
+
+func testScheduleTimezone(t *testing.T, ctx context.Context, b Backend) {
+	requireScheduleManager(t, b)
+
+	err := b.Scheduler.Schedule(ctx, b.SQLBuilder, queue.TaskScheduleRequest{
+		TaskBase:     queue.TaskBase{Queue: "queue1", Type: "invalid", Spec: spec},
+		CronSchedule: "0 8 * * *",
+		Timezone:     "Mars/Olympus",
+	})
+	require.True(t, errors.Is(err, queue.ErrInvalidTimezone))
+
+	require.NoError(t, b.Scheduler.Schedule(ctx, b.SQLBuilder, queue.TaskScheduleRequest{
+		TaskBase:     queue.TaskBase{Queue: "queue1", Type: "zoned", Spec: spec},
+		CronSchedule: "0 0 8 * * *",
+		Timezone:     "Asia/Kathmandu",
+	}))
+	startScheduleWorker(t, ctx, b)
+
+	dequeue(t, ctx, b, "queue1")
+
+	// the next execution time is updated after the task is enqueued
+	var schedule queue.Schedule
+	require.Eventually(t, func() bool {
+		schedule = getSchedule(t, ctx, b, "zoned")
+		return schedule.NextExecutionTime != nil && schedule.NextExecutionTime.After(time.Now())
+	}, 5*time.Second, 50*time.Millisecond)
+	require.Equal(t, "Asia/Kathmandu", schedule.Timezone)
+
+	kathmandu, err := time.LoadLocation("Asia/Kathmandu")
+	require.NoError(t, err)
+	next := schedule.NextExecutionTime.In(kathmandu)
+	require.Equal(t, 8, next.Hour())
+	require.Equal(t, 0, next.Minute())
+	require.True(t, next.Before(time.Now().Add(24*time.Hour)))
+}
+
+func testScheduleInterval(t *testing.T, ctx context.Context, b Backend) {
+	requireScheduleManager(t, b)
+
+	for _, invalid := range []queue.TaskScheduleRequest{
+		{CronSchedule: "@every 1h", Interval: time.Hour},
+		{Interval: -time.Hour},
+		{Interval: time.Millisecond},
+		{Interval: time.Hour, MaxExecutions: -1},
+		{Interval: time.Hour, ValidFrom: time.Now(), ValidUntil: time.Now().Add(-time.Hour)},
+	} {
+		invalid.TaskBase = queue.TaskBase{Queue: "queue1", Type: "invalid", Spec: spec}
+		err := b.Scheduler.Schedule(ctx, b.SQLBuilder, invalid)
+		require.True(t, errors.Is(err, queue.ErrInvalidSchedule), err)
+	}
+
+	require.NoError(t, b.Scheduler.Schedule(ctx, b.SQLBuilder, queue.TaskScheduleRequest{
+		TaskBase: queue.TaskBase{Queue: "queue1", Type: "interval", Spec: spec},
+		Interval: time.Hour,
+	}))
+	created := getSchedule(t, ctx, b, "interval")
+	require.Equal(t, time.Hour, created.Interval)
+	startScheduleWorker(t, ctx, b)
+
+	// the new schedule enqueues the task immediately
+	task := dequeue(t, ctx, b, "queue1")
+	require.Equal(t, queue.TaskType("interval"), task.Type)
+	requireEmpty(t, ctx, b, "queue1")
+
+	// the next task is enqueued an interval after the previous execution time
+	schedule := getSchedule(t, ctx, b, "interval")
+	require.Equal(t, 1, schedule.ExecutionCount)
+	require.NotNil(t, schedule.NextExecutionTime)
+	require.WithinDuration(t, created.NextExecutionTime.Add(time.Hour), *schedule.NextExecutionTime, time.Millisecond)
+}
+
+func testScheduleBounds(t *testing.T, ctx context.Context, b Backend) {
+	requireScheduleManager(t, b)
+
+	validFrom := time.Now().Add(time.Second)
+	require.NoError(t, b.Scheduler.Schedule(ctx, b.SQLBuilder, queue.TaskScheduleRequest{
+		TaskBase:  queue.TaskBase{Queue: "queue1", Type: "delayed", Spec: spec},
+		ValidFrom: validFrom,
+	}))
+	require.NoError(t, b.Scheduler.Schedule(ctx, b.SQLBuilder, queue.TaskScheduleRequest{
+		TaskBase:      queue.TaskBase{Queue: "queue2", Type: "limited", Spec: spec},
+		Interval:      time.Second,
+		MaxExecutions: 2,
+	}))
+	require.NoError(t, b.Scheduler.Schedule(ctx, b.SQLBuilder, queue.TaskScheduleRequest{
+		TaskBase:   queue.TaskBase{Queue: "queue3", Type: "expired", Spec: spec},
+		Interval:   time.Hour,
+		ValidUntil: time.Now().Add(-time.Minute),
+	}))
+
+	expired := getSchedule(t, ctx, b, "expired")
+	require.Nil(t, expired.NextExecutionTime, "the expired schedule never enqueues the task")
+
+	startScheduleWorker(t, ctx, b)
+
+	// the one-shot schedule enqueues the task at the valid from time
+	requireEmpty(t, ctx, b, "queue1")
+	task := dequeue(t, ctx, b, "queue1")
+	require.Equal(t, queue.TaskType("delayed"), task.Type)
+	require.False(t, time.Now().Before(validFrom))
+
+	// the interval schedule stops after the max executions
+	for i := 0; i < 2; i++ {
+		task = dequeue(t, ctx, b, "queue2")
+		require.Equal(t, queue.TaskType("limited"), task.Type)
+		require.NoError(t, b.Dequeuer.Finish(ctx, task.ID, progress))
+	}
+	time.Sleep(time.Second)
+	requireEmpty(t, ctx, b, "queue2")
+
+	limited := getSchedule(t, ctx, b, "limited")
+	require.Equal(t, 2, limited.ExecutionCount)
+	require.Nil(t, limited.NextExecutionTime)
+
+	requireEmpty(t, ctx, b, "queue3")
+}
+
+func testAssertSchedule(t *testing.T, ctx context.Context, b Backend) {
+	requireScheduler(t, b)
+
+	request := queue.TaskScheduleRequest{
+		TaskBase:     queue.TaskBase{Queue: "queue1", Type: "cron", Spec: spec},
+		CronSchedule: "@every 1h",
+		References:   queue.References{"ref1": "value1"},
+	}
+
+	require.Equal(t, queue.ErrNotScheduled, b.Scheduler.EnsureSchedule(ctx, b.SQLBuilder, request))
+
+	require.NoError(t, b.Scheduler.AssertSchedule(ctx, request))
+	// the existing schedule is not added again
+	require.NoError(t, b.Scheduler.AssertSchedule(ctx, request))
+	require.NoError(t, b.Scheduler.EnsureSchedule(ctx, b.SQLBuilder, request))
+
+	other := request
+	other.References = queue.References{"ref1": "value2"}
+	require.Equal(t, queue.ErrNotScheduled, b.Scheduler.EnsureSchedule(ctx, b.SQLBuilder, other))
+
+	invalid := request
+	invalid.CronSchedule = "invalid"
+	require.Error(t, b.Scheduler.Schedule(ctx, b.SQLBuilder, invalid))
+
+	if b.ScheduleManager == nil {
+		return
+	}
+
+	list, err := b.ScheduleManager.ListSchedules(ctx, queue.ScheduleFilter{}, parameters.Page{})
+	require.NoError(t, err)
+	require.Len(t, list.Items, 1)
+}
+
+func testScheduleMisfire(t *testing.T, ctx context.Context, b Backend) {
+	requireScheduleManager(t, b)
+
+	require.NoError(t, b.Scheduler.Schedule(ctx, b.SQLBuilder, queue.TaskScheduleRequest{
+		TaskBase:      queue.TaskBase{Queue: "queue1", Type: "all", Spec: spec},
+		Interval:      time.Second,
+		MaxExecutions: 3,
+		MisfirePolicy: queue.MisfireFireAll,
+	}))
+	require.NoError(t, b.Scheduler.Schedule(ctx, b.SQLBuilder, queue.TaskScheduleRequest{
+		TaskBase:      queue.TaskBase{Queue: "queue2", Type: "once", Spec: spec},
+		Interval:      time.Second,
+		MaxExecutions: 1,
+	}))
+	all := getSchedule(t, ctx, b, "all")
+	once := getSchedule(t, ctx, b, "once")
+
+	// the schedule worker is down for 3 executions
+	time.Sleep(2500 * time.Millisecond)
+	startScheduleWorker(t, ctx, b)
+
+	// every execution gets its own task with the execution time
+	for i := 0; i < 3; i++ {
+		task := dequeue(t, ctx, b, "queue1")
+		require.Equal(t, queue.TaskType("all"), task.Type)
+		require.NotNil(t, task.ScheduledAt)
+		expected := all.NextExecutionTime.Add(time.Duration(i) * time.Second)
+		require.WithinDuration(t, expected, *task.ScheduledAt, time.Millisecond)
+		require.NoError(t, b.Dequeuer.Finish(ctx, task.ID, progress))
+	}
+	requireEmpty(t, ctx, b, "queue1")
+
+	// only the latest execution gets a task
+	task := dequeue(t, ctx, b, "queue2")
+	require.NotNil(t, task.ScheduledAt)
+	require.WithinDuration(t, once.NextExecutionTime.Add(2*time.Second), *task.ScheduledAt, time.Millisecond)
+	requireEmpty(t, ctx, b, "queue2")
+}
+
+func testScheduleOverlap(t *testing.T, ctx context.Context, b Backend) {
+	requireScheduleManager(t, b)
+
+	err := b.Scheduler.Schedule(ctx, b.SQLBuilder, queue.TaskScheduleRequest{
+		TaskBase:      queue.TaskBase{Queue: "queue1", Type: "invalid", Spec: spec},
+		OverlapPolicy: "unknown",
+	})
+	require.True(t, errors.Is(err, queue.ErrInvalidSchedule), err)
+
+	require.NoError(t, b.Scheduler.Schedule(ctx, b.SQLBuilder, queue.TaskScheduleRequest{
+		TaskBase:      queue.TaskBase{Queue: "queue1", Type: "skip", Spec: spec},
+		Interval:      time.Second,
+		OverlapPolicy: queue.OverlapSkip,
+	}))
+	require.NoError(t, b.Scheduler.Schedule(ctx, b.SQLBuilder, queue.TaskScheduleRequest{
+		TaskBase:      queue.TaskBase{Queue: "queue2", Type: "cancel", Spec: spec},
+		Interval:      time.Second,
+		OverlapPolicy: queue.OverlapCancel,
+	}))
+	skip := getSchedule(t, ctx, b, "skip")
+	require.Equal(t, queue.OverlapSkip, skip.OverlapPolicy)
+
+	startScheduleWorker(t, ctx, b)
+
+	skipped := dequeue(t, ctx, b, "queue1")
+	cancelled := dequeue(t, ctx, b, "queue2")
+
+	// the next execution cancels the running task and enqueues a new one
+	next := dequeue(t, ctx, b, "queue2")
+	require.NotEqual(t, cancelled.ID, next.ID)
+	require.Equal(t, queue.ErrTaskCancelled, b.Dequeuer.Heartbeat(ctx, cancelled.ID, progress))
+
+	// the executions are skipped while the task is running
+	time.Sleep(1500 * time.Millisecond)
+	tasks, err := b.Inspector.ListTasks(ctx, queue.TaskFilter{
+		References: queue.References{"schedule_id": skip.ID},
+	}, parameters.Page{})
+	require.NoError(t, err)
+	require.Len(t, tasks.Items, 1)
+
+	skip = getSchedule(t, ctx, b, "skip")
+	require.True(t, skip.ExecutionCount > 1, "the skipped executions are counted")
+
+	// the next execution after the task is finished enqueues a new one
+	require.NoError(t, b.Dequeuer.Finish(ctx, skipped.ID, progress))
+	task := dequeue(t, ctx, b, "queue1")
+	require.NotEqual(t, skipped.ID, task.ID)
+}
+
+func testScheduleFireAllOverlap(t *testing.T, ctx context.Context, b Backend) {
+	requireScheduleManager(t, b)
+
+	// every missed execution would overlap with the task enqueued for the previous one
+	for _, policy := range []queue.OverlapPolicy{queue.OverlapSkip, queue.OverlapCancel} {
+		err := b.Scheduler.Schedule(ctx, b.SQLBuilder, queue.TaskScheduleRequest{
+			TaskBase:      queue.TaskBase{Queue: "queue1", Type: "invalid", Spec: spec},
+			Interval:      time.Second,
+			MisfirePolicy: queue.MisfireFireAll,
+			OverlapPolicy: policy,
+		})
+		require.True(t, errors.Is(err, queue.ErrInvalidSchedule), err)
+	}
+
+	require.NoError(t, b.Scheduler.Schedule(ctx, b.SQLBuilder, queue.TaskScheduleRequest{
+		TaskBase:      queue.TaskBase{Queue: "queue1", Type: "all", Spec: spec},
+		Interval:      time.Hour,
+		MisfirePolicy: queue.MisfireFireAll,
+	}))
+	require.NoError(t, b.Scheduler.Schedule(ctx, b.SQLBuilder, queue.TaskScheduleRequest{
+		TaskBase:      queue.TaskBase{Queue: "queue2", Type: "skip", Spec: spec},
+		Interval:      time.Hour,
+		OverlapPolicy: queue.OverlapSkip,
+	}))
+	all := getSchedule(t, ctx, b, "all")
+	skip := getSchedule(t, ctx, b, "skip")
+
+	// the combination can't be reached with an update either
+	policy := queue.OverlapCancel
+	_, err := b.ScheduleManager.UpdateSchedule(ctx, all.ID, queue.ScheduleUpdate{
+		OverlapPolicy: &policy,
+	})
+	require.True(t, errors.Is(err, queue.ErrInvalidSchedule), err)
+
+	_, err = b.ScheduleManager.UpdateSchedule(ctx, skip.ID, queue.ScheduleUpdate{
+		Timing: &queue.ScheduleTiming{Interval: time.Hour, MisfirePolicy: queue.MisfireFireAll},
+	})
+	require.True(t, errors.Is(err, queue.ErrInvalidSchedule), err)
+
+	stored, err := b.ScheduleManager.GetSchedule(ctx, all.ID)
+	require.NoError(t, err)
+	require.Equal(t, queue.OverlapPolicy(""), stored.OverlapPolicy)
+
+	stored, err = b.ScheduleManager.GetSchedule(ctx, skip.ID)
+	require.NoError(t, err)
+	require.Equal(t, queue.MisfirePolicy(""), stored.MisfirePolicy)
+
+	// both can be changed together
+	allow := queue.OverlapAllow
+	schedule, err := b.ScheduleManager.UpdateSchedule(ctx, skip.ID, queue.ScheduleUpdate{
+		Timing:        &queue.ScheduleTiming{Interval: time.Hour, MisfirePolicy: queue.MisfireFireAll},
+		OverlapPolicy: &allow,
+	})
+	require.NoError(t, err)
+	require.Equal(t, queue.MisfireFireAll, schedule.MisfirePolicy)
+	require.Equal(t, queue.OverlapAllow, schedule.OverlapPolicy)
+}
+
+func testUpdateSchedule(t *testing.T, ctx context.Context, b Backend) {
+	requireScheduleManager(t, b)
+
+	require.NoError(t, b.Scheduler.Schedule(ctx, b.SQLBuilder, queue.TaskScheduleRequest{
+		TaskBase:     queue.TaskBase{Queue: "queue1", Type: "updated", Spec: spec},
+		CronSchedule: "@every 1h",
+	}))
+	created := getSchedule(t, ctx, b, "updated")
+
+	schedule, err := b.ScheduleManager.GetSchedule(ctx, created.ID)
+	require.NoError(t, err)
+	require.Equal(t, created.ID, schedule.ID)
+	require.Equal(t, "@every 1h", schedule.CronSchedule)
+
+	_, err = b.ScheduleManager.UpdateSchedule(ctx, created.ID, queue.ScheduleUpdate{
+		Timing: &queue.ScheduleTiming{CronSchedule: "invalid"},
+	})
+	require.True(t, errors.Is(err, queue.ErrInvalidSchedule), err)
+
+	// the task settings are changed and the timing settings are kept
+	priority := 5
+	newSpec := queue.Spec(`{"field":"updated"}`)
+	schedule, err = b.ScheduleManager.UpdateSchedule(ctx, created.ID, queue.ScheduleUpdate{
+		Spec:     newSpec,
+		Priority: &priority,
+	})
+	require.NoError(t, err)
+	require.JSONEq(t, string(newSpec), string(schedule.Spec))
+	require.Equal(t, 5, schedule.Priority)
+	require.Equal(t, "@every 1h", schedule.CronSchedule)
+	require.Equal(t, created.NextExecutionTime.Unix(), schedule.NextExecutionTime.Unix())
+
+	// the new timing moves the next execution time, the task is not enqueued right away
+	schedule, err = b.ScheduleManager.UpdateSchedule(ctx, created.ID, queue.ScheduleUpdate{
+		Timing: &queue.ScheduleTiming{Interval: 2 * time.Hour, MaxExecutions: 3},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "", schedule.CronSchedule)
+	require.Equal(t, 2*time.Hour, schedule.Interval)
+	require.Equal(t, 3, schedule.MaxExecutions)
+	require.NotNil(t, schedule.NextExecutionTime)
+	require.WithinDuration(t, time.Now().Add(2*time.Hour), *schedule.NextExecutionTime, time.Minute)
+
+	stored, err := b.ScheduleManager.GetSchedule(ctx, created.ID)
+	require.NoError(t, err)
+	require.Equal(t, schedule.Interval, stored.Interval)
+	require.Equal(t, schedule.Priority, stored.Priority)
+	require.Equal(t, schedule.NextExecutionTime.Unix(), stored.NextExecutionTime.Unix())
+
+	startScheduleWorker(t, ctx, b)
+	requireEmpty(t, ctx, b, "queue1")
+
+	missing := "00000000-0000-0000-0000-000000000000"
+	_, err = b.ScheduleManager.GetSchedule(ctx, missing)
+	require.Equal(t, queue.ErrScheduleNotFound, err)
+	_, err = b.ScheduleManager.UpdateSchedule(ctx, missing, queue.ScheduleUpdate{Priority: &priority})
+	require.Equal(t, queue.ErrScheduleNotFound, err)
+}
+
+func testPauseSchedule(t *testing.T, ctx context.Context, b Backend) {
+	requireScheduleManager(t, b)
+
+	require.NoError(t, b.Scheduler.Schedule(ctx, b.SQLBuilder, queue.TaskScheduleRequest{
+		TaskBase: queue.TaskBase{Queue: "queue1", Type: "paused", Spec: spec},
+	}))
+	schedule := getSchedule(t, ctx, b, "paused")
+	require.NoError(t, b.ScheduleManager.PauseSchedule(ctx, schedule.ID))
+
+	startScheduleWorker(t, ctx, b)
+	requireEmpty(t, ctx, b, "queue1")
+
+	require.NoError(t, b.ScheduleManager.ResumeSchedule(ctx, schedule.ID))
+	task := dequeue(t, ctx, b, "queue1")
+	require.Equal(t, queue.TaskType("paused"), task.Type)
+
+	require.Equal(t, queue.ErrScheduleNotFound, b.ScheduleManager.PauseSchedule(ctx, "00000000-0000-0000-0000-000000000000"))
+}
+
+func testTriggerSchedule(t *testing.T, ctx context.Context, b Backend) {
+	requireScheduleManager(t, b)
+
+	require.NoError(t, b.Scheduler.Schedule(ctx, b.SQLBuilder, queue.TaskScheduleRequest{
+		TaskBase:     queue.TaskBase{Queue: "queue1", Type: "triggered", Spec: spec},
+		CronSchedule: "@every 1h",
+	}))
+	schedule := getSchedule(t, ctx, b, "triggered")
+
+	// the schedule worker is not running, the task comes from the trigger only
+	require.NoError(t, b.ScheduleManager.TriggerSchedule(ctx, schedule.ID))
+
+	task := dequeue(t, ctx, b, "queue1")
+	require.Equal(t, queue.TaskType("triggered"), task.Type)
+
+	tasks, err := b.Inspector.ListTasks(ctx, queue.TaskFilter{
+		References: queue.References{"schedule_id": schedule.ID},
+	}, parameters.Page{})
+	require.NoError(t, err)
+	require.Len(t, tasks.Items, 1)
+
+	require.NoError(t, b.ScheduleManager.DeleteSchedule(ctx, schedule.ID))
+	require.Equal(t, queue.ErrScheduleNotFound, b.ScheduleManager.TriggerSchedule(ctx, schedule.ID))
+}
+
+// requireScheduler skips the test if the backend does not support scheduling
+func requireScheduler(t *testing.T, b Backend) {
+	if b.Scheduler == nil || b.ScheduleWorker == nil {
+		t.Skip("the backend has no scheduler")
+	}
+}
+
+// requireScheduleManager skips the test if the backend does not support schedule management
+func requireScheduleManager(t *testing.T, b Backend) {
+	requireScheduler(t, b)
+	if b.ScheduleManager == nil {
+		t.Skip("the backend has no schedule manager")
+	}
+}
+
+// startScheduleWorker runs the schedule worker of the backend until the end of the test
+func startScheduleWorker(t *testing.T, ctx context.Context, b Backend) {
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = b.ScheduleWorker.Work(ctx)
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+// getSchedule returns the only schedule of the given task type
+func getSchedule(t *testing.T, ctx context.Context, b Backend, taskType queue.TaskType) queue.Schedule {
+	list, err := b.ScheduleManager.ListSchedules(ctx, queue.ScheduleFilter{Type: taskType}, parameters.Page{})
+	require.NoError(t, err)
+	require.Len(t, list.Items, 1)
+	return list.Items[0]
+}
@@ -0,0 +1,168 @@
+// Package queuetest provides a conformance test suite for the queue backends.
+//
+// The suite verifies that a backend behaves like the reference Postgres implementation,
+// every backend of this module runs it and custom backends can run it too:
+//
+//	func TestConformance(t *testing.T) {
+//		queuetest.Run(t, func(t *testing.T, cfg config.Queue) queuetest.Backend {
+//			q := memory.NewQueue(cfg, memory.Options{})
+//			return queuetest.Backend{
+//				Queuer:             q,
+//				Dequeuer:           q,
+//				Inspector:          q,
+//				Manager:            q,
+//				ConcurrencyLimiter: q,
+//				Pauser:             q,
+//				RateLimiter:        q,
+//				Scheduler:          q,
+//				ScheduleManager:    q,
+//				ScheduleWorker:     memory.NewScheduleWorker(q, cfg.PollFrequency),
+//			}
+//		})
+//	}
+package queuetest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/contiamo/go-base/v4/pkg/config"
+	cdb "github.com/contiamo/go-base/v4/pkg/db"
+	"github.com/contiamo/go-base/v4/pkg/queue"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	// testTimeout limits the duration of a single test
+	testTimeout = 30 * time.Second
+	// emptyWait is how long the queue is expected to stay empty
+	emptyWait = 300 * time.Millisecond
+	// shortHeartbeatTTL is used by the tests waiting for a task to expire
+	shortHeartbeatTTL = 500 * time.Millisecond
+)
+
+var (
+	spec     = queue.Spec(`{"field": "value"}`)
+	progress = queue.Progress(`{"scale": 99}`)
+)
+
+// Backend is a queue backend under test, all its parts must share the same storage.
+//
+// The scheduling tests are skipped when the backend has no Scheduler,
+// the schedule management tests are skipped when it has no ScheduleManager.
+// The queue settings tests are skipped when the backend doesn't have the corresponding part.
+type Backend struct {
+	Queuer    queue.Queuer
+	Dequeuer  queue.Dequeuer
+	Inspector queue.Inspector
+	Manager   queue.Manager
+
+	ConcurrencyLimiter queue.ConcurrencyLimiter
+	Pauser             queue.Pauser
+	RateLimiter        queue.RateLimiter
+
+	Scheduler       queue.Scheduler
+	ScheduleManager queue.ScheduleManager
+	// SQLBuilder is passed to the Scheduler methods requiring a builder,
+	// backends that don't use SQL can leave it empty.
+	SQLBuilder cdb.SQLBuilder
+	// ScheduleWorker enqueues the tasks of the due schedules, it's started by the scheduling tests
+	// and must check the schedules at least every `cfg.PollFrequency`.
+	ScheduleWorker queue.Worker
+}
+
+// Factory creates a new empty backend with the given configuration.
+// Every test gets its own backend, the factory is responsible for releasing it
+// after the test, e.g. using `t.Cleanup`.
+type Factory func(t *testing.T, cfg config.Queue) Backend
+
+type testCase struct {
+	name string
+	// heartbeatTTL overrides the default heartbeat TTL of the backend
+	heartbeatTTL time.Duration
+	test         func(t *testing.T, ctx context.Context, b Backend)
+}
+
+// Run runs the conformance test suite against the backends created by the factory
+func Run(t *testing.T, newBackend Factory) {
+	tests := []testCase{
+		{name: "enqueued task is dequeued", test: testEnqueueDequeue},
+		{name: "tasks are dequeued by priority and age", test: testDequeueOrder},
+		{name: "tasks are dequeued from the given queues only", test: testDequeueQueues},
+		{name: "dequeue waits for a task to be enqueued", test: testDequeueWaits},
+		{name: "delayed task is dequeued when its time has come", test: testDequeueDelayed},
+		{name: "one task per queue runs at a time", test: testOneTaskPerQueue},
+		{name: "queue concurrency limit is configurable", test: testQueueConcurrency},
+		{name: "paused queues and task types are not dequeued", test: testPause},
+		{name: "rate limited queues and task types wait for a token", test: testRateLimit},
+		{name: "cancelled waiting task is never dequeued", test: testCancelWaiting},
+		{name: "cancelled running task is stopped by the heartbeat", test: testCancelRunning},
+		{name: "task without heartbeats expires", heartbeatTTL: shortHeartbeatTTL, test: testHeartbeatExpiry},
+		{name: "heartbeats keep the task running", heartbeatTTL: shortHeartbeatTTL, test: testHeartbeatKeepsRunning},
+		{name: "finished task is over", test: testFinish},
+		{name: "failed task is over", test: testFail},
+		{name: "failed task is retried", test: testRetry},
+		{name: "waiting task can not be worked on", test: testNotRunning},
+		{name: "unknown task can not be worked on", test: testNotFound},
+		{name: "schedule enqueues the task", test: testScheduleOnce},
+		{name: "cron schedule enqueues the task once per period", test: testScheduleCron},
+		{name: "cron schedule is evaluated in its time zone", test: testScheduleTimezone},
+		{name: "interval schedule enqueues the task once per interval", test: testScheduleInterval},
+		{name: "schedule enqueues the tasks within its bounds", test: testScheduleBounds},
+		{name: "schedule enqueues the missed executions according to the misfire policy", test: testScheduleMisfire},
+		{name: "schedule enqueues the task according to the overlap policy", test: testScheduleOverlap},
+		{name: "fire all misfire policy can not be used with skip or cancel overlap policy", test: testScheduleFireAllOverlap},
+		{name: "schedule is asserted and ensured", test: testAssertSchedule},
+		{name: "schedule is updated", test: testUpdateSchedule},
+		{name: "paused schedule does not enqueue tasks", test: testPauseSchedule},
+		{name: "triggered schedule enqueues the task right away", test: testTriggerSchedule},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+			defer cancel()
+
+			cfg := config.Queue{
+				HeartbeatTTL:  10 * time.Second,
+				PollFrequency: 50 * time.Millisecond,
+			}
+			if tc.heartbeatTTL > 0 {
+				cfg.HeartbeatTTL = tc.heartbeatTTL
+			}
+
+			tc.test(t, ctx, newBackend(t, cfg))
+		})
+	}
+}
+
+// enqueue adds the task and returns its ID
+func enqueue(t *testing.T, ctx context.Context, b Backend, task queue.TaskEnqueueRequest) string {
+	taskID, err := b.Queuer.Enqueue(ctx, task)
+	require.NoError(t, err)
+	require.NotEmpty(t, taskID)
+	return taskID
+}
+
+// dequeue returns the next task from the given queues, the test fails if there is no task
+func dequeue(t *testing.T, ctx context.Context, b Backend, queues ...string) *queue.Task {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	task, err := b.Dequeuer.Dequeue(ctx, queues...)
+	require.NoError(t, err)
+	require.NotNil(t, task)
+	return task
+}
+
+// requireEmpty asserts that no task can be dequeued from the given queues for some time
+func requireEmpty(t *testing.T, ctx context.Context, b Backend, queues ...string) {
+	ctx, cancel := context.WithTimeout(ctx, emptyWait)
+	defer cancel()
+
+	task, err := b.Dequeuer.Dequeue(ctx, queues...)
+	require.Error(t, err, "the dequeuing must be interrupted by the timeout")
+	require.Nil(t, task)
+}
@@ -0,0 +1,321 @@
+package queuetest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/contiamo/go-base/v4/pkg/queue"
+	"github.com/stretchr/testify/require"
+)
+
+func testEnqueueDequeue(t *testing.T, ctx context.Context, b Backend) {
+	taskID := enqueue(t, ctx, b, queue.TaskEnqueueRequest{
+		TaskBase: queue.TaskBase{
+			Queue:    "queue1",
+			Type:     "test",
+			Spec:     spec,
+			Priority: 5,
+		},
+	})
+
+	task, err := b.Inspector.GetTask(ctx, taskID)
+	require.NoError(t, err)
+	require.Equal(t, queue.Waiting, task.Status)
+	require.Equal(t, 0, task.Attempts)
+	require.Nil(t, task.StartedAt)
+
+	task = dequeue(t, ctx, b, "queue1")
+	require.Equal(t, taskID, task.ID)
+	require.Equal(t, "queue1", task.Queue)
+	require.Equal(t, queue.TaskType("test"), task.Type)
+	require.JSONEq(t, string(spec), string(task.Spec))
+	require.Equal(t, 5, task.Priority)
+	require.Equal(t, queue.Running, task.Status)
+	require.Equal(t, 1, task.Attempts)
+	require.NotNil(t, task.StartedAt)
+	require.NotNil(t, task.LastHeartbeatAt)
+
+	task, err = b.Inspector.GetTask(ctx, taskID)
+	require.NoError(t, err)
+	require.Equal(t, queue.Running, task.Status)
+
+	_, err = b.Inspector.GetTask(ctx, "00000000-0000-0000-0000-000000000000")
+	require.Equal(t, queue.ErrTaskNotFound, err)
+}
+
+func testDequeueOrder(t *testing.T, ctx context.Context, b Backend) {
+	// every task is in its own queue, so they can run at the same time
+	enqueueTask := func(queueName string, priority int) string {
+		return enqueue(t, ctx, b, queue.TaskEnqueueRequest{
+			TaskBase: queue.TaskBase{Queue: queueName, Type: "test", Priority: priority},
+		})
+	}
+
+	low := enqueueTask("queue1", -1)
+	older := enqueueTask("queue2", 0)
+	newer := enqueueTask("queue3", 0)
+	high := enqueueTask("queue4", 10)
+
+	for _, expected := range []string{high, older, newer, low} {
+		task := dequeue(t, ctx, b)
+		require.Equal(t, expected, task.ID)
+	}
+}
+
+func testDequeueQueues(t *testing.T, ctx context.Context, b Backend) {
+	enqueue(t, ctx, b, queue.TaskEnqueueRequest{
+		TaskBase: queue.TaskBase{Queue: "queue1", Type: "test", Priority: 10},
+	})
+	taskID := enqueue(t, ctx, b, queue.TaskEnqueueRequest{
+		TaskBase: queue.TaskBase{Queue: "queue2", Type: "test"},
+	})
+
+	requireEmpty(t, ctx, b, "queue3")
+
+	task := dequeue(t, ctx, b, "queue2", "queue3")
+	require.Equal(t, taskID, task.ID)
+}
+
+func testDequeueWaits(t *testing.T, ctx context.Context, b Backend) {
+	dequeued := make(chan *queue.Task, 1)
+	go func() {
+		task, err := b.Dequeuer.Dequeue(ctx, "queue1")
+		if err != nil {
+			t.Error(err)
+		}
+		dequeued <- task
+	}()
+
+	time.Sleep(emptyWait)
+	taskID := enqueue(t, ctx, b, queue.TaskEnqueueRequest{
+		TaskBase: queue.TaskBase{Queue: "queue1", Type: "test"},
+	})
+
+	select {
+	case task := <-dequeued:
+		require.NotNil(t, task)
+		require.Equal(t, taskID, task.ID)
+	case <-ctx.Done():
+		require.Fail(t, "the task was not dequeued")
+	}
+}
+
+func testDequeueDelayed(t *testing.T, ctx context.Context, b Backend) {
+	notBefore := time.Now().Add(time.Second)
+	taskID := enqueue(t, ctx, b, queue.TaskEnqueueRequest{
+		TaskBase:  queue.TaskBase{Queue: "queue1", Type: "test"},
+		NotBefore: notBefore,
+	})
+
+	requireEmpty(t, ctx, b, "queue1")
+
+	task := dequeue(t, ctx, b, "queue1")
+	require.Equal(t, taskID, task.ID)
+	require.False(t, time.Now().Before(notBefore), "the task is dequeued too early")
+}
+
+func testOneTaskPerQueue(t *testing.T, ctx context.Context, b Backend) {
+	first := enqueue(t, ctx, b, queue.TaskEnqueueRequest{
+		TaskBase: queue.TaskBase{Queue: "queue1", Type: "test"},
+	})
+	second := enqueue(t, ctx, b, queue.TaskEnqueueRequest{
+		TaskBase: queue.TaskBase{Queue: "queue1", Type: "test"},
+	})
+	other := enqueue(t, ctx, b, queue.TaskEnqueueRequest{
+		TaskBase: queue.TaskBase{Queue: "queue2", Type: "test"},
+	})
+
+	task := dequeue(t, ctx, b, "queue1")
+	require.Equal(t, first, task.ID)
+
+	requireEmpty(t, ctx, b, "queue1")
+
+	// other queues are not blocked
+	task = dequeue(t, ctx, b)
+	require.Equal(t, other, task.ID)
+
+	require.NoError(t, b.Dequeuer.Finish(ctx, first, progress))
+
+	task = dequeue(t, ctx, b, "queue1")
+	require.Equal(t, second, task.ID)
+}
+
+func testCancelWaiting(t *testing.T, ctx context.Context, b Backend) {
+	taskID := enqueue(t, ctx, b, queue.TaskEnqueueRequest{
+		TaskBase: queue.TaskBase{Queue: "queue1", Type: "test"},
+	})
+
+	require.NoError(t, b.Manager.Cancel(ctx, taskID))
+	// cancelling twice has no effect
+	require.NoError(t, b.Manager.Cancel(ctx, taskID))
+
+	task, err := b.Inspector.GetTask(ctx, taskID)
+	require.NoError(t, err)
+	require.Equal(t, queue.Cancelled, task.Status)
+	require.NotNil(t, task.FinishedAt)
+
+	requireEmpty(t, ctx, b, "queue1")
+}
+
+func testCancelRunning(t *testing.T, ctx context.Context, b Backend) {
+	taskID := enqueue(t, ctx, b, queue.TaskEnqueueRequest{
+		TaskBase: queue.TaskBase{Queue: "queue1", Type: "test"},
+	})
+	dequeue(t, ctx, b, "queue1")
+
+	require.NoError(t, b.Manager.Cancel(ctx, taskID))
+
+	require.Equal(t, queue.ErrTaskCancelled, b.Dequeuer.Heartbeat(ctx, taskID, progress))
+	require.Equal(t, queue.ErrTaskCancelled, b.Dequeuer.Finish(ctx, taskID, progress))
+
+	// the cancelled task does not block its queue
+	next := enqueue(t, ctx, b, queue.TaskEnqueueRequest{
+		TaskBase: queue.TaskBase{Queue: "queue1", Type: "test"},
+	})
+	task := dequeue(t, ctx, b, "queue1")
+	require.Equal(t, next, task.ID)
+}
+
+func testHeartbeatExpiry(t *testing.T, ctx context.Context, b Backend) {
+	taskID := enqueue(t, ctx, b, queue.TaskEnqueueRequest{
+		TaskBase: queue.TaskBase{Queue: "queue1", Type: "test"},
+	})
+
+	task := dequeue(t, ctx, b, "queue1")
+	require.Equal(t, 1, task.Attempts)
+
+	requireEmpty(t, ctx, b, "queue1")
+
+	// the task is abandoned after twice the heartbeat ttl without a heartbeat
+	task = dequeue(t, ctx, b, "queue1")
+	require.Equal(t, taskID, task.ID)
+	require.Equal(t, queue.Running, task.Status)
+	require.Equal(t, 2, task.Attempts)
+}
+
+func testHeartbeatKeepsRunning(t *testing.T, ctx context.Context, b Backend) {
+	taskID := enqueue(t, ctx, b, queue.TaskEnqueueRequest{
+		TaskBase: queue.TaskBase{Queue: "queue1", Type: "test"},
+	})
+	dequeue(t, ctx, b, "queue1")
+
+	// the heartbeats continue for longer than the task would take to expire
+	deadline := time.Now().Add(3 * shortHeartbeatTTL)
+	for time.Now().Before(deadline) {
+		require.NoError(t, b.Dequeuer.Heartbeat(ctx, taskID, progress))
+		time.Sleep(shortHeartbeatTTL / 5)
+	}
+
+	requireEmpty(t, ctx, b, "queue1")
+
+	task, err := b.Inspector.GetTask(ctx, taskID)
+	require.NoError(t, err)
+	require.Equal(t, queue.Running, task.Status)
+	require.Equal(t, 1, task.Attempts)
+	require.JSONEq(t, string(progress), string(task.Progress))
+}
+
+func testFinish(t *testing.T, ctx context.Context, b Backend) {
+	taskID := enqueue(t, ctx, b, queue.TaskEnqueueRequest{
+		TaskBase: queue.TaskBase{Queue: "queue1", Type: "test"},
+	})
+	dequeue(t, ctx, b, "queue1")
+
+	require.NoError(t, b.Dequeuer.Heartbeat(ctx, taskID, queue.Progress(`{"scale": 1}`)))
+	require.NoError(t, b.Dequeuer.Finish(ctx, taskID, progress))
+
+	task, err := b.Inspector.GetTask(ctx, taskID)
+	require.NoError(t, err)
+	require.Equal(t, queue.Finished, task.Status)
+	require.NotNil(t, task.FinishedAt)
+	require.JSONEq(t, string(progress), string(task.Progress))
+
+	require.Equal(t, queue.ErrTaskFinished, b.Dequeuer.Heartbeat(ctx, taskID, progress))
+	require.Equal(t, queue.ErrTaskFinished, b.Dequeuer.Finish(ctx, taskID, progress))
+	require.Equal(t, queue.ErrTaskFinished, b.Dequeuer.Fail(ctx, taskID, progress))
+
+	requireEmpty(t, ctx, b, "queue1")
+}
+
+func testFail(t *testing.T, ctx context.Context, b Backend) {
+	taskID := enqueue(t, ctx, b, queue.TaskEnqueueRequest{
+		TaskBase: queue.TaskBase{Queue: "queue1", Type: "test"},
+	})
+	dequeue(t, ctx, b, "queue1")
+
+	require.NoError(t, b.Dequeuer.Fail(ctx, taskID, progress))
+
+	task, err := b.Inspector.GetTask(ctx, taskID)
+	require.NoError(t, err)
+	require.Equal(t, queue.Failed, task.Status)
+	require.NotNil(t, task.FinishedAt)
+	require.JSONEq(t, string(progress), string(task.Progress))
+
+	require.Equal(t, queue.ErrTaskFinished, b.Dequeuer.Heartbeat(ctx, taskID, progress))
+	require.Equal(t, queue.ErrTaskFinished, b.Dequeuer.Finish(ctx, taskID, progress))
+	require.Equal(t, queue.ErrTaskFinished, b.Dequeuer.Fail(ctx, taskID, progress))
+
+	// the failed task is not retried and does not block its queue
+	requireEmpty(t, ctx, b, "queue1")
+
+	next := enqueue(t, ctx, b, queue.TaskEnqueueRequest{
+		TaskBase: queue.TaskBase{Queue: "queue1", Type: "test"},
+	})
+	task = dequeue(t, ctx, b, "queue1")
+	require.Equal(t, next, task.ID)
+}
+
+func testNotRunning(t *testing.T, ctx context.Context, b Backend) {
+	taskID := enqueue(t, ctx, b, queue.TaskEnqueueRequest{
+		TaskBase: queue.TaskBase{Queue: "queue1", Type: "test"},
+	})
+
+	require.Equal(t, queue.ErrTaskNotRunning, b.Dequeuer.Heartbeat(ctx, taskID, progress))
+	require.Equal(t, queue.ErrTaskNotRunning, b.Dequeuer.Finish(ctx, taskID, progress))
+	require.Equal(t, queue.ErrTaskNotRunning, b.Dequeuer.Fail(ctx, taskID, progress))
+
+	task, err := b.Inspector.GetTask(ctx, taskID)
+	require.NoError(t, err)
+	require.Equal(t, queue.Waiting, task.Status)
+	require.Nil(t, task.FinishedAt)
+}
+
+func testNotFound(t *testing.T, ctx context.Context, b Backend) {
+	taskID := "00000000-0000-0000-0000-000000000000"
+
+	require.Equal(t, queue.ErrTaskNotFound, b.Dequeuer.Heartbeat(ctx, taskID, progress))
+	require.Equal(t, queue.ErrTaskNotFound, b.Dequeuer.Finish(ctx, taskID, progress))
+	require.Equal(t, queue.ErrTaskNotFound, b.Dequeuer.Fail(ctx, taskID, progress))
+}
+
+func testRetry(t *testing.T, ctx context.Context, b Backend) {
+	taskID := enqueue(t, ctx, b, queue.TaskEnqueueRequest{
+		TaskBase:       queue.TaskBase{Queue: "queue1", Type: "test"},
+		IdempotencyKey: "key",
+	})
+
+	task := dequeue(t, ctx, b, "queue1")
+	require.Equal(t, taskID, task.ID)
+	require.NoError(t, b.Dequeuer.Fail(ctx, taskID, progress))
+
+	// the failed task does not deduplicate the enqueued tasks
+	duplicateID := enqueue(t, ctx, b, queue.TaskEnqueueRequest{
+		TaskBase:       queue.TaskBase{Queue: "queue1", Type: "test"},
+		IdempotencyKey: "key",
+	})
+	require.NotEqual(t, taskID, duplicateID)
+
+	// only one unfinished task can have the key
+	require.Equal(t, queue.ErrDuplicateIdempotencyKey, b.Manager.Retry(ctx, taskID))
+
+	task = dequeue(t, ctx, b, "queue1")
+	require.Equal(t, duplicateID, task.ID)
+	require.NoError(t, b.Dequeuer.Finish(ctx, duplicateID, progress))
+
+	require.NoError(t, b.Manager.Retry(ctx, taskID))
+	task = dequeue(t, ctx, b, "queue1")
+	require.Equal(t, taskID, task.ID)
+	require.Equal(t, 1, task.Attempts)
+	require.Nil(t, task.FinishedAt)
+}
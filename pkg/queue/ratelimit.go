@@ -0,0 +1,48 @@
+package queue
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrInvalidRateLimit indicates that the given rate limit is not valid
+var ErrInvalidRateLimit = errors.New("rate limit must have a positive rate and burst")
+
+// RateLimit is a token bucket limiting how often the tasks can be dequeued.
+//
+// The bucket holds up to `Burst` tokens and is refilled with `Rate` tokens per second,
+// every dequeued task takes one token. The tasks stay in the queue while the bucket is empty.
+type RateLimit struct {
+	// Rate is the number of tasks per second that can be dequeued on average
+	Rate float64
+	// Burst is the maximum number of tasks that can be dequeued at once
+	Burst int
+}
+
+// Validate checks that the rate limit can be used
+func (l RateLimit) Validate() error {
+	if l.Rate <= 0 || l.Burst < 1 {
+		return ErrInvalidRateLimit
+	}
+	return nil
+}
+
+// RateLimiter manages how often the tasks can be dequeued per queue and per task type.
+// A task is dequeued only when both its queue and its type have a token available.
+type RateLimiter interface {
+	// SetQueueRateLimit sets the rate limit of the queue, nil removes the limit.
+	// The bucket of the changed limit starts full.
+	// ErrTaskQueueNotSpecified is returned if the queue name is empty and ErrInvalidRateLimit
+	// is returned if the limit is not valid.
+	SetQueueRateLimit(ctx context.Context, queueName string, limit *RateLimit) error
+	// GetQueueRateLimit returns the rate limit of the queue, nil is returned when the queue is not limited
+	GetQueueRateLimit(ctx context.Context, queueName string) (*RateLimit, error)
+
+	// SetTaskTypeRateLimit sets the rate limit of the task type across all the queues,
+	// nil removes the limit. The bucket of the changed limit starts full.
+	// ErrTaskTypeNotSpecified is returned if the task type is empty and ErrInvalidRateLimit
+	// is returned if the limit is not valid.
+	SetTaskTypeRateLimit(ctx context.Context, taskType TaskType, limit *RateLimit) error
+	// GetTaskTypeRateLimit returns the rate limit of the task type, nil is returned when the type is not limited
+	GetTaskTypeRateLimit(ctx context.Context, taskType TaskType) (*RateLimit, error)
+}
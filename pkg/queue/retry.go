@@ -0,0 +1,113 @@
+package queue
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// ErrInvalidRetryPolicy indicates that the retry policy of the task has invalid settings
+var ErrInvalidRetryPolicy = errors.New("invalid retry policy")
+
+// RetryPolicy defines how a task is retried after a failed attempt.
+// The delay before the attempt N+1 is `InitialBackoff * Multiplier^(N-1)`,
+// limited by `MaxBackoff` and randomized by `Jitter`.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts to process the task including the first one.
+	// Values less than 2 mean the task is never retried.
+	MaxAttempts int `json:"maxAttempts"`
+	// InitialBackoff is the delay before the first retry
+	InitialBackoff time.Duration `json:"initialBackoff"`
+	// MaxBackoff limits the delay between two attempts, zero means there is no limit
+	MaxBackoff time.Duration `json:"maxBackoff"`
+	// Multiplier is the factor the delay grows with after each attempt,
+	// values less than 1 are treated as 1 which means the delay is constant
+	Multiplier float64 `json:"multiplier"`
+	// Jitter is the randomization factor in the range [0, 1] applied to the delay,
+	// the actual delay is a random value in the range [delay - Jitter*delay, delay + Jitter*delay]
+	Jitter float64 `json:"jitter"`
+}
+
+// Validate returns an error if the policy settings are not valid
+func (p RetryPolicy) Validate() error {
+	switch {
+	case p.MaxAttempts < 0:
+		return fmt.Errorf("%w: max attempts can not be negative", ErrInvalidRetryPolicy)
+	case p.InitialBackoff < 0:
+		return fmt.Errorf("%w: initial backoff can not be negative", ErrInvalidRetryPolicy)
+	case p.MaxBackoff < 0:
+		return fmt.Errorf("%w: max backoff can not be negative", ErrInvalidRetryPolicy)
+	case p.Multiplier < 0:
+		return fmt.Errorf("%w: multiplier can not be negative", ErrInvalidRetryPolicy)
+	case p.Jitter < 0 || p.Jitter > 1:
+		return fmt.Errorf("%w: jitter must be in the range [0, 1]", ErrInvalidRetryPolicy)
+	}
+	return nil
+}
+
+// ShouldRetry returns true if the task can be attempted again after the given number of attempts
+func (p RetryPolicy) ShouldRetry(attempts int) bool {
+	return attempts < p.MaxAttempts
+}
+
+// Backoff returns the delay before the next attempt after the given number of attempts
+func (p RetryPolicy) Backoff(attempts int) time.Duration {
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+
+	delay := float64(p.InitialBackoff) * math.Pow(multiplier, float64(attempts-1))
+	if p.MaxBackoff > 0 && delay > float64(p.MaxBackoff) {
+		delay = float64(p.MaxBackoff)
+	}
+
+	if p.Jitter > 0 {
+		//nolint: gosec // this random value is not involved in any security related logic
+		delay *= 1 + p.Jitter*(2*rand.Float64()-1)
+	}
+
+	if delay > math.MaxInt64 {
+		return time.Duration(math.MaxInt64)
+	}
+
+	return time.Duration(delay)
+}
+
+// permanentError marks an error as permanent, see Permanent
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent wraps the given error and marks it as permanent.
+// A task that failed with a permanent error is never retried regardless of its retry policy.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err: err}
+}
+
+// IsPermanent returns true if the error was marked as permanent using Permanent.
+// ErrTaskFailed is always considered permanent.
+func IsPermanent(err error) bool {
+	if errors.Is(err, ErrTaskFailed) {
+		return true
+	}
+	var perr permanentError
+	return errors.As(err, &perr)
+}
@@ -0,0 +1,168 @@
+package queue
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	cases := []struct {
+		name     string
+		policy   RetryPolicy
+		attempts int
+		expected time.Duration
+	}{
+		{
+			name:     "constant backoff without a multiplier",
+			policy:   RetryPolicy{InitialBackoff: time.Second},
+			attempts: 5,
+			expected: time.Second,
+		},
+		{
+			name:     "first retry uses the initial backoff",
+			policy:   RetryPolicy{InitialBackoff: time.Second, Multiplier: 2},
+			attempts: 1,
+			expected: time.Second,
+		},
+		{
+			name:     "zero attempts are treated as the first attempt",
+			policy:   RetryPolicy{InitialBackoff: time.Second, Multiplier: 2},
+			attempts: 0,
+			expected: time.Second,
+		},
+		{
+			name:     "exponential backoff grows with attempts",
+			policy:   RetryPolicy{InitialBackoff: time.Second, Multiplier: 2},
+			attempts: 4,
+			expected: 8 * time.Second,
+		},
+		{
+			name:     "backoff is limited by the max backoff",
+			policy:   RetryPolicy{InitialBackoff: time.Second, Multiplier: 2, MaxBackoff: 5 * time.Second},
+			attempts: 4,
+			expected: 5 * time.Second,
+		},
+		{
+			name:     "backoff does not overflow",
+			policy:   RetryPolicy{InitialBackoff: time.Hour, Multiplier: 10},
+			attempts: 100,
+			expected: time.Duration(1<<63 - 1),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expected, tc.policy.Backoff(tc.attempts))
+		})
+	}
+
+	t.Run("jitter randomizes the backoff within the range", func(t *testing.T) {
+		policy := RetryPolicy{InitialBackoff: 10 * time.Second, Jitter: 0.5}
+		for i := 0; i < 100; i++ {
+			delay := policy.Backoff(1)
+			require.GreaterOrEqual(t, int64(delay), int64(5*time.Second))
+			require.LessOrEqual(t, int64(delay), int64(15*time.Second))
+		}
+	})
+}
+
+func TestRetryPolicyShouldRetry(t *testing.T) {
+	require.False(t, RetryPolicy{}.ShouldRetry(1))
+	require.False(t, RetryPolicy{MaxAttempts: 1}.ShouldRetry(1))
+	require.True(t, RetryPolicy{MaxAttempts: 3}.ShouldRetry(2))
+	require.False(t, RetryPolicy{MaxAttempts: 3}.ShouldRetry(3))
+}
+
+func TestRetryPolicyValidate(t *testing.T) {
+	cases := []struct {
+		name     string
+		policy   RetryPolicy
+		expError string
+	}{
+		{
+			name:   "valid policy",
+			policy: RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Second, Multiplier: 2, Jitter: 0.1},
+		},
+		{
+			name:     "negative max attempts",
+			policy:   RetryPolicy{MaxAttempts: -1},
+			expError: "invalid retry policy: max attempts can not be negative",
+		},
+		{
+			name:     "negative backoff",
+			policy:   RetryPolicy{InitialBackoff: -time.Second},
+			expError: "invalid retry policy: initial backoff can not be negative",
+		},
+		{
+			name:     "jitter out of range",
+			policy:   RetryPolicy{Jitter: 1.5},
+			expError: "invalid retry policy: jitter must be in the range [0, 1]",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.policy.Validate()
+			if tc.expError != "" {
+				require.EqualError(t, err, tc.expError)
+				require.True(t, errors.Is(err, ErrInvalidRetryPolicy))
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestIsPermanent(t *testing.T) {
+	cases := []struct {
+		name      string
+		err       error
+		permanent bool
+	}{
+		{
+			name: "regular errors are not permanent",
+			err:  errors.New("some error"),
+		},
+		{
+			name: "nil is not permanent",
+		},
+		{
+			name:      "ErrTaskFailed is permanent",
+			err:       ErrTaskFailed,
+			permanent: true,
+		},
+		{
+			name:      "wrapped ErrTaskFailed is permanent",
+			err:       fmt.Errorf("can not process: %w", ErrTaskFailed),
+			permanent: true,
+		},
+		{
+			name:      "errors marked as permanent are permanent",
+			err:       Permanent(errors.New("some error")),
+			permanent: true,
+		},
+		{
+			name:      "wrapped permanent errors are permanent",
+			err:       fmt.Errorf("can not process: %w", Permanent(errors.New("some error"))),
+			permanent: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.permanent, IsPermanent(tc.err))
+		})
+	}
+
+	t.Run("permanent error keeps the original message and cause", func(t *testing.T) {
+		cause := errors.New("some error")
+		err := Permanent(cause)
+		require.EqualError(t, err, "some error")
+		require.True(t, errors.Is(err, cause))
+		require.Nil(t, Permanent(nil))
+	})
+}
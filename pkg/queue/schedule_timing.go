@@ -0,0 +1,265 @@
+package queue
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrInvalidSchedule indicates that the schedule timing settings are not valid
+var ErrInvalidSchedule = errors.New("invalid schedule")
+
+const (
+	// MisfireThreshold is how late an execution can be enqueued before it's considered missed,
+	// e.g. because all the schedule workers were down
+	MisfireThreshold = time.Minute
+	// DefaultMisfireLimit is the number of the latest missed executions enqueued by MisfireFireAll
+	// when the schedule doesn't set the limit
+	DefaultMisfireLimit = 100
+)
+
+// MisfirePolicy defines how a schedule handles the missed executions, see MisfireThreshold
+type MisfirePolicy string
+
+const (
+	// MisfireFireOnce enqueues a single task for the latest missed execution,
+	// the older missed executions are skipped. It's the default policy.
+	MisfireFireOnce MisfirePolicy = "fire_once"
+	// MisfireFireAll enqueues a task for every missed execution, at most
+	// for the latest `MisfireLimit` of them.
+	MisfireFireAll MisfirePolicy = "fire_all"
+	// MisfireSkip skips all the missed executions, the next task is enqueued
+	// at the next execution time.
+	MisfireSkip MisfirePolicy = "skip"
+)
+
+// IsValid returns true if the policy is known, the empty policy is MisfireFireOnce
+func (p MisfirePolicy) IsValid() bool {
+	switch p {
+	case "", MisfireFireOnce, MisfireFireAll, MisfireSkip:
+		return true
+	}
+	return false
+}
+
+// ScheduleTiming defines when a schedule enqueues its task.
+//
+// A schedule is one of:
+//   - a cron schedule, `CronSchedule` is set;
+//   - an interval schedule, `Interval` is set;
+//   - a one-shot schedule, neither is set, the task is enqueued once.
+//
+// The first task is enqueued at `ValidFrom` or right away when it's not set,
+// the following ones according to the cron expression or the interval
+// until `ValidUntil` or until the schedule is executed `MaxExecutions` times.
+// The executions missed while the schedule workers were down are handled
+// according to `MisfirePolicy`.
+type ScheduleTiming struct {
+	// CronSchedule is the cron expression, see TaskScheduleRequest.CronSchedule
+	CronSchedule string
+	// Timezone is the IANA time zone name the cron expression is evaluated in
+	Timezone string
+	// Interval is the fixed time between two executions
+	Interval time.Duration
+	// ValidFrom is the earliest execution time, the zero value means there is no limit
+	ValidFrom time.Time
+	// ValidUntil is the latest execution time, the zero value means there is no limit
+	ValidUntil time.Time
+	// MaxExecutions is the maximum number of executions, zero means there is no limit
+	MaxExecutions int
+	// MisfirePolicy defines how the missed executions are handled, empty means MisfireFireOnce
+	MisfirePolicy MisfirePolicy
+	// MisfireLimit is the maximum number of the missed executions enqueued by MisfireFireAll,
+	// zero means DefaultMisfireLimit
+	MisfireLimit int
+}
+
+// Validate returns an error if the timing settings are not valid
+func (t ScheduleTiming) Validate() error {
+	_, err := LoadTimezone(t.Timezone)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case t.CronSchedule != "" && t.Interval != 0:
+		return fmt.Errorf("%w: cron schedule and interval can not be used together", ErrInvalidSchedule)
+	case t.Interval < 0:
+		return fmt.Errorf("%w: interval can not be negative", ErrInvalidSchedule)
+	case t.Interval > 0 && t.Interval < time.Second:
+		return fmt.Errorf("%w: interval can not be shorter than a second", ErrInvalidSchedule)
+	case t.MaxExecutions < 0:
+		return fmt.Errorf("%w: max executions can not be negative", ErrInvalidSchedule)
+	case !t.ValidFrom.IsZero() && !t.ValidUntil.IsZero() && t.ValidUntil.Before(t.ValidFrom):
+		return fmt.Errorf("%w: valid until can not be before valid from", ErrInvalidSchedule)
+	case !t.MisfirePolicy.IsValid():
+		return fmt.Errorf("%w: unknown misfire policy %q", ErrInvalidSchedule, t.MisfirePolicy)
+	case t.MisfireLimit < 0:
+		return fmt.Errorf("%w: misfire limit can not be negative", ErrInvalidSchedule)
+	}
+
+	if t.CronSchedule != "" {
+		_, err = ParseCronSchedule(t.CronSchedule, t.Timezone)
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidSchedule, err)
+		}
+	}
+
+	return nil
+}
+
+// FirstExecution returns the execution time of the new schedule,
+// nil means the schedule is never going to enqueue a task.
+func (t ScheduleTiming) FirstExecution(now time.Time) *time.Time {
+	first := now
+	if t.ValidFrom.After(now) {
+		first = t.ValidFrom
+	}
+
+	return t.bounded(first)
+}
+
+// Fire returns the execution times of the due schedule the tasks are enqueued for
+// and the execution time following them, the executions are the number of executions
+// of the schedule so far.
+//
+// All the execution times from the due one until now are due, the ones older
+// than MisfireThreshold are missed and handled according to the misfire policy.
+// Nil next execution time means the schedule is not going to enqueue tasks anymore.
+func (t ScheduleTiming) Fire(due time.Time, executions int, now time.Time) (fire []time.Time, next *time.Time, err error) {
+	if t.MaxExecutions > 0 && executions >= t.MaxExecutions {
+		return nil, nil, nil
+	}
+
+	step, err := t.step()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	limit := 1
+	if t.MisfirePolicy == MisfireFireAll {
+		limit = t.MisfireLimit
+		if limit == 0 {
+			limit = DefaultMisfireLimit
+		}
+	}
+
+	execution := due
+	if t.Interval > 0 {
+		// jump over the interval executions that are not kept anyway
+		end := now
+		if !t.ValidUntil.IsZero() && t.ValidUntil.Before(end) {
+			end = t.ValidUntil
+		}
+		skipped := end.Sub(execution)/t.Interval - time.Duration(limit)
+		if skipped > 0 {
+			execution = execution.Add(skipped * t.Interval)
+		}
+	}
+
+	for {
+		if !t.ValidUntil.IsZero() && execution.After(t.ValidUntil) {
+			next = nil
+			break
+		}
+		if execution.After(now) {
+			next = &execution
+			break
+		}
+
+		// only the latest due executions are kept
+		fire = append(fire, execution)
+		if len(fire) > limit {
+			fire = fire[1:]
+		}
+
+		execution = step(execution)
+		if execution.IsZero() {
+			next = nil
+			break
+		}
+	}
+
+	if t.MisfirePolicy == MisfireSkip {
+		onTime := fire[:0]
+		for _, execution := range fire {
+			if !execution.Before(now.Add(-MisfireThreshold)) {
+				onTime = append(onTime, execution)
+			}
+		}
+		fire = onTime
+	}
+
+	if t.MaxExecutions > 0 && executions+len(fire) >= t.MaxExecutions {
+		fire = fire[:t.MaxExecutions-executions]
+		next = nil
+	}
+
+	return fire, next, nil
+}
+
+// step returns the function calculating the execution time following the given one,
+// the zero time means there is no following execution
+func (t ScheduleTiming) step() (func(time.Time) time.Time, error) {
+	switch {
+	case t.CronSchedule != "":
+		schedule, err := ParseCronSchedule(t.CronSchedule, t.Timezone)
+		if err != nil {
+			return nil, err
+		}
+		return schedule.Next, nil
+	case t.Interval > 0:
+		return func(previous time.Time) time.Time {
+			return previous.Add(t.Interval)
+		}, nil
+	default:
+		// one-shot schedules are executed only once
+		return func(time.Time) time.Time {
+			return time.Time{}
+		}, nil
+	}
+}
+
+// Reschedule returns the execution time of the schedule after its timing settings are changed,
+// the executions are the number of executions of the schedule so far.
+// Unlike the new schedule, the changed one is not executed right away:
+// the next execution is at `ValidFrom` if it's in the future, otherwise it's the next time
+// matching the cron expression or one interval from now.
+// A one-shot schedule is executed right away only if it has not been executed yet.
+// Nil means the schedule is not going to enqueue tasks anymore.
+func (t ScheduleTiming) Reschedule(executions int, now time.Time) (*time.Time, error) {
+	if t.MaxExecutions > 0 && executions >= t.MaxExecutions {
+		return nil, nil
+	}
+
+	if t.ValidFrom.After(now) {
+		return t.bounded(t.ValidFrom), nil
+	}
+
+	switch {
+	case t.CronSchedule != "":
+		schedule, err := ParseCronSchedule(t.CronSchedule, t.Timezone)
+		if err != nil {
+			return nil, err
+		}
+		next := schedule.Next(now)
+		if next.IsZero() {
+			return nil, nil
+		}
+		return t.bounded(next), nil
+	case t.Interval > 0:
+		return t.bounded(now.Add(t.Interval)), nil
+	case executions == 0:
+		return t.bounded(now), nil
+	default:
+		return nil, nil
+	}
+}
+
+// bounded returns the execution time if it's within the validity period
+func (t ScheduleTiming) bounded(execution time.Time) *time.Time {
+	if !t.ValidUntil.IsZero() && execution.After(t.ValidUntil) {
+		return nil
+	}
+	return &execution
+}
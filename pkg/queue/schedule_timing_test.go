@@ -0,0 +1,192 @@
+package queue
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestScheduleTiming(t *testing.T) {
+	now := time.Date(2021, time.January, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("validates the settings", func(t *testing.T) {
+		cases := []struct {
+			name   string
+			timing ScheduleTiming
+			valid  bool
+		}{
+			{name: "one-shot", timing: ScheduleTiming{}, valid: true},
+			{name: "cron", timing: ScheduleTiming{CronSchedule: "0 8 * * *"}, valid: true},
+			{name: "interval", timing: ScheduleTiming{Interval: 90 * time.Second}, valid: true},
+			{name: "bounds", timing: ScheduleTiming{ValidFrom: now, ValidUntil: now.Add(time.Hour), MaxExecutions: 3}, valid: true},
+			{name: "misfire policy", timing: ScheduleTiming{Interval: time.Hour, MisfirePolicy: MisfireFireAll, MisfireLimit: 10}, valid: true},
+			{name: "cron and interval", timing: ScheduleTiming{CronSchedule: "0 8 * * *", Interval: time.Hour}},
+			{name: "negative interval", timing: ScheduleTiming{Interval: -time.Hour}},
+			{name: "short interval", timing: ScheduleTiming{Interval: time.Millisecond}},
+			{name: "negative max executions", timing: ScheduleTiming{MaxExecutions: -1}},
+			{name: "valid until before valid from", timing: ScheduleTiming{ValidFrom: now, ValidUntil: now.Add(-time.Hour)}},
+			{name: "unknown misfire policy", timing: ScheduleTiming{MisfirePolicy: "unknown"}},
+			{name: "negative misfire limit", timing: ScheduleTiming{MisfirePolicy: MisfireFireAll, MisfireLimit: -1}},
+		}
+
+		for _, tc := range cases {
+			t.Run(tc.name, func(t *testing.T) {
+				err := tc.timing.Validate()
+				if tc.valid {
+					require.NoError(t, err)
+					return
+				}
+				require.True(t, errors.Is(err, ErrInvalidSchedule), err)
+			})
+		}
+	})
+
+	t.Run("first execution is at valid from or right away", func(t *testing.T) {
+		require.Equal(t, now, *ScheduleTiming{}.FirstExecution(now))
+		require.Equal(t, now, *ScheduleTiming{ValidFrom: now.Add(-time.Hour)}.FirstExecution(now))
+		require.Equal(t, now.Add(time.Hour), *ScheduleTiming{ValidFrom: now.Add(time.Hour)}.FirstExecution(now))
+		require.Nil(t, ScheduleTiming{ValidUntil: now.Add(-time.Hour)}.FirstExecution(now))
+	})
+
+	t.Run("one-shot schedule is executed once", func(t *testing.T) {
+		fire, next, err := ScheduleTiming{}.Fire(now, 0, now)
+		require.NoError(t, err)
+		require.Equal(t, []time.Time{now}, fire)
+		require.Nil(t, next)
+	})
+
+	t.Run("interval schedule is executed once per interval", func(t *testing.T) {
+		fire, next, err := ScheduleTiming{Interval: 90 * time.Second}.Fire(now, 0, now.Add(time.Second))
+		require.NoError(t, err)
+		require.Equal(t, []time.Time{now}, fire)
+		require.Equal(t, now.Add(90*time.Second), *next)
+	})
+
+	t.Run("cron schedule is executed at the matching time", func(t *testing.T) {
+		fire, next, err := ScheduleTiming{CronSchedule: "0 8 * * *", Timezone: "UTC"}.Fire(now, 0, now)
+		require.NoError(t, err)
+		require.Equal(t, []time.Time{now}, fire)
+		require.Equal(t, time.Date(2021, time.January, 2, 8, 0, 0, 0, time.UTC), next.UTC())
+	})
+
+	t.Run("missed executions are handled according to the misfire policy", func(t *testing.T) {
+		// the hourly schedule was due 3.5 hours ago
+		due := now.Add(-210 * time.Minute)
+		at := func(minutes ...int) []time.Time {
+			times := []time.Time{}
+			for _, m := range minutes {
+				times = append(times, now.Add(time.Duration(m)*time.Minute))
+			}
+			return times
+		}
+
+		cases := []struct {
+			name    string
+			timing  ScheduleTiming
+			now     time.Time
+			expFire []time.Time
+		}{
+			{
+				name:    "fire once by default",
+				timing:  ScheduleTiming{Interval: time.Hour},
+				expFire: at(-30),
+			},
+			{
+				name:    "fire once",
+				timing:  ScheduleTiming{Interval: time.Hour, MisfirePolicy: MisfireFireOnce},
+				expFire: at(-30),
+			},
+			{
+				name:    "fire all",
+				timing:  ScheduleTiming{Interval: time.Hour, MisfirePolicy: MisfireFireAll},
+				expFire: at(-210, -150, -90, -30),
+			},
+			{
+				name:    "fire all with the limit",
+				timing:  ScheduleTiming{Interval: time.Hour, MisfirePolicy: MisfireFireAll, MisfireLimit: 2},
+				expFire: at(-90, -30),
+			},
+			{
+				name:    "fire all within the max executions",
+				timing:  ScheduleTiming{Interval: time.Hour, MisfirePolicy: MisfireFireAll, MaxExecutions: 2},
+				expFire: at(-210, -150),
+			},
+			{
+				name:    "fire all within the validity period",
+				timing:  ScheduleTiming{Interval: time.Hour, MisfirePolicy: MisfireFireAll, ValidUntil: now.Add(-time.Hour)},
+				expFire: at(-210, -150, -90),
+			},
+			{
+				name:    "skip",
+				timing:  ScheduleTiming{Interval: time.Hour, MisfirePolicy: MisfireSkip},
+				expFire: at(),
+			},
+			{
+				name:    "cron fire all",
+				timing:  ScheduleTiming{CronSchedule: "0 * * * *", Timezone: "UTC", MisfirePolicy: MisfireFireAll},
+				expFire: at(-210, -180, -120, -60, 0),
+			},
+		}
+
+		for _, tc := range cases {
+			t.Run(tc.name, func(t *testing.T) {
+				fire, _, err := tc.timing.Fire(due, 0, now)
+				require.NoError(t, err)
+				require.Equal(t, tc.expFire, fire)
+			})
+		}
+
+		// the execution delayed less than the threshold is not skipped
+		fire, next, err := ScheduleTiming{Interval: time.Hour, MisfirePolicy: MisfireSkip}.Fire(now.Add(-time.Second), 0, now)
+		require.NoError(t, err)
+		require.Equal(t, []time.Time{now.Add(-time.Second)}, fire)
+		require.Equal(t, now.Add(time.Hour-time.Second), *next)
+
+		// the skipped executions move the next execution time too
+		_, next, err = ScheduleTiming{Interval: time.Hour, MisfirePolicy: MisfireSkip}.Fire(due, 0, now)
+		require.NoError(t, err)
+		require.Equal(t, now.Add(30*time.Minute), *next)
+	})
+
+	t.Run("changed schedule is not executed right away", func(t *testing.T) {
+		next, err := ScheduleTiming{Interval: time.Hour}.Reschedule(1, now)
+		require.NoError(t, err)
+		require.Equal(t, now.Add(time.Hour), *next)
+
+		next, err = ScheduleTiming{CronSchedule: "0 8 * * *", Timezone: "UTC"}.Reschedule(1, now)
+		require.NoError(t, err)
+		require.Equal(t, time.Date(2021, time.January, 2, 8, 0, 0, 0, time.UTC), next.UTC())
+
+		next, err = ScheduleTiming{Interval: time.Hour, ValidFrom: now.Add(time.Minute)}.Reschedule(1, now)
+		require.NoError(t, err)
+		require.Equal(t, now.Add(time.Minute), *next)
+
+		next, err = ScheduleTiming{Interval: time.Hour, MaxExecutions: 1}.Reschedule(1, now)
+		require.NoError(t, err)
+		require.Nil(t, next)
+	})
+
+	t.Run("changed one-shot schedule is executed only if it was not executed yet", func(t *testing.T) {
+		next, err := ScheduleTiming{}.Reschedule(0, now)
+		require.NoError(t, err)
+		require.Equal(t, now, *next)
+
+		next, err = ScheduleTiming{}.Reschedule(1, now)
+		require.NoError(t, err)
+		require.Nil(t, next)
+	})
+
+	t.Run("schedule stops at the bounds", func(t *testing.T) {
+		fire, next, err := ScheduleTiming{Interval: time.Hour, MaxExecutions: 2}.Fire(now, 1, now)
+		require.NoError(t, err)
+		require.Equal(t, []time.Time{now}, fire)
+		require.Nil(t, next)
+
+		fire, next, err = ScheduleTiming{Interval: time.Hour, ValidUntil: now.Add(30 * time.Minute)}.Fire(now, 1, now)
+		require.NoError(t, err)
+		require.Equal(t, []time.Time{now}, fire)
+		require.Nil(t, next)
+	})
+}
@@ -3,20 +3,257 @@ package queue
 import (
 	"context"
 	"errors"
+	"fmt"
+	"time"
 
+	"github.com/contiamo/go-base/v4/pkg/data/managers"
 	cdb "github.com/contiamo/go-base/v4/pkg/db"
+	"github.com/contiamo/go-base/v4/pkg/http/parameters"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
-// ErrNotScheduled indicates the current scheduled task is has not been created yet. This should
-// be returned from the EnsureSchedule method
-var ErrNotScheduled = errors.New("Task not currently scheduled")
+var (
+	// ErrNotScheduled indicates the current scheduled task is has not been created yet. This should
+	// be returned from the EnsureSchedule method
+	ErrNotScheduled = errors.New("Task not currently scheduled")
+	// ErrScheduleNotFound indicates that the schedule with the given ID does not exist
+	ErrScheduleNotFound = errors.New("schedule not found")
+)
+
+// OverlapPolicy defines what happens when a schedule is due while its previous task
+// is still waiting or running
+type OverlapPolicy string
+
+const (
+	// OverlapAllow enqueues the new task regardless of the previous ones, it's the default policy
+	OverlapAllow OverlapPolicy = "allow"
+	// OverlapSkip skips the execution while a previous task of the schedule is not finished
+	OverlapSkip OverlapPolicy = "skip"
+	// OverlapCancel cancels the unfinished previous tasks of the schedule
+	// and enqueues the new task
+	OverlapCancel OverlapPolicy = "cancel"
+)
+
+// Validate returns ErrInvalidSchedule if the policy is unknown, the empty policy is OverlapAllow
+func (p OverlapPolicy) Validate() error {
+	switch p {
+	case "", OverlapAllow, OverlapSkip, OverlapCancel:
+		return nil
+	}
+	return fmt.Errorf("%w: unknown overlap policy %q", ErrInvalidSchedule, p)
+}
+
+// ValidateFor validates the policy and returns ErrInvalidSchedule if it can't be used
+// with the timing settings: OverlapSkip and OverlapCancel can not be used with MisfireFireAll,
+// every missed execution would overlap with the task enqueued for the previous one.
+func (p OverlapPolicy) ValidateFor(timing ScheduleTiming) error {
+	err := p.Validate()
+	if err != nil {
+		return err
+	}
+
+	if timing.MisfirePolicy == MisfireFireAll && (p == OverlapSkip || p == OverlapCancel) {
+		return fmt.Errorf("%w: overlap policy %q can not be used with misfire policy %q", ErrInvalidSchedule, p, timing.MisfirePolicy)
+	}
+
+	return nil
+}
+
+// Schedule represents a task schedule
+type Schedule struct {
+	TaskBase
+	// ID is the id of the schedule
+	ID string
+	// CronSchedule is the schedule expression in cron syntax that defines
+	// when the task should be executed, empty for interval and one-time schedules.
+	CronSchedule string
+	// Timezone is the IANA time zone name the cron schedule is evaluated in,
+	// empty for the local time zone of the server.
+	Timezone string
+	// Interval is the fixed time between two executions, zero for cron and one-time schedules
+	Interval time.Duration
+	// ValidFrom is the earliest execution time, nil if there is no limit
+	ValidFrom *time.Time
+	// ValidUntil is the latest execution time, nil if there is no limit
+	ValidUntil *time.Time
+	// MaxExecutions is the maximum number of executions, zero means there is no limit
+	MaxExecutions int
+	// MisfirePolicy defines how the missed executions are handled, empty means MisfireFireOnce
+	MisfirePolicy MisfirePolicy
+	// MisfireLimit is the maximum number of the missed executions enqueued by MisfireFireAll,
+	// zero means DefaultMisfireLimit
+	MisfireLimit int
+	// OverlapPolicy defines how the unfinished previous tasks are handled, empty means OverlapAllow
+	OverlapPolicy OverlapPolicy
+	// ExecutionCount is the number of executions of the schedule so far including
+	// the ones skipped by the overlap policy, the manually triggered tasks are not counted
+	ExecutionCount int
+	// NextExecutionTime is when the task is going to be enqueued next time,
+	// nil means the schedule is not going to enqueue tasks anymore.
+	NextExecutionTime *time.Time
+	// PausedAt is when the schedule was paused, nil if the schedule is active
+	PausedAt *time.Time
+	// CreatedAt is when the schedule was created
+	CreatedAt time.Time
+	// UpdatedAt is when the schedule was last updated
+	UpdatedAt time.Time
+}
+
+// Timing returns the timing settings of the schedule
+func (s Schedule) Timing() ScheduleTiming {
+	timing := ScheduleTiming{
+		CronSchedule:  s.CronSchedule,
+		Timezone:      s.Timezone,
+		Interval:      s.Interval,
+		MaxExecutions: s.MaxExecutions,
+		MisfirePolicy: s.MisfirePolicy,
+		MisfireLimit:  s.MisfireLimit,
+	}
+	if s.ValidFrom != nil {
+		timing.ValidFrom = *s.ValidFrom
+	}
+	if s.ValidUntil != nil {
+		timing.ValidUntil = *s.ValidUntil
+	}
+	return timing
+}
+
+// SetTiming replaces the timing settings of the schedule,
+// the next execution time is not changed
+func (s *Schedule) SetTiming(timing ScheduleTiming) {
+	s.CronSchedule = timing.CronSchedule
+	s.Timezone = timing.Timezone
+	s.Interval = timing.Interval
+	s.ValidFrom = nil
+	if !timing.ValidFrom.IsZero() {
+		validFrom := timing.ValidFrom
+		s.ValidFrom = &validFrom
+	}
+	s.ValidUntil = nil
+	if !timing.ValidUntil.IsZero() {
+		validUntil := timing.ValidUntil
+		s.ValidUntil = &validUntil
+	}
+	s.MaxExecutions = timing.MaxExecutions
+	s.MisfirePolicy = timing.MisfirePolicy
+	s.MisfireLimit = timing.MisfireLimit
+}
+
+// ScheduleUpdate contains the new settings of a schedule, the nil values are not changed
+type ScheduleUpdate struct {
+	// Spec is the new specification of the scheduled task
+	Spec Spec
+	// Priority is the new priority of the scheduled task
+	Priority *int
+	// Timing replaces all the timing settings of the schedule,
+	// the next execution time is calculated with ScheduleTiming.Reschedule
+	Timing *ScheduleTiming
+	// OverlapPolicy is the new overlap policy of the schedule
+	OverlapPolicy *OverlapPolicy
+}
+
+// Apply validates the update and changes the schedule accordingly
+func (u ScheduleUpdate) Apply(schedule *Schedule, now time.Time) error {
+	timing := schedule.Timing()
+	if u.Timing != nil {
+		timing = *u.Timing
+	}
+
+	overlapPolicy := schedule.OverlapPolicy
+	if u.OverlapPolicy != nil {
+		overlapPolicy = *u.OverlapPolicy
+	}
+
+	if u.Timing != nil || u.OverlapPolicy != nil {
+		err := overlapPolicy.ValidateFor(timing)
+		if err != nil {
+			return err
+		}
+	}
+
+	if u.Timing != nil {
+		err := u.Timing.Validate()
+		if err != nil {
+			return err
+		}
+
+		next, err := u.Timing.Reschedule(schedule.ExecutionCount, now)
+		if err != nil {
+			return err
+		}
+
+		schedule.SetTiming(*u.Timing)
+		schedule.NextExecutionTime = next
+	}
+
+	if u.OverlapPolicy != nil {
+		schedule.OverlapPolicy = *u.OverlapPolicy
+	}
+
+	if u.Spec != nil {
+		schedule.Spec = u.Spec
+	}
+
+	if u.Priority != nil {
+		schedule.Priority = *u.Priority
+	}
+
+	schedule.UpdatedAt = now
+	return nil
+}
+
+// ScheduleList is a page of schedules
+type ScheduleList struct {
+	// Items contains the schedules on the requested page
+	Items []Schedule
+	// PageInfo contains the pagination metadata
+	PageInfo managers.PageInfo
+}
+
+// ScheduleFilter narrows down the set of schedules, zero values are ignored
+type ScheduleFilter struct {
+	// Queue matches schedules of the tasks from the given queue
+	Queue string
+	// Type matches schedules of the tasks of the given type
+	Type TaskType
+	// References matches schedules with all the given reference values
+	References References
+}
+
+// ScheduleManager manages the existing schedules
+type ScheduleManager interface {
+	// GetSchedule returns the schedule with the given ID.
+	// ErrScheduleNotFound is returned if the schedule does not exist.
+	GetSchedule(ctx context.Context, scheduleID string) (*Schedule, error)
+	// ListSchedules returns the requested page of schedules matching the filter,
+	// the most recently created schedules come first.
+	ListSchedules(ctx context.Context, filter ScheduleFilter, page parameters.Page) (ScheduleList, error)
+	// UpdateSchedule changes the settings of the schedule and returns the updated schedule.
+	// ErrInvalidSchedule is returned if the new timing settings or overlap policy are not valid,
+	// ErrScheduleNotFound is returned if the schedule does not exist.
+	UpdateSchedule(ctx context.Context, scheduleID string, update ScheduleUpdate) (*Schedule, error)
+	// PauseSchedule stops the schedule from enqueuing tasks until it's resumed.
+	// Pausing an already paused schedule has no effect.
+	// ErrScheduleNotFound is returned if the schedule does not exist.
+	PauseSchedule(ctx context.Context, scheduleID string) error
+	// ResumeSchedule resumes the paused schedule.
+	// Resuming an active schedule has no effect.
+	// ErrScheduleNotFound is returned if the schedule does not exist.
+	ResumeSchedule(ctx context.Context, scheduleID string) error
+	// DeleteSchedule deletes the schedule.
+	// ErrScheduleNotFound is returned if the schedule does not exist.
+	DeleteSchedule(ctx context.Context, scheduleID string) error
+	// TriggerSchedule enqueues the scheduled task right away, it does not
+	// change the next execution time and works for paused schedules too.
+	// ErrScheduleNotFound is returned if the schedule does not exist.
+	TriggerSchedule(ctx context.Context, scheduleID string) error
+}
 
 // Scheduler defines how one schedules a task
 type Scheduler interface {
-	// Schedule creates a cron schedule according to which the worker will enqueue
-	// the given task.
-	// `task.CronSchedule` cannot be blank.
+	// Schedule creates a schedule according to which the worker will enqueue
+	// the given task, see ScheduleTiming for the supported kinds of schedules.
+	// ErrInvalidSchedule is returned if the timing settings or the overlap policy are not valid.
 	Schedule(ctx context.Context, builder cdb.SQLBuilder, task TaskScheduleRequest) error
 
 	// EnsureSchedule checks if a task for with the given queue, type, and references
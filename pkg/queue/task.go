@@ -33,6 +33,10 @@ type Progress []byte
 // The actual underlying type depends on the task type and consumers must serialize the value.
 type Spec []byte
 
+// Result is a serialized result object of the successfully finished task.
+// The actual underlying type depends on the task type and consumers must serialize the value.
+type Result []byte
+
 // TaskType is a type which specifies what a task it is
 type TaskType string
 
@@ -50,6 +54,8 @@ const (
 	Waiting TaskStatus = "waiting"
 	// Running task is currently running
 	Running TaskStatus = "running"
+	// Blocked task is waiting for the tasks it depends on to finish before it can be picked up
+	Blocked TaskStatus = "blocked"
 	// Cancelled task is canceled by the user
 	//nolint: misspell // the initial spelling was with double 'l' now we have to stick with it
 	Cancelled TaskStatus = "cancelled"
@@ -59,6 +65,11 @@ const (
 	Failed TaskStatus = "failed"
 )
 
+// IsFinal returns true if the task with this status is not going to be processed anymore
+func (s TaskStatus) IsFinal() bool {
+	return s == Finished || s == Failed || s == Cancelled
+}
+
 // TaskBase contains basic fields for a task
 type TaskBase struct {
 	// Queue is the queue to which the task belongs
@@ -67,6 +78,11 @@ type TaskBase struct {
 	Type TaskType
 	// Spec contains the task specification based on type of the task.
 	Spec Spec
+	// Priority defines the order in which the tasks are processed.
+	// Tasks with a higher priority are dequeued before tasks with a lower priority,
+	// tasks with the same priority are dequeued in the order they were created.
+	// The default priority is 0, negative values are allowed.
+	Priority int
 }
 
 // TaskEnqueueRequest contains fields required for adding a task to the queue
@@ -75,19 +91,82 @@ type TaskEnqueueRequest struct {
 	// References contain names and values for additinal
 	// SQL columns to set external references for a task for easy clean up
 	References References
+	// NotBefore is the earliest time when the task can be dequeued.
+	// The zero value means the task can be dequeued immediately.
+	NotBefore time.Time
+	// RetryPolicy defines how the task is retried after a failed attempt.
+	// If nil, the default policy for the task type is used, if any.
+	RetryPolicy *RetryPolicy
+	// DependsOn contains the IDs of the tasks that must finish before this task can be dequeued.
+	// If any of them fails or is cancelled, this task is cancelled too.
+	DependsOn []string
+	// WorkflowID groups the related tasks, e.g. the steps of a pipeline,
+	// so their status can be queried together, see Inspector.GetWorkflow
+	WorkflowID string
+	// IdempotencyKey identifies the logical work of the task within its queue.
+	// When a task with the same key is not finished yet, the enqueue is deduplicated
+	// and returns the ID of the existing task instead of adding a new one.
+	// An empty key disables deduplication.
+	IdempotencyKey string
+	// ScheduledAt is the execution time of the schedule the task is enqueued for,
+	// it's set by the schedule workers and it's earlier than the enqueue time
+	// for the missed executions. The zero value means the task is not scheduled.
+	ScheduledAt time.Time
 }
 
 // TaskScheduleRequest contains fields required for scheduling a task
 type TaskScheduleRequest struct {
 	TaskBase
 	// CronSchedule is the schedule impression in cron syntax that defines
-	// when the task should be executed.
+	// when the task should be executed. The expression can have the leading seconds field,
+	// e.g. "*/30 * * * * *" runs every 30 seconds.
 	CronSchedule string
+	// Timezone is the IANA time zone name, e.g. "Europe/Berlin", the cron schedule
+	// is evaluated in this time zone, see CronSchedule.Next for the daylight saving time handling.
+	// The empty value means the local time zone of the server.
+	Timezone string
+	// Interval is the fixed time between two executions, e.g. 90 seconds,
+	// it can't be used together with CronSchedule.
+	// When neither is set the schedule enqueues the task only once.
+	Interval time.Duration
+	// ValidFrom is when the schedule enqueues the first task,
+	// the zero value means the first task is enqueued right away.
+	// One-shot schedules use it as the execution time.
+	ValidFrom time.Time
+	// ValidUntil is the time after which the schedule doesn't enqueue tasks anymore,
+	// the zero value means there is no end.
+	ValidUntil time.Time
+	// MaxExecutions is the maximum number of executions of the schedule,
+	// zero means there is no limit.
+	MaxExecutions int
+	// MisfirePolicy defines how the executions missed while the schedule workers
+	// were down are handled, the empty value means MisfireFireOnce.
+	MisfirePolicy MisfirePolicy
+	// MisfireLimit is the maximum number of the missed executions enqueued by MisfireFireAll,
+	// zero means DefaultMisfireLimit.
+	MisfireLimit int
+	// OverlapPolicy defines what happens when the schedule is due while its previous task
+	// is still waiting or running, the empty value means OverlapAllow.
+	OverlapPolicy OverlapPolicy
 	// References contain names and values for additinal
 	// SQL columns to set external references for a schedule for easy clean up
 	References References
 }
 
+// Timing returns the timing settings of the schedule
+func (t TaskScheduleRequest) Timing() ScheduleTiming {
+	return ScheduleTiming{
+		CronSchedule:  t.CronSchedule,
+		Timezone:      t.Timezone,
+		Interval:      t.Interval,
+		ValidFrom:     t.ValidFrom,
+		ValidUntil:    t.ValidUntil,
+		MaxExecutions: t.MaxExecutions,
+		MisfirePolicy: t.MisfirePolicy,
+		MisfireLimit:  t.MisfireLimit,
+	}
+}
+
 // Task represents a task in the queue
 type Task struct {
 	TaskBase
@@ -103,10 +182,34 @@ type Task struct {
 	CreatedAt time.Time
 	// CreatedAt is when the task was initially put in the queue
 	UpdatedAt time.Time
+	// NotBefore is the earliest time when the task can be picked up by a worker
+	NotBefore time.Time
 	// StartedAt is when a worker picked up the task
 	StartedAt *time.Time
 	// FinishedAt is when the task was finished being processed
 	FinishedAt *time.Time
 	// LastHeartbeat provides a way to ensure the task is still being processed and hasn't failed.
 	LastHeartbeatAt *time.Time
+	// Attempts is the number of times the task has been picked up by a worker
+	Attempts int
+	// LastError is the error message of the last failed attempt
+	LastError string
+	// RetryPolicy defines how the task is retried after a failed attempt,
+	// nil means the task is never retried
+	RetryPolicy *RetryPolicy
+	// DeadLetteredAt is when the task used up all its attempts and was moved to the dead-letter queue
+	DeadLetteredAt *time.Time
+	// Result is the outcome of the successfully finished task, nil if the task
+	// has not finished yet or the handler did not produce a result
+	Result Result
+	// Error is the structured error of the last failed attempt, nil if the task has not failed
+	// since it was enqueued or retried
+	Error *TaskError
+	// DependsOn contains the IDs of the tasks that must finish before this task can be dequeued
+	DependsOn []string
+	// WorkflowID is the ID of the workflow the task belongs to, empty if it does not belong to any
+	WorkflowID string
+	// ScheduledAt is the execution time of the schedule the task was enqueued for,
+	// nil if the task was not enqueued by a schedule
+	ScheduledAt *time.Time
 }
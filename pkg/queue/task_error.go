@@ -0,0 +1,65 @@
+package queue
+
+import (
+	"errors"
+	"fmt"
+
+	pkgerrors "github.com/pkg/errors"
+)
+
+// TaskError is a structured description of the error a task attempt has failed with
+type TaskError struct {
+	// Message is the error message
+	Message string `json:"message"`
+	// Type is the Go type of the root cause, e.g. `*net.OpError`
+	Type string `json:"type"`
+	// Causes contains the messages of the wrapped errors, the outermost first
+	Causes []string `json:"causes,omitempty"`
+	// Stack is the stack trace of the root cause, if it has one.
+	// Only the errors created with github.com/pkg/errors have a stack trace.
+	Stack string `json:"stack,omitempty"`
+}
+
+// Error implements the error interface
+func (e TaskError) Error() string {
+	return e.Message
+}
+
+// stackTracer is implemented by the errors of github.com/pkg/errors
+type stackTracer interface {
+	StackTrace() pkgerrors.StackTrace
+}
+
+// NewTaskError creates a structured description of the error, nil is returned for nil errors
+func NewTaskError(err error) *TaskError {
+	if err == nil {
+		return nil
+	}
+
+	taskErr := &TaskError{
+		Message: err.Error(),
+	}
+
+	root, last := err, taskErr.Message
+	for cause := errors.Unwrap(err); cause != nil; cause = errors.Unwrap(cause) {
+		root = cause
+		// the wrappers that only add a stack trace repeat the message of the wrapped error
+		if cause.Error() == last {
+			continue
+		}
+		last = cause.Error()
+		taskErr.Causes = append(taskErr.Causes, last)
+	}
+
+	// the deepest stack trace points to where the error has originated
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		tracer, ok := e.(stackTracer)
+		if ok {
+			taskErr.Stack = fmt.Sprintf("%+v", tracer.StackTrace())
+		}
+	}
+
+	taskErr.Type = fmt.Sprintf("%T", root)
+
+	return taskErr
+}
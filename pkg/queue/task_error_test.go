@@ -0,0 +1,40 @@
+package queue
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	pkgerrors "github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewTaskError(t *testing.T) {
+	t.Run("returns nil for nil errors", func(t *testing.T) {
+		require.Nil(t, NewTaskError(nil))
+	})
+
+	t.Run("describes a plain error", func(t *testing.T) {
+		taskErr := NewTaskError(errors.New("boom"))
+		require.Equal(t, &TaskError{Message: "boom", Type: "*errors.errorString"}, taskErr)
+	})
+
+	t.Run("lists the wrapped errors and the type of the root cause", func(t *testing.T) {
+		err := fmt.Errorf("can not process: %w", Permanent(ErrTaskFailed))
+		taskErr := NewTaskError(err)
+		require.Equal(t, &TaskError{
+			Message: "can not process: task failed",
+			Type:    "*errors.errorString",
+			Causes:  []string{"task failed"},
+		}, taskErr)
+	})
+
+	t.Run("keeps the stack trace of the root cause", func(t *testing.T) {
+		err := pkgerrors.Wrap(pkgerrors.New("boom"), "can not process")
+		taskErr := NewTaskError(err)
+		require.Equal(t, "can not process: boom", taskErr.Message)
+		require.Equal(t, []string{"boom"}, taskErr.Causes)
+		require.Equal(t, "*errors.fundamental", taskErr.Type)
+		require.Contains(t, taskErr.Stack, "TestNewTaskError")
+	})
+}
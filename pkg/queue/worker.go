@@ -8,3 +8,11 @@ type Worker interface {
 	// It should run continuously or until the context is canceled
 	Work(context.Context) error
 }
+
+// Drainer is implemented by workers that can be stopped gracefully
+type Drainer interface {
+	// Drain stops dequeuing new tasks and waits until the tasks that are being processed
+	// are finished, after that the `Work` calls return without an error.
+	// It returns the context error if the waiting time is over before the work is done.
+	Drain(context.Context) error
+}
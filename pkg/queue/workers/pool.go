@@ -0,0 +1,296 @@
+package workers
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/contiamo/go-base/v4/pkg/queue"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrInvalidPoolSize is returned when the pool size is negative
+	ErrInvalidPoolSize = errors.New("worker pool size can not be negative")
+	// ErrPoolRunning is returned when the pool is started while it's already running
+	ErrPoolRunning = errors.New("worker pool is already running")
+)
+
+// WorkerState is the state of a pool worker reported by queue.TaskWorkerStateGauge
+type WorkerState string
+
+const (
+	// WorkerDequeueing means the worker is waiting for a new task
+	WorkerDequeueing WorkerState = "dequeueing"
+	// WorkerProcessing means the worker is processing a task
+	WorkerProcessing WorkerState = "processing"
+	// WorkerDraining means the worker finishes its current task and stops
+	WorkerDraining WorkerState = "draining"
+)
+
+// workerStates contains all the states reported for each worker
+var workerStates = []WorkerState{WorkerDequeueing, WorkerProcessing, WorkerDraining}
+
+// PoolOptions controls how the worker pool behaves.
+type PoolOptions struct {
+	// Options are used by every worker of the pool
+	Options
+	// Name identifies the pool in the metrics, it's required when a service runs several pools
+	Name string
+	// Size is the number of workers processing tasks at the same time
+	Size int
+	// ShutdownTimeout is how long the tasks being processed can take to finish
+	// after the context of `Work` is cancelled, the tasks are cancelled when the time is over.
+	// The zero value means the pool waits until the tasks are finished.
+	ShutdownTimeout time.Duration
+}
+
+// NewPool creates a pool of task workers processing the tasks of the same dequeuer
+// with the same handler. The handler must be safe for concurrent use.
+func NewPool(dequeuer queue.Dequeuer, handler queue.TaskHandler, opts PoolOptions) (*Pool, error) {
+	if opts.Size < 0 {
+		return nil, ErrInvalidPoolSize
+	}
+
+	return &Pool{
+		dequeuer: dequeuer,
+		handler:  handler,
+		opts:     opts,
+		size:     opts.Size,
+	}, nil
+}
+
+// Pool runs several task workers at the same time. It implements queue.Worker and queue.Drainer.
+//
+// When the context of `Work` is cancelled the pool stops dequeuing new tasks
+// and gives the tasks being processed `ShutdownTimeout` to finish, see also `Drain`.
+type Pool struct {
+	dequeuer queue.Dequeuer
+	handler  queue.TaskHandler
+	opts     PoolOptions
+
+	mu sync.Mutex
+	// size is the requested number of workers
+	size int
+	// workers are the running workers that are not stopped
+	workers []*taskWorker
+	// lastID is the ID of the last started worker
+	lastID int
+	// running is true between the start and the end of `Work`
+	running bool
+	// stopping is closed when the pool must stop dequeuing new tasks
+	stopping chan struct{}
+	// workCtx is the context of the running workers, it's cancelled to interrupt
+	// the tasks being processed
+	workCtx    context.Context
+	cancelWork context.CancelFunc
+	// done tracks the running workers
+	done sync.WaitGroup
+}
+
+// Work starts the workers and blocks until the pool is shut down
+// either by cancelling the context or by `Drain`.
+// Returns the context error if the context was cancelled.
+func (p *Pool) Work(ctx context.Context) error {
+	p.mu.Lock()
+	if p.running {
+		p.mu.Unlock()
+		return ErrPoolRunning
+	}
+	p.running = true
+	p.stopping = make(chan struct{})
+	// the workers are interrupted by the pool only, so the tasks can be finished after
+	// the context is cancelled
+	p.workCtx, p.cancelWork = context.WithCancel(detachedContext{ctx})
+	for len(p.workers) < p.size {
+		p.startWorker()
+	}
+	stopping := p.stopping
+	p.mu.Unlock()
+
+	logrus.Debug("worker pool is started")
+
+	var err error
+	select {
+	case <-stopping:
+	case <-ctx.Done():
+		err = ctx.Err()
+
+		shutdownCtx := context.Background()
+		if p.opts.ShutdownTimeout > 0 {
+			var cancel context.CancelFunc
+			shutdownCtx, cancel = context.WithTimeout(shutdownCtx, p.opts.ShutdownTimeout)
+			defer cancel()
+		}
+
+		e := p.shutdown(shutdownCtx)
+		if e != nil {
+			logrus.Errorf("worker pool shutdown: %s", e)
+		}
+	}
+
+	// wait for the workers stopped by `Drain` or `Resize`
+	p.done.Wait()
+
+	p.mu.Lock()
+	p.cancelWork()
+	p.running = false
+	p.mu.Unlock()
+
+	logrus.Debug("worker pool is stopped")
+
+	return err
+}
+
+// Drain stops dequeuing new tasks and waits until the tasks being processed are finished.
+// When the context is done before, the tasks are cancelled and the context error is returned.
+// `Work` returns after the pool is drained.
+func (p *Pool) Drain(ctx context.Context) error {
+	return p.shutdown(ctx)
+}
+
+// Resize changes the number of workers, the pool can be resized while it's running.
+// The removed workers finish their current tasks before they stop.
+func (p *Pool) Resize(size int) error {
+	if size < 0 {
+		return ErrInvalidPoolSize
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.size = size
+	if !p.running || isClosed(p.stopping) {
+		return nil
+	}
+
+	for len(p.workers) < size {
+		p.startWorker()
+	}
+
+	for _, w := range p.workers[size:] {
+		w.stop()
+	}
+	p.workers = p.workers[:size]
+
+	return nil
+}
+
+// Size returns the requested number of workers
+func (p *Pool) Size() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.size
+}
+
+// shutdown stops all the workers and waits until they are stopped,
+// the tasks are cancelled when the context is done
+func (p *Pool) shutdown(ctx context.Context) error {
+	p.mu.Lock()
+	if !p.running {
+		p.mu.Unlock()
+		return nil
+	}
+	if !isClosed(p.stopping) {
+		close(p.stopping)
+	}
+	for _, w := range p.workers {
+		w.stop()
+	}
+	p.workers = nil
+	cancelWork := p.cancelWork
+	p.mu.Unlock()
+
+	stopped := make(chan struct{})
+	go func() {
+		p.done.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		logrus.Warn("worker pool shutdown timeout, cancelling the tasks")
+		cancelWork()
+		<-stopped
+		return ctx.Err()
+	}
+}
+
+// startWorker starts a new worker.
+// Must be called with the lock held.
+func (p *Pool) startWorker() {
+	p.lastID++
+	labels := prometheus.Labels{
+		"pool":   p.opts.Name,
+		"worker": strconv.Itoa(p.lastID),
+	}
+
+	w := newTaskWorker(p.dequeuer, p.handler, p.opts.Options)
+	w.onStateChange = func(state WorkerState) {
+		setWorkerState(labels, state)
+	}
+	p.workers = append(p.workers, w)
+
+	p.done.Add(1)
+	go func(ctx context.Context) {
+		defer p.done.Done()
+		defer deleteWorkerState(labels)
+
+		err := w.Work(ctx)
+		if err != nil && err != context.Canceled {
+			logrus.Errorf("pool worker %s: %s", labels["worker"], err)
+		}
+	}(p.workCtx)
+}
+
+// setWorkerState sets the gauge of the current state of the worker to 1 and the other ones to 0
+func setWorkerState(labels prometheus.Labels, current WorkerState) {
+	for _, state := range workerStates {
+		value := 0.0
+		if state == current {
+			value = 1
+		}
+		queue.TaskWorkerStateGauge.With(withState(labels, state)).Set(value)
+	}
+}
+
+// deleteWorkerState removes the gauges of the stopped worker
+func deleteWorkerState(labels prometheus.Labels) {
+	for _, state := range workerStates {
+		queue.TaskWorkerStateGauge.Delete(withState(labels, state))
+	}
+}
+
+func withState(labels prometheus.Labels, state WorkerState) prometheus.Labels {
+	return prometheus.Labels{
+		"pool":   labels["pool"],
+		"worker": labels["worker"],
+		"state":  string(state),
+	}
+}
+
+// isClosed returns true if the channel is closed
+func isClosed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+// detachedContext keeps the values of the parent context but is never cancelled
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (deadline time.Time, ok bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}                   { return nil }
+func (detachedContext) Err() error                              { return nil }
+func (c detachedContext) Value(key interface{}) interface{}     { return c.parent.Value(key) }
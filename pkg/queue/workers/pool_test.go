@@ -0,0 +1,393 @@
+package workers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/contiamo/go-base/v4/pkg/config"
+	dbtest "github.com/contiamo/go-base/v4/pkg/db/test"
+	"github.com/contiamo/go-base/v4/pkg/queue"
+	"github.com/contiamo/go-base/v4/pkg/queue/memory"
+	"github.com/contiamo/go-base/v4/pkg/queue/postgres"
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
+)
+
+func TestPool(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	logrus.SetOutput(io.Discard)
+	defer logrus.SetOutput(os.Stdout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	opts := Options{HeartbeatPeriod: 10 * time.Second}
+
+	// blockingHandler reports the started tasks and blocks them until they are released
+	blockingHandler := func(started chan<- string, release <-chan struct{}) queue.TaskHandler {
+		return queue.TaskHandlerFunc(func(ctx context.Context, task queue.Task, heartbeats chan<- queue.Progress) error {
+			defer close(heartbeats)
+			started <- task.ID
+
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-release:
+			}
+
+			heartbeats <- queue.Progress(`{"done":true}`)
+			return nil
+		})
+	}
+
+	// enqueue adds tasks to different queues, so they can be processed at the same time
+	enqueue := func(t *testing.T, q *memory.Queue, count int) (taskIDs []string) {
+		for i := 0; i < count; i++ {
+			taskID, err := q.Enqueue(ctx, queue.TaskEnqueueRequest{
+				TaskBase: queue.TaskBase{Queue: fmt.Sprintf("queue%d", i), Type: "test"},
+			})
+			require.NoError(t, err)
+			taskIDs = append(taskIDs, taskID)
+		}
+		return taskIDs
+	}
+
+	// receive waits for the given number of started tasks
+	receive := func(t *testing.T, started <-chan string, count int) {
+		for i := 0; i < count; i++ {
+			select {
+			case <-started:
+			case <-time.After(5 * time.Second):
+				require.Fail(t, "the task was not started")
+			}
+		}
+	}
+
+	requireStatus := func(t *testing.T, q *memory.Queue, status queue.TaskStatus, taskIDs ...string) {
+		for _, taskID := range taskIDs {
+			task, err := q.GetTask(ctx, taskID)
+			require.NoError(t, err)
+			require.Equal(t, status, task.Status)
+		}
+	}
+
+	stateGauge := func(pool string, worker int, state WorkerState) float64 {
+		return testutil.ToFloat64(queue.TaskWorkerStateGauge.With(prometheus.Labels{
+			"pool":   pool,
+			"worker": fmt.Sprint(worker),
+			"state":  string(state),
+		}))
+	}
+
+	t.Run("rejects negative size", func(t *testing.T) {
+		_, err := NewPool(nil, nil, PoolOptions{Size: -1})
+		require.Equal(t, ErrInvalidPoolSize, err)
+	})
+
+	t.Run("processes the tasks at the same time", func(t *testing.T) {
+		q := memory.NewQueue(config.Queue{}, memory.Options{})
+		started := make(chan string, 3)
+		release := make(chan struct{})
+
+		p, err := NewPool(q, blockingHandler(started, release), PoolOptions{Options: opts, Name: "concurrent", Size: 3})
+		require.NoError(t, err)
+
+		workCtx, stop := context.WithCancel(ctx)
+		done := make(chan error)
+		go func() {
+			done <- p.Work(workCtx)
+		}()
+
+		taskIDs := enqueue(t, q, 3)
+		receive(t, started, 3)
+
+		for worker := 1; worker <= 3; worker++ {
+			require.Equal(t, float64(1), stateGauge("concurrent", worker, WorkerProcessing))
+			require.Equal(t, float64(0), stateGauge("concurrent", worker, WorkerDequeueing))
+		}
+		require.Equal(t, ErrPoolRunning, p.Work(ctx))
+
+		close(release)
+		for _, taskID := range taskIDs {
+			task, err := q.WaitFor(ctx, taskID)
+			require.NoError(t, err)
+			require.Equal(t, queue.Finished, task.Status)
+		}
+
+		stop()
+		require.Equal(t, context.Canceled, <-done)
+		require.Equal(t, 0, testutil.CollectAndCount(queue.TaskWorkerStateGauge), "the stopped workers are not reported")
+	})
+
+	t.Run("resizes the running pool", func(t *testing.T) {
+		q := memory.NewQueue(config.Queue{}, memory.Options{})
+		started := make(chan string, 3)
+		release := make(chan struct{})
+
+		p, err := NewPool(q, blockingHandler(started, release), PoolOptions{Options: opts, Name: "resized", Size: 1})
+		require.NoError(t, err)
+
+		workCtx, stop := context.WithCancel(ctx)
+		done := make(chan error)
+		go func() {
+			done <- p.Work(workCtx)
+		}()
+
+		taskIDs := enqueue(t, q, 3)
+		receive(t, started, 1)
+		select {
+		case <-started:
+			require.Fail(t, "only one task can be processed by one worker")
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		require.Equal(t, ErrInvalidPoolSize, p.Resize(-1))
+		require.NoError(t, p.Resize(3))
+		require.Equal(t, 3, p.Size())
+		receive(t, started, 2)
+
+		// the removed workers finish their tasks
+		require.NoError(t, p.Resize(1))
+		require.Equal(t, float64(1), stateGauge("resized", 2, WorkerDraining))
+		require.Equal(t, float64(1), stateGauge("resized", 3, WorkerDraining))
+
+		close(release)
+		for _, taskID := range taskIDs {
+			task, err := q.WaitFor(ctx, taskID)
+			require.NoError(t, err)
+			require.Equal(t, queue.Finished, task.Status)
+		}
+
+		require.Eventually(t, func() bool {
+			// one worker with 3 states
+			return testutil.CollectAndCount(queue.TaskWorkerStateGauge) == 3
+		}, time.Second, 10*time.Millisecond)
+
+		stop()
+		require.Equal(t, context.Canceled, <-done)
+	})
+
+	t.Run("drain waits for the tasks", func(t *testing.T) {
+		q := memory.NewQueue(config.Queue{}, memory.Options{})
+		started := make(chan string, 2)
+		release := make(chan struct{})
+
+		p, err := NewPool(q, blockingHandler(started, release), PoolOptions{Options: opts, Size: 2})
+		require.NoError(t, err)
+
+		done := make(chan error)
+		go func() {
+			done <- p.Work(ctx)
+		}()
+
+		taskIDs := enqueue(t, q, 2)
+		receive(t, started, 2)
+
+		drained := make(chan error)
+		go func() {
+			drained <- p.Drain(ctx)
+		}()
+
+		// new tasks are not dequeued anymore
+		waiting := enqueue(t, q, 3)[2]
+		select {
+		case <-drained:
+			require.Fail(t, "the pool is drained before the tasks are finished")
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		close(release)
+		require.NoError(t, <-drained)
+		require.NoError(t, <-done)
+
+		requireStatus(t, q, queue.Finished, taskIDs...)
+		requireStatus(t, q, queue.Waiting, waiting)
+	})
+
+	t.Run("cancelled pool finishes the tasks within the shutdown timeout", func(t *testing.T) {
+		q := memory.NewQueue(config.Queue{}, memory.Options{})
+		started := make(chan string, 1)
+		release := make(chan struct{})
+
+		p, err := NewPool(q, blockingHandler(started, release), PoolOptions{
+			Options:         opts,
+			Size:            1,
+			ShutdownTimeout: 5 * time.Second,
+		})
+		require.NoError(t, err)
+
+		workCtx, stop := context.WithCancel(ctx)
+		done := make(chan error)
+		go func() {
+			done <- p.Work(workCtx)
+		}()
+
+		taskIDs := enqueue(t, q, 1)
+		receive(t, started, 1)
+
+		stop()
+		time.Sleep(100 * time.Millisecond)
+		close(release)
+
+		require.Equal(t, context.Canceled, <-done)
+		requireStatus(t, q, queue.Finished, taskIDs...)
+	})
+
+	t.Run("cancelled pool cancels the tasks after the shutdown timeout", func(t *testing.T) {
+		q := memory.NewQueue(config.Queue{}, memory.Options{})
+		started := make(chan string, 1)
+		interrupted := make(chan error, 1)
+		handler := queue.TaskHandlerFunc(func(ctx context.Context, task queue.Task, heartbeats chan<- queue.Progress) error {
+			defer close(heartbeats)
+			started <- task.ID
+
+			<-ctx.Done()
+			interrupted <- ctx.Err()
+			return ctx.Err()
+		})
+
+		p, err := NewPool(q, handler, PoolOptions{
+			Options:         opts,
+			Size:            1,
+			ShutdownTimeout: 100 * time.Millisecond,
+		})
+		require.NoError(t, err)
+
+		workCtx, stop := context.WithCancel(ctx)
+		done := make(chan error)
+		go func() {
+			done <- p.Work(workCtx)
+		}()
+
+		enqueue(t, q, 1)
+		receive(t, started, 1)
+
+		stopped := time.Now()
+		stop()
+
+		select {
+		case err := <-done:
+			require.Equal(t, context.Canceled, err)
+			require.True(t, time.Since(stopped) >= 100*time.Millisecond)
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "the pool was not stopped")
+		}
+
+		require.Equal(t, context.Canceled, <-interrupted)
+	})
+}
+
+func TestPoolPostgres(t *testing.T) {
+	logrus.SetOutput(io.Discard)
+	defer logrus.SetOutput(os.Stdout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	name, db := dbtest.GetDatabase(t)
+	defer db.Close()
+	require.NoError(t, postgres.SetupTables(ctx, db, nil))
+
+	connStr := "user=contiamo_test password=localdev sslmode=disable dbname=" + name
+	dbListener := pq.NewListener(
+		connStr,
+		10*time.Second,
+		time.Minute,
+		func(ev pq.ListenerEventType, err error) {
+			if err != nil {
+				logrus.Error(err)
+			}
+		},
+	)
+	defer dbListener.Close()
+
+	// the poll frequency is longer than the test, the idle workers must be woken up by notifications
+	cfg := config.Queue{
+		HeartbeatTTL:  10 * time.Second,
+		PollFrequency: time.Minute,
+	}
+	q := postgres.NewQueuer(db)
+	w := postgres.NewWaiter(db, nil, config.Queue{PollFrequency: 50 * time.Millisecond})
+
+	started := make(chan string, 3)
+	release := make(chan struct{})
+	handler := queue.TaskHandlerFunc(func(ctx context.Context, task queue.Task, heartbeats chan<- queue.Progress) error {
+		defer close(heartbeats)
+		started <- task.ID
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-release:
+		}
+
+		heartbeats <- queue.Progress(`{"done":true}`)
+		return nil
+	})
+
+	p, err := NewPool(postgres.NewDequeuer(db, dbListener, cfg), handler, PoolOptions{
+		Options: Options{HeartbeatPeriod: 10 * time.Second},
+		Name:    "postgres",
+		Size:    3,
+	})
+	require.NoError(t, err)
+
+	workCtx, stop := context.WithCancel(ctx)
+	done := make(chan error)
+	go func() {
+		done <- p.Work(workCtx)
+	}()
+
+	// all the workers wait for a task on the same dequeuer
+	require.Eventually(t, func() bool {
+		for worker := 1; worker <= 3; worker++ {
+			value := testutil.ToFloat64(queue.TaskWorkerStateGauge.With(prometheus.Labels{
+				"pool":   "postgres",
+				"worker": fmt.Sprint(worker),
+				"state":  string(WorkerDequeueing),
+			}))
+			if value != 1 {
+				return false
+			}
+		}
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+	time.Sleep(200 * time.Millisecond)
+
+	// the tasks are in different queues, so they can be processed at the same time
+	var taskIDs []string
+	for i := 0; i < 3; i++ {
+		taskID, err := q.Enqueue(ctx, queue.TaskEnqueueRequest{
+			TaskBase: queue.TaskBase{Queue: fmt.Sprintf("queue%d", i), Type: "test", Spec: queue.Spec(`{}`)},
+		})
+		require.NoError(t, err)
+		taskIDs = append(taskIDs, taskID)
+	}
+
+	for i := 0; i < 3; i++ {
+		select {
+		case <-started:
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "the task was not started")
+		}
+	}
+
+	close(release)
+	for _, taskID := range taskIDs {
+		task, err := w.WaitFor(ctx, taskID)
+		require.NoError(t, err)
+		require.Equal(t, queue.Finished, task.Status)
+	}
+
+	stop()
+	require.Equal(t, context.Canceled, <-done)
+}
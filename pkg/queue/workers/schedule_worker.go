@@ -9,11 +9,9 @@ import (
 	cdb "github.com/contiamo/go-base/v4/pkg/db"
 	"github.com/contiamo/go-base/v4/pkg/queue"
 	"github.com/contiamo/go-base/v4/pkg/tracing"
-	cvalidation "github.com/contiamo/go-base/v4/pkg/validation"
 	"github.com/opentracing/opentracing-go"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/robfig/cron"
 	"github.com/sirupsen/logrus"
 )
 
@@ -151,11 +149,22 @@ func (w *scheduleWorker) scheduleTask(ctx context.Context) (err error) {
 	}()
 
 	var (
-		scheduleID   string
-		cronSchedule string
-		taskQueue    string
-		taskType     queue.TaskType
-		specBytes    []byte
+		scheduleID     string
+		cronSchedule   string
+		timezone       string
+		intervalMs     int64
+		validFrom      *time.Time
+		validUntil     *time.Time
+		maxExecutions  int
+		misfirePolicy  queue.MisfirePolicy
+		misfireLimit   int
+		overlapPolicy  queue.OverlapPolicy
+		executionCount int
+		executionTime  time.Time
+		taskQueue      string
+		taskType       queue.TaskType
+		specBytes      []byte
+		taskPriority   int
 	)
 
 	timer := prometheus.NewTimer(queue.ScheduleWorkerMetrics.DequeueingDuration)
@@ -167,10 +176,22 @@ func (w *scheduleWorker) scheduleTask(ctx context.Context) (err error) {
 			"task_type",
 			"task_queue",
 			"task_spec",
+			"task_priority",
 			"cron_schedule",
+			"timezone",
+			"interval_ms",
+			"valid_from",
+			"valid_until",
+			"max_executions",
+			"misfire_policy",
+			"misfire_limit",
+			"overlap_policy",
+			"execution_count",
+			"next_execution_time",
 		).
 		From("schedules").
 		Where(squirrel.LtOrEq{"next_execution_time": time.Now()}).
+		Where(squirrel.Eq{"paused_at": nil}).
 		OrderBy("next_execution_time").
 		Limit(1).
 		// Skipping locked rows provides an inconsistent view of the data,
@@ -186,7 +207,18 @@ func (w *scheduleWorker) scheduleTask(ctx context.Context) (err error) {
 		&taskType,
 		&taskQueue,
 		&specBytes,
+		&taskPriority,
 		&cronSchedule,
+		&timezone,
+		&intervalMs,
+		&validFrom,
+		&validUntil,
+		&maxExecutions,
+		&misfirePolicy,
+		&misfireLimit,
+		&overlapPolicy,
+		&executionCount,
+		&executionTime,
 	)
 	timer.ObserveDuration()
 
@@ -209,49 +241,86 @@ func (w *scheduleWorker) scheduleTask(ctx context.Context) (err error) {
 
 	span.SetTag("schedule.id", scheduleID)
 	span.SetTag("schedule.cron", cronSchedule)
+	span.SetTag("schedule.timezone", timezone)
+	span.SetTag("schedule.interval", intervalMs)
+	span.SetTag("schedule.executionCount", executionCount)
+	span.SetTag("schedule.misfirePolicy", misfirePolicy)
+	span.SetTag("schedule.overlapPolicy", overlapPolicy)
 	span.SetTag("task.type", taskType.String())
 	span.SetTag("task.queue", taskQueue)
 	span.SetTag("task.spec", string(specBytes))
+	span.SetTag("task.priority", taskPriority)
 
 	logrus := logrus.WithField("type", taskType).
 		WithField("queue", taskQueue).
 		WithField("schedule_id", scheduleID).
 		WithField("schedule_cron", cronSchedule)
 
-	logrus.Debug("adding the task to the queue")
-	task := queue.TaskEnqueueRequest{
-		TaskBase: queue.TaskBase{
-			Queue: taskQueue,
-			Type:  taskType,
-			Spec:  specBytes,
-		},
-		References: queue.References{
-			"schedule_id": scheduleID,
-		},
+	timing := queue.ScheduleTiming{
+		CronSchedule:  cronSchedule,
+		Timezone:      timezone,
+		Interval:      time.Duration(intervalMs) * time.Millisecond,
+		MaxExecutions: maxExecutions,
+		MisfirePolicy: misfirePolicy,
+		MisfireLimit:  misfireLimit,
+	}
+	if validFrom != nil {
+		timing.ValidFrom = *validFrom
+	}
+	if validUntil != nil {
+		timing.ValidUntil = *validUntil
 	}
-	err = w.queue.Enqueue(ctx, task)
+
+	fire, nextExecution, err := timing.Fire(executionTime, executionCount, time.Now())
 	if err != nil {
 		return err
 	}
 
-	logrus.Debug("task has been scheduled successfully")
+	if len(fire) == 0 {
+		logrus.WithField("execution_time", executionTime).
+			Debug("the missed executions are skipped")
+	}
 
-	logrus.Debug("calculating and updating the next execution time")
+	for _, scheduledAt := range fire {
+		overlaps, err := resolveOverlap(ctx, builder, scheduleID, overlapPolicy)
+		if err != nil {
+			return err
+		}
+		if overlaps {
+			logrus.WithField("scheduled_at", scheduledAt).
+				Debug("the previous task is not finished, the execution is skipped")
+			continue
+		}
 
-	var nextExecution *time.Time
-	if cronSchedule != "" {
-		p := cron.NewParser(cvalidation.JobCronFormat)
-		schedule, err := p.Parse(cronSchedule)
+		logrus.WithField("scheduled_at", scheduledAt).
+			Debug("adding the task to the queue")
+		task := queue.TaskEnqueueRequest{
+			TaskBase: queue.TaskBase{
+				Queue:    taskQueue,
+				Type:     taskType,
+				Spec:     specBytes,
+				Priority: taskPriority,
+			},
+			References: queue.References{
+				"schedule_id": scheduleID,
+			},
+			ScheduledAt: scheduledAt,
+		}
+		_, err = w.queue.EnqueueTx(ctx, builder, task)
 		if err != nil {
 			return err
 		}
-		t := schedule.Next(time.Now())
-		nextExecution = &t
 	}
 
+	logrus.WithField("count", len(fire)).
+		Debug("tasks have been scheduled successfully")
+
+	logrus.Debug("updating the next execution time")
+
 	res, err := builder.
 		Update("schedules").
 		Set("next_execution_time", nextExecution).
+		Set("execution_count", executionCount+len(fire)).
 		Where(squirrel.Eq{
 			"schedule_id": scheduleID,
 		}).
@@ -272,3 +341,41 @@ func (w *scheduleWorker) scheduleTask(ctx context.Context) (err error) {
 	queue.ScheduleWorkerMetrics.ProcessedCounter.With(labels).Inc()
 	return nil
 }
+
+// unfinishedStatuses are the statuses of the tasks that overlap with the next task of the schedule
+var unfinishedStatuses = []queue.TaskStatus{queue.Waiting, queue.Running, queue.Blocked}
+
+// resolveOverlap applies the overlap policy of the schedule before its next task is enqueued,
+// returns true if the execution must be skipped because the previous task is not finished
+func resolveOverlap(ctx context.Context, builder cdb.SQLBuilder, scheduleID string, policy queue.OverlapPolicy) (bool, error) {
+	unfinished := squirrel.Eq{
+		"schedule_id": scheduleID,
+		"status":      unfinishedStatuses,
+	}
+
+	switch policy {
+	case queue.OverlapSkip:
+		var exists bool
+		err := builder.
+			Select("1").
+			Prefix("SELECT EXISTS (").
+			From("tasks").
+			Where(unfinished).
+			Suffix(")").
+			QueryRowContext(ctx).
+			Scan(&exists)
+		return exists, err
+	case queue.OverlapCancel:
+		now := time.Now()
+		_, err := builder.
+			Update("tasks").
+			Set("status", queue.Cancelled).
+			Set("finished_at", now).
+			Set("updated_at", now).
+			Where(unfinished).
+			ExecContext(ctx)
+		return false, err
+	default:
+		return false, nil
+	}
+}
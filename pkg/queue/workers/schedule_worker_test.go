@@ -7,6 +7,7 @@ import (
 
 	"github.com/Masterminds/squirrel"
 
+	cdb "github.com/contiamo/go-base/v4/pkg/db"
 	dbtest "github.com/contiamo/go-base/v4/pkg/db/test"
 	"github.com/contiamo/go-base/v4/pkg/queue"
 	"github.com/contiamo/go-base/v4/pkg/queue/postgres"
@@ -46,6 +47,7 @@ func TestScheduleTask(t *testing.T) {
 				"task_queue",
 				"task_type",
 				"task_spec",
+				"task_priority",
 				"cron_schedule",
 				"next_execution_time",
 				"created_at",
@@ -56,6 +58,7 @@ func TestScheduleTask(t *testing.T) {
 				taskQueue1,
 				taskType,
 				taskSpec1,
+				5,
 				"@weekly",
 				now.Add(-2*time.Minute), // this must be executed instantly
 				now.Add(-1*time.Minute),
@@ -66,6 +69,7 @@ func TestScheduleTask(t *testing.T) {
 				taskQueue2,
 				taskType,
 				taskSpec2,
+				0,
 				"",                      // empty value is a one-time job
 				now.Add(-1*time.Minute), // this must be executed after the first
 				now.Add(-1*time.Minute),
@@ -97,11 +101,13 @@ func TestScheduleTask(t *testing.T) {
 		require.Equal(t, taskQueue1, task1.Queue)
 		require.Equal(t, taskType, task1.Type.String())
 		require.Equal(t, string(taskSpec1), string(task1.Spec))
+		require.Equal(t, 5, task1.Priority)
 
 		task2 := qm.q[1]
 		require.Equal(t, taskQueue2, task2.Queue)
 		require.Equal(t, taskType, task2.Type.String())
 		require.Equal(t, string(taskSpec2), string(task2.Spec))
+		require.Equal(t, 0, task2.Priority)
 
 		// checking that the execution time has changed
 		dbtest.EqualCount(t, db, 0, "schedules", squirrel.LtOrEq{
@@ -142,6 +148,44 @@ func TestScheduleTask(t *testing.T) {
 		require.Equal(t, ErrScheduleQueueIsEmpty, err)
 		require.Len(t, qm.q, 0)
 	})
+
+	t.Run("Skips paused schedules", func(t *testing.T) {
+		_, db := dbtest.GetDatabase(t)
+		defer db.Close()
+		require.NoError(t, postgres.SetupTables(ctx, db, nil))
+
+		now := time.Now()
+		_, err := squirrel.StatementBuilder.
+			PlaceholderFormat(squirrel.Dollar).
+			RunWith(db).
+			Insert("schedules").
+			Columns(
+				"schedule_id",
+				"task_queue",
+				"task_type",
+				"task_spec",
+				"cron_schedule",
+				"next_execution_time",
+				"paused_at",
+			).
+			Values(
+				uuid.NewV4().String(),
+				"queue1",
+				"type",
+				[]byte(`{}`),
+				"@weekly",
+				now.Add(-1*time.Minute),
+				now,
+			).
+			ExecContext(ctx)
+		require.NoError(t, err)
+
+		qm := &queueMock{}
+		w := newScheduleWorker(db, qm, time.Second)
+		err = w.scheduleTask(ctx)
+		require.Equal(t, ErrScheduleQueueIsEmpty, err)
+		require.Len(t, qm.q, 0)
+	})
 }
 
 func TestMetrics(t *testing.T) {
@@ -184,9 +228,25 @@ type queueMock struct {
 	q       []queue.TaskEnqueueRequest
 }
 
-func (q *queueMock) Enqueue(ctx context.Context, task queue.TaskEnqueueRequest) error {
+func (q *queueMock) Enqueue(ctx context.Context, task queue.TaskEnqueueRequest) (string, error) {
 	q.q = append(q.q, task)
-	return q.enqueue
+	return uuid.NewV4().String(), q.enqueue
+}
+
+func (q *queueMock) EnqueueTx(ctx context.Context, builder cdb.SQLBuilder, task queue.TaskEnqueueRequest) (string, error) {
+	return q.Enqueue(ctx, task)
+}
+
+func (q *queueMock) EnqueueBatch(ctx context.Context, tasks []queue.TaskEnqueueRequest) ([]string, error) {
+	taskIDs := make([]string, 0, len(tasks))
+	for _, task := range tasks {
+		taskID, err := q.Enqueue(ctx, task)
+		if err != nil {
+			return nil, err
+		}
+		taskIDs = append(taskIDs, taskID)
+	}
+	return taskIDs, nil
 }
 
 func (q *queueMock) Dequeue(ctx context.Context, queue ...string) (*queue.Task, error) {
@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/contiamo/go-base/v4/pkg/queue"
@@ -50,11 +51,16 @@ func NewTaskWorker(dequeuer queue.Dequeuer, handler queue.TaskHandler) queue.Wor
 
 // NewTaskWorkerWithOpts creates a task Worker instance with the specified options.
 func NewTaskWorkerWithOpts(dequeuer queue.Dequeuer, handler queue.TaskHandler, opts Options) queue.Worker {
+	return newTaskWorker(dequeuer, handler, opts)
+}
+
+func newTaskWorker(dequeuer queue.Dequeuer, handler queue.TaskHandler, opts Options) *taskWorker {
 	return &taskWorker{
 		Tracer:          tracing.NewTracer("workers", "TaskWorker"),
 		dequeuer:        dequeuer,
 		handler:         handler,
 		heartbeatPeriod: opts.HeartbeatPeriod,
+		draining:        make(chan struct{}),
 	}
 }
 
@@ -67,22 +73,32 @@ type taskWorker struct {
 	queue.Worker
 
 	heartbeatPeriod time.Duration
+
+	// mu guards the start of the work and the state changes against draining
+	mu sync.Mutex
+	// draining is closed when the worker must stop dequeuing new tasks
+	draining chan struct{}
+	// running tracks the `Work` calls that have not returned yet
+	running sync.WaitGroup
+
+	// onStateChange is called when the worker changes its state, it's optional
+	onStateChange func(WorkerState)
 }
 
 func (w *taskWorker) Work(ctx context.Context) (err error) {
+	w.mu.Lock()
+	if w.isDraining() {
+		w.mu.Unlock()
+		return nil
+	}
+	w.running.Add(1)
+	w.mu.Unlock()
+	defer w.running.Done()
+
 	tracer := opentracing.GlobalTracer()
 	queue.TaskWorkerMetrics.ActiveGauge.Inc()
 	defer queue.TaskWorkerMetrics.ActiveGauge.Dec()
 
-	// the error in the iteration should not stop the work
-	// it's logged by the Tracer interface, so we don't have to handle it here
-	// since the ticker delivers the first tick after the interval we need to run it for the
-	// first time out of the loop
-	e := w.iteration(ctx, tracer)
-	if e != nil {
-		logrus.Error(e)
-	}
-
 	logrus.Debug("starting task worker loop...")
 	// while ctx is not canceled or interrupted
 	for {
@@ -90,7 +106,12 @@ func (w *taskWorker) Work(ctx context.Context) (err error) {
 		case <-ctx.Done():
 			logrus.Debug("processing loop is interrupted")
 			return ctx.Err()
+		case <-w.draining:
+			logrus.Debug("processing loop is drained")
+			return nil
 		default:
+			// the error in the iteration should not stop the work
+			// it's logged by the Tracer interface, so we don't have to handle it here
 			e := w.iteration(ctx, tracer)
 			if e != nil {
 				logrus.Error(e)
@@ -99,6 +120,65 @@ func (w *taskWorker) Work(ctx context.Context) (err error) {
 	}
 }
 
+// Drain stops dequeuing new tasks and waits until the current tasks are finished.
+// The tasks that are being processed are not interrupted.
+func (w *taskWorker) Drain(ctx context.Context) error {
+	w.stop()
+
+	done := make(chan struct{})
+	go func() {
+		w.running.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-done:
+		return nil
+	}
+}
+
+// stop stops dequeuing new tasks without waiting for the current tasks
+func (w *taskWorker) stop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.isDraining() {
+		return
+	}
+
+	close(w.draining)
+	if w.onStateChange != nil {
+		w.onStateChange(WorkerDraining)
+	}
+}
+
+// setState reports the new state of the worker,
+// a draining worker does not report anything else until it stops.
+func (w *taskWorker) setState(state WorkerState) {
+	if w.onStateChange == nil {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.isDraining() {
+		w.onStateChange(state)
+	}
+}
+
+// isDraining returns true when the worker must not dequeue new tasks
+func (w *taskWorker) isDraining() bool {
+	select {
+	case <-w.draining:
+		return true
+	default:
+		return false
+	}
+}
+
 func (w *taskWorker) iteration(ctx context.Context, tracer opentracing.Tracer) (err error) {
 	span := tracer.StartSpan("iteration")
 	ctx, cancel := context.WithCancel(opentracing.ContextWithSpan(ctx, span))
@@ -136,6 +216,10 @@ func (w *taskWorker) iteration(ctx context.Context, tracer opentracing.Tracer) (
 			if err == sql.ErrNoRows {
 				return nil
 			}
+			// the dequeuing is interrupted because the worker is drained
+			if err != nil && w.isDraining() {
+				return nil
+			}
 			if err != nil {
 				return err
 			}
@@ -166,11 +250,23 @@ func (w *taskWorker) tryDequeueTask(ctx context.Context) (task *queue.Task, err
 		}
 	}()
 
+	w.setState(WorkerDequeueing)
 	queue.TaskWorkerMetrics.DequeueingGauge.Inc()
 	defer queue.TaskWorkerMetrics.DequeueingGauge.Dec()
 	timer := prometheus.NewTimer(queue.TaskWorkerMetrics.DequeueingDuration)
 	defer timer.ObserveDuration()
 
+	// draining interrupts the waiting for a new task but not the processing of the dequeued one
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-w.draining:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
 	return w.dequeuer.Dequeue(ctx)
 }
 
@@ -185,6 +281,7 @@ func (w *taskWorker) handleTask(ctx context.Context, task queue.Task) (err error
 		w.FinishSpan(span, err)
 	}()
 
+	w.setState(WorkerProcessing)
 	timer := prometheus.NewTimer(queue.TaskWorkerMetrics.ProcessingDuration)
 	defer timer.ObserveDuration()
 
@@ -194,6 +291,8 @@ func (w *taskWorker) handleTask(ctx context.Context, task queue.Task) (err error
 
 	heartbeats := make(chan queue.Progress)
 	processDone := make(chan error, 1)
+	// the result is set before the processing error is sent to processDone
+	var result queue.Result
 
 	go func() {
 		// handle panics because we force close the heartbeats if the beats are too slow
@@ -212,7 +311,15 @@ func (w *taskWorker) handleTask(ctx context.Context, task queue.Task) (err error
 
 		// handler.Process is responsible for closing the heartbeats channel
 		// if `Process` returns an error it means the task failed
-		processDone <- w.handler.Process(ctx, task, heartbeats)
+		resultHandler, ok := w.handler.(queue.ResultHandler)
+		if !ok {
+			processDone <- w.handler.Process(ctx, task, heartbeats)
+			return
+		}
+
+		var err error
+		result, err = resultHandler.ProcessWithResult(ctx, task, heartbeats)
+		processDone <- err
 	}()
 
 	// block while we process the heartbeats
@@ -224,7 +331,15 @@ func (w *taskWorker) handleTask(ctx context.Context, task queue.Task) (err error
 		queue.TaskWorkerMetrics.ProcessingErrorsCounter.With(labels).Inc()
 
 		progress = w.setError(progress, err)
-		return w.dequeuer.Fail(ctx, task.ID, progress)
+		return w.fail(ctx, task.ID, progress, err)
+	}
+
+	if err == queue.ErrTaskCancelled {
+		// stop the handler right away, the task must not be finished or failed
+		span.SetTag("cancelled", true)
+		cancel()
+		w.waitForHandler(logger, heartbeats, processDone)
+		return nil
 	}
 
 	if err != nil {
@@ -240,10 +355,10 @@ func (w *taskWorker) handleTask(ctx context.Context, task queue.Task) (err error
 		queue.TaskWorkerMetrics.ProcessingErrorsCounter.With(labels).Inc()
 
 		progress = w.setError(progress, workErr)
-		return w.dequeuer.Fail(ctx, task.ID, progress)
+		return w.fail(ctx, task.ID, progress, workErr)
 	}
 
-	err = w.dequeuer.Finish(ctx, task.ID, progress)
+	err = w.finish(ctx, task.ID, progress, result)
 	if err != nil {
 		return err
 	}
@@ -252,6 +367,29 @@ func (w *taskWorker) handleTask(ctx context.Context, task queue.Task) (err error
 	return nil
 }
 
+// waitForHandler waits until the handler of the cancelled task returns, so the worker does not
+// start another task while the handler is still working. The heartbeats sent in the meantime are discarded.
+// A handler ignoring the cancellation is waited for at most one heartbeat period.
+func (w *taskWorker) waitForHandler(logger logrus.FieldLogger, heartbeats <-chan queue.Progress, processDone <-chan error) {
+	timeout := time.NewTimer(w.heartbeatPeriod)
+	defer timeout.Stop()
+
+	for {
+		select {
+		case <-processDone:
+			return
+		case _, ok := <-heartbeats:
+			if !ok {
+				// the handler closed the heartbeats and is about to return
+				heartbeats = nil
+			}
+		case <-timeout.C:
+			logger.Error("the handler of the cancelled task did not stop in time")
+			return
+		}
+	}
+}
+
 // processHeartbeats will synchronously process the heartbeats channel, saving the progress reports to the dequeuer.
 // We moved this to a method because using returns is nicer than labels and break.
 func (w *taskWorker) processHeartbeats(ctx context.Context, task queue.Task, heartbeats chan queue.Progress) (progress queue.Progress, err error) {
@@ -286,8 +424,11 @@ func (w *taskWorker) processHeartbeats(ctx context.Context, task queue.Task, hea
 			hrtErr := w.dequeuer.Heartbeat(ctx, task.ID, progress)
 			if hrtErr != nil {
 				switch hrtErr {
-				case queue.ErrTaskCancelled,
-					queue.ErrTaskFinished,
+				case queue.ErrTaskCancelled:
+					logger.Info(hrtErr)
+					// the task was cancelled by the user, the handler must stop working on it
+					return progress, hrtErr
+				case queue.ErrTaskFinished,
 					queue.ErrTaskNotFound,
 					queue.ErrTaskNotRunning:
 					logger.Error(hrtErr)
@@ -307,6 +448,36 @@ func (w *taskWorker) processHeartbeats(ctx context.Context, task queue.Task, hea
 	}
 }
 
+// finish reports the finished task to the dequeuer,
+// the result is stored if the dequeuer supports storing results.
+func (w *taskWorker) finish(ctx context.Context, taskID string, progress queue.Progress, result queue.Result) error {
+	writer, ok := w.dequeuer.(queue.ResultWriter)
+	if !ok || result == nil {
+		return w.dequeuer.Finish(ctx, taskID, progress)
+	}
+	return writer.FinishWithResult(ctx, taskID, progress, result)
+}
+
+// fail reports the failed attempt to the dequeuer. The task is retried if the dequeuer
+// supports retries and the retry policy of the task allows it, otherwise the task fails.
+// The error is stored if the dequeuer supports storing errors.
+func (w *taskWorker) fail(ctx context.Context, taskID string, progress queue.Progress, taskErr error) error {
+	retrier, ok := w.dequeuer.(queue.Retrier)
+	if ok {
+		return retrier.FailAttempt(ctx, taskID, progress, taskErr)
+	}
+
+	writer, ok := w.dequeuer.(queue.ResultWriter)
+	if ok {
+		return writer.FailWithError(ctx, taskID, progress, queue.NewTaskError(taskErr))
+	}
+
+	return w.dequeuer.Fail(ctx, taskID, progress)
+}
+
+// setError puts the error message into the `error` field of the progress.
+// It's kept for the backward compatibility, consumers should use the structured
+// task error when the dequeuer supports it, see queue.ResultWriter.
 func (w *taskWorker) setError(progress queue.Progress, err error) queue.Progress {
 	p := map[string]interface{}{}
 	e := json.Unmarshal(progress, &p)
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -472,6 +473,365 @@ func TestTaskWorkerWork(t *testing.T) {
 	})
 }
 
+func TestTaskWorkerRetries(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	logrus.SetOutput(io.Discard)
+	defer logrus.SetOutput(os.Stdout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	t.Run("worker reports the failed attempt with the error if the dequeuer supports retries", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		qCh := make(chan *queue.Task, 1)
+		q := &retryingMockQueue{mockQueue: mockQueue{queue: qCh}}
+
+		qCh <- &queue.Task{
+			TaskBase: queue.TaskBase{
+				Queue: "retryQueue",
+				Type:  "retryType",
+			},
+			ID: "testTask",
+		}
+
+		taskErr := errors.New("temporary error")
+		handler := queue.TaskHandlerFunc(func(ctx context.Context, task queue.Task, heartbeats chan<- queue.Progress) error {
+			defer close(heartbeats)
+			return taskErr
+		})
+
+		w := NewTaskWorker(q, handler)
+
+		done := make(chan error)
+		go func() {
+			done <- w.Work(ctx)
+		}()
+
+		time.Sleep(5 * time.Millisecond)
+		cancel()
+		err := <-done
+		require.EqualError(t, err, "context canceled")
+
+		require.Equal(t, []error{taskErr}, q.attemptErrors)
+		require.Equal(t, []queue.Progress{queue.Progress(`{"error":"temporary error"}`)}, q.attemptProgress)
+		require.Len(t, q.fails, 0, "Fail should not be called when the dequeuer supports retries")
+	})
+}
+
+func TestTaskWorkerResults(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	logrus.SetOutput(io.Discard)
+	defer logrus.SetOutput(os.Stdout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	task := queue.Task{
+		TaskBase: queue.TaskBase{
+			Queue: "resultQueue",
+			Type:  "resultType",
+		},
+		ID: "testTask",
+	}
+
+	cases := []struct {
+		name       string
+		handler    queue.TaskHandler
+		expResults []queue.Result
+		expErrors  []*queue.TaskError
+		expFinish  int
+	}{
+		{
+			name: "worker stores the result of the result handler",
+			handler: queue.ResultHandlerFunc(func(ctx context.Context, task queue.Task, heartbeats chan<- queue.Progress) (queue.Result, error) {
+				defer close(heartbeats)
+				return queue.Result(`{"rows":42}`), nil
+			}),
+			expResults: []queue.Result{queue.Result(`{"rows":42}`)},
+		},
+		{
+			name: "worker finishes the task without a result",
+			handler: queue.TaskHandlerFunc(func(ctx context.Context, task queue.Task, heartbeats chan<- queue.Progress) error {
+				defer close(heartbeats)
+				return nil
+			}),
+			expFinish: 1,
+		},
+		{
+			name: "worker stores the structured error",
+			handler: queue.ResultHandlerFunc(func(ctx context.Context, task queue.Task, heartbeats chan<- queue.Progress) (queue.Result, error) {
+				defer close(heartbeats)
+				return nil, fmt.Errorf("can not process: %w", errors.New("boom"))
+			}),
+			expErrors: []*queue.TaskError{{
+				Message: "can not process: boom",
+				Type:    "*errors.errorString",
+				Causes:  []string{"boom"},
+			}},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(ctx)
+			qCh := make(chan *queue.Task, 1)
+			q := &resultMockQueue{mockQueue: mockQueue{queue: qCh}}
+
+			task := task
+			qCh <- &task
+
+			w := NewTaskWorker(q, tc.handler)
+
+			done := make(chan error)
+			go func() {
+				done <- w.Work(ctx)
+			}()
+
+			time.Sleep(5 * time.Millisecond)
+			cancel()
+			err := <-done
+			require.EqualError(t, err, "context canceled")
+
+			require.Equal(t, tc.expResults, q.results)
+			require.Equal(t, tc.expErrors, q.errors)
+			require.Len(t, q.finishes, tc.expFinish)
+			require.Len(t, q.fails, 0, "Fail should not be called when the dequeuer stores errors")
+		})
+	}
+}
+
+func TestTaskWorkerCancellation(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	logrus.SetOutput(io.Discard)
+	defer logrus.SetOutput(os.Stdout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	t.Run("worker cancels the handler context when the task is cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		qCh := make(chan *queue.Task, 1)
+		q := &mockQueue{queue: qCh, heartbeatErr: queue.ErrTaskCancelled}
+
+		qCh <- &queue.Task{
+			TaskBase: queue.TaskBase{
+				Queue: "cancelQueue",
+				Type:  "cancelType",
+			},
+			ID: "testTask",
+		}
+
+		handlerErr := make(chan error, 1)
+		handler := queue.TaskHandlerFunc(func(ctx context.Context, task queue.Task, heartbeats chan<- queue.Progress) error {
+			defer close(heartbeats)
+			heartbeats <- queue.Progress(`{"some":"text"}`)
+
+			// a long running task that is only stopped by the context
+			select {
+			case <-ctx.Done():
+				handlerErr <- ctx.Err()
+				return ctx.Err()
+			case <-time.After(5 * time.Second):
+				handlerErr <- nil
+				return nil
+			}
+		})
+
+		w := NewTaskWorker(q, handler)
+
+		done := make(chan error)
+		go func() {
+			done <- w.Work(ctx)
+		}()
+
+		select {
+		case err := <-handlerErr:
+			require.Equal(t, context.Canceled, err)
+		case <-time.After(time.Second):
+			require.Fail(t, "the handler context was not cancelled")
+		}
+
+		cancel()
+		err := <-done
+		require.EqualError(t, err, "context canceled")
+
+		require.Len(t, q.heartbeats, 1)
+		require.Len(t, q.finishes, 0, "cancelled task must not be finished")
+		require.Len(t, q.fails, 0, "cancelled task must not be failed")
+	})
+
+	t.Run("worker waits for the handler of the cancelled task to stop", func(t *testing.T) {
+		q := &mockQueue{heartbeatErr: queue.ErrTaskCancelled}
+
+		var stopped int32
+		handler := queue.TaskHandlerFunc(func(ctx context.Context, task queue.Task, heartbeats chan<- queue.Progress) error {
+			defer close(heartbeats)
+			heartbeats <- queue.Progress(`{"some":"text"}`)
+
+			<-ctx.Done()
+			// the heartbeats sent during the cleanup must not block the handler
+			heartbeats <- queue.Progress(`{"cleanup":"started"}`)
+			time.Sleep(100 * time.Millisecond)
+			atomic.StoreInt32(&stopped, 1)
+			return ctx.Err()
+		})
+
+		w := newTaskWorker(q, handler, Options{HeartbeatPeriod: 5 * time.Second})
+		err := w.handleTask(ctx, queue.Task{ID: "testTask"})
+		require.NoError(t, err)
+		require.Equal(t, int32(1), atomic.LoadInt32(&stopped), "the handler must be stopped")
+		require.Len(t, q.finishes, 0, "cancelled task must not be finished")
+		require.Len(t, q.fails, 0, "cancelled task must not be failed")
+	})
+
+	t.Run("worker stops waiting for the handler ignoring the cancellation after the heartbeat period", func(t *testing.T) {
+		q := &mockQueue{heartbeatErr: queue.ErrTaskCancelled}
+
+		handlerDone := make(chan struct{})
+		handler := queue.TaskHandlerFunc(func(ctx context.Context, task queue.Task, heartbeats chan<- queue.Progress) error {
+			defer close(handlerDone)
+			defer close(heartbeats)
+			heartbeats <- queue.Progress(`{"some":"text"}`)
+
+			time.Sleep(time.Second)
+			return nil
+		})
+
+		w := newTaskWorker(q, handler, Options{HeartbeatPeriod: 100 * time.Millisecond})
+		start := time.Now()
+		err := w.handleTask(ctx, queue.Task{ID: "testTask"})
+		require.NoError(t, err)
+		require.Less(t, time.Since(start), 500*time.Millisecond)
+
+		<-handlerDone
+	})
+}
+
+func TestTaskWorkerDrain(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	logrus.SetOutput(io.Discard)
+	defer logrus.SetOutput(os.Stdout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	t.Run("worker finishes the current task and stops dequeuing", func(t *testing.T) {
+		qCh := make(chan *queue.Task, 2)
+		q := &mockQueue{queue: qCh}
+
+		started := make(chan struct{})
+		release := make(chan struct{})
+		handler := queue.TaskHandlerFunc(func(ctx context.Context, task queue.Task, heartbeats chan<- queue.Progress) error {
+			defer close(heartbeats)
+			close(started)
+
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-release:
+			}
+
+			heartbeats <- queue.Progress(`{"done":true}`)
+			return nil
+		})
+
+		w := NewTaskWorker(q, handler)
+
+		done := make(chan error)
+		go func() {
+			done <- w.Work(ctx)
+		}()
+
+		qCh <- &queue.Task{TaskBase: queue.TaskBase{Queue: "drainQueue"}, ID: "task1"}
+		<-started
+
+		drained := make(chan error)
+		go func() {
+			drained <- w.(queue.Drainer).Drain(ctx)
+		}()
+
+		// the next task must not be dequeued
+		qCh <- &queue.Task{TaskBase: queue.TaskBase{Queue: "drainQueue"}, ID: "task2"}
+
+		select {
+		case <-drained:
+			require.Fail(t, "the worker is drained before the task is finished")
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		close(release)
+
+		select {
+		case err := <-drained:
+			require.NoError(t, err)
+		case <-time.After(time.Second):
+			require.Fail(t, "the worker was not drained")
+		}
+
+		require.NoError(t, <-done)
+		require.Len(t, q.finishes, 1)
+		require.Equal(t, queue.Progress(`{"done":true}`), q.finishes[0])
+		require.Len(t, qCh, 1, "the next task must stay in the queue")
+	})
+
+	t.Run("idle worker is drained right away", func(t *testing.T) {
+		q := &mockQueue{queue: make(chan *queue.Task)}
+		handler := queue.TaskHandlerFunc(func(ctx context.Context, task queue.Task, heartbeats chan<- queue.Progress) error {
+			defer close(heartbeats)
+			return nil
+		})
+
+		w := NewTaskWorker(q, handler)
+
+		done := make(chan error)
+		go func() {
+			done <- w.Work(ctx)
+		}()
+		time.Sleep(50 * time.Millisecond)
+
+		require.NoError(t, w.(queue.Drainer).Drain(ctx))
+		require.NoError(t, <-done)
+
+		// the drained worker does not start working again
+		require.NoError(t, w.Work(ctx))
+	})
+
+	t.Run("returns the context error when the waiting time is over", func(t *testing.T) {
+		qCh := make(chan *queue.Task, 1)
+		q := &mockQueue{queue: qCh}
+
+		release := make(chan struct{})
+		started := make(chan struct{})
+		handler := queue.TaskHandlerFunc(func(ctx context.Context, task queue.Task, heartbeats chan<- queue.Progress) error {
+			defer close(heartbeats)
+			close(started)
+			<-release
+			return nil
+		})
+
+		w := NewTaskWorker(q, handler)
+
+		done := make(chan error)
+		go func() {
+			done <- w.Work(ctx)
+		}()
+
+		qCh <- &queue.Task{TaskBase: queue.TaskBase{Queue: "drainQueue"}, ID: "task1"}
+		<-started
+
+		drainCtx, drainCancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer drainCancel()
+		require.Equal(t, context.DeadlineExceeded, w.(queue.Drainer).Drain(drainCtx))
+
+		close(release)
+		require.NoError(t, <-done)
+	})
+}
+
 type mockQueue struct {
 	queue        chan *queue.Task
 	dequeueErr   error
@@ -515,3 +875,31 @@ func (q *mockQueue) Fail(ctx context.Context, taskID string, metadata queue.Prog
 	q.fails = append(q.fails, metadata)
 	return q.failErr
 }
+
+type retryingMockQueue struct {
+	mockQueue
+	attemptErrors   []error
+	attemptProgress []queue.Progress
+}
+
+func (q *retryingMockQueue) FailAttempt(ctx context.Context, taskID string, metadata queue.Progress, taskErr error) error {
+	q.attemptErrors = append(q.attemptErrors, taskErr)
+	q.attemptProgress = append(q.attemptProgress, metadata)
+	return q.failErr
+}
+
+type resultMockQueue struct {
+	mockQueue
+	results []queue.Result
+	errors  []*queue.TaskError
+}
+
+func (q *resultMockQueue) FinishWithResult(ctx context.Context, taskID string, metadata queue.Progress, result queue.Result) error {
+	q.results = append(q.results, result)
+	return q.finishErr
+}
+
+func (q *resultMockQueue) FailWithError(ctx context.Context, taskID string, metadata queue.Progress, taskErr *queue.TaskError) error {
+	q.errors = append(q.errors, taskErr)
+	return q.failErr
+}
@@ -0,0 +1,49 @@
+package queue
+
+import "errors"
+
+// ErrWorkflowNotFound is returned when there are no tasks with the given workflow ID
+var ErrWorkflowNotFound = errors.New("workflow not found")
+
+// Workflow is a group of related tasks sharing the same workflow ID
+type Workflow struct {
+	// ID is the workflow ID shared by the tasks
+	ID string
+	// Status is the overall status of the workflow tasks:
+	//   - Failed as soon as any task has failed;
+	//   - Cancelled as soon as any task is cancelled and none has failed;
+	//   - Finished when all the tasks are finished;
+	//   - Waiting when none of the tasks has been picked up yet;
+	//   - Running otherwise.
+	Status TaskStatus
+	// Tasks contains the workflow tasks in the order they were enqueued
+	Tasks []Task
+}
+
+// NewWorkflow creates a workflow of the given tasks and determines its status
+func NewWorkflow(workflowID string, tasks []Task) *Workflow {
+	counts := make(map[TaskStatus]int, len(tasks))
+	for _, task := range tasks {
+		counts[task.Status]++
+	}
+
+	var status TaskStatus
+	switch {
+	case counts[Failed] > 0:
+		status = Failed
+	case counts[Cancelled] > 0:
+		status = Cancelled
+	case counts[Finished] == len(tasks):
+		status = Finished
+	case counts[Waiting]+counts[Blocked] == len(tasks):
+		status = Waiting
+	default:
+		status = Running
+	}
+
+	return &Workflow{
+		ID:     workflowID,
+		Status: status,
+		Tasks:  tasks,
+	}
+}
@@ -0,0 +1,60 @@
+package queue
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewWorkflow(t *testing.T) {
+	cases := []struct {
+		name      string
+		statuses  []TaskStatus
+		expStatus TaskStatus
+	}{
+		{
+			name:      "waiting when no task has been picked up",
+			statuses:  []TaskStatus{Waiting, Blocked, Blocked},
+			expStatus: Waiting,
+		},
+		{
+			name:      "running when a task is running",
+			statuses:  []TaskStatus{Running, Blocked},
+			expStatus: Running,
+		},
+		{
+			name:      "running when some tasks are finished",
+			statuses:  []TaskStatus{Finished, Waiting},
+			expStatus: Running,
+		},
+		{
+			name:      "finished when all the tasks are finished",
+			statuses:  []TaskStatus{Finished, Finished},
+			expStatus: Finished,
+		},
+		{
+			name:      "failed when any task has failed",
+			statuses:  []TaskStatus{Finished, Failed, Cancelled, Running},
+			expStatus: Failed,
+		},
+		{
+			name:      "cancelled when any task is cancelled",
+			statuses:  []TaskStatus{Finished, Cancelled, Running},
+			expStatus: Cancelled,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			tasks := make([]Task, 0, len(tc.statuses))
+			for _, status := range tc.statuses {
+				tasks = append(tasks, Task{Status: status})
+			}
+
+			workflow := NewWorkflow("workflow1", tasks)
+			require.Equal(t, "workflow1", workflow.ID)
+			require.Equal(t, tc.expStatus, workflow.Status)
+			require.Equal(t, tasks, workflow.Tasks)
+		})
+	}
+}
@@ -2,6 +2,7 @@ package validation
 
 import (
 	"regexp"
+	"strings"
 
 	validation "github.com/go-ozzo/ozzo-validation"
 	"github.com/go-ozzo/ozzo-validation/is"
@@ -18,6 +19,9 @@ var JobCronFormat = cron.Minute |
 	cron.Dow |
 	cron.Descriptor
 
+// JobCronFormatWithSeconds is JobCronFormat with the leading seconds field
+var JobCronFormatWithSeconds = cron.Second | JobCronFormat
+
 const (
 	// MinNameLength is the minimal length of a name
 	MinNameLength = 2
@@ -42,6 +46,27 @@ func CronTab(value string) error {
 	return nil
 }
 
+// CronTabWithSeconds returns an error if the string is not a cron tab expression,
+// unlike CronTab it also accepts expressions with the leading seconds field
+func CronTabWithSeconds(value string) error {
+	p := cron.NewParser(CronFormat(value))
+	_, cerr := p.Parse(value)
+	if cerr != nil {
+		return errors.Wrap(cerr, "failed to parse crontab")
+	}
+
+	return nil
+}
+
+// CronFormat returns the cron format of the expression: JobCronFormatWithSeconds
+// for the expressions with 6 fields and JobCronFormat for anything else
+func CronFormat(value string) cron.ParseOption {
+	if len(strings.Fields(value)) == 6 {
+		return JobCronFormatWithSeconds
+	}
+	return JobCronFormat
+}
+
 // Name returns an error if the name value is not valid
 func Name(value string) error {
 	return validation.Validate(
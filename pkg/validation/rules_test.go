@@ -17,6 +17,19 @@ func TestCronTab(t *testing.T) {
 	})
 }
 
+func TestCronTabWithSeconds(t *testing.T) {
+	t.Run("Returns no error if the value is valid", func(t *testing.T) {
+		require.NoError(t, CronTabWithSeconds("* * * * *"))
+		require.NoError(t, CronTabWithSeconds("*/10 * * * * *"))
+		require.NoError(t, CronTabWithSeconds("@hourly"))
+	})
+	t.Run("Returns error if the value is invalid", func(t *testing.T) {
+		err := CronTabWithSeconds("60 * * * * *")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to parse crontab")
+	})
+}
+
 func TestName(t *testing.T) {
 	t.Run("Returns no error if the value is valid", func(t *testing.T) {
 		require.NoError(t, Name("name"))